@@ -2,11 +2,16 @@ package cmd
 
 import (
 	"github.com/thewug/fsb/pkg/botbehavior"
+	"github.com/thewug/fsb/pkg/storage"
 
 	"github.com/thewug/gogram"
 	"github.com/thewug/gogram/data"
 
+	"bytes"
 	"fmt"
+	"html"
+	"sort"
+	"time"
 )
 
 
@@ -21,8 +26,25 @@ type ManageState struct {
 // a user pushing the switch-to-pm button in an inline query
 // handle it by modifying the command and redispatching.
 func (this *ManageState) Handle(ctx *gogram.MessageCtx) {
-	// unceremoniously ignore non-owners
-	if ctx.Msg.From == nil || this.Behavior.MySettings.Owner != ctx.Msg.From.Id { return }
+	if ctx.Msg.From == nil { return }
+
+	if len(ctx.Cmd.Args) > 0 && ctx.Cmd.Args[0] == "roles" {
+		this.Roles(ctx)
+		return
+	}
+
+	// unceremoniously ignore everyone who isn't allowed to manage the bot
+	if allowed, _ := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_MANAGE_BOT); !allowed { return }
+
+	if len(ctx.Cmd.Args) > 0 && ctx.Cmd.Args[0] == "conversions" {
+		this.ShowConversions(ctx)
+		return
+	}
+
+	if len(ctx.Cmd.Args) > 0 && ctx.Cmd.Args[0] == "keys" {
+		this.ShowCredentialKeys(ctx)
+		return
+	}
 
 	photo := ctx.Msg.Photo
 	if (photo == nil || *photo == nil) && ctx.Msg.ReplyToMessage != nil {
@@ -44,3 +66,79 @@ func (this *ManageState) Handle(ctx *gogram.MessageCtx) {
 		return
 	}
 }
+
+// the most jobs of each status listed by /manage conversions.
+const conversionListLimit = 20
+
+func (this *ManageState) ShowConversions(ctx *gogram.MessageCtx) {
+	var b bytes.Buffer
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		counts, err := storage.CountWebmConversions(tx)
+		if err != nil { return err }
+
+		b.WriteString("<b>Media conversions</b>\n")
+		for _, status := range []string{storage.WebmJobRunning, storage.WebmJobQueued, storage.WebmJobFailed} {
+			b.WriteString(fmt.Sprintf("\n<b>%s</b>: %d\n", status, counts[status]))
+			if counts[status] == 0 { continue }
+
+			jobs, err := storage.GetWebmConversions(tx, status, conversionListLimit)
+			if err != nil { return err }
+
+			for _, job := range jobs {
+				b.WriteString(fmt.Sprintf("<code>%s</code> (%s) attempts: %d", job.Md5, job.Kind, job.Attempts))
+				if status == storage.WebmJobQueued && job.NextAttempt.After(time.Now()) {
+					b.WriteString(fmt.Sprintf(", retry in %s", time.Until(job.NextAttempt).Round(time.Second)))
+				}
+				if job.LastError != "" {
+					b.WriteString(fmt.Sprintf("\n  <i>%s</i>", html.EscapeString(job.LastError)))
+				}
+				b.WriteString("\n")
+			}
+			if counts[status] > len(jobs) {
+				b.WriteString(fmt.Sprintf("...and %d more\n", counts[status] - len(jobs)))
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: "Couldn't list conversions: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: b.String(), ParseMode: data.ParseHTML}}, nil)
+}
+
+// reports how many stored credentials are encrypted with each key version, so you can tell when an old key is safe to remove.
+func (this *ManageState) ShowCredentialKeys(ctx *gogram.MessageCtx) {
+	counts, err := storage.CountCredentialKeyVersions(storage.DefaultNoTx())
+	if err != nil {
+		ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: "Couldn't count credentials: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	var versions []int
+	for version := range counts { versions = append(versions, version) }
+	sort.Ints(versions)
+
+	var b bytes.Buffer
+	b.WriteString("<b>Stored credentials by key version</b>\n")
+	if len(versions) == 0 { b.WriteString("<i>None</i>\n") }
+	for _, version := range versions {
+		if version == storage.PLAINTEXT_KEY_VERSION {
+			b.WriteString("Unencrypted")
+		} else {
+			b.WriteString(fmt.Sprintf("Version %d", version))
+		}
+		b.WriteString(fmt.Sprintf(": %d", counts[version]))
+		if version == storage.CurrentKeyVersion() { b.WriteString(" (current)") }
+		if !storage.HasKeyVersion(version) { b.WriteString(" <b>(no key configured!)</b>") }
+		b.WriteString("\n")
+	}
+
+	if len(versions) > 1 || len(versions) == 1 && versions[0] != storage.CurrentKeyVersion() {
+		b.WriteString("\nRun <code>fsbctl rekey</code> to move everything to the current key.")
+	}
+
+	ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: b.String(), ParseMode: data.ParseHTML}}, nil)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// how many audit log entries /manage roles log shows.
+const ROLE_AUDIT_SHOWN = 20
+
+var roleName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// finds the user a /manage roles command is talking about, by telegram id or by their site username.
+func findRoleTarget(tx storage.DBLike, who string) (data.UserID, error) {
+	if id, err := strconv.ParseInt(who, 10, 64); err == nil { return data.UserID(id), nil }
+
+	id, err := storage.FindUserBySiteName(tx, strings.TrimPrefix(who, "@"))
+	if err == storage.ErrNoLogin { return 0, errors.New("Nobody is logged in as " + who + ".") }
+	return id, err
+}
+
+// looks up a user's permissions. replaced in tests.
+var hasPermission = storage.HasPermission
+
+// checks whether someone may grant or revoke a role. the owner can hand out anything, but everyone else can only hand
+// out permissions they have themselves, so that nobody can give someone else more than they could do on their own.
+func canDelegateRole(tx storage.DBLike, actor data.UserID, is_owner bool, role storage.Role) (bool, error) {
+	if is_owner { return true, nil }
+	for _, p := range role.Permissions {
+		if allowed, err := hasPermission(tx, actor, p); !allowed || err != nil { return false, err }
+	}
+	return true, nil
+}
+
+// handles /manage roles, which shows and changes who can use which commands. anyone with can-manage-users can
+// grant and revoke roles made up of permissions they have themselves, but only the owner can define roles, or hand out
+// roles which themselves have can-manage-users.
+func (this *ManageState) Roles(ctx *gogram.MessageCtx) {
+	from := ctx.Msg.From.Id
+	if allowed, _ := storage.HasPermission(storage.DefaultNoTx(), from, storage.PERM_MANAGE_USERS); !allowed { return }
+	is_owner := from == this.Behavior.MySettings.Owner
+
+	args := ctx.Cmd.Args[1:]
+	subcommand := "list"
+	if len(args) > 0 { subcommand, args = args[0], args[1:] }
+
+	var reply string
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		switch subcommand {
+		case "list":
+			reply, err = RolesText(tx)
+		case "log":
+			reply, err = RoleAuditText(tx)
+		case "define":
+			if !is_owner { reply = "Only the owner can define roles."; return nil }
+			if len(args) < 1 || !roleName.MatchString(args[0]) { reply = "Usage: <code>/manage roles define [role] [permission...]</code>"; return nil }
+
+			role := storage.Role{Name: args[0]}
+			for _, p := range args[1:] {
+				if !storage.IsPermission(storage.Permission(p)) { reply = fmt.Sprintf("There's no permission called <code>%s</code>.", html.EscapeString(p)); return nil }
+				role.Permissions = append(role.Permissions, storage.Permission(p))
+			}
+			if err = storage.DefineRole(tx, from, role); err != nil { return err }
+			reply = fmt.Sprintf("Defined role <code>%s</code>.", html.EscapeString(role.Name))
+		case "delete":
+			if !is_owner { reply = "Only the owner can delete roles."; return nil }
+			if len(args) != 1 { reply = "Usage: <code>/manage roles delete [role]</code>"; return nil }
+
+			deleted, err := storage.DeleteRole(tx, from, args[0])
+			if err != nil { return err }
+			reply = map[bool]string{true: "Deleted role <code>%s</code>.", false: "There's no role called <code>%s</code>."}[deleted]
+			reply = fmt.Sprintf(reply, html.EscapeString(args[0]))
+		case "grant", "revoke":
+			if len(args) != 2 { reply = fmt.Sprintf("Usage: <code>/manage roles %s [user] [role]</code>", subcommand); return nil }
+
+			target, err := findRoleTarget(tx, args[0])
+			if err != nil { reply = html.EscapeString(err.Error()); return nil }
+
+			role, err := storage.GetRole(tx, args[1])
+			if err != nil { return err }
+			if role == nil { reply = fmt.Sprintf("There's no role called <code>%s</code>.", html.EscapeString(args[1])); return nil }
+			if role.Has(storage.PERM_MANAGE_USERS) && !is_owner { reply = "Only the owner can " + subcommand + " roles which can manage users."; return nil }
+
+			allowed, err := canDelegateRole(tx, from, is_owner, *role)
+			if err != nil { return err }
+			if !allowed { reply = "You can only " + subcommand + " roles whose permissions you have yourself."; return nil }
+
+			var changed bool
+			if subcommand == "grant" {
+				changed, err = storage.GrantRole(tx, from, target, role.Name)
+			} else {
+				changed, err = storage.RevokeRole(tx, from, target, role.Name)
+			}
+			if err != nil { return err }
+
+			if !changed {
+				reply = fmt.Sprintf("Nothing to do, %d %s role <code>%s</code>.", target, map[bool]string{true: "already has", false: "doesn't have"}[subcommand == "grant"], html.EscapeString(role.Name))
+			} else {
+				reply = fmt.Sprintf("%s role <code>%s</code> %s %d.", map[bool]string{true: "Granted", false: "Revoked"}[subcommand == "grant"], html.EscapeString(role.Name), map[bool]string{true: "to", false: "from"}[subcommand == "grant"], target)
+			}
+		default:
+			reply = "Usage: <code>/manage roles [list|log|define|delete|grant|revoke]</code>"
+		}
+		return err
+	})
+
+	if err != nil {
+		ctx.Bot.ErrorLog.Println("Error managing roles:", err.Error())
+		reply = "Something went wrong: " + html.EscapeString(err.Error())
+	}
+	ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: reply, ParseMode: data.ParseHTML}}, nil)
+}
+
+func RolesText(tx storage.DBLike) (string, error) {
+	roles, err := storage.GetRoles(tx)
+	if err != nil { return "", err }
+	grants, err := storage.GetRoleGrants(tx)
+	if err != nil { return "", err }
+
+	var b bytes.Buffer
+	b.WriteString("<b>Roles</b>\n")
+	if len(roles) == 0 { b.WriteString("<i>None</i>\n") }
+	for _, r := range roles {
+		var perms []string
+		for _, p := range r.Permissions { perms = append(perms, string(p)) }
+		b.WriteString(fmt.Sprintf("<code>%s</code>: %s\n", html.EscapeString(r.Name), strings.Join(perms, ", ")))
+		for _, g := range grants {
+			if g.Role != r.Name { continue }
+			b.WriteString(fmt.Sprintf("  %d", g.TelegramId))
+			if g.User != "" { b.WriteString(" (" + html.EscapeString(g.User) + ")") }
+			b.WriteString("\n")
+		}
+	}
+
+	var all []string
+	for _, p := range storage.ALL_PERMISSIONS { all = append(all, string(p)) }
+	b.WriteString("\n<b>Permissions:</b> " + strings.Join(all, ", "))
+	b.WriteString("\nUsers with the janitor flag have every permission except can-manage-users and can-manage-bot.")
+	return b.String(), nil
+}
+
+func RoleAuditText(tx storage.DBLike) (string, error) {
+	entries, err := storage.GetRoleAudit(tx, ROLE_AUDIT_SHOWN)
+	if err != nil { return "", err }
+
+	var b bytes.Buffer
+	b.WriteString("<b>Recent role changes</b>\n")
+	if len(entries) == 0 { b.WriteString("<i>None</i>\n") }
+	for _, e := range entries {
+		b.WriteString(fmt.Sprintf("<code>%s</code> %d %s <code>%s</code>", e.Time.Format("2006-01-02 15:04"), e.Actor, e.Action, html.EscapeString(e.Role)))
+		if e.Target != 0 { b.WriteString(fmt.Sprintf(" %s %d", map[bool]string{true: "to", false: "from"}[e.Action == storage.ROLE_GRANT], e.Target)) }
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram/data"
+
+	"errors"
+	"testing"
+)
+
+func Test_canDelegateRole(t *testing.T) {
+	old_has := hasPermission
+	defer func() { hasPermission = old_has }()
+
+	// user 1 can sync and edit blits, user 2 can only sync, and looking up user 3 fails.
+	hasPermission = func(tx storage.DBLike, id data.UserID, p storage.Permission) (bool, error) {
+		switch id {
+		case 1:
+			return p == storage.PERM_SYNC || p == storage.PERM_EDIT_BLITS, nil
+		case 2:
+			return p == storage.PERM_SYNC, nil
+		case 3:
+			return false, errors.New("no database")
+		}
+		return false, nil
+	}
+
+	role := storage.Role{Name: "tidier", Permissions: []storage.Permission{storage.PERM_SYNC, storage.PERM_EDIT_BLITS}}
+
+	testcases := map[string]struct{
+		actor    data.UserID
+		is_owner bool
+		role     storage.Role
+		allowed  bool
+		err      bool
+	}{
+		"has everything": {1, false, role, true, false},
+		"missing one": {2, false, role, false, false},
+		"has nothing": {4, false, role, false, false},
+		"owner": {4, true, role, true, false},
+		"empty role": {4, false, storage.Role{Name: "nothing"}, true, false},
+		"error": {3, false, role, false, true},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			allowed, err := canDelegateRole(nil, v.actor, v.is_owner, v.role)
+			if (err != nil) != v.err { t.Errorf("Unexpected error: %v", err) }
+			if allowed != v.allowed { t.Errorf("Unexpected result: got %t, expected %t", allowed, v.allowed) }
+		})
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"bytes"
 	"fmt"
 	"html"
+	"sort"
 	"strconv"
+	"strings"
+	"unicode"
 )
 
 const SETTINGS = "/settings"
@@ -20,6 +23,20 @@ const RATING = "rating"
 const VERIFY = "verify"
 const VERIFYDONE = "doneverifying"
 const VERIFYFAIL = "failverifying"
+const ADD = "add"
+const REMOVE = "remove"
+
+// the longest a bot blacklist can get, so that it always fits in the settings message.
+const MAX_BOT_BLACKLIST_LENGTH = 2000
+
+// whether someone gets the janitor mark next to their account, which is anyone who can do any of what the janitor flag
+// used to allow, whether that's from the flag or from their roles.
+func isJanitor(tx storage.DBLike, id data.UserID) (bool, error) {
+	for _, p := range storage.JANITOR_PERMISSIONS {
+		if allowed, err := hasPermission(tx, id, p); allowed || err != nil { return allowed, err }
+	}
+	return false, nil
+}
 
 func Account(user string, bot_janitor bool) string {
 	if user == "" {
@@ -35,7 +52,7 @@ func SettingsMessage(subcommand string, settings *storage.UserSettings, user str
 
 	prompt := ""
 	if subcommand == BLACKLIST {
-		prompt = "Please use the buttons to change your blacklist preferences.\n\nBy default, both blacklists are applied."
+		prompt = "Please use the buttons to change your blacklist preferences.\n\nBy default, both your " + api.ApiName + " blacklist and your bot blacklist are applied. If you haven't connected an account, a default blacklist is used in place of your " + api.ApiName + " blacklist.\n\n" + BotBlacklistText(settings)
 	} else if subcommand == RATING {
 		prompt = "Please use the buttons to change your rating filter.\n\nBy default, only posts rated <b>safe</b> are shown. Users who have not verified their age can only view posts rated <b>safe</b> or <b>questionable</b>."
 	} else if subcommand == VERIFY {
@@ -48,6 +65,12 @@ func SettingsMessage(subcommand string, settings *storage.UserSettings, user str
 	b.WriteString(settings.RatingMode.Display())
 	b.WriteString("\n<code>Blacklist Mode: </code>")
 	b.WriteString(settings.BlacklistMode.Display())
+	b.WriteString("\n<code>Bot Blacklist:  </code>")
+	if n := len(settings.BlacklistLines()); n == 1 {
+		b.WriteString("1 line")
+	} else {
+		b.WriteString(fmt.Sprintf("%d lines", n))
+	}
 	b.WriteString("\n<code>Age Status:     </code>")
 	b.WriteString(settings.AgeStatus.Display())
 	b.WriteString("\n\n<b>Your Account</b>\n<code>Telegram ID:  </code>")
@@ -74,6 +97,9 @@ func SettingsMessage(subcommand string, settings *storage.UserSettings, user str
 		k.AddRow()
 		k.AddButton(data.TInlineKeyboardButton{Text: "Enabled", Data: sptr(SETTINGS + " " + BLACKLIST + " " + types.BLACKLIST_ON.String())})
 		k.AddButton(data.TInlineKeyboardButton{Text: "Disabled", Data: sptr(SETTINGS + " " + BLACKLIST + " " + types.BLACKLIST_OFF.String())})
+		k.AddRow()
+		k.AddButton(data.TInlineKeyboardButton{Text: "Bot Only", Data: sptr(SETTINGS + " " + BLACKLIST + " " + types.BLACKLIST_BOT_ONLY.String())})
+		k.AddButton(data.TInlineKeyboardButton{Text: api.ApiName + " Only", Data: sptr(SETTINGS + " " + BLACKLIST + " " + types.BLACKLIST_SITE_ONLY.String())})
 	} else if subcommand == RATING {
 		k.AddRow()
 		k.AddButton(data.TInlineKeyboardButton{Text: "Safe Only", Data: sptr(SETTINGS + " " + RATING + " " + types.FILTER_QUESTIONABLE.String())})
@@ -89,6 +115,30 @@ func SettingsMessage(subcommand string, settings *storage.UserSettings, user str
 	return d
 }
 
+// lists the lines of a user's bot blacklist, numbered so they can be removed, with instructions for editing it.
+func BotBlacklistText(settings *storage.UserSettings) string {
+	var b bytes.Buffer
+	b.WriteString("<b>Your bot blacklist</b>\n")
+	lines := settings.BlacklistLines()
+	if len(lines) == 0 { b.WriteString("<i>Empty</i>\n") }
+	for i, line := range lines {
+		b.WriteString(fmt.Sprintf("<code>%2d</code> %s\n", i + 1, html.EscapeString(line)))
+	}
+	b.WriteString("\nTo add lines, send <code>/settings blacklist add [tags]</code>, with one blacklist line per line of your message. To remove them, send <code>/settings blacklist remove [number...]</code>.")
+	return b.String()
+}
+
+// strips the first n words from a command's arguments, keeping any line breaks in what's left.
+func afterWords(s string, n int) string {
+	for i := 0; i < n; i++ {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end == -1 { return "" }
+		s = s[end:]
+	}
+	return strings.TrimSpace(s)
+}
+
 type SettingsState struct {
 	gogram.StateBase
 }
@@ -118,13 +168,34 @@ func (this *SettingsState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) e
 			return fmt.Errorf("Error looking up " + api.ApiName + " account for %d: %v", ctx.Msg.From.Id, err)
 		}
 
-		ctx.ReplyAsync(data.OMessage{SendData: SettingsMessage("", settings, creds.User, creds.Janitor)}, nil)
+		subcommand, args := "", strings.Fields(ctx.Cmd.Argstr)
+		if len(args) > 0 && args[0] == BLACKLIST {
+			subcommand = BLACKLIST
+			if len(args) > 1 {
+				if reply := this.EditBlacklist(settings, args[1], afterWords(ctx.Cmd.Argstr, 2)); reply != "" {
+					ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: reply, ParseMode: data.ParseHTML}}, nil)
+					return nil
+				}
+
+				err = storage.WriteUserSettings(tx, settings)
+				if err != nil {
+					ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Sorry! There was an error saving your settings."}}, nil)
+					return fmt.Errorf("Error saving settings for %d: %w", ctx.Msg.From.Id, err)
+				}
+			}
+		}
+
+		janitor, err := isJanitor(tx, ctx.Msg.From.Id)
+		if err != nil { ctx.Bot.ErrorLog.Printf("Error looking up permissions for %d: %s\n", ctx.Msg.From.Id, err.Error()) }
+
+		ctx.ReplyAsync(data.OMessage{SendData: SettingsMessage(subcommand, settings, creds.User, janitor)}, nil)
 	} else if ctx.Cmd.Command == "/delete_my_data_and_forget_me" {
 		if ctx.Cmd.Argstr == "Yes I'm sure!" {
 			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("I'll always remember you, %s!\n<i>MEMORY DELETED</i>", html.EscapeString(ctx.Msg.From.FirstName)), ParseMode: data.ParseHTML}}, nil)
 			if err := storage.DeleteUserSettings(tx, ctx.Msg.From.Id); err != nil { ctx.Bot.ErrorLog.Println("Error deleting settings: ", err.Error()) }
 			if err := storage.DeleteUserCreds(tx, ctx.Msg.From.Id); err != nil { ctx.Bot.ErrorLog.Println("Error deleting credentials: ", err.Error()) }
 			if err := storage.DeleteUserTagRules(tx, ctx.Msg.From.Id); err != nil { ctx.Bot.ErrorLog.Println("Error deleting credentials: ", err.Error()) }
+			if err := storage.DeleteUserSubscriptions(tx, ctx.Msg.From.Id); err != nil { ctx.Bot.ErrorLog.Println("Error deleting saved searches: ", err.Error()) }
 		} else if len(ctx.Cmd.Argstr) == 0 || ctx.Cmd.Argstr != "Yes I'm sure!" {
 			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "<b>Forget all data and settings: are you sure?</b>\n\nIf you're sure, copy and paste the command\n<code>/delete_my_data_and_forget_me Yes I'm sure!</code>", ParseMode: data.ParseHTML}}, nil)
 			return nil
@@ -134,6 +205,52 @@ func (this *SettingsState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) e
 	return nil
 }
 
+// adds or removes lines from a user's bot blacklist. if the change can't be made, returns a message explaining why.
+func (this *SettingsState) EditBlacklist(settings *storage.UserSettings, action, argstr string) string {
+	switch action {
+	case ADD:
+		// posts only know their uploader's id, so user:name has to be looked up now to be any use later.
+		argstr, unknown, err := api.ResolveBlacklistUsers(argstr)
+		if err != nil {
+			return "Sorry! I couldn't look up the users in your blacklist on " + api.ApiName + ", try again later."
+		} else if len(unknown) != 0 {
+			return fmt.Sprintf("There's no user called <code>%s</code> on %s, so your blacklist wasn't changed.", html.EscapeString(strings.Join(unknown, ", ")), api.ApiName)
+		}
+
+		var added int
+		for _, line := range strings.Split(argstr, "\n") {
+			if settings.AddBlacklistLine(line) { added++ }
+		}
+		if added == 0 {
+			return "Add some tags, like this: <code>/settings blacklist add [tags]</code>"
+		}
+		if len(settings.Blacklist) > MAX_BOT_BLACKLIST_LENGTH {
+			return fmt.Sprintf("Sorry! Your bot blacklist can't be longer than %d characters.", MAX_BOT_BLACKLIST_LENGTH)
+		}
+	case REMOVE:
+		var numbers []int
+		for _, field := range strings.Fields(argstr) {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(settings.BlacklistLines()) {
+				return fmt.Sprintf("There's no line <code>%s</code> in your bot blacklist.", html.EscapeString(field))
+			}
+			numbers = append(numbers, n)
+		}
+		if len(numbers) == 0 {
+			return "Say which lines to remove, like this: <code>/settings blacklist remove [number...]</code>"
+		}
+
+		// remove from the bottom up, so the numbers still refer to the same lines.
+		sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+		for i, n := range numbers {
+			if i == 0 || numbers[i - 1] != n { settings.RemoveBlacklistLine(n) }
+		}
+	default:
+		return fmt.Sprintf("I don't know how to <code>%s</code> your blacklist, try <code>%s</code> or <code>%s</code>.", html.EscapeString(action), ADD, REMOVE)
+	}
+	return ""
+}
+
 func (this *SettingsState) HandleCallback(ctx *gogram.CallbackCtx) {
 	err := storage.DefaultTransact(func(tx storage.DBLike) error { return this.HandleCallbackTx(tx, ctx) })
 	if err != nil {
@@ -171,6 +288,10 @@ func (this *SettingsState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.Callb
 					settings.BlacklistMode = types.BLACKLIST_ON
 				case types.BLACKLIST_OFF.String():
 					settings.BlacklistMode = types.BLACKLIST_OFF
+				case types.BLACKLIST_BOT_ONLY.String():
+					settings.BlacklistMode = types.BLACKLIST_BOT_ONLY
+				case types.BLACKLIST_SITE_ONLY.String():
+					settings.BlacklistMode = types.BLACKLIST_SITE_ONLY
 				}
 			} else if subcommand == RATING {
 				switch ctx.Cmd.Args[1] {
@@ -206,7 +327,10 @@ func (this *SettingsState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.Callb
 			return fmt.Errorf("Error saving settings for %d: %w", ctx.Cb.From.Id, err)
 		}
 
-		gogram.NewMessageCtx(ctx.Cb.Message, false, ctx.Bot).EditText(data.OMessageEdit{SendData: SettingsMessage(subcommand, settings, creds.User, creds.Janitor)})
+		janitor, err := isJanitor(tx, ctx.Cb.From.Id)
+		if err != nil { ctx.Bot.ErrorLog.Printf("Error looking up permissions for %d: %s\n", ctx.Cb.From.Id, err.Error()) }
+
+		gogram.NewMessageCtx(ctx.Cb.Message, false, ctx.Bot).EditText(data.OMessageEdit{SendData: SettingsMessage(subcommand, settings, creds.User, janitor)})
 	}
 
 	return nil
@@ -70,9 +70,16 @@ func main() {
 	operator := bot.OperatorState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
 	manage := cmd.ManageState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
 	autofix := bot.AutofixState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
+	comments := bot.CommentState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
+	subscriptions := bot.SubscriptionState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
+	credentials := bot.CredentialCheckState{Behavior: &behavior}
+	backfill := bot.PostBackfillState{Behavior: &behavior}
+	hashes := bot.PreviewHashState{Behavior: &behavior}
+	reverse := botbehavior.ReverseSearchState{StateBase: gogram.StateBase{StateMachine: machine}, Behavior: &behavior}
 	post := bot.PostState{StateBasePersistent: persist.Register(p, machine, "post", bot.PostStateFactory)}
 	edit := bot.EditState{StateBasePersistent: persist.Register(p, machine, "edit", bot.EditStateFactory)}
 
+	machine.Default = &reverse
 	machine.AddCommand("/help", &help)
 	machine.AddCommand("/start", &start)
 	machine.AddCommand("/settings", &settingscmd)
@@ -83,6 +90,7 @@ func main() {
 	machine.AddCommand("/post", &post)
 	machine.AddCommand("/indextags", &janitor)
 	machine.AddCommand("/indextagaliases", &janitor)
+	machine.AddCommand("/indextagimplications", &janitor)
 	machine.AddCommand("/recountnegative", &janitor)
 	machine.AddCommand("/cats", &janitor)
 	machine.AddCommand("/blits", &janitor)
@@ -91,9 +99,16 @@ func main() {
 	machine.AddCommand("/syncposts", &janitor)
 	machine.AddCommand("/resynclist", &janitor)
 	machine.AddCommand("/parseexpression", &janitor)
+	machine.AddCommand("/bulkretag", &janitor)
+	machine.AddCommand("/revertreplacements", &janitor)
+	machine.AddCommand("/replacements", &janitor)
 	machine.AddCommand("/upvote", &votes)
 	machine.AddCommand("/downvote", &votes)
 	machine.AddCommand("/favorite", &votes)
+	machine.AddCommand("/comments", &comments)
+	machine.AddCommand("/comment", &comments)
+	machine.AddCommand("/subscribe", &subscriptions)
+	machine.AddCommand("/subscriptions", &subscriptions)
 	machine.AddCommand("/af-commit", &autofix)
 	machine.AddCommand("/af-dismiss", &autofix)
 	machine.AddCommand("/af-toggle", &autofix)
@@ -109,6 +124,10 @@ func main() {
 	thebot.AddMaintenanceCallback(&behavior)
 	thebot.AddMaintenanceCallback(&votes)
 	thebot.AddMaintenanceCallback(&autofix)
+	thebot.AddMaintenanceCallback(&subscriptions)
+	thebot.AddMaintenanceCallback(&credentials)
+	thebot.AddMaintenanceCallback(&backfill)
+	thebot.AddMaintenanceCallback(&hashes)
 
 	err := p.LoadAllStates(machine)
 	if err != nil { thebot.ErrorLog.Println(err.Error()) }
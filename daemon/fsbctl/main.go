@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/thewug/fsb/pkg/api/tagindex"
+	"github.com/thewug/fsb/pkg/botbehavior/settings"
+	"github.com/thewug/fsb/pkg/storage"
+
+	tgdata "github.com/thewug/gogram/data"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// fsbctl runs the janitor tag tools (the same ones behind /typos, /cats, /blits, /syncposts and /recounttags) from
+// the command line, without going through telegram. It takes the same settings file as the bot, and prints its
+// progress and results to stdout, which makes it suitable for scripts, cron jobs, and listings too long for a message.
+
+func usage() {
+	fmt.Printf("Usage: %s [-c CONFIGFILE] [--as TELEGRAM_ID] COMMAND [ARGS...]\n", os.Args[0])
+	fmt.Println("  CONFIGFILE  - Read this file for settings. (if omitted, use /etc/fsb/settings.json)")
+	fmt.Println("  TELEGRAM_ID - Make edits with the stored credentials of this user. (if omitted, use the search credentials from the settings file)")
+	fmt.Println("Commands:")
+	fmt.Println("  typos ARGS...   - same as /typos")
+	fmt.Println("  cats ARGS...    - same as /cats (except --entry, which needs a previous listing)")
+	fmt.Println("  blits ARGS...   - same as /blits")
+	fmt.Println("  sync [--aliases] [--recount] - same as /syncposts")
+	fmt.Println("  recount [--real] [--alias]   - same as /recounttags (does both if neither is specified)")
+	fmt.Println("  rekey           - encrypt every stored api key with the current credential key, after adding or rotating keys")
+	fmt.Println("                    (make a new key with: head -c 32 /dev/urandom | base64)")
+	fmt.Println("See the bot's /help for the arguments each command takes.")
+}
+
+func main() {
+	settingsFile := "/etc/fsb/settings.json"
+	var as *tgdata.UserID
+
+	args := os.Args[1:]
+	for len(args) != 0 {
+		if args[0] == "--help" || args[0] == "-h" {
+			usage()
+			os.Exit(0)
+		} else if args[0] == "-c" && len(args) > 1 {
+			settingsFile, args = args[1], args[2:]
+		} else if args[0] == "--as" && len(args) > 1 {
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				fmt.Println("Invalid telegram id:", args[1])
+				os.Exit(1)
+			}
+			user := tgdata.UserID(id)
+			as, args = &user, args[2:]
+		} else {
+			break
+		}
+	}
+
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+	command, args := args[0], args[1:]
+
+	var s settings.Settings
+	b, err := ioutil.ReadFile(settingsFile)
+	if err == nil { err = json.Unmarshal(b, &s) }
+	if err == nil { err = s.InitializeStandalone() }
+	if err != nil {
+		fmt.Println("Error loading settings:", err.Error())
+		os.Exit(1)
+	}
+
+	creds := s.DefaultSearchCredentials()
+	if as != nil {
+		creds, err = storage.GetUserCreds(nil, *as)
+		if err != nil {
+			fmt.Println("Error loading credentials:", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	progress := tagindex.ProgressWriter(os.Stdout)
+
+	switch command {
+	case "typos":
+		var control tagindex.TyposControl
+		control, err = tagindex.ParseTyposArgs(args)
+		if err == nil {
+			err = storage.DefaultTransact(func(tx storage.DBLike) error { return tagindex.TyposInternal(tx, control, creds, progress) })
+		}
+	case "cats":
+		var control tagindex.CatsControl
+		control, err = tagindex.ParseCatsArgs(args, nil)
+		if err == nil {
+			err = storage.DefaultTransact(func(tx storage.DBLike) error { return tagindex.CatsInternal(tx, control, creds, progress) })
+		}
+	case "blits":
+		control := tagindex.ParseBlitsArgs(args)
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return tagindex.BlitsInternal(tx, control, progress) })
+	case "sync":
+		aliases, recount := false, false
+		for _, token := range args {
+			if token == "--aliases" { aliases = true }
+			if token == "--recount" { recount = true }
+		}
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return tagindex.SyncPostsInternal(tx, creds.User, creds.ApiKey, aliases, recount, progress, nil) })
+	case "recount":
+		real_counts, alias_counts := false, false
+		for _, token := range args {
+			if token == "--real" { real_counts = true }
+			if token == "--alias" { alias_counts = true }
+		}
+		if !real_counts && !alias_counts {
+			real_counts, alias_counts = true, true
+		}
+		err = storage.DefaultTransact(func(tx storage.DBLike) error {
+			if real_counts {
+				if err := tagindex.RecountTagsInternal(tx, progress); err != nil { return err }
+			}
+			if alias_counts {
+				if err := tagindex.CalculateAliasedCountsInternal(tx, progress); err != nil { return err }
+			}
+			return nil
+		})
+	case "rekey":
+		var count int
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { count, err = storage.ReencryptUserCreds(tx); return err })
+		if err == nil { fmt.Printf("Re-encrypted %d credentials with key version %d.\n", count, storage.CurrentKeyVersion()) }
+	default:
+		fmt.Println("Unknown command:", command)
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"fmt"
+	"io"
+)
+
+// Backend is the set of calls the rest of the bot makes against a booru. Each kind of site gets its own
+// implementation, which translates between its own API and the types in pkg/api/types, so that nothing outside
+// of this package needs to know which kind of site it's talking to.
+type Backend interface {
+	TestLogin(user, apitoken string) (*types.TUserInfo, bool, error)
+	ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagInfoArray, error)
+	ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (types.TAliasInfoArray, error)
+	ListTagImplications(user, apitoken string, options types.ListTagImplicationOptions) (types.TImplicationInfoArray, error)
+	ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPostInfoArray, error)
+	FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error)
+	ListPools(user, apitoken string, options types.ListPoolOptions) (types.TPoolInfoArray, error)
+	FetchPool(user, apitoken string, id int) (*types.TPoolData, error)
+	ListComments(user, apitoken string, options types.ListCommentOptions) (types.TCommentInfoArray, error)
+	GetTagData(user, apitoken string, id int) (*types.TTagData, error)
+	FetchUser(username, api_key string) (*types.TUserInfo, error)
+
+	UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating types.PostRating, source, description string, parent *int, user, apitoken string) (*UploadCallResult, error)
+	UpdatePost(user, apitoken string, id int, tagdiff tags.TagDiff, rating types.PostRating, parent *int, sourcediff []string, description *string, reason *string) (*types.TPostInfo, error)
+	VotePost(user, apitoken string, id int, vote types.PostVote, no_unvote bool) (*types.TPostScore, error)
+	UnvotePost(user, apitoken string, id int) (error)
+	FavoritePost(user, apitoken string, id int) (*types.TPostInfo, error)
+	UnfavoritePost(user, apitoken string, id int) (error)
+	CreateComment(user, apitoken string, post_id int, body string) (*types.TCommentData, error)
+
+	FillPostURLs(post *types.TPostInfo)
+}
+
+// E621Backend talks to e621, and other sites running the same software.
+type E621Backend struct{}
+
+// Backends lists the known backends by the name used to select them in the settings file.
+var Backends = map[string]Backend{
+	"e621":     E621Backend{},
+	"danbooru": DanbooruBackend{},
+}
+
+const DefaultBackend = "e621"
+
+var backend Backend = E621Backend{}
+
+func selectBackend(name string) error {
+	if name == "" { name = DefaultBackend }
+	b, ok := Backends[name]
+	if !ok { return fmt.Errorf("unknown api backend: %s", name) }
+	backend = b
+	return nil
+}
+
+// checks whether a user's credentials are valid, returning information about the user if the user exists.
+func TestLogin(user, apitoken string) (*types.TUserInfo, bool, error) {
+	return backend.TestLogin(user, apitoken)
+}
+
+func ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagInfoArray, error) {
+	return backend.ListTags(user, apitoken, options)
+}
+
+func ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (types.TAliasInfoArray, error) {
+	return backend.ListTagAliases(user, apitoken, options)
+}
+
+func ListTagImplications(user, apitoken string, options types.ListTagImplicationOptions) (types.TImplicationInfoArray, error) {
+	return backend.ListTagImplications(user, apitoken, options)
+}
+
+func ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPostInfoArray, error) {
+	return backend.ListPosts(user, apitoken, options)
+}
+
+func FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error) {
+	return backend.FetchOnePost(user, apitoken, id)
+}
+
+func ListPools(user, apitoken string, options types.ListPoolOptions) (types.TPoolInfoArray, error) {
+	return backend.ListPools(user, apitoken, options)
+}
+
+func FetchPool(user, apitoken string, id int) (*types.TPoolData, error) {
+	return backend.FetchPool(user, apitoken, id)
+}
+
+func ListComments(user, apitoken string, options types.ListCommentOptions) (types.TCommentInfoArray, error) {
+	return backend.ListComments(user, apitoken, options)
+}
+
+func GetTagData(user, apitoken string, id int) (*types.TTagData, error) {
+	return backend.GetTagData(user, apitoken, id)
+}
+
+func FetchUser(username, api_key string) (*types.TUserInfo, error) {
+	return backend.FetchUser(username, api_key)
+}
+
+func UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating types.PostRating, source, description string, parent *int, user, apitoken string) (*UploadCallResult, error) {
+	return backend.UploadFile(file_data, upload_url, tags, rating, source, description, parent, user, apitoken)
+}
+
+func UpdatePost(user, apitoken string,
+		id int,
+		tagdiff tags.TagDiff,				// empty to leave tags unchanged.
+		rating types.PostRating,			// nil to leave rating unchanged.
+		parent *int,					// nil to leave parent unchanged, -1 to UNSET parent
+		sourcediff []string,				// nil to leave source unchanged
+		description *string,				// nil to leave description unchanged
+		reason *string) (*types.TPostInfo, error) {
+	return backend.UpdatePost(user, apitoken, id, tagdiff, rating, parent, sourcediff, description, reason)
+}
+
+func VotePost(user, apitoken string, id int, vote types.PostVote, no_unvote bool) (*types.TPostScore, error) {
+	return backend.VotePost(user, apitoken, id, vote, no_unvote)
+}
+
+func UnvotePost(user, apitoken string, id int) (error) {
+	return backend.UnvotePost(user, apitoken, id)
+}
+
+// you shouldn't depend on this to return anything useful, as it will return nil if you favorite the same post twice
+func FavoritePost(user, apitoken string, id int) (*types.TPostInfo, error) {
+	return backend.FavoritePost(user, apitoken, id)
+}
+
+func UnfavoritePost(user, apitoken string, id int) (error) {
+	return backend.UnfavoritePost(user, apitoken, id)
+}
+
+func CreateComment(user, apitoken string, post_id int, body string) (*types.TCommentData, error) {
+	return backend.CreateComment(user, apitoken, post_id, body)
+}
+
+// posts read from the local index don't include any file urls, so this rebuilds them from the post's md5 and
+// file extension, wherever the site keeps its files.
+func FillPostURLs(post *types.TPostInfo) {
+	backend.FillPostURLs(post)
+}
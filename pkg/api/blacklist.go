@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"strconv"
+	"strings"
+)
+
+// looks up a user by name, without logging in as them. replaced in tests.
+var lookupUser = func(name string) (*types.TUserInfo, error) {
+	return FetchUser(name, "")
+}
+
+// rewrites the user:name tags in a blacklist as user:!id, since posts only say who uploaded them by id, so names can't
+// be matched against them directly. names which don't belong to anyone are left alone, and returned. they can never
+// match anything, which is what the site does with them too. if a name can't be looked up, the error is returned along
+// with the blacklist as far as it could be resolved.
+func ResolveBlacklistUsers(blacklist string) (string, []string, error) {
+	ids := make(map[string]int)
+	var unknown []string
+
+	lines := strings.Split(blacklist, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		changed := false
+		for j, field := range fields {
+			tag := strings.TrimLeft(field, "-~")
+			if !strings.HasPrefix(strings.ToLower(tag), "user:") { continue }
+			name := strings.ToLower(tag[len("user:"):])
+			if name == "" || strings.HasPrefix(name, "!") { continue }
+
+			id, ok := ids[name]
+			if !ok {
+				// the site's name search takes wildcards, which user: doesn't, so don't go looking for those.
+				var user *types.TUserInfo
+				if !strings.Contains(name, "*") {
+					var err error
+					user, err = lookupUser(name)
+					if err != nil { return strings.Join(lines, "\n"), unknown, err }
+				}
+				if user != nil { id = user.Id } else { unknown = append(unknown, name) }
+				ids[name] = id
+			}
+			if id == 0 { continue }
+
+			fields[j] = field[:len(field) - len(tag)] + "user:!" + strconv.Itoa(id)
+			changed = true
+		}
+		if changed { lines[i] = strings.Join(fields, " ") }
+	}
+
+	return strings.Join(lines, "\n"), unknown, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ResolveBlacklistUsers(t *testing.T) {
+	old_lookup := lookupUser
+	defer func() { lookupUser = old_lookup }()
+
+	var lookups []string
+	lookupUser = func(name string) (*types.TUserInfo, error) {
+		lookups = append(lookups, name)
+		switch name {
+		case "alice":
+			return &types.TUserInfo{Id: 1, Name: "Alice"}, nil
+		case "broken":
+			return nil, errors.New("site is down")
+		}
+		return nil, nil
+	}
+
+	testcases := map[string]struct{
+		blacklist string
+		expected  string
+		unknown   []string
+		lookups   []string
+		err       bool
+	}{
+		"no users": {"gore\nferal -rating:s", "gore\nferal -rating:s", nil, nil, false},
+		"name": {"user:Alice", "user:!1", nil, []string{"alice"}, false},
+		"prefixes": {"wolf -user:alice\n~user:alice ~fox", "wolf -user:!1\n~user:!1 ~fox", nil, []string{"alice"}, false},
+		"id": {"user:!5", "user:!5", nil, nil, false},
+		"unknown": {"user:nobody  wolf\nuser:nobody", "user:nobody  wolf\nuser:nobody", []string{"nobody"}, []string{"nobody"}, false},
+		"wildcard": {"user:ali*", "user:ali*", []string{"ali*"}, nil, false},
+		"error": {"user:alice\nuser:broken\nuser:alice", "user:!1\nuser:broken\nuser:alice", nil, []string{"alice", "broken"}, true},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			lookups = nil
+			out, unknown, err := ResolveBlacklistUsers(v.blacklist)
+			if (err != nil) != v.err { t.Errorf("Unexpected error: %v", err) }
+			if out != v.expected { t.Errorf("Unexpected blacklist: got %q, expected %q", out, v.expected) }
+			if !reflect.DeepEqual(unknown, v.unknown) { t.Errorf("Unexpected unknown users: got %v, expected %v", unknown, v.unknown) }
+			if !reflect.DeepEqual(lookups, v.lookups) { t.Errorf("Unexpected lookups: got %s, expected %s", strings.Join(lookups, ","), strings.Join(v.lookups, ",")) }
+		})
+	}
+}
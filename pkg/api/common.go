@@ -4,11 +4,14 @@ import (
         "github.com/thewug/reqtify"
 
 	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/fsb/metrics"
 
 	"errors"
         "fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
         "time"
 )
@@ -30,6 +33,20 @@ type settings interface {
 	GetApiStaticPrefix() string
 }
 
+// settings may optionally choose which kind of site the api talks to, otherwise DefaultBackend is used.
+type backendSettings interface {
+	GetApiBackend() string
+}
+
+// settings may also optionally change how the api is reached, which is mostly useful for pointing it at a local
+// server for testing. a zero request interval turns off rate limiting entirely.
+type transportSettings interface {
+	GetApiScheme() string
+	GetApiRequestInterval() time.Duration
+}
+
+const DefaultRequestInterval = 750 * time.Millisecond
+
 func Init(s settings) error {
 	ApiName = s.GetApiName()
 	Endpoint = s.GetApiEndpoint()
@@ -40,7 +57,18 @@ func Init(s settings) error {
 		return errors.New("missing required parameter")
 	}
 
-	api = reqtify.New(fmt.Sprintf("https://%s", Endpoint), time.NewTicker(750 * time.Millisecond), nil, nil, userAgent)
+	var backend_name string
+	if bs, ok := s.(backendSettings); ok { backend_name = bs.GetApiBackend() }
+	if err := selectBackend(backend_name); err != nil { return err }
+
+	scheme, interval := "https", DefaultRequestInterval
+	if ts, ok := s.(transportSettings); ok { scheme, interval = ts.GetApiScheme(), ts.GetApiRequestInterval() }
+
+	var ticker *time.Ticker
+	if interval > 0 { ticker = time.NewTicker(interval) }
+
+	// requests wait for the rate limiter here, rather than inside reqtify, so they can be counted while they wait.
+	api = reqtify.New(fmt.Sprintf("%s://%s", scheme, Endpoint), nil, nil, func(reqtify.Request) error { waitForRateLimit(ticker); return nil }, userAgent)
 	return nil
 }
 
@@ -66,7 +94,23 @@ func SanitizeRatingForEdit(input string) (types.PostRating, error) {
 	return types.Invalid, errors.New("Invalid rating")
 }
 
+var apiCalls = metrics.NewCounter("fsb_api_calls_total", "API calls made, by endpoint and HTTP status.", "endpoint", "status")
+var apiWaiting = metrics.NewGauge("fsb_api_requests_waiting", "API requests waiting on the rate limiter.")
+
+func waitForRateLimit(ticker *time.Ticker) {
+	apiWaiting.Inc()
+	defer apiWaiting.Dec()
+	if ticker != nil { <- ticker.C }
+}
+
+var numericPathPart = regexp.MustCompile(`/\d+`)
+
 func APILog(url, user string, length int, response *http.Response, err error) {
+	status := "error"
+	if response != nil { status = strconv.Itoa(response.StatusCode) }
+	// strip out IDs, so that each post or tag doesn't get counted separately.
+	apiCalls.Inc(numericPathPart.ReplaceAllString(url, "/:id"), status)
+
 	caller := "unauthenticated"
 	if user != "" {
 		caller = fmt.Sprintf("as %s", user)
@@ -100,3 +144,42 @@ func LocationToURLWithRating(location string, rating types.PostRating) string {
 func LocationToURL(location string) string {
 	return LocationToURLWithRating(location, "e")
 }
+
+const SampleSize = 850
+const PreviewSize = 150
+
+// scales dimensions down to fit within a max by max box, preserving the aspect ratio.
+func scaleToFit(w, h, max int) (int, int) {
+	if w <= max && h <= max || w == 0 || h == 0 { return w, h }
+	if w > h { return max, h * max / w }
+	return w * max / h, max
+}
+
+// posts read from the local index don't include any file urls, but e621 stores files at predictable locations
+// based on the post's md5 and file extension, so they can be rebuilt.
+func (E621Backend) FillPostURLs(post *types.TPostInfo) {
+	if len(post.Md5) < 4 { return }
+
+	path := fmt.Sprintf("%s/%s/%s", post.Md5[0:2], post.Md5[2:4], post.Md5)
+	static := fmt.Sprintf("https://%s%s/data", StaticPrefix, Endpoint)
+
+	post.File_url = fmt.Sprintf("%s/%s.%s", static, path, post.File_ext)
+	post.Preview_url = fmt.Sprintf("%s/preview/%s.jpg", static, path)
+	if post.Has_sample { post.Sample_url = fmt.Sprintf("%s/sample/%s.jpg", static, path) }
+	fillPostSizes(post)
+}
+
+// fills in preview and sample dimensions, and points the sample at the file itself if there isn't one.
+func fillPostSizes(post *types.TPostInfo) {
+	post.Preview_width, post.Preview_height = scaleToFit(post.Width, post.Height, PreviewSize)
+
+	if post.Has_sample {
+		post.Sample_width, post.Sample_height = post.Width, post.Height
+		if post.Sample_width > SampleSize {
+			post.Sample_width, post.Sample_height = SampleSize, post.Height * SampleSize / post.Width
+		}
+	} else {
+		post.Sample_url = post.File_url
+		post.Sample_width, post.Sample_height = post.Width, post.Height
+	}
+}
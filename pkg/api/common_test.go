@@ -1,11 +1,18 @@
 package api
 
 import (
+	apitest "github.com/thewug/fsb/pkg/api/test"
+
 	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/fsb/metrics"
 
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
-	"errors"
 )
 
 func Test_SanitizeRating(t *testing.T) {
@@ -94,3 +101,76 @@ func Test_LocationToURL(t *testing.T) {
 		}
 	})
 }
+
+func Test_FillPostURLs(t *testing.T) {
+	md5 := "0123456789abcdef0123456789abcdef"
+	static := "https://" + StaticPrefix + Endpoint + "/data/"
+	testcases := map[string]struct{
+		input types.TPostInfo
+		expected types.TPostInfo
+	}{
+		"small": {
+			types.TPostInfo{TPostFile: types.TPostFile{Md5: md5, File_ext: "png", Width: 100, Height: 50}},
+			types.TPostInfo{
+				TPostFile: types.TPostFile{Md5: md5, File_ext: "png", Width: 100, Height: 50, File_url: static + "01/23/" + md5 + ".png"},
+				TPostPreview: types.TPostPreview{Preview_url: static + "preview/01/23/" + md5 + ".jpg", Preview_width: 100, Preview_height: 50},
+				TPostSample: types.TPostSample{Sample_url: static + "01/23/" + md5 + ".png", Sample_width: 100, Sample_height: 50},
+			},
+		},
+		"sampled": {
+			types.TPostInfo{TPostFile: types.TPostFile{Md5: md5, File_ext: "webm", Width: 1000, Height: 2000}, TPostSample: types.TPostSample{Has_sample: true}},
+			types.TPostInfo{
+				TPostFile: types.TPostFile{Md5: md5, File_ext: "webm", Width: 1000, Height: 2000, File_url: static + "01/23/" + md5 + ".webm"},
+				TPostPreview: types.TPostPreview{Preview_url: static + "preview/01/23/" + md5 + ".jpg", Preview_width: 75, Preview_height: 150},
+				TPostSample: types.TPostSample{Sample_url: static + "sample/01/23/" + md5 + ".jpg", Sample_width: 850, Sample_height: 1700, Has_sample: true},
+			},
+		},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := v.input
+			FillPostURLs(&out)
+			if !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %+v, expected %+v", out, v.expected) }
+		})
+	}
+}
+
+// the waiting gauge should always come back down to zero, however the request went.
+func Test_WaitingGauge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+
+	// Init replaces the package's globals, which the other tests rely on.
+	old_api, old_backend := api, backend
+	old_name, old_endpoint, old_filtered, old_static := ApiName, Endpoint, FilteredEndpoint, StaticPrefix
+	defer func() {
+		api, backend = old_api, old_backend
+		ApiName, Endpoint, FilteredEndpoint, StaticPrefix = old_name, old_endpoint, old_filtered, old_static
+	}()
+
+	if err := Init(apitest.Settings{Endpoint: strings.TrimPrefix(server.URL, "http://")}); err != nil { t.Fatalf("Couldn't initialize api: %s", err.Error()) }
+
+	waiting := func() string {
+		var buf bytes.Buffer
+		metrics.DefaultRegistry.WriteText(&buf)
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "fsb_api_requests_waiting ") { return strings.TrimPrefix(line, "fsb_api_requests_waiting ") }
+		}
+		return ""
+	}
+
+	for _, path := range []string{"/ok", "/redirect"} {
+		if _, err := api.New(path).Do(); err != nil { t.Errorf("Unexpected error for %s: %s", path, err.Error()) }
+		if w := waiting(); w != "0" { t.Errorf("Unexpected waiting requests after %s: %s", path, w) }
+	}
+
+	server.Close()
+	if _, err := api.New("/ok").Do(); err == nil { t.Errorf("Expected an error from a closed server") }
+	if w := waiting(); w != "0" { t.Errorf("Unexpected waiting requests after a failure: %s", w) }
+}
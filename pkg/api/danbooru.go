@@ -0,0 +1,840 @@
+package api
+
+import (
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"github.com/thewug/reqtify"
+
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DanbooruBackend talks to sites running danbooru (or anything else which exposes danbooru's JSON api).
+// The two are close enough that most calls translate directly, but there are a few differences worth knowing about:
+//   - posts have no change sequence, so post ids stand in for them. this means incremental syncs pick up
+//     new posts, but not edits to old ones.
+//   - tag categories are numbered differently, and there is no species, lore or invalid category.
+//   - both the "general" and "sensitive" ratings are treated as safe, and safe is sent as "sensitive".
+//   - edits send the complete new tag string instead of a diff, and posts only have a single source.
+//   - posts have no description, and edit reasons aren't supported, so both are ignored.
+type DanbooruBackend struct{}
+
+// the most results danbooru will return from a single listing request.
+const danbooruPostLimit = 200
+const danbooruListLimit = 1000
+
+const danbooruTCMeta = 5
+
+type danbooruPost struct {
+	Id                int    `json:"id"`
+	UploaderId        int    `json:"uploader_id"`
+	Score             int    `json:"score"`
+	UpScore           int    `json:"up_score"`
+	DownScore         int    `json:"down_score"`
+	FavCount          int    `json:"fav_count"`
+	Rating            string `json:"rating"`
+	Source            string `json:"source"`
+	Md5               string `json:"md5"`
+	FileExt           string `json:"file_ext"`
+	FileSize          int    `json:"file_size"`
+	ImageWidth        int    `json:"image_width"`
+	ImageHeight       int    `json:"image_height"`
+	FileUrl           string `json:"file_url"`
+	LargeFileUrl      string `json:"large_file_url"`
+	PreviewFileUrl    string `json:"preview_file_url"`
+	HasLarge          bool   `json:"has_large"`
+	ParentId         *int    `json:"parent_id"`
+	HasChildren       bool   `json:"has_children"`
+	HasActiveChildren bool   `json:"has_active_children"`
+	IsPending         bool   `json:"is_pending"`
+	IsFlagged         bool   `json:"is_flagged"`
+	IsDeleted         bool   `json:"is_deleted"`
+	IsNoteLocked      bool   `json:"is_note_locked"`
+	IsRatingLocked    bool   `json:"is_rating_locked"`
+	IsStatusLocked    bool   `json:"is_status_locked"`
+
+	TagString          string `json:"tag_string"`
+	TagStringGeneral   string `json:"tag_string_general"`
+	TagStringArtist    string `json:"tag_string_artist"`
+	TagStringCopyright string `json:"tag_string_copyright"`
+	TagStringCharacter string `json:"tag_string_character"`
+	TagStringMeta      string `json:"tag_string_meta"`
+}
+
+func danbooruRating(rating string) types.PostRating {
+	switch rating {
+	case "g", "s":
+		return types.Safe
+	case "q":
+		return types.Questionable
+	case "e":
+		return types.Explicit
+	}
+	return types.Original
+}
+
+func (this danbooruPost) PostInfo() types.TPostInfo {
+	post := types.TPostInfo{
+		TPostScore: types.TPostScore{Upvotes: this.UpScore, Downvotes: this.DownScore, Score: this.Score},
+		TPostFile: types.TPostFile{Width: this.ImageWidth, Height: this.ImageHeight, File_ext: this.FileExt, File_size: this.FileSize, File_url: this.FileUrl, Md5: this.Md5},
+		TPostPreview: types.TPostPreview{Preview_url: this.PreviewFileUrl},
+		TPostFlags: types.TPostFlags{Pending: this.IsPending, Flagged: this.IsFlagged, Locked_notes: this.IsNoteLocked, Locked_status: this.IsStatusLocked, Locked_rating: this.IsRatingLocked, Deleted: this.IsDeleted},
+		TPostRelationships: types.TPostRelationships{Has_children: this.HasChildren, Has_active_children: this.HasActiveChildren},
+		TPostTags: types.TPostTags{
+			General: strings.Fields(this.TagStringGeneral),
+			Species: []string{},
+			Character: strings.Fields(this.TagStringCharacter),
+			Copyright: strings.Fields(this.TagStringCopyright),
+			Artist: strings.Fields(this.TagStringArtist),
+			Invalid: []string{},
+			Lore: []string{},
+			Meta: strings.Fields(this.TagStringMeta),
+		},
+		Id: this.Id,
+		Creator_id: this.UploaderId,
+		Change: this.Id,
+		Fav_count: this.FavCount,
+		Rating: danbooruRating(this.Rating),
+	}
+
+	if this.ParentId != nil { post.Parent_id = *this.ParentId }
+	if this.Source != "" { post.Sources = []string{this.Source} }
+
+	post.Preview_width, post.Preview_height = scaleToFit(this.ImageWidth, this.ImageHeight, PreviewSize)
+	if this.HasLarge && this.LargeFileUrl != "" && this.LargeFileUrl != this.FileUrl {
+		post.Has_sample = true
+		post.Sample_url = this.LargeFileUrl
+		post.Sample_width, post.Sample_height = this.ImageWidth, this.ImageHeight
+		if post.Sample_width > SampleSize {
+			post.Sample_width, post.Sample_height = SampleSize, this.ImageHeight * SampleSize / this.ImageWidth
+		}
+	} else {
+		post.Sample_url = this.FileUrl
+		post.Sample_width, post.Sample_height = this.ImageWidth, this.ImageHeight
+	}
+
+	return post
+}
+
+// danbooru keeps originals, samples and previews in separate directories on its static host, with samples
+// named after the original's md5.
+func (DanbooruBackend) FillPostURLs(post *types.TPostInfo) {
+	if len(post.Md5) < 4 { return }
+
+	dirs := fmt.Sprintf("%s/%s", post.Md5[0:2], post.Md5[2:4])
+	static := fmt.Sprintf("https://%s%s", StaticPrefix, Endpoint)
+
+	post.File_url = fmt.Sprintf("%s/original/%s/%s.%s", static, dirs, post.Md5, post.File_ext)
+	post.Preview_url = fmt.Sprintf("%s/preview/%s/%s.jpg", static, dirs, post.Md5)
+	if post.Has_sample { post.Sample_url = fmt.Sprintf("%s/sample/%s/sample-%s.jpg", static, dirs, post.Md5) }
+	fillPostSizes(post)
+}
+
+// rewrites the parts of a search query which refer to the change sequence to use the post id instead, since danbooru
+// doesn't have one. everything else is passed through untouched.
+func danbooruQuery(query string) string {
+	terms := strings.Fields(query)
+	for i, t := range terms {
+		lower := strings.ToLower(t)
+		if lower == "order:change_asc" {
+			terms[i] = "order:id_asc"
+		} else if lower == "order:change" || lower == "order:change_desc" {
+			terms[i] = "order:id_desc"
+		} else if strings.HasPrefix(lower, "change:") {
+			terms[i] = "id:" + t[len("change:"):]
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func danbooruTagCategory(category types.TagCategory) types.TagCategory {
+	if category == danbooruTCMeta { return types.TCMeta }
+	return category
+}
+
+// returns false if there is no equivalent category on danbooru.
+func toDanbooruTagCategory(category types.TagCategory) (int, bool) {
+	switch category {
+	case types.TCGeneral, types.TCArtist, types.TCCopyright, types.TCCharacter:
+		return category.Value(), true
+	case types.TCMeta:
+		return danbooruTCMeta, true
+	}
+	return 0, false
+}
+
+// danbooru limits how many results a listing call can return, so larger requests are split into several smaller
+// ones. fetch is called with the page and size of each piece, and returns the ids it got back, which are used to
+// pick the next page. listing stops once at least limit results have been fetched or a short page comes back, so
+// callers should trim anything past the limit.
+func danbooruPaginate(page types.PageSelector, limit, max int, fetch func(page types.PageSelector, limit int) ([]int, error)) error {
+	chunk := limit
+	if limit == 0 || limit > max { chunk = max }
+
+	// numbered pages have to be renumbered to the smaller size, which only works if they still line up.
+	if page.Page != nil && chunk != limit {
+		offset := (*page.Page - 1) * limit
+		for offset % chunk != 0 { chunk-- }
+		page = types.Page(offset / chunk + 1)
+	}
+
+	for total := 0; ; {
+		size := chunk
+		if page.Page == nil && limit != 0 && limit - total < size { size = limit - total }
+
+		ids, err := fetch(page, size)
+		if err != nil { return err }
+
+		total += len(ids)
+		if limit == 0 || len(ids) < size || total >= limit { return nil }
+
+		if page.After != nil {
+			highest := ids[0]
+			for _, id := range ids { if id > highest { highest = id } }
+			page = types.After(highest)
+		} else if page.Before != nil {
+			lowest := ids[0]
+			for _, id := range ids { if id < lowest { lowest = id } }
+			page = types.Before(lowest)
+		} else if page.Page != nil {
+			page = types.Page(*page.Page + 1)
+		} else {
+			page = types.Page(2)
+		}
+	}
+}
+
+func (this DanbooruBackend) TestLogin(user, apitoken string) (*types.TUserInfo, bool, error) {
+	url := "/profile.json"
+
+	var profile types.TUserInfo
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&profile).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, false, e
+	}
+
+	// a bad login is reported as an error, or as the anonymous user, so fall back to looking the user up normally.
+	if r.StatusCode != 200 || profile.Id == 0 || strings.ToLower(profile.Name) != strings.ToLower(user) {
+		u, err := this.FetchUser(user, "")
+		return u, false, err
+	}
+
+	return &profile, true, nil
+}
+
+func (DanbooruBackend) ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagInfoArray, error) {
+	url := "/tags.json"
+
+	var category *int
+	if options.Category != nil {
+		c, ok := toDanbooruTagCategory(*options.Category)
+		if !ok { return nil, nil }
+		category = &c
+	}
+
+	var out types.TTagInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruListLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results types.TTagInfoArray
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				URLArgDefault("page", page, "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("search[name_matches]", options.MatchTags, "").
+				URLArgDefault("search[order]", options.Order.String(), "").
+				URLArg("search[category]", category).
+				URLArg("search[hide_empty]", options.HideEmpty).
+				URLArg("search[has_wiki]", options.HasWiki).
+				URLArg("search[has_artist]", options.HasArtist).
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, t := range results {
+			t.Type = danbooruTagCategory(t.Type)
+			out = append(out, t)
+			ids = append(ids, t.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (types.TAliasInfoArray, error) {
+	url := "/tag_aliases.json"
+
+	var out types.TAliasInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruListLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results types.TAliasInfoArray
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				URLArgDefault("page", page, "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("search[name_matches]", options.MatchAliases, "").
+				URLArgDefault("search[status]", strings.ToLower(options.Status.String()), "").
+				URLArgDefault("search[order]", options.Order.String(), "").
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, a := range results {
+			out = append(out, a)
+			ids = append(ids, a.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) ListTagImplications(user, apitoken string, options types.ListTagImplicationOptions) (types.TImplicationInfoArray, error) {
+	url := "/tag_implications.json"
+
+	var out types.TImplicationInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruListLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results types.TImplicationInfoArray
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				URLArgDefault("page", page, "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("search[name_matches]", options.MatchImplications, "").
+				URLArgDefault("search[status]", strings.ToLower(options.Status.String()), "").
+				URLArgDefault("search[order]", options.Order.String(), "").
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, i := range results {
+			out = append(out, i)
+			ids = append(ids, i.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPostInfoArray, error) {
+	url := "/posts.json"
+
+	var out types.TPostInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruPostLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results []danbooruPost
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				URLArgDefault("tags", danbooruQuery(options.SearchQuery), "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("page", page, "").
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, p := range results {
+			out = append(out, p.PostInfo())
+			ids = append(ids, p.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) fetchPost(user, apitoken string, id int) (*danbooruPost, error) {
+	url := fmt.Sprintf("/posts/%d.json", id)
+
+	var post danbooruPost
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&post).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if !((r.StatusCode >= 200 && r.StatusCode < 300) || r.StatusCode == 404) {
+		return nil, errors.New(r.Status)
+	}
+
+	if post.Id != 0 { return &post, nil }
+	return nil, nil
+}
+
+func (DanbooruBackend) ListPools(user, apitoken string, options types.ListPoolOptions) (types.TPoolInfoArray, error) {
+	url := "/pools.json"
+
+	var out types.TPoolInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruListLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results types.TPoolInfoArray
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				URLArgDefault("page", page, "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("search[name_matches]", options.MatchName, "").
+				URLArgDefault("search[category]", options.Category.String(), "").
+				URLArgDefault("search[order]", options.Order.String(), "").
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, p := range results {
+			out = append(out, p)
+			ids = append(ids, p.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) FetchPool(user, apitoken string, id int) (*types.TPoolData, error) {
+	url := fmt.Sprintf("/pools/%d.json", id)
+
+	var pool types.TPoolData
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&pool).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if !((r.StatusCode >= 200 && r.StatusCode < 300) || r.StatusCode == 404) {
+		return nil, errors.New(r.Status)
+	}
+
+	if pool.Id != 0 { return &pool, nil }
+	return nil, nil
+}
+
+func (this DanbooruBackend) FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error) {
+	post, err := this.fetchPost(user, apitoken, id)
+	if post == nil || err != nil { return nil, err }
+	info := post.PostInfo()
+	return &info, nil
+}
+
+// danbooru only says who wrote a comment if you ask for the whole user.
+type danbooruComment struct {
+	types.TCommentData
+	IsDeleted bool `json:"is_deleted"`
+	Creator   struct {
+		Name string `json:"name"`
+	} `json:"creator"`
+}
+
+func (this danbooruComment) CommentData() types.TCommentData {
+	comment := this.TCommentData
+	comment.CreatorName = this.Creator.Name
+	comment.IsHidden = comment.IsHidden || this.IsDeleted
+	return comment
+}
+
+const danbooruCommentFields = "id,post_id,creator_id,body,score,created_at,is_deleted,creator"
+
+func (DanbooruBackend) ListComments(user, apitoken string, options types.ListCommentOptions) (types.TCommentInfoArray, error) {
+	url := "/comments.json"
+
+	var out types.TCommentInfoArray
+
+	err := danbooruPaginate(options.Page, options.Limit, danbooruListLimit, func(page types.PageSelector, limit int) ([]int, error) {
+		var results []danbooruComment
+
+		r, e := api.New(url).
+				BasicAuthentication(user, apitoken).
+				Arg("group_by", "comment").
+				Arg("only", danbooruCommentFields).
+				URLArgDefault("page", page, "").
+				URLArgDefault("limit", limit, 0).
+				URLArgDefault("search[post_id]", options.PostId, 0).
+				JSONInto(&results).
+				Do()
+
+		APILog(url, user, len(results), r, e)
+
+		if e != nil { return nil, e }
+		if r.StatusCode != 200 { return nil, errors.New(r.Status) }
+
+		var ids []int
+		for _, c := range results {
+			out = append(out, c.CommentData())
+			ids = append(ids, c.Id)
+		}
+		return ids, nil
+	})
+
+	if err != nil { return nil, err }
+	if options.Limit != 0 && len(out) > options.Limit { out = out[:options.Limit] }
+	return out, nil
+}
+
+func (DanbooruBackend) GetTagData(user, apitoken string, id int) (*types.TTagData, error) {
+	url := fmt.Sprintf("/tags/%d.json", id)
+
+	var tag types.TTagData
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&tag).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode != 200 {
+		return nil, errors.New(r.Status)
+	}
+
+	tag.Type = danbooruTagCategory(tag.Type)
+	return &tag, nil
+}
+
+func (DanbooruBackend) FetchUser(username, api_key string) (*types.TUserInfo, error) {
+	url := "/users.json"
+
+	var user types.TUserInfoArray
+
+	req := api.New(url).
+			Arg("search[name]", username).
+			JSONInto(&user)
+
+	if api_key != "" {
+		req.BasicAuthentication(username, api_key)
+	}
+
+	r, e := req.Do()
+
+	APILog(url, username, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	} else if r.StatusCode != 200 {
+		return nil, errors.New(r.Status)
+	} else if len(user) == 0 {
+		return nil, nil
+	} else if len(user) > 1 {
+		return nil, errors.New("Got wrong number of users?")
+	} else if strings.ToLower(user[0].Name) != strings.ToLower(username) {
+		return nil, errors.New("Got non-matching user?")
+	}
+
+	return &user[0], nil
+}
+
+func (DanbooruBackend) UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating types.PostRating, source, description string, parent *int, user, apitoken string) (*UploadCallResult, error) {
+	url := "/uploads.json"
+
+	var upload struct {
+		Id     int    `json:"id"`
+		Status string `json:"status"`
+		PostId int    `json:"post_id"`
+	}
+
+	req := api.New(url).
+			Method(reqtify.POST).
+			BasicAuthentication(user, apitoken).
+			FormArg("upload[tag_string]", tags.String()).
+			FormArg("upload[rating]", string(rating)).
+			JSONInto(&upload).
+			Multipart()
+	if parent != nil { req.FormArg("upload[parent_id]", strconv.Itoa(*parent)) }
+
+	if upload_url == "" && file_data != nil {
+		req.FileArg("upload[file]", "post.file", file_data)
+		req.FormArg("upload[source]", source)
+	} else if upload_url != "" && file_data == nil {
+		// danbooru downloads the file from the source, so there's nowhere to put a different one.
+		req.FormArg("upload[source]", upload_url)
+	} else { return nil, MissingArguments }
+
+	r, e := req.Do()
+	APILog(url, user, -1, r, e)
+
+	out := UploadCallResult{}
+	if r != nil {
+		out.Status, out.StatusCode = r.Status, r.StatusCode
+	}
+
+	// uploads are processed in the background, so the post might not exist yet. in that case, point at the upload instead.
+	if upload.PostId != 0 {
+		location := fmt.Sprintf("/posts/%d", upload.PostId)
+		out.Success, out.Location = true, &location
+	} else if strings.HasPrefix(upload.Status, "error") {
+		out.Reason = &upload.Status
+	} else if upload.Id != 0 {
+		location := fmt.Sprintf("/uploads/%d", upload.Id)
+		out.Success, out.Location = true, &location
+	}
+
+	return &out, e
+}
+
+func (this DanbooruBackend) UpdatePost(user, apitoken string,
+		id int,
+		tagdiff tags.TagDiff,
+		rating types.PostRating,
+		parent *int,
+		sourcediff []string,
+		description *string,
+		reason *string) (*types.TPostInfo, error) {
+	url := fmt.Sprintf("/posts/%d.json", id)
+
+	// danbooru wants the whole tag string and source, so they have to be worked out from the current post.
+	current, err := this.fetchPost(user, apitoken, id)
+	if err != nil { return nil, err }
+	if current == nil { return nil, PostIsDeleted }
+
+	var post danbooruPost
+
+	req := api.New(url).
+			Method(reqtify.PUT).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&post)
+	if !tagdiff.IsZero() {
+		var tagset tags.TagSet
+		tagset.ApplyString(current.TagString)
+		tagset.ApplyDiff(tagdiff)
+		req.FormArg("post[old_tag_string]", current.TagString)
+		req.FormArg("post[tag_string]", tagset.String())
+	}
+	if rating != types.Original { req.FormArgDefault("post[rating]", string(rating), string(types.Original)) }
+	if parent != nil && *parent == -1 { req.FormArg("post[parent_id]", "") }
+	if parent != nil && *parent != -1 { req.FormArg("post[parent_id]", strconv.Itoa(*parent)) }
+	if sourcediff != nil {
+		var sources []string
+		if current.Source != "" { sources = append(sources, current.Source) }
+		for _, s := range sourcediff {
+			if strings.HasPrefix(s, "-") {
+				var kept []string
+				for _, x := range sources { if x != s[1:] { kept = append(kept, x) } }
+				sources = kept
+			} else if s != "" {
+				sources = append(sources, s)
+			}
+		}
+		// only one source fits, so the most recently added one wins.
+		source := ""
+		if len(sources) != 0 { source = sources[len(sources) - 1] }
+		req.FormArg("post[source]", source)
+	}
+	r, e := req.Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode == 403 || r.StatusCode == 404 {
+		return nil, PostIsDeleted
+	} else if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
+	info := post.PostInfo()
+	return &info, nil
+}
+
+// danbooru never toggles a vote off when voting the same way twice, so no_unvote doesn't need to do anything.
+func (this DanbooruBackend) VotePost(user, apitoken string,
+		id int,
+		vote types.PostVote,
+		no_unvote bool) (*types.TPostScore, error) {
+	url := fmt.Sprintf("/posts/%d/votes.json", id)
+
+	r, e := api.New(url).
+			Method(reqtify.POST).
+			BasicAuthentication(user, apitoken).
+			FormArg("score", vote.Value()).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
+	// the vote itself doesn't include the post's new score, so go get it.
+	post, e := this.fetchPost(user, apitoken, id)
+	if e != nil { return nil, e }
+	if post == nil { return nil, errors.New("Post disappeared after voting?") }
+
+	return &types.TPostScore{Upvotes: post.UpScore, Downvotes: post.DownScore, Score: post.Score, OurScore: vote}, nil
+}
+
+func (DanbooruBackend) UnvotePost(user, apitoken string,
+		id int) (error) {
+	url := fmt.Sprintf("/posts/%d/votes.json", id)
+
+	r, e := api.New(url).
+			Method(reqtify.DELETE).
+			BasicAuthentication(user, apitoken).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return e
+	}
+
+	r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return errors.New(r.Status)
+	}
+
+	return nil
+}
+
+func (DanbooruBackend) FavoritePost(user, apitoken string,
+		id int) (*types.TPostInfo, error) {
+	url := "/favorites.json"
+
+	var post danbooruPost
+
+	r, e := api.New(url).
+		Method(reqtify.POST).
+		BasicAuthentication(user, apitoken).
+		FormArg("post_id", id).
+		JSONInto(&post).
+		Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	// this means the post was already favorited, which the api treats as an error, but we want to treat it as OK
+	if r.StatusCode == 422 {
+		return nil, nil
+	} else if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
+	if post.Id == 0 { return nil, nil }
+	info := post.PostInfo()
+	return &info, nil
+}
+
+func (DanbooruBackend) UnfavoritePost(user, apitoken string,
+		id int) (error) {
+	url := fmt.Sprintf("/favorites/%d.json", id)
+
+	r, e := api.New(url).
+		Method(reqtify.DELETE).
+		BasicAuthentication(user, apitoken).
+		Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return e
+	}
+
+	r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return errors.New(r.Status)
+	}
+
+	return nil
+}
+
+func (DanbooruBackend) CreateComment(user, apitoken string,
+		post_id int,
+		body string) (*types.TCommentData, error) {
+	url := "/comments.json"
+
+	var comment danbooruComment
+
+	r, e := api.New(url).
+		Method(reqtify.POST).
+		BasicAuthentication(user, apitoken).
+		FormArg("comment[post_id]", post_id).
+		FormArg("comment[body]", body).
+		JSONInto(&comment).
+		Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
+	out := comment.CommentData()
+	return &out, nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"testing"
+	"reflect"
+	"encoding/json"
+
+	"github.com/thewug/fsb/pkg/api/types"
+)
+
+func TestDanbooruQuery(t *testing.T) {
+	testcases := map[string]struct{
+		query, expected string
+	}{
+		"empty": {"", ""},
+		"passthrough": {"cat -dog rating:s", "cat -dog rating:s"},
+		"sync": {types.PostsAfterChangeSeq(1234), "status:any order:id_asc id:>1234"},
+		"descending": {"order:change_desc cat", "order:id_desc cat"},
+		"case": {"ORDER:CHANGE_ASC Change:5", "order:id_asc id:5"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := danbooruQuery(v.query)
+			if out != v.expected { t.Errorf("\nExpected: %s\nActual:   %s\n", v.expected, out) }
+		})
+	}
+}
+
+func TestDanbooruPostInfo(t *testing.T) {
+	var post danbooruPost
+	err := json.Unmarshal([]byte(`{"id":4321,"uploader_id":7,"score":10,"up_score":12,"down_score":-2,"fav_count":20,"rating":"g","source":"https://example.com/art","md5":"0123456789abcdef0123456789abcdef","file_ext":"png","file_size":1000,"image_width":1700,"image_height":1000,"file_url":"https://example.com/file.png","large_file_url":"https://example.com/sample.jpg","preview_file_url":"https://example.com/preview.jpg","has_large":true,"parent_id":99,"has_children":false,"has_active_children":false,"is_pending":false,"is_flagged":false,"is_deleted":true,"tag_string":"artist_name cat meta_tag","tag_string_general":"cat","tag_string_artist":"artist_name","tag_string_copyright":"","tag_string_character":"","tag_string_meta":"meta_tag"}`), &post)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+	expected := types.TPostInfo{
+		TPostScore: types.TPostScore{Upvotes: 12, Downvotes: -2, Score: 10},
+		TPostFile: types.TPostFile{Width: 1700, Height: 1000, File_ext: "png", File_size: 1000, File_url: "https://example.com/file.png", Md5: "0123456789abcdef0123456789abcdef"},
+		TPostPreview: types.TPostPreview{Preview_width: 150, Preview_height: 88, Preview_url: "https://example.com/preview.jpg"},
+		TPostSample: types.TPostSample{Sample_width: 850, Sample_height: 500, Sample_url: "https://example.com/sample.jpg", Has_sample: true},
+		TPostFlags: types.TPostFlags{Deleted: true},
+		TPostRelationships: types.TPostRelationships{Parent_id: 99},
+		TPostTags: types.TPostTags{
+			General: []string{"cat"},
+			Species: []string{},
+			Character: []string{},
+			Copyright: []string{},
+			Artist: []string{"artist_name"},
+			Invalid: []string{},
+			Lore: []string{},
+			Meta: []string{"meta_tag"},
+		},
+		Id: 4321, Creator_id: 7, Change: 4321, Fav_count: 20, Rating: types.Safe, Sources: []string{"https://example.com/art"},
+	}
+
+	if out := post.PostInfo(); !reflect.DeepEqual(out, expected) {
+		t.Errorf("\nExpected: %+v\nActual:   %+v\n", expected, out)
+	}
+}
+
+func TestDanbooruFillPostURLs(t *testing.T) {
+	md5 := "0123456789abcdef0123456789abcdef"
+	static := "https://" + StaticPrefix + Endpoint + "/"
+	post := types.TPostInfo{TPostFile: types.TPostFile{Md5: md5, File_ext: "png", Width: 1700, Height: 1000}, TPostSample: types.TPostSample{Has_sample: true}}
+	expected := types.TPostInfo{
+		TPostFile: types.TPostFile{Md5: md5, File_ext: "png", Width: 1700, Height: 1000, File_url: static + "original/01/23/" + md5 + ".png"},
+		TPostPreview: types.TPostPreview{Preview_url: static + "preview/01/23/" + md5 + ".jpg", Preview_width: 150, Preview_height: 88},
+		TPostSample: types.TPostSample{Sample_url: static + "sample/01/23/sample-" + md5 + ".jpg", Sample_width: 850, Sample_height: 500, Has_sample: true},
+	}
+
+	DanbooruBackend{}.FillPostURLs(&post)
+	if !reflect.DeepEqual(post, expected) { t.Errorf("\nExpected: %+v\nActual:   %+v\n", expected, post) }
+}
+
+func TestDanbooruCommentData(t *testing.T) {
+	var comment danbooruComment
+	err := json.Unmarshal([]byte(`{"id":12,"post_id":4321,"creator_id":7,"body":"[b]hi[/b]","score":2,"created_at":"2020-01-01T00:00:00.000-05:00","is_deleted":true,"creator":{"id":7,"name":"someone"}}`), &comment)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+	expected := types.TCommentData{Id: 12, PostId: 4321, CreatorId: 7, CreatorName: "someone", Body: "[b]hi[/b]", Score: 2, CreatedAt: "2020-01-01T00:00:00.000-05:00", IsHidden: true}
+	if out := comment.CommentData(); out != expected {
+		t.Errorf("\nExpected: %+v\nActual:   %+v\n", expected, out)
+	}
+}
+
+func TestDanbooruPaginate(t *testing.T) {
+	testcases := map[string]struct{
+		page types.PageSelector
+		limit int
+		available int
+		expected []string
+	}{
+		"unlimited": {types.PageSelector{}, 0, 500, []string{":200"}},
+		"small": {types.PageSelector{}, 50, 500, []string{":50"}},
+		"split": {types.PageSelector{}, 320, 500, []string{":200", "2:200"}},
+		"short": {types.PageSelector{}, 320, 150, []string{":200"}},
+		"numbered": {types.Page(3), 300, 5000, []string{"4:200", "5:200"}},
+		"misaligned": {types.Page(2), 300, 5000, []string{"3:150", "4:150"}},
+		"after": {types.After(1000), 320, 5000, []string{"a1000:200", "a1200:120"}},
+		"before": {types.Before(1000), 320, 5000, []string{"b1000:200", "b800:120"}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var requests []string
+			err := danbooruPaginate(v.page, v.limit, 200, func(page types.PageSelector, limit int) ([]int, error) {
+				requests = append(requests, page.String() + ":" + types.Page(limit).String())
+
+				// pretend the site has ids 1 through available, and hands them out in the order asked for.
+				var ids []int
+				for i := 0; i < limit; i++ {
+					var id int
+					if page.After != nil {
+						id = *page.After + 1 + i
+					} else if page.Before != nil {
+						id = *page.Before - 1 - i
+					} else {
+						p := 1
+						if page.Page != nil { p = *page.Page }
+						id = (p - 1) * limit + i + 1
+					}
+					if id < 1 || id > v.available { break }
+					ids = append(ids, id)
+				}
+				return ids, nil
+			})
+
+			if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+			if !reflect.DeepEqual(requests, v.expected) { t.Errorf("\nExpected: %v\nActual:   %v\n", v.expected, requests) }
+		})
+	}
+}
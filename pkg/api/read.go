@@ -12,14 +12,14 @@ type FailedCall struct {
 	Success bool `json:"success"`
 }
 
-func TestLogin(user, apitoken string) (*types.TUserInfo, bool, error) {
-	u, err := FetchUser(user, apitoken)
+func (this E621Backend) TestLogin(user, apitoken string) (*types.TUserInfo, bool, error) {
+	u, err := this.FetchUser(user, apitoken)
 	if err != nil { return nil, false, err }
 	// email is only populated if we are logged into the account we are querying.
 	return u, (u != nil && u.Email != ""), nil
 }
 
-func ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagInfoArray, error) {
+func (E621Backend) ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagInfoArray, error) {
 	url := "/tags.json"
 
 	var results types.TTagListing
@@ -50,7 +50,7 @@ func ListTags(user, apitoken string, options types.ListTagsOptions) (types.TTagI
 	return results.Tags, nil
 }
 
-func ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (types.TAliasInfoArray, error) {
+func (E621Backend) ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (types.TAliasInfoArray, error) {
 	url := "/tag_aliases.json"
 
 	var results types.TAliasListing
@@ -78,7 +78,35 @@ func ListTagAliases(user, apitoken string, options types.ListTagAliasOptions) (t
 	return results.Aliases, nil
 }
 
-func ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPostInfoArray, error) {
+func (E621Backend) ListTagImplications(user, apitoken string, options types.ListTagImplicationOptions) (types.TImplicationInfoArray, error) {
+	url := "/tag_implications.json"
+
+	var results types.TImplicationListing
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			URLArgDefault("page", options.Page, "").
+			URLArgDefault("limit", options.Limit, 0).
+			URLArgDefault("search[name_matches]", options.MatchImplications, "").
+			URLArgDefault("search[status]", options.Status, "").
+			URLArgDefault("search[order]", options.Order, "").
+			JSONInto(&results).
+			Do()
+
+	APILog(url, user, len(results.Implications), r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode != 200 {
+		return nil, errors.New(r.Status)
+	}
+
+	return results.Implications, nil
+}
+
+func (E621Backend) ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPostInfoArray, error) {
 	url := "/posts.json"
 
 	var results types.TPostListing
@@ -105,7 +133,59 @@ func ListPosts(user, apitoken string, options types.ListPostOptions) (types.TPos
 }
 
 
-func FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error) {
+func (E621Backend) ListPools(user, apitoken string, options types.ListPoolOptions) (types.TPoolInfoArray, error) {
+	url := "/pools.json"
+
+	var results types.TPoolListing
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			URLArgDefault("page", options.Page, "").
+			URLArgDefault("limit", options.Limit, 0).
+			URLArgDefault("search[name_matches]", options.MatchName, "").
+			URLArgDefault("search[category]", options.Category.String(), "").
+			URLArgDefault("search[order]", options.Order.String(), "").
+			JSONInto(&results).
+			Do()
+
+	APILog(url, user, len(results.Pools), r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode != 200 {
+		return nil, errors.New(r.Status)
+	}
+
+	return results.Pools, nil
+}
+
+func (E621Backend) FetchPool(user, apitoken string, id int) (*types.TPoolData, error) {
+	url := fmt.Sprintf("/pools/%d.json", id)
+
+	var pool types.TPoolData
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			JSONInto(&pool).
+			Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if !((r.StatusCode >= 200 && r.StatusCode < 300) || r.StatusCode == 404) {
+		return nil, errors.New(r.Status)
+	}
+
+	if pool.Id != 0 { return &pool, nil }
+	return nil, nil
+}
+
+func (E621Backend) FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error) {
 	url := fmt.Sprintf("/posts/%d.json", id)
 
 	var post struct {
@@ -131,7 +211,34 @@ func FetchOnePost(user, apitoken string, id int) (*types.TPostInfo, error) {
 	return nil, nil
 }
 
-func GetTagData(user, apitoken string, id int) (*types.TTagData, error) {
+func (E621Backend) ListComments(user, apitoken string, options types.ListCommentOptions) (types.TCommentInfoArray, error) {
+	url := "/comments.json"
+
+	var results types.TCommentListing
+
+	r, e := api.New(url).
+			BasicAuthentication(user, apitoken).
+			Arg("group_by", "comment").
+			URLArgDefault("page", options.Page, "").
+			URLArgDefault("limit", options.Limit, 0).
+			URLArgDefault("search[post_id]", options.PostId, 0).
+			JSONInto(&results).
+			Do()
+
+	APILog(url, user, len(results.Comments), r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode != 200 {
+		return nil, errors.New(r.Status)
+	}
+
+	return results.Comments, nil
+}
+
+func (E621Backend) GetTagData(user, apitoken string, id int) (*types.TTagData, error) {
 	url := fmt.Sprintf("/tags/%d.json", id)
 
 	var tag types.TTagData
@@ -154,7 +261,7 @@ func GetTagData(user, apitoken string, id int) (*types.TTagData, error) {
 	return &tag, nil
 }
 
-func FetchUser(username, api_key string) (*types.TUserInfo, error) {
+func (E621Backend) FetchUser(username, api_key string) (*types.TUserInfo, error) {
 	url := "/users.json"
 
 	var user types.TUserInfoArray
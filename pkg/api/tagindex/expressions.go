@@ -12,6 +12,7 @@ import (
 
 type TagExpression interface {
     SerializeReal(buf *bytes.Buffer, index *int, tokens map[string]string)
+    String() string
 }
 
 func Serialize(this TagExpression) (string, map[string]string) {
@@ -39,6 +40,13 @@ func (this *TETag) SerializeReal(buf *bytes.Buffer, index *int, tokens map[strin
     buf.WriteString(fmt.Sprintf("((SELECT {{.tag_id}} FROM {{.tag_index}} WHERE {{.tag_name}} = {{.%s}}) IN (SELECT * FROM {{.temp}}) IS %s TRUE)", token, expects))
 }
 
+func (this *TETag) String() string {
+    if this.negate {
+        return "-" + this.tag
+    }
+    return this.tag
+}
+
 type TENegate struct {
     sub_exp TagExpression
 }
@@ -49,6 +57,16 @@ func (this *TENegate) SerializeReal(buf *bytes.Buffer, index *int, tokens map[st
     buf.WriteString("))")
 }
 
+func (this *TENegate) String() string {
+    switch sub := this.sub_exp.(type) {
+    case *TEOr:
+        return "-" + sub.String()
+    case *TETag:
+        if !sub.negate { return "-" + sub.String() }
+    }
+    return "-{" + this.sub_exp.String() + "}"
+}
+
 type TEAnd struct {
     sub_exp_1, sub_exp_2 TagExpression
 }
@@ -61,6 +79,10 @@ func (this *TEAnd) SerializeReal(buf *bytes.Buffer, index *int, tokens map[strin
     buf.WriteString("))")
 }
 
+func (this *TEAnd) String() string {
+    return this.sub_exp_1.String() + " " + this.sub_exp_2.String()
+}
+
 type TEOr struct {
     sub_exp_1, sub_exp_2 TagExpression
 }
@@ -73,6 +95,10 @@ func (this *TEOr) SerializeReal(buf *bytes.Buffer, index *int, tokens map[string
     buf.WriteString("))")
 }
 
+func (this *TEOr) String() string {
+    return "{" + this.sub_exp_1.String() + ", " + this.sub_exp_2.String() + "}"
+}
+
 func Tokenize(expression string) ([]string) {
     reader := bufio.NewReader(bytes.NewBuffer([]byte(expression)))
     var out []string
@@ -174,31 +200,24 @@ func ParseExpression(pushdown stack, maxlevel int) (TagExpression, stack) {
 func ParseSubExpression(s stack) (TagExpression, stack) {
     tok := s.pop()
     if tok == nil || *tok != "{" {
-        fmt.Println("subexpression: no dice")
         return nil, nil
     }
-    fmt.Println("subexpression: ", *tok)
     e, ns := ParseExpression(s, 5)
     if e == nil {
-        fmt.Println("subexpression: no dice (subexpression)")
         return nil, nil
     }
     tok = ns.pop()
     if tok == nil || *tok != "}" {
-        fmt.Println("subexpression: no dice")
         return nil, nil
     }
-    fmt.Println("subexpression: ", *tok)
     return e, ns
 }
 
 func ParseLiteral(s stack) (TagExpression, stack) {
     tok := s.pop()
     if tok == nil || *tok == "{" || *tok == "}" || *tok == "," || *tok == "-" || strings.ContainsAny(*tok, "%#*") || strings.HasPrefix(*tok, "~") {
-        fmt.Println("literal: no dice")
         return nil, nil
     }
-    fmt.Println("literal: ", *tok)
     if strings.HasPrefix(*tok, "-") {
         return &TETag{tag: (*tok)[1:], negate: true}, s
     } else {
@@ -209,23 +228,18 @@ func ParseLiteral(s stack) (TagExpression, stack) {
 func ParseNegation(s stack) (TagExpression, stack) {
     tok := s.pop()
     if tok == nil || *tok != "-" {
-        fmt.Println("negation: no dice")
         return nil, nil
     }
-    fmt.Println("negation: ", *tok)
     e, ns := ParseExpression(s, 3)
     if e == nil {
-        fmt.Println("negation: no dice (subexpression)")
         return nil, nil
     }
     return &TENegate{sub_exp: e}, ns
 }
 
 func ParseIntersection(first TagExpression, s stack) (TagExpression, stack) {
-    fmt.Println("intersection: parsing subexpression")
     e, ns := ParseExpression(s, 4)
     if e == nil {
-        fmt.Println("intersection: no dice (subexpression)")
         return nil, nil
     }
     return &TEAnd{sub_exp_1: first, sub_exp_2: e}, ns
@@ -234,14 +248,10 @@ func ParseIntersection(first TagExpression, s stack) (TagExpression, stack) {
 func ParseUnion(first TagExpression, s stack) (TagExpression, stack) {
     tok := s.pop()
     if tok == nil || *tok != "," {
-        fmt.Println("union: no dice")
         return nil, nil
     }
-    fmt.Println("union: ", *tok)
-    fmt.Println("union: parsing subexpression")
     e, ns := ParseExpression(s, 5)
     if e == nil {
-        fmt.Println("union: no dice (subexpression)")
         return nil, nil
     }
     return &TEOr{sub_exp_1: first, sub_exp_2: e}, ns
@@ -0,0 +1,49 @@
+package tagindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParseExpression(t *testing.T) {
+	testcases := map[string]struct{
+		expression string
+		expectedString string
+		expectedTokens map[string]string
+	}{
+		"single": {"canine", "canine", map[string]string{"token0": "canine"}},
+		"negated": {"-canid", "-canid", map[string]string{"token0": "canid"}},
+		"intersection": {"canine -canid", "canine -canid", map[string]string{"token0": "canine", "token1": "canid"}},
+		"union": {"wolf, fox", "{wolf, fox}", map[string]string{"token0": "wolf", "token1": "fox"}},
+		"precedence": {"wolf fox, dog", "{wolf fox, dog}", map[string]string{"token0": "wolf", "token1": "fox", "token2": "dog"}},
+		"grouped": {"{wolf, fox} -canid", "{wolf, fox} -canid", map[string]string{"token0": "wolf", "token1": "fox", "token2": "canid"}},
+		"negated-group": {"-{wolf, fox} canine", "-{wolf, fox} canine", map[string]string{"token0": "wolf", "token1": "fox", "token2": "canine"}},
+		"negated-intersection": {"-{wolf fox}", "-{wolf fox}", map[string]string{"token0": "wolf", "token1": "fox"}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			e := Parse(Tokenize(v.expression))
+			if e == nil { t.Fatalf("Failed to parse expression %q", v.expression) }
+			if e.String() != v.expectedString { t.Errorf("Unexpected result: got %v, expected %v", e.String(), v.expectedString) }
+			_, tokens := Serialize(e)
+			if !reflect.DeepEqual(tokens, v.expectedTokens) { t.Errorf("Unexpected tokens: got %v, expected %v", tokens, v.expectedTokens) }
+		})
+	}
+}
+
+func Test_ParseExpressionInvalid(t *testing.T) {
+	testcases := map[string]string{
+		"unclosed": "{wolf, fox",
+		"unopened": "wolf, fox}",
+		"dangling-union": "wolf,",
+		"wildcard": "wol*",
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			e := Parse(Tokenize(v))
+			if e != nil { t.Errorf("Unexpected result: got %v, expected nil", e.String()) }
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package tagindex
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/imagehash"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"errors"
+	"log"
+	"sync"
+)
+
+// how many previews to download at once while hashing.
+const hashWorkers = 4
+
+// replaceable so tests don't need to download anything.
+var hashPreview = imagehash.FromURL
+
+// HashPostPreviewsInternal computes perceptual hashes for the previews of up to limit posts whose current file hasn't
+// been hashed yet, so they can be found by reverse image search. this downloads every preview, so it shouldn't be done
+// inside a transaction. previews which can't be decoded are skipped, and will be tried again the next time the post's
+// file changes. previews which can't be downloaded are tried again on the next pass. returns how many posts were hashed.
+func HashPostPreviewsInternal(d storage.DBLike, limit int) (int, error) {
+	posts, err := storage.GetPostsWithoutImageHash(d, limit)
+	if err != nil { return 0, err }
+
+	type result struct {
+		post types.TPostInfo
+		hash uint64
+		err error
+	}
+
+	work := make(chan types.TPostInfo)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < hashWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				api.FillPostURLs(&p)
+				hash, err := hashPreview(p.Preview_url)
+				if err != nil { log.Printf("Couldn't hash preview for post #%d: %s", p.Id, err.Error()) }
+				results <- result{post: p, hash: hash, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, p := range posts { work <- p }
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	hashed := 0
+	for r := range results {
+		if err != nil { continue }
+		if r.err != nil {
+			// only previews which can't be hashed are recorded. anything else, like the site having trouble, is tried again.
+			if errors.Is(r.err, imagehash.ErrUnsupported) || errors.Is(r.err, imagehash.ErrUndecodable) {
+				err = storage.SetImageUnhashable(d, r.post.Id, r.post.Md5)
+			}
+		} else if err = storage.SetImageHash(d, r.post.Id, r.post.Md5, r.hash); err == nil {
+			hashed++
+		}
+	}
+
+	return hashed, err
+}
@@ -0,0 +1,22 @@
+package tagindex
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_ProgressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	progress := ProgressWriter(&buf)
+
+	progress.AppendNotice("Syncing <b>tags</b>...")
+	progress.SetStatus("(50%)")
+	progress.SetStatus("")
+	progress.SetMessage("<a href=\"https://example.com\">Post #1</a>: <code>-wolf &amp; fox</code>")
+	progress.Close()
+
+	expected := "Syncing tags...\n(50%)\nPost #1: -wolf & fox\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output: got %q, expected %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,36 @@
+package tagindex
+
+import (
+	"github.com/thewug/fsb/pkg/storage"
+
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_TestReplacerInternal_EmptyMatch(t *testing.T) {
+	var buf bytes.Buffer
+	err := TestReplacerInternal(nil, storage.Replacer{Id: 1, ReplaceSpec: "canid"}, 10, ProgressWriter(&buf))
+	if err == nil || !strings.Contains(err.Error(), "empty match spec") { t.Errorf("Expected an empty match spec error, got %v", err) }
+}
+
+func Test_parseReplacerSpec(t *testing.T) {
+	testcases := map[string]struct{
+		spec, expected, err string
+	}{
+		"add": {"Canid", "canid", ""},
+		"mixed": {"canine -canid", "canine -canid", ""},
+		"empty": {"", "", "empty tag list"},
+		"blank": {"   ", "", "empty tag list"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out, err := parseReplacerSpec(v.spec)
+			if err == nil && v.err != "" || err != nil && (v.err == "" || !strings.Contains(err.Error(), v.err)) {
+				t.Errorf("Unexpected error: got %v, wanted matching %q", err, v.err)
+			}
+			if out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
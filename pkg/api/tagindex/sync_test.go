@@ -0,0 +1,275 @@
+package tagindex
+
+import (
+	apitest "github.com/thewug/fsb/pkg/api/test"
+	dbtest "github.com/thewug/fsb/pkg/storage/test"
+
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/imagehash"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fixturePosts = []int{101, 102, 103, 104, 105}
+
+// starts a fake site for the sync to talk to. these tests also need the test database, and are skipped without it.
+func startSync(t *testing.T) *apitest.FakeBooru {
+	db, err := dbtest.TestDatabase()
+	if err == nil { err = db.Ping() }
+	if err != nil { t.Skipf("Test database not available: %s", err.Error()) }
+	storage.Db_pool = db
+
+	fake := apitest.NewFakeBooru()
+	if err := api.Init(fake.Settings()); err != nil { t.Fatalf("Couldn't initialize api: %s", err.Error()) }
+	apiRetryDelay = time.Millisecond
+
+	// the fake site doesn't serve images, so give every preview a hash made from its url instead.
+	hashPreview = func(url string) (uint64, error) {
+		if strings.Contains(url, "/11111111") { return 0x0F0F0F0F0F0F0F0F, nil }
+		return 0xF0F0F0F0F0F0F0F0, nil
+	}
+	return fake
+}
+
+// the test database already has posts in it, so make the fixture posts newer than all of them.
+func touchFixtures(tx storage.DBLike, fake *apitest.FakeBooru) error {
+	last, err := storage.GetMostRecentlyUpdatedPost(tx)
+	if err != nil { return err }
+	if last != nil { fake.SetChangeSeq(last.Change) }
+	for _, id := range fixturePosts { fake.Touch(id) }
+	return nil
+}
+
+func Test_SyncPostsInternal(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	// a couple of rate limited calls along the way should be retried, not treated as the end of the sync.
+	fake.RateLimit(2)
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := touchFixtures(tx, fake); err != nil { return err }
+
+		updates := make(chan []types.TPostInfo, 16)
+		if err := SyncPostsInternal(tx, "alice", "alicekey", true, false, ProgressWriter(&buf), updates); err != nil { return err }
+		close(updates)
+
+		var updated int
+		for list := range updates { updated += len(list) }
+		if updated != len(fixturePosts) { t.Errorf("Unexpected number of updated posts: got %d, expected %d", updated, len(fixturePosts)) }
+
+		for _, id := range fixturePosts {
+			post, err := storage.PostByID(tx, id)
+			if err != nil { return err }
+			expected := fake.Post(id)
+			if post == nil {
+				t.Errorf("Post %d wasn't synced", id)
+			} else if post.Change != expected.Change || post.Deleted != expected.Deleted || post.Rating != expected.Rating || post.Md5 != expected.Md5 {
+				t.Errorf("Post %d synced incorrectly: got %+v, expected %+v", id, *post, *expected)
+			}
+		}
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+
+	var aliases bool
+	for _, r := range fake.Requests() {
+		aliases = aliases || r.Path == "/tag_aliases.json" && r.Query.Get("search[status]") == "Active"
+	}
+	if !aliases { t.Errorf("Aliases weren't synced") }
+}
+
+func Test_SyncOnlyPostsInternal_Edits(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := touchFixtures(tx, fake); err != nil { return err }
+		if err := SyncOnlyPostsInternal(tx, "alice", "alicekey", ProgressWriter(&buf), nil); err != nil { return err }
+
+		// an edit on the site should be picked up by the next sync, and nothing else should be fetched again.
+		if _, err := api.UpdatePost("alice", "alicekey", 102, tags.TagDiffFromString("-sitting standing"), types.Explicit, nil, nil, nil, nil); err != nil { return err }
+		before := len(fake.Requests())
+		if err := SyncOnlyPostsInternal(tx, "alice", "alicekey", ProgressWriter(&buf), nil); err != nil { return err }
+
+		requests := fake.Requests()[before:]
+		if len(requests) != 1 { t.Errorf("Expected a single listing call, got %d", len(requests)) }
+
+		post, err := storage.PostByID(tx, 102)
+		if err != nil { return err }
+		if post == nil || post.Rating != types.Explicit || post.Change != fake.Post(102).Change {
+			t.Errorf("Edit wasn't synced: got %+v", post)
+		}
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
+
+func Test_HashPostPreviewsInternal(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := touchFixtures(tx, fake); err != nil { return err }
+		if err := SyncOnlyPostsInternal(tx, "alice", "alicekey", ProgressWriter(&buf), nil); err != nil { return err }
+
+		// the sync itself doesn't hash anything.
+		before, err := storage.GetPostsWithoutImageHash(tx, 1000000)
+		if err != nil { return err }
+
+		hashed, err := HashPostPreviewsInternal(tx, 1000000)
+		if err != nil { return err }
+		if hashed != len(before) { t.Errorf("Unexpected number of posts hashed: got %d, expected %d", hashed, len(before)) }
+
+		// the deleted post has no preview, everything else should have been hashed.
+		missing, err := storage.GetPostsWithoutImageHash(tx, 1000000)
+		if err != nil { return err }
+		if len(missing) != 0 { t.Errorf("Posts weren't hashed: %v", missing) }
+
+		matches, err := storage.SimilarPosts(tx, 0x0F0F0F0F0F0F0F0E, 2, 5)
+		if err != nil { return err }
+		if len(matches) != 1 || matches[0] != (storage.ImageMatch{PostId: 101, Distance: 1}) { t.Errorf("Unexpected matches: %+v", matches) }
+
+		// a match as far away as reverse search looks is still found, even with the differences spread over every band.
+		matches, err = storage.SimilarPosts(tx, 0x0F0F0F0F0F0F0F0F ^ 0x07000007_00F0000F, imagehash.POSSIBLE_MATCH, 5)
+		if err != nil { return err }
+		if len(matches) != 1 || matches[0] != (storage.ImageMatch{PostId: 101, Distance: imagehash.POSSIBLE_MATCH}) { t.Errorf("Unexpected distant matches: %+v", matches) }
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
+
+// previews which can't be downloaded are tried again, but ones which can't be decoded aren't.
+func Test_HashPostPreviewsInternal_Errors(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := touchFixtures(tx, fake); err != nil { return err }
+		if err := SyncOnlyPostsInternal(tx, "alice", "alicekey", ProgressWriter(&buf), nil); err != nil { return err }
+
+		before, err := storage.GetPostsWithoutImageHash(tx, 1000000)
+		if err != nil { return err }
+
+		hashPreview = func(url string) (uint64, error) { return 0, errors.New("Request failed: 503 Service Unavailable") }
+		if _, err := HashPostPreviewsInternal(tx, 1000000); err != nil { return err }
+		after, err := storage.GetPostsWithoutImageHash(tx, 1000000)
+		if err != nil { return err }
+		if len(after) != len(before) { t.Errorf("Posts were given up on after a download failed: %d left, expected %d", len(after), len(before)) }
+
+		hashPreview = func(url string) (uint64, error) { return 0, imagehash.ErrUndecodable }
+		if _, err := HashPostPreviewsInternal(tx, 1000000); err != nil { return err }
+		after, err = storage.GetPostsWithoutImageHash(tx, 1000000)
+		if err != nil { return err }
+		if len(after) != 0 { t.Errorf("Undecodable posts weren't recorded: %v", after) }
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
+
+func Test_SyncOnlyPostsInternal_GivesUp(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	fake.Fail("/posts.json", http.StatusServiceUnavailable, 10)
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		return SyncOnlyPostsInternal(tx, "alice", "alicekey", ProgressWriter(&buf), nil)
+	}))
+
+	if err == nil || !strings.Contains(err.Error(), "Repeated failure") { t.Errorf("Expected the sync to give up, got %v", err) }
+	if len(fake.Requests()) != 10 { t.Errorf("Unexpected number of attempts: %d", len(fake.Requests())) }
+}
+
+func Test_SyncAliasesInternal(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	fake.RateLimit(1)
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		return SyncAliasesInternal(tx, "alice", "alicekey", ProgressWriter(&buf))
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+
+	// one rate limited call, one which gets every alias, and one which finds nothing left.
+	if len(fake.Requests()) != 3 { t.Errorf("Unexpected number of requests: %d", len(fake.Requests())) }
+}
+
+// alias_index is keyed by the alias, and points at the tag it's an alias of.
+func Test_SyncAliasesInternal_Direction(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := SyncTagsInternal(tx, "alice", "alicekey", ProgressWriter(&buf)); err != nil { return err }
+		if err := SyncAliasesInternal(tx, "alice", "alicekey", ProgressWriter(&buf)); err != nil { return err }
+
+		n, err := storage.NormalizeTags(tx, []string{"lupine", "wolf"})
+		if err != nil { return err }
+		expected := []storage.TagRewrite{{From: "lupine", To: "wolf"}}
+		if !reflect.DeepEqual(n.Aliased, expected) { t.Errorf("Unexpected aliases: got %v, expected %v", n.Aliased, expected) }
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
+
+func Test_SyncImplicationsInternal(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	fake.RateLimit(1)
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		return SyncImplicationsInternal(tx, "alice", "alicekey", ProgressWriter(&buf))
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+
+	// one rate limited call, one which gets every implication, and one which finds nothing left.
+	if len(fake.Requests()) != 3 { t.Errorf("Unexpected number of requests: %d", len(fake.Requests())) }
+}
+
+func Test_ExpandImplications(t *testing.T) {
+	fake := startSync(t)
+	defer fake.Close()
+
+	var buf bytes.Buffer
+	err := storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		if err := SyncTagsInternal(tx, "alice", "alicekey", ProgressWriter(&buf)); err != nil { return err }
+		if err := SyncImplicationsInternal(tx, "alice", "alicekey", ProgressWriter(&buf)); err != nil { return err }
+
+		// wolf implies canine, which implies mammal. the deleted sitting -> inside implication shouldn't apply.
+		var set, expected tags.TagSet
+		set.ApplyString("wolf sitting")
+		expected.ApplyString("wolf sitting canine mammal")
+		if err := storage.ExpandImplications(tx, &set); err != nil { return err }
+		if !set.Equal(expected) { t.Errorf("Unexpected tags: got %s, expected %s", set, expected) }
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
@@ -18,6 +18,7 @@ import (
 	"html"
 	"io"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,6 +50,9 @@ type ProgMessage struct {
 	// internally accessible fields
 	target, actual string
 	updater <- chan time.Time
+
+	// if set, progress is printed here as plain text instead of being sent to telegram.
+	output io.Writer
 }
 
 func (this *ProgMessage) Respin(previous, notice, status string) string {
@@ -146,6 +150,7 @@ func (this *ProgMessage) AppendNotice(text string) (error) {
 	this.status = ""
 	this.notice = text
 	this.active = this.Respin(this.previous, this.notice, this.status)
+	if this.output != nil { return this.print(text) }
 	return this.Push(this.active)
 }
 
@@ -153,6 +158,7 @@ func (this *ProgMessage) ReplaceNotice(text string) (error) {
 	if this == nil { return nil }
 	this.notice = text
 	this.active = this.Respin(this.previous, this.notice, this.status)
+	if this.output != nil { return this.print(text) }
 	return this.Push(this.active)
 }
 
@@ -160,6 +166,7 @@ func (this *ProgMessage) SetStatus(text string) (error) {
 	if this == nil { return nil }
 	this.status = text
 	this.active = this.Respin(this.previous, this.notice, this.status)
+	if this.output != nil { return this.print(text) }
 	return this.Push(this.active)
 }
 
@@ -169,6 +176,7 @@ func (this *ProgMessage) SetMessage(text string) (error) {
 	this.previous = ""
 	this.status = ""
 	this.notice = text
+	if this.output != nil { return this.print(text) }
 	return this.Push(this.active)
 }
 
@@ -185,6 +193,21 @@ func (this *ProgMessage) Close() {
 	}
 }
 
+var htmlTags = regexp.MustCompile("<[^>]*>")
+
+// writes progress text to the output, stripped of its HTML formatting.
+func (this *ProgMessage) print(text string) error {
+	if text == "" { return nil }
+	_, err := fmt.Fprintln(this.output, html.UnescapeString(htmlTags.ReplaceAllString(text, "")))
+	return err
+}
+
+// ProgressWriter creates a ProgMessage which prints each notice, status and message to w as a line of plain text,
+// for running things outside of telegram.
+func ProgressWriter(w io.Writer) *ProgMessage {
+	return &ProgMessage{output: w}
+}
+
 func ProgressMessage2(initial_message data.OMessage,
 		      initial_text string,
 		      interval time.Duration,
@@ -224,7 +247,8 @@ func ResyncListCommand(ctx *gogram.MessageCtx) {
 
 func ResyncList(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
 	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return err }
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
 
 	doc := ctx.Msg.Document
 	if doc == nil {
@@ -256,6 +280,8 @@ func ResyncList(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
 	return storage.DefaultTransact(func(tx storage.DBLike) error { return ResyncListInternal(tx, creds.User, creds.ApiKey, file_data, progress) })
 }
 
+// how long to wait before retrying a failed api call during a sync. tests shorten this.
+var apiRetryDelay = 30 * time.Second
 
 func ResyncListInternal(tx storage.DBLike, user, api_key string, file_data io.Reader, progress *ProgMessage) (error) {
 	progress.AppendNotice("Updating posts from list...")
@@ -309,7 +335,7 @@ func ResyncListInternal(tx storage.DBLike, user, api_key string, file_data io.Re
 					close(fixed_posts)
 					return errors.New(fmt.Sprintf("Repeated failure while calling " + api.ApiName + " API (%s)", err.Error()))
 				}
-				time.Sleep(30 * time.Second)
+				time.Sleep(apiRetryDelay)
 				continue
 			}
 
@@ -343,7 +369,8 @@ func SyncTagsCommand(ctx *gogram.MessageCtx) {
 
 func SyncTags(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
 	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return err }
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
 
 	if progress == nil {
 		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
@@ -385,7 +412,7 @@ func SyncTagsInternal(tx storage.DBLike, user, api_key string, progress *ProgMes
 				close(fixed_tags)
 				return errors.New(fmt.Sprintf("Repeated failure while calling " + api.ApiName + " API (%s)", err.Error()))
 			}
-			time.Sleep(30 * time.Second)
+			time.Sleep(apiRetryDelay)
 			continue
 		}
 
@@ -429,9 +456,9 @@ func RecountTagsCommand(ctx *gogram.MessageCtx) {
 }
 
 func RecountTags(ctx *gogram.MessageCtx, progress *ProgMessage, real_counts, alias_counts bool) (error) {
-	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC)
 	if err != nil { return err }
-	if !creds.Janitor { return errors.New("You need to be a janitor to use this command.") }
+	if !ok { return errors.New("You don't have permission to use this command.") }
 
 	if progress == nil {
 		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
@@ -543,7 +570,8 @@ func SyncPostsCommand(ctx *gogram.MessageCtx) {
 
 func SyncPosts(ctx *gogram.MessageCtx, aliases_too, recount_too bool, progress *ProgMessage) (error) {
 	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return err }
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
 
 	if progress == nil {
 		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
@@ -588,7 +616,7 @@ func SyncOnlyPostsInternal(tx storage.DBLike, user, api_key string, progress *Pr
 				close(fixed_posts)
 				return errors.New(fmt.Sprintf("Repeated failure while calling " + api.ApiName + " API (%s)", err.Error()))
 			}
-			time.Sleep(30 * time.Second)
+			time.Sleep(apiRetryDelay)
 			continue
 		}
 
@@ -624,6 +652,7 @@ func SyncPostsInternal(tx storage.DBLike, user, api_key string, aliases_too, rec
 
 	if aliases_too {
 		if err := SyncAliasesInternal(tx, user, api_key, progress); err != nil { return err }
+		if err := SyncImplicationsInternal(tx, user, api_key, progress); err != nil { return err }
 	}
 
 	progress.AppendNotice("Resolving post tags...")
@@ -663,7 +692,8 @@ func SyncAliasesCommand(ctx *gogram.MessageCtx) {
 
 func SyncAliases(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
 	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return err }
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
 
 	if progress == nil {
 		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
@@ -679,48 +709,104 @@ func SyncAliasesInternal(tx storage.DBLike, user, api_key string, progress *Prog
 
 	storage.ClearAliasIndex(tx)
 
+	fixed_aliases := make(chan types.TAliasData)
+	updater := func() error { return storage.AliasUpdater(tx, fixed_aliases) }
+	list := func(page types.PageSelector) (int, error) {
+		list, err := api.ListTagAliases(user, api_key, types.ListTagAliasOptions{Limit: 10000, Page: page, Order: types.ASOCreated, Status: types.ASActive})
+		if err != nil || len(list) == 0 { return 0, err }
+		for _, a := range list {
+			fixed_aliases <- a
+		}
+		return list[0].Id, nil
+	}
+
+	return syncRelationshipsInternal(list, updater, func() { close(fixed_aliases) }, progress)
+}
+
+// pages through one of the site's tag relationship lists, handing everything on it to an updater running alongside.
+// list fetches a page, sends its entries to the updater, and returns the id to continue after, or 0 once the list is
+// exhausted. done is called once nothing else will be sent, and must let the updater finish.
+func syncRelationshipsInternal(list func(types.PageSelector) (int, error), updater func() error, done func(), progress *ProgMessage) (error) {
 	consecutive_errors := 0
 	page := types.After(0)
 
-	fixed_aliases := make(chan types.TAliasData)
-
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err := storage.AliasUpdater(tx, fixed_aliases)
+		err := updater()
 		if err != nil { log.Println(err.Error()) }
 		wg.Done()
 	}()
 
 	for {
-		list, err := api.ListTagAliases(user, api_key, types.ListTagAliasOptions{Limit: 10000, Page: page, Order: types.ASOCreated, Status: types.ASActive})
+		last, err := list(page)
 		if err != nil {
 			if consecutive_errors++; consecutive_errors == 10 {
 				// transient API errors are okay, they might be because of network issues or whatever, but give up if they last too long.
-				close(fixed_aliases)
+				done()
 				return errors.New(fmt.Sprintf("Repeated failure while calling " + api.ApiName + " API (%s)", err.Error()))
 			}
-			time.Sleep(30 * time.Second)
+			time.Sleep(apiRetryDelay)
 			continue
 		}
 
 		consecutive_errors = 0
 
-		if len(list) == 0 { break }
+		if last == 0 { break }
 
-		page = types.After(list[0].Id)
-		for _, a := range list {
-			fixed_aliases <- a
-		}
+		page = types.After(last)
 	}
 
-	close(fixed_aliases)
+	done()
 	wg.Wait()
 
 	progress.SetStatus("done.")
 	return nil
 }
 
+func SyncImplicationsCommand(ctx *gogram.MessageCtx) {
+	err := SyncImplications(ctx, nil)
+	if err == storage.ErrNoLogin {
+		ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: "You need to be logged in to " + api.ApiName + " to use this command (see <code>/help login</code>)", ParseMode: data.ParseHTML}}, nil)
+		return
+	} else if err != nil {
+		ctx.Bot.Log.Printf("Error occurred syncing implications: %s", err.Error())
+	}
+}
+
+func SyncImplications(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
+
+	if progress == nil {
+		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
+	                                       "", 3 * time.Second, ctx.Bot)
+		defer progress.Close()
+	}
+
+	return storage.DefaultTransact(func(tx storage.DBLike) error { return SyncImplicationsInternal(tx, creds.User, creds.ApiKey, progress) })
+}
+
+func SyncImplicationsInternal(tx storage.DBLike, user, api_key string, progress *ProgMessage) (error) {
+	progress.AppendNotice("Syncing implication list...")
+
+	if err := storage.ClearImplicationIndex(tx); err != nil { return err }
+
+	fixed_implications := make(chan types.TImplicationData)
+	updater := func() error { return storage.ImplicationUpdater(tx, fixed_implications) }
+	list := func(page types.PageSelector) (int, error) {
+		list, err := api.ListTagImplications(user, api_key, types.ListTagImplicationOptions{Limit: 10000, Page: page, Order: types.ASOCreated, Status: types.ASActive})
+		if err != nil || len(list) == 0 { return 0, err }
+		for _, i := range list {
+			fixed_implications <- i
+		}
+		return list[0].Id, nil
+	}
+
+	return syncRelationshipsInternal(list, updater, func() { close(fixed_implications) }, progress)
+}
+
 const (
 	STATE_READY = 0
 	STATE_COUNT = iota
@@ -786,6 +872,10 @@ const (
 	MODE_SELECT
 	MODE_SKIP
 	MODE_ALIAS
+	MODE_REPLACER
+	MODE_USER
+	MODE_SINCE
+	MODE_UNTIL
 )
 
 type TagEditBox struct {
@@ -878,10 +968,9 @@ type TyposControl struct {
 	list_settings ListSettings
 }
 
-func Typos(ctx *gogram.MessageCtx) {
-	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return }
-
+// parses the arguments for /typos (see its help text) into a TyposControl.
+func ParseTyposArgs(args []string) (TyposControl, error) {
+	var err error
 	var control TyposControl
 
 	control.mode = MODE_READY
@@ -889,7 +978,7 @@ func Typos(ctx *gogram.MessageCtx) {
 	control.reason = "supervised tag replacement"
 	control.list_settings = ListSettings{wild: true}
 
-	for _, token := range ctx.Cmd.Args {
+	for _, token := range args {
 		ltoken := strings.Replace(strings.ToLower(token), "\uFE0F", "", -1)
 		switch token {
 		case "--list-wild", "-w":  // show unconfirmed possible typos
@@ -984,6 +1073,15 @@ func Typos(ctx *gogram.MessageCtx) {
 		err = fmt.Errorf("missing required argument (%d)", control.mode)
 	}
 
+	return control, err
+}
+
+func Typos(ctx *gogram.MessageCtx) {
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil { return }
+	if ok, _ := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_AUTOFIX); !ok { return }
+
+	control, err := ParseTyposArgs(ctx.Cmd.Args)
 	if err != nil {
 		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("Bad arguments: %s.", err.Error())}}, nil)
 		return
@@ -1223,7 +1321,8 @@ func RefetchDeletedPostsCommand(ctx *gogram.MessageCtx) {
 
 func RefetchDeletedPosts(ctx *gogram.MessageCtx, progress *ProgMessage) (error) {
 	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return err }
+	if err != nil { return err }
+	if ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_SYNC); !ok { return err }
 
 	if progress == nil {
 		progress, _ = ProgressMessage2(data.OMessage{SendData: data.SendData{ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
@@ -1261,7 +1360,7 @@ func RefetchDeletedPostsInternal(tx storage.DBLike, user, api_key string, progre
 				close(fixed_posts)
 				return errors.New(fmt.Sprintf("Repeated failure while calling " + api.ApiName + " API (%s)", err.Error()))
 			}
-			time.Sleep(30 * time.Second)
+			time.Sleep(apiRetryDelay)
 			continue
 		}
 
@@ -1288,6 +1387,41 @@ func RefetchDeletedPostsInternal(tx storage.DBLike, user, api_key string, progre
 	return nil
 }
 
+// how many posts are asked for by id in each api call.
+const backfillPageSize = 100
+
+// fills in file information for up to limit posts which were indexed before it was stored, returning how many
+// were filled in. posts the site no longer returns at all are marked deleted, so they aren't asked about again.
+// each post is written on its own, so d shouldn't be a transaction, which would be held open between api calls.
+func BackfillPostFilesInternal(d storage.DBLike, user, api_key string, limit int) (int, error) {
+	ids, err := storage.GetPostsMissingFileInfo(d, limit)
+	if err != nil { return 0, err }
+
+	filled := 0
+	for len(ids) != 0 {
+		page := ids
+		if len(page) > backfillPageSize { page = page[:backfillPageSize] }
+		ids = ids[len(page):]
+
+		list, err := api.ListPosts(user, api_key, types.ListPostOptions{Limit: len(page), SearchQuery: types.PostsById(page)})
+		if err != nil { return filled, err }
+
+		found := make(map[int]bool)
+		for _, p := range list {
+			found[p.Id] = true
+			if err := storage.SetPostFileInfo(d, p); err != nil { return filled, err }
+			filled++
+		}
+
+		for _, id := range page {
+			if found[id] { continue }
+			if err := storage.MarkPostDeleted(d, id); err != nil { return filled, err }
+		}
+	}
+
+	return filled, nil
+}
+
 type ListSettings struct {
 	overridden, wild, yes, no bool
 }
@@ -1315,16 +1449,14 @@ type BlitsControl struct {
 	list_settings ListSettings
 }
 
-func Blits(ctx *gogram.MessageCtx) {
-	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return }
-
+// parses the arguments for /blits (see its help text) into a BlitsControl.
+func ParseBlitsArgs(args []string) BlitsControl {
 	var control BlitsControl
 	control.mode = MODE_LIST
 	control.list_settings = ListSettings{wild: true}
 	control.include, control.exclude, control.to_delete = make(map[string]bool), make(map[string]bool), make(map[string]bool)
 
-	for _, token := range ctx.Cmd.Args {
+	for _, token := range args {
 		ltoken := strings.Replace(strings.ToLower(token), "\uFE0F", "", -1)
 		if control.mode == MODE_EXCLUDE {
 			control.exclude[ltoken] = true
@@ -1349,6 +1481,15 @@ func Blits(ctx *gogram.MessageCtx) {
 		}
 	}
 
+	return control
+}
+
+func Blits(ctx *gogram.MessageCtx) {
+	ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_EDIT_BLITS)
+	if err != nil || !ok { return }
+
+	control := ParseBlitsArgs(ctx.Cmd.Args)
+
 	progress, err := ProgressMessage2(data.OMessage{SendData: data.SendData{TargetData: data.TargetData{ChatId: ctx.Msg.Chat.Id}, ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
 	                                  "", 3 * time.Second, ctx.Bot)
 
@@ -1523,10 +1664,10 @@ type CatsControl struct {
 	list_settings     ListSettings
 }
 
-func Concatenations(ctx *gogram.MessageCtx) {
-	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if err != nil || !creds.Janitor { return }
-
+// parses the arguments for /cats (see its help text) into a CatsControl. cats is the list of candidates which
+// --entry selects from, which is normally taken from an earlier listing.
+func ParseCatsArgs(args []string, cats []Triplet) (CatsControl, error) {
+	var err error
 	var control CatsControl
 
 	var current_list []Triplet
@@ -1538,28 +1679,9 @@ func Concatenations(ctx *gogram.MessageCtx) {
 	control.suffix_only = true
 	control.list_settings = ListSettings{wild: true}
 
-	// read candidate cats from the replied message, if there is one, so -e can select them
-	header := "Here are some random concatenated tags:"
-	if ctx.Msg.ReplyToMessage != nil {
-		text := ctx.Msg.ReplyToMessage.Text
-		if text != nil {
-			prev_cats := strings.Split(*text, "\n")
-			if prev_cats[0] == header {
-				prev_cats = prev_cats[1:]
-				for _, line := range prev_cats {
-					t := Triplet{&types.TTagData{}, &types.TTagData{}, &types.TTagData{}}
-					tokens := strings.Split(line, " ")
-					if !(len(tokens) == 4 && tokens[2] == "+") { continue }
-					t.subtag1.Name = tokens[1]
-					t.subtag2.Name = tokens[3]
-					t.tag.Name = t.subtag1.Name + t.subtag2.Name
-					control.cats = append(control.cats, t)
-				}
-			}
-		}
-	}
+	control.cats = cats
 
-	for _, token := range ctx.Cmd.Args {
+	for _, token := range args {
 		ltoken := strings.Replace(strings.ToLower(token), "\uFE0F", "", -1)
 		switch token {
 		case "--list-wild", "-w":
@@ -1684,6 +1806,39 @@ func Concatenations(ctx *gogram.MessageCtx) {
 		err = fmt.Errorf("missing required argument (%d)", control.mode)
 	}
 
+	return control, err
+}
+
+func Concatenations(ctx *gogram.MessageCtx) {
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil { return }
+	if ok, _ := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_AUTOFIX); !ok { return }
+
+	var cats []Triplet
+
+	// read candidate cats from the replied message, if there is one, so -e can select them
+	header := "Here are some random concatenated tags:"
+	if ctx.Msg.ReplyToMessage != nil {
+		text := ctx.Msg.ReplyToMessage.Text
+		if text != nil {
+			prev_cats := strings.Split(*text, "\n")
+			if prev_cats[0] == header {
+				prev_cats = prev_cats[1:]
+				for _, line := range prev_cats {
+					t := Triplet{&types.TTagData{}, &types.TTagData{}, &types.TTagData{}}
+					tokens := strings.Split(line, " ")
+					if !(len(tokens) == 4 && tokens[2] == "+") { continue }
+					t.subtag1.Name = tokens[1]
+					t.subtag2.Name = tokens[3]
+					t.tag.Name = t.subtag1.Name + t.subtag2.Name
+					cats = append(cats, t)
+				}
+			}
+		}
+	}
+
+	control, err := ParseCatsArgs(ctx.Cmd.Args, cats)
+
 	// If there was an error processing command line arguments, report the error and bail.
 	if err != nil {
 		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Error while processing command: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
@@ -1872,22 +2027,651 @@ func CatsInternal(tx storage.DBLike, control CatsControl, creds storage.UserCred
 	return nil
 }
 
-/*
-func BulkRetag(searchtags, applytags string) {
-        expression_tokens := Tokenize(searchtags)
-        search_expression := Parse(expression_tokens)
-        sql_format_string, sql_tokens := Serialize(search_expression)
-        for k, v := range sql_tokens {
-            sql_tokens[k] = pq.QuoteLiteral(v)
-        }
-        replace["tag_id"] = "tag_id"
-        replace["tag_index"] = "tag_index"
-        replace["tag_name"] = "tag_name"
-        replace["temp"] = "x"
-        var buf bytes.Buffer
-        template.Must(template.New("decoder").Parse(sql_format_string)).Execute(&buf, sql_tokens)
-        sql_substring := buf.String()
-
-        // TODO finish this
-}
-*/
+func ParseExpressionCommand(ctx *gogram.MessageCtx) {
+	expression := Parse(Tokenize(ctx.Cmd.Argstr))
+	if expression == nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Couldn't parse that expression.", ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	var count int
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		sql_format_string, sql_tokens := Serialize(expression)
+		ids, err := storage.PostsMatchingExpression(tx, sql_format_string, sql_tokens)
+		count = len(ids)
+		return err
+	})
+
+	if err != nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Whoops! An error occurred: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("Parsed as: <code>%s</code>\n%d posts match.", html.EscapeString(expression.String()), count), ParseMode: data.ParseHTML}}, nil)
+}
+
+type BulkRetagControl struct {
+	expression TagExpression
+	diff       tags.TagDiff
+	reason     string
+	fix        bool
+	samples    int
+}
+
+func BulkRetagCommand(ctx *gogram.MessageCtx) {
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil { return }
+	if ok, _ := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_BULK_RETAG); !ok { return }
+
+	var control BulkRetagControl
+	control.samples = 10
+
+	mode := MODE_READY
+	for _, token := range ctx.Cmd.Args {
+		if mode == MODE_REASON {
+			control.reason = token
+			mode = MODE_READY
+		} else if token == "--reason" || token == "-r" {
+			mode = MODE_REASON
+		} else if token == "--fix" || token == "-F" {
+			control.fix = true
+		} else if control.expression == nil {
+			control.expression = Parse(Tokenize(token))
+			if control.expression == nil {
+				err = fmt.Errorf("couldn't parse expression: %s", token)
+				break
+			}
+		} else {
+			control.diff.ApplyString(strings.ToLower(token))
+		}
+	}
+
+	if err == nil {
+		if mode != MODE_READY {
+			err = errors.New("missing required argument")
+		} else if control.expression == nil {
+			err = errors.New("you must specify an expression to match posts against")
+		} else if control.diff.IsZero() {
+			err = errors.New("you must specify some tag changes to apply")
+		}
+	}
+
+	if err != nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Error while processing command: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	progress, err := ProgressMessage2(data.OMessage{SendData: data.SendData{TargetData: data.TargetData{ChatId: ctx.Msg.Chat.Id}, ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
+	                                  "", 3 * time.Second, ctx.Bot)
+
+	if err != nil {
+		ctx.Bot.ErrorLog.Println("Failed to create ProgMessage")
+		return
+	}
+
+	defer progress.Close()
+
+	err = storage.DefaultTransact(func(tx storage.DBLike) error { return BulkRetagInternal(tx, control, creds, progress) })
+	if err != nil {
+		progress.SetMessage(fmt.Sprintf("Whoops! An error occurred: %s", html.EscapeString(err.Error())))
+	}
+}
+
+func BulkRetagInternal(tx storage.DBLike, control BulkRetagControl, creds storage.UserCreds, progress *ProgMessage) error {
+	progress.AppendNotice(fmt.Sprintf("Searching for posts matching <code>%s</code>...", html.EscapeString(control.expression.String())))
+
+	sql_format_string, sql_tokens := Serialize(control.expression)
+	ids, err := storage.PostsMatchingExpression(tx, sql_format_string, sql_tokens)
+	if err != nil { return fmt.Errorf("PostsMatchingExpression: %w", err) }
+
+	// the bulk diff isn't necessarily a perfect fit for every post, so figure out what it will actually do to each
+	// one, and skip any posts it wouldn't change at all.
+	diffs := make(map[int]tags.TagDiff)
+	var changed_ids []int
+	for page := range storage.PaginatedPostsById(tx, ids, 10000) {
+		if page.Err != nil { return fmt.Errorf("PaginatedPostsById: %w", page.Err) }
+
+		for i, _ := range page.Posts {
+			post_tags := page.Posts[i].TagSet()
+			var diff tags.TagDiff
+			for tag, _ := range control.diff.AddList {
+				if post_tags.Status(tag) != tags.AddsTag { diff.Add(tag) }
+			}
+			for tag, _ := range control.diff.RemoveList {
+				if post_tags.Status(tag) == tags.AddsTag { diff.Remove(tag) }
+			}
+
+			if diff.IsZero() { continue }
+			diffs[page.Posts[i].Id] = diff
+			changed_ids = append(changed_ids, page.Posts[i].Id)
+		}
+	}
+
+	sort.Ints(changed_ids)
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Expression: <code>%s</code>\nChanges: <code>%s</code>\n%d posts match, %d of them would be changed.\n",
+	                            html.EscapeString(control.expression.String()), html.EscapeString(control.diff.APIString()), len(ids), len(changed_ids)))
+	for i, id := range changed_ids {
+		if i == control.samples {
+			buf.WriteString("...\n")
+			break
+		}
+		buf.WriteString(fmt.Sprintf("<a href=\"https://%s/posts/%d\">Post #%d</a>: <code>%s</code>\n", api.Endpoint, id, id, html.EscapeString(diffs[id].APIString())))
+	}
+
+	if !control.fix {
+		buf.WriteString("\nThis was a dry run, nothing has been changed. Repeat this command with <code>--fix</code> to apply it.")
+		progress.SetMessage(buf.String())
+		return nil
+	}
+
+	progress.SetMessage(buf.String())
+
+	reason := fmt.Sprintf("Bulk retag: %s --> %s", control.expression.String(), control.diff.APIString())
+	if control.reason != "" {
+		reason = fmt.Sprintf("%s (%s)", reason, control.reason)
+	}
+
+	progress.AppendNotice("Applying changes...")
+	for i, id := range changed_ids {
+		diff := diffs[id]
+		newp, err := api.UpdatePost(creds.User, creds.ApiKey, id, diff, types.Original, nil, nil, nil, &reason)
+
+		if err == api.PostIsDeleted {
+			log.Printf("Post was deleted which we didn't know about? DB consistency? (%d)\n", id)
+			err = storage.MarkPostDeleted(tx, id)
+			if err != nil { return fmt.Errorf("MarkPostDeleted: %w", err) }
+			continue
+		} else if err != nil {
+			// edits which already went through can't be taken back, so stop here but keep the records of what was done.
+			progress.AppendNotice(fmt.Sprintf("Stopped after %d of %d posts, an error occurred: %s", i, len(changed_ids), html.EscapeString(err.Error())))
+			return nil
+		}
+
+		if newp != nil {
+			err = storage.UpdatePost(tx, *newp)
+			if err != nil { return fmt.Errorf("UpdatePost: %w", err) }
+		}
+
+		err = storage.AddBulkRetagAction(tx, &storage.BulkRetagAction{
+			TelegramUserId: creds.TelegramId,
+			PostId: id,
+			Expression: control.expression.String(),
+			TagDiff: diff.APIString(),
+			Timestamp: time.Now(),
+		})
+		if err != nil { return fmt.Errorf("AddBulkRetagAction: %w", err) }
+
+		progress.SetStatus(fmt.Sprintf("(%d/%d %d: <code>%s</code>)", i + 1, len(changed_ids), id, html.EscapeString(diff.APIString())))
+	}
+
+	progress.SetStatus("(done)")
+	return nil
+}
+
+type RevertReplacementsControl struct {
+	filter  storage.ReplacementHistoryFilter
+	reason  string
+	fix     bool
+	samples int
+}
+
+// parses a point in time, either as a date (with an optional time of day) in the bot's local time, or as a duration
+// like 36h, meaning that long ago.
+func parseTimeArg(arg string) (time.Time, error) {
+	if d, err := time.ParseDuration(arg); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, arg, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("couldn't understand time: %s", arg)
+}
+
+func RevertReplacementsCommand(ctx *gogram.MessageCtx) {
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil { return }
+	if ok, _ := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_AUTOFIX); !ok { return }
+
+	var control RevertReplacementsControl
+	control.samples = 10
+
+	mode := MODE_READY
+	for _, token := range ctx.Cmd.Args {
+		if mode == MODE_REASON {
+			control.reason = token
+			mode = MODE_READY
+		} else if mode == MODE_REPLACER {
+			var id int64
+			id, err = strconv.ParseInt(token, 10, 64)
+			if err != nil { break }
+			control.filter.ReplacerId = &id
+			mode = MODE_READY
+		} else if mode == MODE_USER {
+			user := storage.AUTOFIX_USER
+			if token != "autofix" {
+				var id int64
+				id, err = strconv.ParseInt(token, 10, 64)
+				if err != nil { break }
+				user = data.UserID(id)
+			}
+			control.filter.TelegramUserId = &user
+			mode = MODE_READY
+		} else if mode == MODE_SINCE || mode == MODE_UNTIL {
+			var t time.Time
+			t, err = parseTimeArg(token)
+			if err != nil { break }
+			if mode == MODE_SINCE {
+				control.filter.Since = &t
+			} else {
+				control.filter.Until = &t
+			}
+			mode = MODE_READY
+		} else if token == "--replacer" || token == "-R" {
+			mode = MODE_REPLACER
+		} else if token == "--user" || token == "-u" {
+			mode = MODE_USER
+		} else if token == "--since" || token == "-S" {
+			mode = MODE_SINCE
+		} else if token == "--until" || token == "-U" {
+			mode = MODE_UNTIL
+		} else if token == "--reason" || token == "-r" {
+			mode = MODE_REASON
+		} else if token == "--fix" || token == "-F" {
+			control.fix = true
+		} else {
+			err = fmt.Errorf("unrecognized argument: %s", token)
+			break
+		}
+	}
+
+	if err == nil {
+		if mode != MODE_READY {
+			err = errors.New("missing required argument")
+		} else if control.filter.ReplacerId == nil && control.filter.TelegramUserId == nil && control.filter.Since == nil && control.filter.Until == nil {
+			err = errors.New("you must specify a replacer, a user, or a time window to revert")
+		}
+	}
+
+	if err != nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Error while processing command: " + html.EscapeString(err.Error()), ParseMode: data.ParseHTML}}, nil)
+		return
+	}
+
+	progress, err := ProgressMessage2(data.OMessage{SendData: data.SendData{TargetData: data.TargetData{ChatId: ctx.Msg.Chat.Id}, ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
+	                                  "", 3 * time.Second, ctx.Bot)
+
+	if err != nil {
+		ctx.Bot.ErrorLog.Println("Failed to create ProgMessage")
+		return
+	}
+
+	defer progress.Close()
+
+	err = storage.DefaultTransact(func(tx storage.DBLike) error { return RevertReplacementsInternal(tx, control, creds, progress) })
+	if err != nil {
+		progress.SetMessage(fmt.Sprintf("Whoops! An error occurred: %s", html.EscapeString(err.Error())))
+	}
+}
+
+func RevertReplacementsInternal(tx storage.DBLike, control RevertReplacementsControl, creds storage.UserCreds, progress *ProgMessage) error {
+	progress.AppendNotice("Looking up replacement history...")
+
+	history, err := storage.GetReplacementHistory(tx, control.filter)
+	if err != nil { return fmt.Errorf("GetReplacementHistory: %w", err) }
+
+	// history comes back grouped by post. records with no tag diff either predate diffs being recorded, or belong to
+	// replacers which only prompted and so changed nothing, and either way there's nothing to revert.
+	actions := make(map[int][]storage.ReplacementHistory)
+	var post_ids []int
+	unrecorded := 0
+	for _, h := range history {
+		if h.TagDiff == "" {
+			unrecorded++
+			continue
+		}
+		if actions[h.PostId] == nil { post_ids = append(post_ids, h.PostId) }
+		actions[h.PostId] = append(actions[h.PostId], h)
+	}
+
+	diffs := make(map[int]tags.TagDiff)
+	var changed_ids, skipped_ids []int
+	for page := range storage.PaginatedPostsById(tx, post_ids, 10000) {
+		if page.Err != nil { return fmt.Errorf("PaginatedPostsById: %w", page.Err) }
+
+		for i, _ := range page.Posts {
+			post := &page.Posts[i]
+			if post.Deleted { continue }
+
+			var applied tags.TagDiff
+			change_seq := 0
+			for _, h := range actions[post.Id] {
+				applied = applied.Union(tags.TagDiffFromString(h.TagDiff))
+				if h.ChangeSeq > change_seq { change_seq = h.ChangeSeq }
+			}
+
+			// if anything has touched the post since the replacer did, leave it alone, since undoing the replacement
+			// might clobber something somebody else did on purpose.
+			post_tags := post.ExtendedTagSet()
+			still_applied := true
+			for tag, _ := range applied.AddList {
+				if post_tags.Status(tag) != tags.AddsTag { still_applied = false }
+			}
+			for tag, _ := range applied.RemoveList {
+				if post_tags.Status(tag) == tags.AddsTag { still_applied = false }
+			}
+			if (change_seq != 0 && post.Change != change_seq) || !still_applied {
+				skipped_ids = append(skipped_ids, post.Id)
+				continue
+			}
+
+			diffs[post.Id] = applied.Invert()
+			changed_ids = append(changed_ids, post.Id)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Found %d replacement records (%d without recorded changes).\n%d posts would be reverted, %d have changed since and would be skipped.\n",
+	                            len(history), unrecorded, len(changed_ids), len(skipped_ids)))
+	for i, id := range changed_ids {
+		if i == control.samples {
+			buf.WriteString("...\n")
+			break
+		}
+		buf.WriteString(fmt.Sprintf("<a href=\"https://%s/posts/%d\">Post #%d</a>: <code>%s</code>\n", api.Endpoint, id, id, html.EscapeString(diffs[id].APIString())))
+	}
+
+	if !control.fix {
+		buf.WriteString("\nThis was a dry run, nothing has been changed. Repeat this command with <code>--fix</code> to apply it.")
+		progress.SetMessage(buf.String())
+		return nil
+	}
+
+	progress.SetMessage(buf.String())
+
+	reason := "Reverting automatic tag cleanup (via KnottyBot)"
+	if control.reason != "" {
+		reason = fmt.Sprintf("%s (%s)", reason, control.reason)
+	}
+
+	progress.AppendNotice("Reverting changes...")
+	for i, id := range changed_ids {
+		diff := diffs[id]
+		newp, err := api.UpdatePost(creds.User, creds.ApiKey, id, diff, types.Original, nil, nil, nil, &reason)
+
+		if err == api.PostIsDeleted {
+			log.Printf("Post was deleted which we didn't know about? DB consistency? (%d)\n", id)
+			err = storage.MarkPostDeleted(tx, id)
+			if err != nil { return fmt.Errorf("MarkPostDeleted: %w", err) }
+			continue
+		} else if err != nil {
+			// edits which already went through can't be taken back, so stop here but keep the records of what was done.
+			progress.AppendNotice(fmt.Sprintf("Stopped after %d of %d posts, an error occurred: %s", i, len(changed_ids), html.EscapeString(err.Error())))
+			return nil
+		}
+
+		if newp != nil {
+			err = storage.UpdatePost(tx, *newp)
+			if err != nil { return fmt.Errorf("UpdatePost: %w", err) }
+		}
+
+		var action_ids []int64
+		for _, h := range actions[id] { action_ids = append(action_ids, h.Id) }
+		err = storage.MarkReplacementHistoryReverted(tx, action_ids)
+		if err != nil { return fmt.Errorf("MarkReplacementHistoryReverted: %w", err) }
+
+		progress.SetStatus(fmt.Sprintf("(%d/%d %d: <code>%s</code>)", i + 1, len(changed_ids), id, html.EscapeString(diff.APIString())))
+	}
+
+	progress.SetStatus("(done)")
+	return nil
+}
+
+const replacementsPageSize = 25
+
+func formatReplacer(r storage.Replacer) string {
+	return fmt.Sprintf("#%d%s: <code>%s</code> → <code>%s</code>", r.Id, ternary(r.Autofix, " (autofix)", ""), html.EscapeString(r.MatchSpec), html.EscapeString(r.ReplaceSpec))
+}
+
+// normalizes a match or replace spec, rejecting empty ones (an empty match spec would match every post).
+func parseReplacerSpec(spec string) (string, error) {
+	diff := tags.TagDiffFromString(strings.ToLower(spec))
+	if diff.IsZero() { return "", fmt.Errorf("empty tag list: %q", spec) }
+	return diff.APIString(), nil
+}
+
+func ReplacementsCommand(ctx *gogram.MessageCtx) {
+	ok, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, storage.PERM_AUTOFIX)
+	if err != nil || !ok { return }
+
+	reply := func(text string) {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: text, ParseMode: data.ParseHTML}, DisableWebPagePreview: true}, nil)
+	}
+
+	args := ctx.Cmd.Args
+	subcommand := "list"
+	if len(args) != 0 {
+		subcommand, args = strings.ToLower(args[0]), args[1:]
+	}
+
+	// every subcommand except add and list takes a replacer id as its first argument.
+	var replacer *storage.Replacer
+	if subcommand != "add" && subcommand != "list" {
+		if len(args) == 0 {
+			reply("You must specify a replacer id.")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			reply("Invalid replacer id: " + html.EscapeString(args[0]))
+			return
+		}
+		args = args[1:]
+
+		err = storage.DefaultTransact(func(tx storage.DBLike) (err error) {
+			replacer, err = storage.GetReplacement(tx, id)
+			return
+		})
+		if err != nil {
+			reply("Whoops! An error occurred: " + html.EscapeString(err.Error()))
+			return
+		} else if replacer == nil {
+			reply(fmt.Sprintf("There's no replacer #%d.", id))
+			return
+		}
+	}
+
+	switch subcommand {
+	case "list":
+		page := 1
+		if len(args) != 0 {
+			page, err = strconv.Atoi(args[0])
+			if err != nil || page < 1 {
+				reply("Invalid page number: " + html.EscapeString(args[0]))
+				return
+			}
+		}
+
+		var buf bytes.Buffer
+		total := 0
+		err = storage.DefaultTransact(func(tx storage.DBLike) error {
+			for replacers := range storage.PaginatedGetAllReplacements(tx, replacementsPageSize) {
+				if replacers.Err != nil { return replacers.Err }
+				for _, r := range replacers.Replacers {
+					total++
+					if (total - 1) / replacementsPageSize + 1 == page {
+						buf.WriteString(formatReplacer(r))
+						buf.WriteString("\n")
+					}
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			reply("Whoops! An error occurred: " + html.EscapeString(err.Error()))
+			return
+		}
+
+		pages := (total + replacementsPageSize - 1) / replacementsPageSize
+		if buf.Len() == 0 {
+			buf.WriteString("No replacers here.\n")
+		}
+		reply(fmt.Sprintf("<b>Replacers</b> (page %d of %d, %d total)\n%s", page, pages, total, buf.String()))
+	case "add", "edit":
+		// unless it's set, autofix is left as it was when editing, and off when adding.
+		autofix, autofix_set := false, false
+		var specs []string
+		for _, token := range args {
+			if token == "--autofix" || token == "-A" {
+				autofix, autofix_set = true, true
+			} else if token == "--no-autofix" || token == "-N" {
+				autofix, autofix_set = false, true
+			} else {
+				specs = append(specs, token)
+			}
+		}
+
+		if len(specs) != 2 {
+			reply("You must specify a match spec and a replace spec, each quoted. See <code>/help replacements</code>.")
+			return
+		}
+
+		var r storage.Replacer
+		r.MatchSpec, err = parseReplacerSpec(specs[0])
+		if err == nil { r.ReplaceSpec, err = parseReplacerSpec(specs[1]) }
+		if err != nil {
+			reply("Error while processing command: " + html.EscapeString(err.Error()))
+			return
+		}
+
+		if replacer == nil {
+			r.Autofix = autofix
+			err = storage.DefaultTransact(func(tx storage.DBLike) error {
+				added, err := storage.AddReplacement(tx, r)
+				if added != nil { r.Id = added.Id }
+				return err
+			})
+		} else {
+			r.Id, r.Autofix = replacer.Id, replacer.Autofix
+			if autofix_set { r.Autofix = autofix }
+			err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.UpdateReplacement(tx, r) })
+		}
+
+		if err != nil {
+			reply("Whoops! An error occurred: " + html.EscapeString(err.Error()))
+			return
+		}
+		reply(ternary(replacer == nil, "Added ", "Updated ") + formatReplacer(r))
+	case "autofix":
+		replacer.Autofix = !replacer.Autofix
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.UpdateReplacement(tx, *replacer) })
+		if err != nil {
+			reply("Whoops! An error occurred: " + html.EscapeString(err.Error()))
+			return
+		}
+		reply(ternary(replacer.Autofix, "Enabled", "Disabled") + " autofix for " + formatReplacer(*replacer))
+	case "delete":
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.DeleteReplacement(tx, replacer.Id) })
+		if err != nil {
+			reply("Whoops! An error occurred: " + html.EscapeString(err.Error()))
+			return
+		}
+		reply("Deleted " + formatReplacer(*replacer))
+	case "test":
+		progress, err := ProgressMessage2(data.OMessage{SendData: data.SendData{TargetData: data.TargetData{ChatId: ctx.Msg.Chat.Id}, ReplyToId: &ctx.Msg.Id, ParseMode: data.ParseHTML}, DisableWebPagePreview: true},
+		                                  "", 3 * time.Second, ctx.Bot)
+
+		if err != nil {
+			ctx.Bot.ErrorLog.Println("Failed to create ProgMessage")
+			return
+		}
+
+		defer progress.Close()
+
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return TestReplacerInternal(tx, *replacer, 10, progress) })
+		if err != nil {
+			progress.SetMessage(fmt.Sprintf("Whoops! An error occurred: %s", html.EscapeString(err.Error())))
+		}
+	default:
+		reply("Unknown subcommand: " + html.EscapeString(subcommand) + ". See <code>/help replacements</code>.")
+	}
+}
+
+// shows how many posts in the local index a replacer would match, and what it would do to them, without changing anything.
+func TestReplacerInternal(tx storage.DBLike, replacer storage.Replacer, samples int, progress *ProgMessage) error {
+	progress.AppendNotice("Searching for posts matching " + formatReplacer(replacer) + "...")
+
+	m := replacer.Matcher()
+
+	// narrow things down with the tag index first, so we don't have to look at every post.
+	var expression TagExpression
+	for _, tag := range m.MatchSpec.Array() {
+		var term TagExpression
+		if strings.HasPrefix(tag, "-") {
+			term = &TETag{tag: tag[1:], negate: true}
+		} else {
+			term = &TETag{tag: tag}
+		}
+
+		if expression == nil {
+			expression = term
+		} else {
+			expression = &TEAnd{sub_exp_1: expression, sub_exp_2: term}
+		}
+	}
+
+	// a replacer which doesn't require any tags would match every post, so there's nothing useful to show.
+	if expression == nil { return errors.New("this replacer has an empty match spec, so it would match every post") }
+
+	sql_format_string, sql_tokens := Serialize(expression)
+	ids, err := storage.PostsMatchingExpression(tx, sql_format_string, sql_tokens)
+	if err != nil { return fmt.Errorf("PostsMatchingExpression: %w", err) }
+
+	matched := 0
+	diffs := make(map[int]tags.TagDiff)
+	var changed_ids []int
+	for page := range storage.PaginatedPostsById(tx, ids, 10000) {
+		if page.Err != nil { return fmt.Errorf("PaginatedPostsById: %w", page.Err) }
+
+		for i, _ := range page.Posts {
+			post_tags := page.Posts[i].ExtendedTagSet()
+			if !m.Matches(post_tags) { continue }
+			matched++
+
+			var diff tags.TagDiff
+			for tag, _ := range m.ReplaceSpec.AddList {
+				if post_tags.Status(tag) != tags.AddsTag { diff.Add(tag) }
+			}
+			for tag, _ := range m.ReplaceSpec.RemoveList {
+				if post_tags.Status(tag) == tags.AddsTag { diff.Remove(tag) }
+			}
+
+			if diff.IsZero() { continue }
+			diffs[page.Posts[i].Id] = diff
+			changed_ids = append(changed_ids, page.Posts[i].Id)
+		}
+	}
+
+	sort.Ints(changed_ids)
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s\n%d posts match, %d of them would be changed.\n", formatReplacer(replacer), matched, len(changed_ids)))
+	for i, id := range changed_ids {
+		if i == samples {
+			buf.WriteString("...\n")
+			break
+		}
+		buf.WriteString(fmt.Sprintf("<a href=\"https://%s/posts/%d\">Post #%d</a>: <code>%s</code>\n", api.Endpoint, id, id, html.EscapeString(diffs[id].APIString())))
+	}
+	buf.WriteString("\nThis was a dry run, nothing has been changed.")
+
+	progress.SetMessage(buf.String())
+	return nil
+}
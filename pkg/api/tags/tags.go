@@ -6,14 +6,18 @@ import (
 
 func WildcardMatch(wildcard, tag string) (bool) {
 	tokens := strings.Split(wildcard, "*")
-	lastfound := 0
-	for i, t := range tokens {
-		if t == "" { continue }
-		current := strings.Index(tag[lastfound:], t)
+	if len(tokens) == 1 { return wildcard == tag }
+
+	// the first and last pieces are anchored to the ends of the tag, and mustn't overlap each other.
+	first, last := tokens[0], tokens[len(tokens) - 1]
+	if len(first) + len(last) > len(tag) || !strings.HasPrefix(tag, first) || !strings.HasSuffix(tag, last) { return false }
+
+	// everything in between can go anywhere between them, so taking the earliest place for each one is never wrong.
+	rest := tag[len(first):len(tag) - len(last)]
+	for _, t := range tokens[1:len(tokens) - 1] {
+		current := strings.Index(rest, t)
 		if current == -1 { return false }
-		if i == 0 && current != 0 { return false }
-		if i == len(tokens) - 1 && current + len(t) + lastfound != len(tag) { return false}
-		lastfound = lastfound + current + len(t)
+		rest = rest[current + len(t):]
 	}
 	return true
 }
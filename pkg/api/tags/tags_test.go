@@ -17,11 +17,21 @@ func Test_WildcardMatch(t *testing.T) {
 		{"middle_*_match", "middle_asdf_match_but_theres_more", false},
 		{"exact_match", "exact_match", true},
 		{"beginning_match", "non_beginning_match", false},
+		{"*ears", "ears_of_ears", true},
+		{"*ears", "ears_of_tears_too", false},
+		{"ears*", "ears_of_ears", true},
+		{"*_of_*", "ears_of_ears", true},
+		{"a*b*c", "abbc_abc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+		{"*", "anything", true},
+		{"**", "", true},
+		{"a*a", "a", false},
 	}
 
 	for _, x := range pairs {
 		if WildcardMatch(x.wildcard, x.test) != x.shouldMatch {
-			t.Errorf("\nExpected: %t\nActual:   %t\n", x.shouldMatch, !x.shouldMatch)
+			t.Errorf("\n%s vs %s\nExpected: %t\nActual:   %t\n", x.wildcard, x.test, x.shouldMatch, !x.shouldMatch)
 		}
 	}
 }
@@ -0,0 +1,933 @@
+package test
+
+import (
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"crypto/md5"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// the most results the site will return from a single listing request, and how many it returns if you don't say.
+const maxLimit = 320
+const defaultLimit = 75
+
+type fakeUser struct {
+	types.TUserInfo
+	ApiKey string `json:"api_key"`
+}
+
+type fakeAlias struct {
+	types.TAliasData
+	Status string `json:"status"`
+}
+
+type fakeImplication struct {
+	types.TImplicationData
+	Status string `json:"status"`
+}
+
+type interaction struct {
+	user string
+	post int
+}
+
+type failure struct {
+	prefix string
+	status int
+	times  int
+}
+
+// Request is a record of a call made to the fake site, for tests which want to check what was sent.
+type Request struct {
+	Method string
+	Path   string
+	User   string
+	Query  url.Values
+	Form   url.Values
+}
+
+// FakeBooru is an in-process stand-in for the site's REST api, serving posts, comments, pools, tags, aliases, implications and users
+// loaded from the JSON fixtures in this package. It supports enough of the api to sync, search, edit, vote, favorite and
+// upload, and keeps track of changes so tests can check what happened afterwards. Failures, including rate
+// limiting, can be injected with Fail and RateLimit.
+type FakeBooru struct {
+	Server *httptest.Server
+
+	lock       sync.Mutex
+	posts      map[int]*types.TPostInfo
+	tags     []types.TTagData
+	aliases  []fakeAlias
+	implications []fakeImplication
+	pools    []types.TPoolData
+	comments []types.TCommentData
+	users    []fakeUser
+	votes      map[interaction]types.PostVote
+	favorites  map[interaction]bool
+	change_seq int
+	failures []failure
+	requests []Request
+}
+
+// Settings describes how to reach a FakeBooru, and can be passed directly to api.Init.
+type Settings struct {
+	Endpoint string
+}
+
+func (this Settings) GetApiName() string { return "fakebooru" }
+func (this Settings) GetApiEndpoint() string { return this.Endpoint }
+func (this Settings) GetApiFilteredEndpoint() string { return this.Endpoint }
+func (this Settings) GetApiStaticPrefix() string { return "static." }
+func (this Settings) GetApiBackend() string { return "e621" }
+func (this Settings) GetApiScheme() string { return "http" }
+func (this Settings) GetApiRequestInterval() time.Duration { return 0 }
+
+func loadFixture(name string, into interface{}) {
+	data, err := fixtures.ReadFile(path.Join("fixtures", name))
+	if err != nil { panic(err) }
+	if err = json.Unmarshal(data, into); err != nil { panic(fmt.Sprintf("%s: %s", name, err.Error())) }
+}
+
+// NewFakeBooru starts a fake site loaded with the default fixtures. Close it when you're done with it.
+func NewFakeBooru() *FakeBooru {
+	this := &FakeBooru{
+		posts: make(map[int]*types.TPostInfo),
+		votes: make(map[interaction]types.PostVote),
+		favorites: make(map[interaction]bool),
+	}
+
+	var posts types.TPostListing
+	loadFixture("posts.json", &posts)
+	for i := range posts.Posts {
+		p := posts.Posts[i]
+		this.posts[p.Id] = &p
+		if p.Change > this.change_seq { this.change_seq = p.Change }
+	}
+
+	loadFixture("tags.json", &this.tags)
+	loadFixture("tag_aliases.json", &this.aliases)
+	loadFixture("tag_implications.json", &this.implications)
+	loadFixture("pools.json", &this.pools)
+	loadFixture("comments.json", &this.comments)
+	loadFixture("users.json", &this.users)
+
+	this.Server = httptest.NewServer(http.HandlerFunc(this.serve))
+	return this
+}
+
+func (this *FakeBooru) Close() {
+	this.Server.Close()
+}
+
+func (this *FakeBooru) Settings() Settings {
+	return Settings{Endpoint: strings.TrimPrefix(this.Server.URL, "http://")}
+}
+
+// Fail makes the next n requests whose path starts with prefix fail with the given http status.
+func (this *FakeBooru) Fail(prefix string, status, n int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failures = append(this.failures, failure{prefix: prefix, status: status, times: n})
+}
+
+// RateLimit makes the next n requests of any kind fail the way the site does when it is being called too often.
+func (this *FakeBooru) RateLimit(n int) {
+	this.Fail("/", http.StatusServiceUnavailable, n)
+}
+
+// Requests returns every request the fake site has received so far, in order.
+func (this *FakeBooru) Requests() []Request {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]Request(nil), this.requests...)
+}
+
+// Post returns a copy of the current state of a post, or nil if there is no such post.
+func (this *FakeBooru) Post(id int) *types.TPostInfo {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	p, ok := this.posts[id]
+	if !ok { return nil }
+	out := *p
+	return &out
+}
+
+// Pool returns a copy of the current state of a pool, or nil if there is no such pool.
+func (this *FakeBooru) Pool(id int) *types.TPoolData {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	p := this.pool(id)
+	if p == nil { return nil }
+	out := *p
+	out.PostIds = append([]int(nil), p.PostIds...)
+	return &out
+}
+
+// Comments returns every comment on a post, oldest first.
+func (this *FakeBooru) Comments(post int) []types.TCommentData {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var out []types.TCommentData
+	for _, c := range this.comments {
+		if c.PostId == post { out = append(out, c) }
+	}
+	return out
+}
+
+func (this *FakeBooru) pool(id int) *types.TPoolData {
+	for i := range this.pools {
+		if this.pools[i].Id == id { return &this.pools[i] }
+	}
+	return nil
+}
+
+func (this *FakeBooru) Vote(user string, id int) types.PostVote {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.votes[interaction{strings.ToLower(user), id}]
+}
+
+func (this *FakeBooru) Favorited(user string, id int) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.favorites[interaction{strings.ToLower(user), id}]
+}
+
+// SetChangeSeq moves the site's change sequence counter, so posts touched afterwards sort after anything else.
+func (this *FakeBooru) SetChangeSeq(seq int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.change_seq = seq
+}
+
+// Touch marks a post as changed, as though someone edited it on the site.
+func (this *FakeBooru) Touch(id int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if p, ok := this.posts[id]; ok { this.touch(p) }
+}
+
+func (this *FakeBooru) touch(p *types.TPostInfo) {
+	this.change_seq++
+	p.Change = this.change_seq
+}
+
+var postPath = regexp.MustCompile(`^/posts/(\d+)\.json$`)
+var votePath = regexp.MustCompile(`^/posts/(\d+)/votes\.json$`)
+var tagPath = regexp.MustCompile(`^/tags/(\d+)\.json$`)
+var favoritePath = regexp.MustCompile(`^/favorites/(\d+)\.json$`)
+var poolPath = regexp.MustCompile(`^/pools/(\d+)\.json$`)
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil { json.NewEncoder(w).Encode(body) }
+}
+
+func replyError(w http.ResponseWriter, status int, key, message string) {
+	reply(w, status, map[string]interface{}{"success": false, key: message})
+}
+
+func (this *FakeBooru) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.ParseMultipartForm(32 << 20)
+	} else {
+		r.ParseForm()
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	username, key, has_auth := r.BasicAuth()
+	this.requests = append(this.requests, Request{Method: r.Method, Path: r.URL.Path, User: username, Query: r.URL.Query(), Form: r.PostForm})
+
+	for i := range this.failures {
+		f := &this.failures[i]
+		if f.times > 0 && strings.HasPrefix(r.URL.Path, f.prefix) {
+			f.times--
+			replyError(w, f.status, "message", http.StatusText(f.status))
+			return
+		}
+	}
+
+	var user *fakeUser
+	if has_auth {
+		for i := range this.users {
+			if strings.ToLower(this.users[i].Name) == strings.ToLower(username) && this.users[i].ApiKey == key {
+				user = &this.users[i]
+			}
+		}
+		if user == nil {
+			replyError(w, http.StatusUnauthorized, "message", "SessionLoader::AuthenticationFailure")
+			return
+		}
+	}
+
+	if r.Method != http.MethodGet && user == nil {
+		replyError(w, http.StatusForbidden, "message", "Access Denied")
+		return
+	}
+
+	id := func(m []string) int { i, _ := strconv.Atoi(m[1]); return i }
+
+	switch p := r.URL.Path; {
+	case p == "/posts.json" && r.Method == http.MethodGet:
+		this.listPosts(w, r)
+	case postPath.MatchString(p) && r.Method == http.MethodGet:
+		this.showPost(w, id(postPath.FindStringSubmatch(p)))
+	case postPath.MatchString(p) && r.Method == http.MethodPatch:
+		this.editPost(w, r, id(postPath.FindStringSubmatch(p)))
+	case votePath.MatchString(p) && r.Method == http.MethodPost:
+		this.votePost(w, r, user, id(votePath.FindStringSubmatch(p)))
+	case votePath.MatchString(p) && r.Method == http.MethodDelete:
+		this.unvotePost(w, user, id(votePath.FindStringSubmatch(p)))
+	case p == "/favorites.json" && r.Method == http.MethodPost:
+		this.favoritePost(w, r, user)
+	case favoritePath.MatchString(p) && r.Method == http.MethodDelete:
+		this.unfavoritePost(w, user, id(favoritePath.FindStringSubmatch(p)))
+	case p == "/uploads.json" && r.Method == http.MethodPost:
+		this.upload(w, r, user)
+	case p == "/tags.json" && r.Method == http.MethodGet:
+		this.listTags(w, r)
+	case tagPath.MatchString(p) && r.Method == http.MethodGet:
+		this.showTag(w, id(tagPath.FindStringSubmatch(p)))
+	case p == "/tag_aliases.json" && r.Method == http.MethodGet:
+		this.listAliases(w, r)
+	case p == "/tag_implications.json" && r.Method == http.MethodGet:
+		this.listImplications(w, r)
+	case p == "/comments.json" && r.Method == http.MethodGet:
+		this.listComments(w, r)
+	case p == "/comments.json" && r.Method == http.MethodPost:
+		this.createComment(w, r, user)
+	case p == "/pools.json" && r.Method == http.MethodGet:
+		this.listPools(w, r)
+	case poolPath.MatchString(p) && r.Method == http.MethodGet:
+		this.showPool(w, id(poolPath.FindStringSubmatch(p)))
+	case p == "/users.json" && r.Method == http.MethodGet:
+		this.listUsers(w, r, user)
+	default:
+		replyError(w, http.StatusNotFound, "reason", "not found")
+	}
+}
+
+func limitArg(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 { return defaultLimit }
+	if limit > maxLimit { return maxLimit }
+	return limit
+}
+
+// picks one page out of a list of ids, which should already be sorted in the order they'll be returned in.
+// "a" and "b" pages select by id, the way the site does, and numbered pages select by position.
+func paginate(ids []int, page string, limit int) []int {
+	var out []int
+	if strings.HasPrefix(page, "a") || strings.HasPrefix(page, "b") {
+		n, _ := strconv.Atoi(page[1:])
+		for _, id := range ids {
+			if page[0] == 'a' && id > n || page[0] == 'b' && id < n { out = append(out, id) }
+		}
+		// after-pages return the ids just past the cursor, but still in descending order.
+		sort.Sort(sort.Reverse(sort.IntSlice(out)))
+		if len(out) > limit {
+			if page[0] == 'a' {
+				out = out[len(out) - limit:]
+			} else {
+				out = out[:limit]
+			}
+		}
+		return out
+	}
+
+	n, _ := strconv.Atoi(page)
+	if n < 1 { n = 1 }
+	start := (n - 1) * limit
+	if start >= len(ids) { return nil }
+	end := start + limit
+	if end > len(ids) { end = len(ids) }
+	return ids[start:end]
+}
+
+// supports the subset of the search syntax that the bot actually sends.
+func (this *FakeBooru) matchPost(p *types.TPostInfo, terms []string) bool {
+	status := "active"
+	ts := p.TagSet()
+	for _, t := range terms {
+		negate := strings.HasPrefix(t, "-")
+		t = strings.TrimPrefix(t, "-")
+		var match bool
+		switch {
+		case strings.HasPrefix(t, "status:"):
+			status = strings.TrimPrefix(t, "status:")
+			continue
+		case strings.HasPrefix(t, "order:"):
+			continue
+		case strings.HasPrefix(t, "id:"):
+			match = matchRange(strings.TrimPrefix(t, "id:"), p.Id)
+		case strings.HasPrefix(t, "change:"):
+			match = matchRange(strings.TrimPrefix(t, "change:"), p.Change)
+		case strings.HasPrefix(t, "md5:"):
+			match = strings.TrimPrefix(t, "md5:") == p.Md5
+		case strings.HasPrefix(t, "pool:"):
+			id, _ := strconv.Atoi(strings.TrimPrefix(t, "pool:"))
+			match = this.inPool(id, p.Id)
+		case strings.HasPrefix(t, "rating:"):
+			match = strings.HasPrefix(strings.TrimPrefix(t, "rating:"), string(p.Rating))
+		default:
+			match = ts.Status(t) == tags.AddsTag
+		}
+		if match == negate { return false }
+	}
+
+	switch status {
+	case "any":
+		return true
+	case "deleted":
+		return p.Deleted
+	default:
+		return !p.Deleted
+	}
+}
+
+func (this *FakeBooru) inPool(pool, post int) bool {
+	if p := this.pool(pool); p != nil {
+		for _, id := range p.PostIds { if id == post { return true } }
+	}
+	return false
+}
+
+func matchRange(spec string, value int) bool {
+	if strings.HasPrefix(spec, ">") {
+		n, err := strconv.Atoi(spec[1:])
+		return err == nil && value > n
+	} else if strings.HasPrefix(spec, "<") {
+		n, err := strconv.Atoi(spec[1:])
+		return err == nil && value < n
+	}
+	for _, s := range strings.Split(spec, ",") {
+		if n, err := strconv.Atoi(s); err == nil && n == value { return true }
+	}
+	return false
+}
+
+func (this *FakeBooru) listPosts(w http.ResponseWriter, r *http.Request) {
+	terms := strings.Fields(strings.ToLower(r.URL.Query().Get("tags")))
+
+	var matches []*types.TPostInfo
+	for _, p := range this.posts {
+		if this.matchPost(p, terms) { matches = append(matches, p) }
+	}
+
+	order := "id_desc"
+	for _, t := range terms {
+		if strings.HasPrefix(t, "order:") { order = strings.TrimPrefix(t, "order:") }
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		switch order {
+		case "change_asc":
+			return matches[i].Change < matches[j].Change
+		case "id_asc":
+			return matches[i].Id < matches[j].Id
+		}
+		return matches[i].Id > matches[j].Id
+	})
+
+	byid := make(map[int]*types.TPostInfo)
+	var ids []int
+	for _, p := range matches {
+		byid[p.Id] = p
+		ids = append(ids, p.Id)
+	}
+
+	out := types.TPostInfoArray{}
+	for _, id := range paginate(ids, r.URL.Query().Get("page"), limitArg(r)) {
+		out = append(out, *byid[id])
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"posts": out})
+}
+
+func (this *FakeBooru) showPost(w http.ResponseWriter, id int) {
+	p, ok := this.posts[id]
+	if !ok {
+		replyError(w, http.StatusNotFound, "reason", "not found")
+		return
+	}
+	reply(w, http.StatusOK, map[string]interface{}{"post": p})
+}
+
+func (this *FakeBooru) categoryOf(tag string) types.TagCategory {
+	for _, t := range this.tags {
+		if t.Name == tag { return t.Type }
+	}
+	return types.TCGeneral
+}
+
+func (this *FakeBooru) editPost(w http.ResponseWriter, r *http.Request, id int) {
+	p, ok := this.posts[id]
+	if !ok {
+		replyError(w, http.StatusNotFound, "reason", "not found")
+		return
+	} else if p.Deleted {
+		replyError(w, http.StatusForbidden, "reason", "Access Denied: Post not visible to you")
+		return
+	}
+
+	form := r.PostForm
+	if rating, ok := form["post[rating]"]; ok {
+		if rating[0] != "s" && rating[0] != "q" && rating[0] != "e" {
+			replyError(w, http.StatusUnprocessableEntity, "reason", "rating is invalid")
+			return
+		}
+		p.Rating = types.PostRating(rating[0])
+	}
+
+	if diff, ok := form["post[tag_string_diff]"]; ok {
+		var td tags.TagDiff
+		td.ApplyString(diff[0])
+		categories := map[types.TagCategory]*[]string{
+			types.TCGeneral: &p.General, types.TCSpecies: &p.Species, types.TCCharacter: &p.Character, types.TCCopyright: &p.Copyright,
+			types.TCArtist: &p.Artist, types.TCInvalid: &p.Invalid, types.TCLore: &p.Lore, types.TCMeta: &p.Meta,
+		}
+		current := p.TagSet()
+		for tag, _ := range td.AddList {
+			if strings.HasPrefix(tag, "pool:") {
+				this.addToPool(tag, p.Id)
+				continue
+			}
+			if current.Status(tag) == tags.AddsTag { continue }
+			list := categories[this.categoryOf(tag)]
+			*list = append(*list, tag)
+		}
+		for tag, _ := range td.RemoveList {
+			if strings.HasPrefix(tag, "pool:") {
+				this.removeFromPool(tag, p.Id)
+				continue
+			}
+			for _, list := range categories {
+				var kept []string
+				for _, t := range *list { if t != tag { kept = append(kept, t) } }
+				if kept == nil { kept = []string{} }
+				*list = kept
+			}
+		}
+	}
+
+	if parent, ok := form["post[parent_id]"]; ok {
+		p.Parent_id, _ = strconv.Atoi(parent[0])
+	}
+
+	if sourcediff, ok := form["post[source_diff]"]; ok {
+		for _, s := range strings.Split(sourcediff[0], "\n") {
+			if strings.HasPrefix(s, "-") {
+				var kept []string
+				for _, x := range p.Sources { if x != s[1:] { kept = append(kept, x) } }
+				p.Sources = kept
+			} else if s != "" {
+				p.Sources = append(p.Sources, s)
+			}
+		}
+	}
+
+	if description, ok := form["post[description]"]; ok {
+		p.Description = description[0]
+	}
+
+	this.touch(p)
+	reply(w, http.StatusOK, map[string]interface{}{"post": p})
+}
+
+// pool metatags add posts to the end of a pool, or take them out of it.
+func (this *FakeBooru) addToPool(tag string, post int) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(tag, "pool:"))
+	if p := this.pool(id); p != nil && !this.inPool(id, post) {
+		p.PostIds = append(p.PostIds, post)
+		p.PostCount = len(p.PostIds)
+	}
+}
+
+func (this *FakeBooru) removeFromPool(tag string, post int) {
+	id, _ := strconv.Atoi(strings.TrimPrefix(tag, "pool:"))
+	if p := this.pool(id); p != nil {
+		kept := []int{}
+		for _, x := range p.PostIds { if x != post { kept = append(kept, x) } }
+		p.PostIds = kept
+		p.PostCount = len(p.PostIds)
+	}
+}
+
+func (this *FakeBooru) votePost(w http.ResponseWriter, r *http.Request, user *fakeUser, id int) {
+	p, ok := this.posts[id]
+	if !ok {
+		replyError(w, http.StatusNotFound, "reason", "not found")
+		return
+	}
+
+	score, err := strconv.Atoi(r.PostForm.Get("score"))
+	if err != nil || (score != 1 && score != -1) {
+		replyError(w, http.StatusUnprocessableEntity, "reason", "score is invalid")
+		return
+	}
+
+	// voting the same way twice takes the vote back, unless no_unvote is set.
+	key := interaction{strings.ToLower(user.Name), id}
+	vote := types.PostVote(score)
+	if this.votes[key] == vote {
+		if r.PostForm.Get("no_unvote") != "true" { this.removeVote(p, key) }
+	} else {
+		this.removeVote(p, key)
+		this.votes[key] = vote
+		if vote == types.Upvote { p.Upvotes++ } else { p.Downvotes-- }
+		p.Score += vote.Value()
+	}
+
+	reply(w, http.StatusOK, map[string]interface{}{"up": p.Upvotes, "down": p.Downvotes, "total": p.Score, "our_score": this.votes[key]})
+}
+
+func (this *FakeBooru) removeVote(p *types.TPostInfo, key interaction) {
+	vote, ok := this.votes[key]
+	if !ok { return }
+	delete(this.votes, key)
+	if vote == types.Upvote { p.Upvotes-- } else { p.Downvotes++ }
+	p.Score -= vote.Value()
+}
+
+func (this *FakeBooru) unvotePost(w http.ResponseWriter, user *fakeUser, id int) {
+	if p, ok := this.posts[id]; ok { this.removeVote(p, interaction{strings.ToLower(user.Name), id}) }
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *FakeBooru) favoritePost(w http.ResponseWriter, r *http.Request, user *fakeUser) {
+	id, _ := strconv.Atoi(r.PostForm.Get("post_id"))
+	p, ok := this.posts[id]
+	if !ok {
+		replyError(w, http.StatusNotFound, "message", "not found")
+		return
+	}
+
+	key := interaction{strings.ToLower(user.Name), id}
+	if this.favorites[key] {
+		replyError(w, http.StatusUnprocessableEntity, "message", "You have already favorited this post")
+		return
+	}
+
+	this.favorites[key] = true
+	p.Fav_count++
+	reply(w, http.StatusOK, map[string]interface{}{"post": p})
+}
+
+func (this *FakeBooru) unfavoritePost(w http.ResponseWriter, user *fakeUser, id int) {
+	key := interaction{strings.ToLower(user.Name), id}
+	if this.favorites[key] {
+		delete(this.favorites, key)
+		this.posts[id].Fav_count--
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *FakeBooru) upload(w http.ResponseWriter, r *http.Request, user *fakeUser) {
+	var content []byte
+	if r.MultipartForm != nil && len(r.MultipartForm.File["upload[file]"]) != 0 {
+		f, err := r.MultipartForm.File["upload[file]"][0].Open()
+		if err == nil {
+			content, _ = ioutil.ReadAll(f)
+			f.Close()
+		}
+	} else if direct_url := r.PostForm.Get("upload[direct_url]"); direct_url != "" {
+		// there's nowhere to download from, so pretend the url is the file.
+		content = []byte(direct_url)
+	} else {
+		replyError(w, http.StatusPreconditionFailed, "reason", "no file or url provided")
+		return
+	}
+
+	rating := r.PostForm.Get("upload[rating]")
+	if rating != "s" && rating != "q" && rating != "e" {
+		replyError(w, http.StatusPreconditionFailed, "reason", "rating is invalid")
+		return
+	}
+
+	sum := md5.Sum(content)
+	hash := hex.EncodeToString(sum[:])
+
+	highest := 0
+	for id, p := range this.posts {
+		if p.Md5 == hash {
+			reply(w, http.StatusPreconditionFailed, map[string]interface{}{"success": false, "reason": "duplicate", "location": fmt.Sprintf("/posts/%d", id), "post_id": id})
+			return
+		}
+		if id > highest { highest = id }
+	}
+
+	p := &types.TPostInfo{Id: highest + 1, Creator_id: user.Id, Rating: types.PostRating(rating), Description: r.PostForm.Get("upload[description]")}
+	p.Md5 = hash
+	p.File_size = len(content)
+	p.TPostTags = types.TPostTags{General: []string{}, Species: []string{}, Character: []string{}, Copyright: []string{}, Artist: []string{}, Invalid: []string{}, Lore: []string{}, Meta: []string{}}
+	for _, tag := range strings.Fields(r.PostForm.Get("upload[tag_string]")) {
+		switch this.categoryOf(tag) {
+		case types.TCSpecies:   p.Species = append(p.Species, tag)
+		case types.TCCharacter: p.Character = append(p.Character, tag)
+		case types.TCCopyright: p.Copyright = append(p.Copyright, tag)
+		case types.TCArtist:    p.Artist = append(p.Artist, tag)
+		case types.TCMeta:      p.Meta = append(p.Meta, tag)
+		default:                p.General = append(p.General, tag)
+		}
+	}
+	if source := r.PostForm.Get("upload[source]"); source != "" { p.Sources = strings.Split(source, "\n") }
+	if parent, err := strconv.Atoi(r.PostForm.Get("upload[parent_id]")); err == nil { p.Parent_id = parent }
+
+	this.touch(p)
+	this.posts[p.Id] = p
+	user.PostUploadCount++
+
+	reply(w, http.StatusOK, map[string]interface{}{"success": true, "location": fmt.Sprintf("/posts/%d", p.Id), "post_id": p.Id})
+}
+
+// converts the site's "name_matches" wildcard syntax to a regular expression.
+func wildcard(pattern string) *regexp.Regexp {
+	parts := strings.Split(strings.ToLower(pattern), "*")
+	for i := range parts { parts[i] = regexp.QuoteMeta(parts[i]) }
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (this *FakeBooru) listTags(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var matches []types.TTagData
+	for _, t := range this.tags {
+		if name := q.Get("search[name_matches]"); name != "" && !wildcard(name).MatchString(t.Name) { continue }
+		if category := q.Get("search[category]"); category != "" && category != strconv.Itoa(t.Type.Value()) { continue }
+		if q.Get("search[hide_empty]") == "true" && t.Count == 0 { continue }
+		matches = append(matches, t)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		switch q.Get("search[order]") {
+		case "count":
+			return matches[i].Count > matches[j].Count
+		case "name":
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].Id > matches[j].Id
+	})
+
+	byid := make(map[int]types.TTagData)
+	var ids []int
+	for _, t := range matches {
+		byid[t.Id] = t
+		ids = append(ids, t.Id)
+	}
+
+	var out types.TTagInfoArray
+	for _, id := range paginate(ids, q.Get("page"), limitArg(r)) {
+		out = append(out, byid[id])
+	}
+
+	// like the real site, an empty result comes back as an object instead of an empty list.
+	if len(out) == 0 {
+		reply(w, http.StatusOK, map[string]interface{}{"tags": []types.TTagData{}})
+		return
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (this *FakeBooru) showTag(w http.ResponseWriter, id int) {
+	for _, t := range this.tags {
+		if t.Id == id {
+			reply(w, http.StatusOK, t)
+			return
+		}
+	}
+	replyError(w, http.StatusNotFound, "reason", "not found")
+}
+
+func (this *FakeBooru) listAliases(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var matches []fakeAlias
+	for _, a := range this.aliases {
+		if name := q.Get("search[name_matches]"); name != "" && !wildcard(name).MatchString(a.Alias) && !wildcard(name).MatchString(a.Name) { continue }
+		if status := q.Get("search[status]"); status != "" && strings.ToLower(status) != a.Status { continue }
+		matches = append(matches, a)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Id > matches[j].Id })
+
+	byid := make(map[int]fakeAlias)
+	var ids []int
+	for _, a := range matches {
+		byid[a.Id] = a
+		ids = append(ids, a.Id)
+	}
+
+	var out []fakeAlias
+	for _, id := range paginate(ids, q.Get("page"), limitArg(r)) {
+		out = append(out, byid[id])
+	}
+
+	if len(out) == 0 {
+		reply(w, http.StatusOK, map[string]interface{}{"tag_aliases": []fakeAlias{}})
+		return
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (this *FakeBooru) listImplications(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var matches []fakeImplication
+	for _, i := range this.implications {
+		if name := q.Get("search[name_matches]"); name != "" && !wildcard(name).MatchString(i.Tag) && !wildcard(name).MatchString(i.Implies) { continue }
+		if status := q.Get("search[status]"); status != "" && strings.ToLower(status) != i.Status { continue }
+		matches = append(matches, i)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Id > matches[j].Id })
+
+	byid := make(map[int]fakeImplication)
+	var ids []int
+	for _, i := range matches {
+		byid[i.Id] = i
+		ids = append(ids, i.Id)
+	}
+
+	var out []fakeImplication
+	for _, id := range paginate(ids, q.Get("page"), limitArg(r)) {
+		out = append(out, byid[id])
+	}
+
+	if len(out) == 0 {
+		reply(w, http.StatusOK, map[string]interface{}{"tag_implications": []fakeImplication{}})
+		return
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (this *FakeBooru) listComments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("group_by") != "comment" {
+		replyError(w, http.StatusBadRequest, "message", "only comments grouped by comment are supported")
+		return
+	}
+
+	byid := make(map[int]types.TCommentData)
+	var ids []int
+	for _, c := range this.comments {
+		if post := q.Get("search[post_id]"); post != "" && post != strconv.Itoa(c.PostId) { continue }
+		byid[c.Id] = c
+		ids = append(ids, c.Id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+	var out []types.TCommentData
+	for _, id := range paginate(ids, q.Get("page"), limitArg(r)) {
+		out = append(out, byid[id])
+	}
+
+	if len(out) == 0 {
+		reply(w, http.StatusOK, map[string]interface{}{"comments": []types.TCommentData{}})
+		return
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (this *FakeBooru) createComment(w http.ResponseWriter, r *http.Request, user *fakeUser) {
+	id, _ := strconv.Atoi(r.PostForm.Get("comment[post_id]"))
+	p, ok := this.posts[id]
+	if !ok {
+		replyError(w, http.StatusNotFound, "message", "not found")
+		return
+	}
+
+	body := r.PostForm.Get("comment[body]")
+	if strings.TrimSpace(body) == "" {
+		reply(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": map[string][]string{"body": []string{"can't be blank"}}})
+		return
+	}
+
+	comment := types.TCommentData{
+		Id: len(this.comments) + 1,
+		PostId: id,
+		CreatorId: user.Id,
+		CreatorName: user.Name,
+		Body: body,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	this.comments = append(this.comments, comment)
+	p.Comment_count++
+	reply(w, http.StatusCreated, comment)
+}
+
+func (this *FakeBooru) listPools(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var matches []types.TPoolData
+	for _, p := range this.pools {
+		if name := q.Get("search[name_matches]"); name != "" && !wildcard(name).MatchString(p.Name) { continue }
+		if category := q.Get("search[category]"); category != "" && category != p.Category.String() { continue }
+		matches = append(matches, p)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		switch q.Get("search[order]") {
+		case "name":
+			return matches[i].Name < matches[j].Name
+		case "post_count":
+			return matches[i].PostCount > matches[j].PostCount
+		}
+		return matches[i].Id > matches[j].Id
+	})
+
+	byid := make(map[int]types.TPoolData)
+	var ids []int
+	for _, p := range matches {
+		byid[p.Id] = p
+		ids = append(ids, p.Id)
+	}
+
+	out := types.TPoolInfoArray{}
+	for _, id := range paginate(ids, q.Get("page"), limitArg(r)) {
+		out = append(out, byid[id])
+	}
+	reply(w, http.StatusOK, out)
+}
+
+func (this *FakeBooru) showPool(w http.ResponseWriter, id int) {
+	p := this.pool(id)
+	if p == nil {
+		replyError(w, http.StatusNotFound, "reason", "not found")
+		return
+	}
+	reply(w, http.StatusOK, p)
+}
+
+func (this *FakeBooru) listUsers(w http.ResponseWriter, r *http.Request, caller *fakeUser) {
+	name := r.URL.Query().Get("search[name_matches]")
+
+	out := types.TUserInfoArray{}
+	for _, u := range this.users {
+		if name != "" && !wildcard(name).MatchString(strings.ToLower(u.Name)) { continue }
+		info := u.TUserInfo
+		// private details are only shown to the user they belong to.
+		if caller == nil || caller.Id != u.Id { info.Email, info.Blacklist = "", "" }
+		out = append(out, info)
+	}
+
+	reply(w, http.StatusOK, out)
+}
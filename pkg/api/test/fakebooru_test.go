@@ -0,0 +1,366 @@
+package test
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func start(t *testing.T) *FakeBooru {
+	fake := NewFakeBooru()
+	if err := api.Init(fake.Settings()); err != nil { t.Fatalf("Couldn't initialize api: %s", err.Error()) }
+	return fake
+}
+
+// posts lose the difference between empty and missing lists on the way through json, so compare them the same way.
+func samePost(a, b types.TPostInfo) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+func postIds(posts types.TPostInfoArray) []int {
+	ids := []int{}
+	for _, p := range posts { ids = append(ids, p.Id) }
+	return ids
+}
+
+func Test_TestLogin(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	testcases := map[string]struct{
+		user, key string
+		expectedSuccess bool
+		expectedBlacklist string
+	}{
+		"good": {"alice", "alicekey", true, "gore\nscat"},
+		"other-user": {"Bob", "bobkey", true, ""},
+		"bad-key": {"alice", "bobkey", false, ""},
+		"no-such-user": {"carol", "carolkey", false, ""},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			user, success, err := api.TestLogin(v.user, v.key)
+			if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+			if success != v.expectedSuccess { t.Errorf("Unexpected login result: got %t, expected %t", success, v.expectedSuccess) }
+			if success && user.Blacklist != v.expectedBlacklist { t.Errorf("Unexpected blacklist: got %q, expected %q", user.Blacklist, v.expectedBlacklist) }
+		})
+	}
+}
+
+func Test_ListPosts(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	testcases := map[string]struct{
+		options types.ListPostOptions
+		expected []int
+	}{
+		"everything": {types.ListPostOptions{}, []int{104, 103, 102, 101}},
+		"tag": {types.ListPostOptions{SearchQuery: "wolf"}, []int{104, 101}},
+		"exclude": {types.ListPostOptions{SearchQuery: "canine -wolf"}, []int{103, 102}},
+		"rating": {types.ListPostOptions{SearchQuery: "rating:s solo"}, []int{104, 101}},
+		"deleted": {types.ListPostOptions{SearchQuery: types.DeletedPostsAfterId(0)}, []int{105}},
+		"changes": {types.ListPostOptions{SearchQuery: types.PostsAfterChangeSeq(1002)}, []int{103, 104, 105}},
+		"ids": {types.ListPostOptions{SearchQuery: "status:any id:101,105"}, []int{105, 101}},
+		"md5": {types.ListPostOptions{SearchQuery: types.SinglePostByMd5("22222222222222222222222222222222")}, []int{102}},
+		"limit": {types.ListPostOptions{Limit: 2}, []int{104, 103}},
+		"page": {types.ListPostOptions{Limit: 2, Page: types.Page(2)}, []int{102, 101}},
+		"after": {types.ListPostOptions{Limit: 2, Page: types.After(101)}, []int{103, 102}},
+		"before": {types.ListPostOptions{Limit: 2, Page: types.Before(104)}, []int{103, 102}},
+		"nothing": {types.ListPostOptions{SearchQuery: "dragon"}, []int{}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			posts, err := api.ListPosts("alice", "alicekey", v.options)
+			if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+			if ids := postIds(posts); !reflect.DeepEqual(ids, v.expected) { t.Errorf("Unexpected posts: got %v, expected %v", ids, v.expected) }
+		})
+	}
+}
+
+func Test_FetchOnePost(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	post, err := api.FetchOnePost("", "", 101)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if post == nil || !samePost(*post, *fake.Post(101)) { t.Errorf("Unexpected post: got %+v, expected %+v", post, fake.Post(101)) }
+
+	post, err = api.FetchOnePost("", "", 999)
+	if post != nil || err != nil { t.Errorf("Expected nothing for a missing post, got %+v, %v", post, err) }
+}
+
+func Test_UpdatePost(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	parent := 102
+	description := "new description"
+	post, err := api.UpdatePost("alice", "alicekey", 101, tags.TagDiffFromString("fox -solo"), types.Questionable, &parent, []string{"-https://example.com/art/101", "https://example.com/art/101b"}, &description, nil)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if post == nil { t.Fatalf("Expected a post back from the edit") }
+
+	after := fake.Post(101)
+	ts := after.TagSet()
+	if ts.Status("fox") != tags.AddsTag || ts.Status("solo") != tags.NotPresent { t.Errorf("Tags not edited correctly: %v", after.Tags()) }
+	if !reflect.DeepEqual(after.Species, []string{"canine", "mammal", "wolf", "fox"}) { t.Errorf("Added tag not put in its category: %v", after.Species) }
+	if after.Rating != types.Questionable { t.Errorf("Rating not changed: %s", after.Rating) }
+	if after.Parent_id != 102 { t.Errorf("Parent not changed: %d", after.Parent_id) }
+	if !reflect.DeepEqual(after.Sources, []string{"https://example.com/art/101b"}) { t.Errorf("Sources not changed: %v", after.Sources) }
+	if after.Description != description { t.Errorf("Description not changed: %s", after.Description) }
+	if after.Change <= 1005 { t.Errorf("Change sequence not advanced: %d", after.Change) }
+	if !samePost(*post, *after) { t.Errorf("Returned post doesn't match: got %+v, expected %+v", *post, *after) }
+
+	_, err = api.UpdatePost("alice", "alicekey", 105, tags.TagDiffFromString("fox"), types.Original, nil, nil, nil, nil)
+	if err != api.PostIsDeleted { t.Errorf("Expected PostIsDeleted editing a deleted post, got %v", err) }
+}
+
+func Test_Votes(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	steps := []struct{
+		name string
+		vote types.PostVote
+		no_unvote bool
+		unvote bool
+		expected types.PostVote
+		expectedScore int
+	}{
+		{"upvote", types.Upvote, false, false, types.Upvote, 10},
+		{"upvote-again-keep", types.Upvote, true, false, types.Upvote, 10},
+		{"upvote-again-toggle", types.Upvote, false, false, types.Neutral, 9},
+		{"downvote", types.Downvote, false, false, types.Downvote, 8},
+		{"switch", types.Upvote, false, false, types.Upvote, 10},
+		{"unvote", types.Neutral, false, true, types.Neutral, 9},
+	}
+
+	// these depend on each other, so they have to run in order.
+	for _, v := range steps {
+		if v.unvote {
+			if err := api.UnvotePost("bob", "bobkey", 101); err != nil { t.Fatalf("%s: unexpected error: %s", v.name, err.Error()) }
+		} else {
+			score, err := api.VotePost("bob", "bobkey", 101, v.vote, v.no_unvote)
+			if err != nil { t.Fatalf("%s: unexpected error: %s", v.name, err.Error()) }
+			if score.OurScore != v.expected { t.Errorf("%s: unexpected vote: got %d, expected %d", v.name, score.OurScore, v.expected) }
+			if score.Score != v.expectedScore { t.Errorf("%s: unexpected score: got %d, expected %d", v.name, score.Score, v.expectedScore) }
+		}
+		if vote := fake.Vote("bob", 101); vote != v.expected { t.Errorf("%s: site recorded %d, expected %d", v.name, vote, v.expected) }
+		if score := fake.Post(101).Score; score != v.expectedScore { t.Errorf("%s: site score %d, expected %d", v.name, score, v.expectedScore) }
+	}
+}
+
+func Test_Favorites(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	post, err := api.FavoritePost("alice", "alicekey", 102)
+	if err != nil || post == nil || post.Fav_count != 4 { t.Errorf("Unexpected favorite result: %+v, %v", post, err) }
+	if !fake.Favorited("alice", 102) { t.Errorf("Favorite not recorded") }
+
+	// favoriting twice isn't an error.
+	post, err = api.FavoritePost("alice", "alicekey", 102)
+	if err != nil || post != nil { t.Errorf("Unexpected result favoriting twice: %+v, %v", post, err) }
+
+	if err = api.UnfavoritePost("alice", "alicekey", 102); err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+	if fake.Favorited("alice", 102) || fake.Post(102).Fav_count != 3 { t.Errorf("Unfavorite not recorded") }
+}
+
+func Test_UploadFile(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	var ts tags.TagSet
+	ts.ApplyString("solo wolf some_artist")
+	parent := 101
+
+	result, err := api.UploadFile(ioutil.NopCloser(bytes.NewReader([]byte("new file contents"))), "", ts, types.Safe, "https://example.com/new", "", &parent, "alice", "alicekey")
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if !result.Success || result.Location == nil || *result.Location != "/posts/106" { t.Fatalf("Unexpected upload result: %+v", result) }
+
+	post := fake.Post(106)
+	if post == nil { t.Fatalf("Upload didn't create a post") }
+	sort.Strings(post.General)
+	if !reflect.DeepEqual(post.General, []string{"solo"}) || !reflect.DeepEqual(post.Species, []string{"wolf"}) || !reflect.DeepEqual(post.Artist, []string{"some_artist"}) {
+		t.Errorf("Uploaded tags not categorized correctly: %+v", post.TPostTags)
+	}
+	if post.Parent_id != 101 || post.Rating != types.Safe { t.Errorf("Uploaded post has wrong details: %+v", post) }
+
+	result, err = api.UploadFile(ioutil.NopCloser(bytes.NewReader([]byte("new file contents"))), "", ts, types.Safe, "", "", nil, "alice", "alicekey")
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if result.Success || result.StatusCode != http.StatusPreconditionFailed || result.Reason == nil || *result.Reason != "duplicate" {
+		t.Errorf("Expected a duplicate upload to be rejected, got %+v", result)
+	}
+}
+
+func Test_ListTags(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	species := types.TCSpecies
+	testcases := map[string]struct{
+		options types.ListTagsOptions
+		expected []int
+	}{
+		"after": {types.ListTagsOptions{Page: types.After(18), Limit: 2}, []int{20, 19}},
+		"after-end": {types.ListTagsOptions{Page: types.After(22), Limit: 2}, nil},
+		"match": {types.ListTagsOptions{MatchTags: "*_artist"}, []int{16, 15, 14}},
+		"category": {types.ListTagsOptions{Category: &species, HideEmpty: true, Order: types.TSOName}, []int{9, 13, 12, 10, 11}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			list, err := api.ListTags("", "", v.options)
+			if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+			var ids []int
+			for _, tag := range list { ids = append(ids, tag.Id) }
+			if !reflect.DeepEqual(ids, v.expected) { t.Errorf("Unexpected tags: got %v, expected %v", ids, v.expected) }
+		})
+	}
+}
+
+func Test_ListTagAliases(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	list, err := api.ListTagAliases("", "", types.ListTagAliasOptions{Page: types.After(0), Limit: 10000, Status: types.ASActive})
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	var names []string
+	for _, a := range list { names = append(names, a.Alias + ">" + a.Name) }
+	expected := []string{"standing_up>standing", "vulpine>fox", "lupine>wolf"}
+	if !reflect.DeepEqual(names, expected) { t.Errorf("Unexpected aliases: got %v, expected %v", names, expected) }
+}
+
+func Test_ListTagImplications(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	list, err := api.ListTagImplications("", "", types.ListTagImplicationOptions{Page: types.After(0), Limit: 10000, Status: types.ASActive})
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	var names []string
+	for _, i := range list { names = append(names, i.Tag + ">" + i.Implies) }
+	expected := []string{"webm>animated", "felid>mammal", "canine>mammal", "fox>canine", "wolf>canine"}
+	if !reflect.DeepEqual(names, expected) { t.Errorf("Unexpected implications: got %v, expected %v", names, expected) }
+}
+
+func Test_ListPools(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	testcases := map[string]struct{
+		options  types.ListPoolOptions
+		expected []int
+	}{
+		"all": {types.ListPoolOptions{}, []int{3, 2, 1}},
+		"name": {types.ListPoolOptions{MatchName: "*comic*"}, []int{3}},
+		"category": {types.ListPoolOptions{Category: types.PCSeries, Order: types.PSOName}, []int{3, 1}},
+		"page": {types.ListPoolOptions{Page: types.Page(2), Limit: 2}, []int{1}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			pools, err := api.ListPools("", "", v.options)
+			if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+			ids := []int{}
+			for _, p := range pools { ids = append(ids, p.Id) }
+			if !reflect.DeepEqual(ids, v.expected) { t.Errorf("Unexpected pools: got %v, expected %v", ids, v.expected) }
+		})
+	}
+}
+
+func Test_FetchPool(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	pool, err := api.FetchPool("", "", 1)
+	if err != nil || pool == nil { t.Fatalf("Unexpected result: %v %v", pool, err) }
+	if pool.DisplayName() != "wolf run" || !reflect.DeepEqual(pool.PostIds, []int{104, 101}) { t.Errorf("Unexpected pool: %+v", *pool) }
+
+	pool, err = api.FetchPool("", "", 999)
+	if err != nil || pool != nil { t.Errorf("Expected no pool, got %v %v", pool, err) }
+
+	// posts go in and out of pools using metatags.
+	_, err = api.UpdatePost("alice", "alicekey", 102, tags.TagDiffFromString("pool:1 -pool:2"), types.Original, nil, nil, nil, nil)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if ids := fake.Pool(1).PostIds; !reflect.DeepEqual(ids, []int{104, 101, 102}) { t.Errorf("Unexpected pool 1: %v", ids) }
+	if ids := fake.Pool(2).PostIds; !reflect.DeepEqual(ids, []int{103}) { t.Errorf("Unexpected pool 2: %v", ids) }
+	if ts := fake.Post(102).TagSet(); ts.Status("pool:1") == tags.AddsTag { t.Errorf("Pool metatag shouldn't become a tag") }
+
+	posts, err := api.ListPosts("", "", types.ListPostOptions{SearchQuery: "pool:1"})
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if ids := postIds(posts); !reflect.DeepEqual(ids, []int{104, 102, 101}) { t.Errorf("Unexpected posts: %v", ids) }
+}
+
+func Test_ListComments(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	testcases := map[string]struct{
+		options  types.ListCommentOptions
+		expected []int
+	}{
+		"post": {types.ListCommentOptions{PostId: 101}, []int{2, 1}},
+		"limit": {types.ListCommentOptions{PostId: 104, Limit: 3}, []int{7, 6, 5}},
+		"none": {types.ListCommentOptions{PostId: 102}, []int{}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			comments, err := api.ListComments("", "", v.options)
+			if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+			ids := []int{}
+			for _, c := range comments { ids = append(ids, c.Id) }
+			if !reflect.DeepEqual(ids, v.expected) { t.Errorf("Unexpected comments: got %v, expected %v", ids, v.expected) }
+		})
+	}
+}
+
+func Test_CreateComment(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	comment, err := api.CreateComment("alice", "alicekey", 102, "[b]nice[/b]")
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if comment == nil || comment.PostId != 102 || comment.CreatorName != "Alice" || comment.Body != "[b]nice[/b]" { t.Errorf("Unexpected comment: %+v", comment) }
+	if comments := fake.Comments(102); len(comments) != 1 { t.Errorf("Comment wasn't saved: %v", comments) }
+	if fake.Post(102).Comment_count != 1 { t.Errorf("Comment count wasn't updated") }
+
+	if _, err = api.CreateComment("alice", "alicekey", 102, " "); err == nil { t.Errorf("Expected an empty comment to fail") }
+	if _, err = api.CreateComment("", "", 102, "anonymous"); err == nil { t.Errorf("Expected an anonymous comment to fail") }
+}
+
+func Test_Failures(t *testing.T) {
+	fake := start(t)
+	defer fake.Close()
+
+	fake.Fail("/posts.json", http.StatusInternalServerError, 1)
+	if _, err := api.ListPosts("", "", types.ListPostOptions{}); err == nil || err.Error() != "500 Internal Server Error" {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+
+	fake.RateLimit(2)
+	for i := 0; i < 2; i++ {
+		if _, err := api.ListTags("", "", types.ListTagsOptions{}); err == nil || err.Error() != "503 Service Unavailable" {
+			t.Errorf("Expected to be rate limited, got %v", err)
+		}
+	}
+
+	// the failures are used up, so things should work again.
+	if posts, err := api.ListPosts("", "", types.ListPostOptions{}); err != nil || len(posts) != 4 {
+		t.Errorf("Expected the site to recover, got %d posts, %v", len(posts), err)
+	}
+
+	if len(fake.Requests()) != 4 { t.Errorf("Unexpected number of requests: %d", len(fake.Requests())) }
+}
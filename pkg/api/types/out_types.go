@@ -2,6 +2,8 @@ package types
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type TagCategory int
@@ -39,6 +41,18 @@ const ASRetired    AliasStatus = "Retired"
 const ASProcessing AliasStatus = "Processing"
 const ASQueued     AliasStatus = "Queued"
 
+type PoolCategory string
+func (this PoolCategory) String() string { return string(this) }
+const PCSeries     PoolCategory = "series"
+const PCCollection PoolCategory = "collection"
+
+type PoolSearchOrder string
+func (this PoolSearchOrder) String() string { return string(this) }
+const PSOUpdated   PoolSearchOrder = "updated_at" // default
+const PSOCreated   PoolSearchOrder = "created_at"
+const PSOName      PoolSearchOrder = "name"
+const PSOPostCount PoolSearchOrder = "post_count"
+
 type PostVote int
 func (this PostVote) Value() int { return int(this) }
 const Upvote   PostVote = 1
@@ -75,6 +89,12 @@ func DeletedPostsAfterId(id int) (string) {
 	return fmt.Sprintf("status:deleted order:id_asc id:>%d", id)
 }
 
+func PostsById(ids []int) (string) {
+	var id_strings []string
+	for _, id := range ids { id_strings = append(id_strings, strconv.Itoa(id)) }
+	return fmt.Sprintf("status:any id:%s", strings.Join(id_strings, ","))
+}
+
 func SinglePostByMd5(md5 string) (string) {
 	return fmt.Sprintf("status:any md5:%s", md5)
 }
@@ -111,6 +131,29 @@ type ListTagAliasOptions struct {
 	Order               AliasSearchOrder
 }
 
+// implications have the same statuses and orderings as aliases.
+type ListTagImplicationOptions struct {
+	Page                PageSelector
+	Limit               int
+	MatchImplications   string
+	Status              AliasStatus
+	Order               AliasSearchOrder
+}
+
+type ListPoolOptions struct {
+	Page        PageSelector
+	Limit       int
+	MatchName   string
+	Category    PoolCategory
+	Order       PoolSearchOrder
+}
+
+type ListCommentOptions struct {
+	Page        PageSelector
+	Limit       int
+	PostId      int
+}
+
 type ListPostOptions struct {
 	Page        PageSelector
 	Limit       int
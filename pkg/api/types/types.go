@@ -119,6 +119,112 @@ func (this *TAliasListing) UnmarshalJSON(b []byte) (error) {
 	return err
 }
 
+type TImplicationData struct {
+	Id int         `json:"id"`
+	Tag string     `json:"antecedent_name"`
+	Implies string `json:"consequent_name"`
+
+	// reason
+	// creator_id
+	// created_at
+	// updated_at
+	// forum_post_id
+	// forum_topic_id
+}
+
+type TImplicationInfoArray []TImplicationData
+
+type TImplicationListing struct {
+	Implications TImplicationInfoArray
+}
+
+func (this *TImplicationListing) UnmarshalJSON(b []byte) (error) {
+	var x struct {
+		Implications *TImplicationInfoArray `json:"tag_implications"`
+	}
+
+	err := multi_format_unmarshal(b, func() bool {
+		return x.Implications != nil
+	}, func() { x.Implications = nil }, &x, &x.Implications)
+	if x.Implications != nil { this.Implications = *x.Implications }
+	return err
+}
+
+type TPoolData struct {
+	Id          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Category    PoolCategory `json:"category"`
+	IsActive    bool         `json:"is_active"`
+	CreatorId   int          `json:"creator_id"`
+	PostIds     []int        `json:"post_ids"` // in the order they appear in the pool.
+	PostCount   int          `json:"post_count"`
+
+	// created_at
+	// updated_at
+	// creator_name
+}
+
+// pool names use underscores in place of spaces, like tags do.
+func (this TPoolData) DisplayName() string {
+	return strings.Replace(this.Name, "_", " ", -1)
+}
+
+type TPoolInfoArray []TPoolData
+
+type TPoolListing struct {
+	Pools TPoolInfoArray
+}
+
+func (this *TPoolListing) UnmarshalJSON(b []byte) (error) {
+	var x struct {
+		Pools *TPoolInfoArray `json:"pools"`
+	}
+
+	err := multi_format_unmarshal(b, func() bool {
+		return x.Pools != nil
+	}, func() { x.Pools = nil }, &x, &x.Pools)
+	if x.Pools != nil { this.Pools = *x.Pools }
+	return err
+}
+
+type TCommentData struct {
+	Id          int    `json:"id"`
+	PostId      int    `json:"post_id"`
+	CreatorId   int    `json:"creator_id"`
+	CreatorName string `json:"creator_name"`
+	Body        string `json:"body"` // in dtext.
+	Score       int    `json:"score"`
+	CreatedAt   string `json:"created_at"`
+	IsHidden    bool   `json:"is_hidden"`
+
+	// updated_at
+	// updater_id
+	// updater_name
+	// do_not_bump_post
+	// is_sticky
+	// warning_type
+	// warning_user_id
+}
+
+type TCommentInfoArray []TCommentData
+
+type TCommentListing struct {
+	Comments TCommentInfoArray
+}
+
+func (this *TCommentListing) UnmarshalJSON(b []byte) (error) {
+	var x struct {
+		Comments *TCommentInfoArray `json:"comments"`
+	}
+
+	err := multi_format_unmarshal(b, func() bool {
+		return x.Comments != nil
+	}, func() { x.Comments = nil }, &x, &x.Comments)
+	if x.Comments != nil { this.Comments = *x.Comments }
+	return err
+}
+
 type TTagHistory struct {
 	Id int `json:"id"`
 	Post_id int `json:"post_id"`
@@ -233,9 +339,13 @@ func (this *TPostInfo) GetFields() (dml.NamedFields, error) {
 	return dml.NamedFields{
 		Names: []string{
 			"post_id", "post_change_seq", "post_rating", "post_description", "post_sources", "post_hash", "post_deleted", "post_tags",
+			"post_score_up", "post_score_down", "post_score", "post_fav_count", "post_file_ext", "post_width", "post_height", "post_has_sample",
+			"post_tags_artist", "post_tags_copyright", "post_tags_character", "post_tags_species", "post_tags_invalid", "post_tags_meta", "post_tags_lore",
 		},
 		Fields: []interface{}{
 			&this.Id, &this.Change, &this.Rating, &this.Description, &this.sources_internal, &this.Md5, &this.Deleted, pq.Array(&this.General),
+			&this.Upvotes, &this.Downvotes, &this.Score, &this.Fav_count, &this.File_ext, &this.Width, &this.Height, &this.Has_sample,
+			pq.Array(&this.Artist), pq.Array(&this.Copyright), pq.Array(&this.Character), pq.Array(&this.Species), pq.Array(&this.Invalid), pq.Array(&this.Meta), pq.Array(&this.Lore),
 		},
 	}, nil
 }
@@ -286,24 +396,27 @@ func (this *TPostInfo) ExtendedTagSet() (tags.TagSet) {
 func matchIntRange(tag string, against int) bool {
 	if strings.HasPrefix(tag, ">=") {
 		i, err := strconv.Atoi(tag[2:])
-		return err == nil && i >= against
+		return err == nil && against >= i
 	}
 	if strings.HasPrefix(tag, "<=") {
 		i, err := strconv.Atoi(tag[2:])
-		return err == nil && i <= against
+		return err == nil && against <= i
 	}
 	if strings.HasPrefix(tag, ">") {
 		i, err := strconv.Atoi(tag[1:])
-		return err == nil && i > against
+		return err == nil && against > i
 	}
 	if strings.HasPrefix(tag, "<") {
 		i, err := strconv.Atoi(tag[1:])
-		return err == nil && i < against
+		return err == nil && against < i
 	}
 	if strings.Contains(tag, "..") {
-		ids := strings.Split(tag, "..")
-		bottom, err1 := strconv.Atoi(ids[0])
-		top, err2 := strconv.Atoi(ids[1])
+		// either end of a range can be left off, to leave it open.
+		ids := strings.SplitN(tag, "..", 2)
+		bottom, top := against, against
+		var err1, err2 error
+		if ids[0] != "" { bottom, err1 = strconv.Atoi(ids[0]) }
+		if ids[1] != "" { top, err2 = strconv.Atoi(ids[1]) }
 		return err1 == nil && err2 == nil && against >= bottom && against <= top
 	} else {
 		i, err := strconv.Atoi(tag)
@@ -312,21 +425,96 @@ func matchIntRange(tag string, against int) bool {
 	return false
 }
 
+// matches a rating: metatag value, either spelled out or shortened to its first letter.
+func matchRating(value string, rating PostRating) bool {
+	switch value {
+	case "s", "safe":
+		return rating == Safe
+	case "q", "questionable":
+		return rating == Questionable
+	case "e", "explicit":
+		return rating == Explicit
+	}
+	return false
+}
+
+// matches a status: metatag value.
+func matchStatus(value string, post *TPostInfo) bool {
+	switch value {
+	case "pending":
+		return post.Pending
+	case "flagged":
+		return post.Flagged
+	case "deleted":
+		return post.Deleted
+	case "active":
+		return !post.Pending && !post.Flagged && !post.Deleted
+	case "any", "all":
+		return true
+	}
+	return false
+}
+
+// matches a yes/no metatag value, like ischild:true.
+func matchBool(value string, against bool) bool {
+	switch value {
+	case "true", "yes":
+		return against
+	case "false", "no":
+		return !against
+	}
+	return false
+}
+
+// matches a tag with asterisk wildcards against every tag a post has.
+func matchWildcard(t *tags.TagSet, tag string) bool {
+	for k := range t.Data {
+		if tags.WildcardMatch(tag, k) { return true }
+	}
+	return false
+}
+
 func matchesTag(post *TPostInfo, t *tags.TagSet, tag string) bool {
 	tag_noprefix := strings.TrimPrefix(tag, "-")
 	tag, positive_match := tag_noprefix, tag == tag_noprefix
 	matches := false
 
 	if trimmed := strings.TrimPrefix(tag, "rating:"); trimmed != tag {
-		matches = strings.HasPrefix(trimmed, "s") && post.Rating == Safe ||
-		          strings.HasPrefix(trimmed, "q") && post.Rating == Questionable ||
-		          strings.HasPrefix(trimmed, "e") && post.Rating == Explicit
+		matches = matchRating(trimmed, post.Rating)
 	} else if trimmed := strings.TrimPrefix(tag, "id:"); trimmed != tag {
 		matches = matchIntRange(trimmed, post.Id)
 	} else if trimmed := strings.TrimPrefix(tag, "score:"); trimmed != tag {
 		matches = matchIntRange(trimmed, post.Score)
 	} else if trimmed := strings.TrimPrefix(tag, "favcount:"); trimmed != tag {
 		matches = matchIntRange(trimmed, post.Fav_count)
+	} else if trimmed := strings.TrimPrefix(tag, "width:"); trimmed != tag {
+		matches = matchIntRange(trimmed, post.Width)
+	} else if trimmed := strings.TrimPrefix(tag, "height:"); trimmed != tag {
+		matches = matchIntRange(trimmed, post.Height)
+	} else if trimmed := strings.TrimPrefix(tag, "type:"); trimmed != tag {
+		matches = trimmed == strings.ToLower(post.File_ext)
+	} else if trimmed := strings.TrimPrefix(tag, "status:"); trimmed != tag {
+		matches = matchStatus(trimmed, post)
+	} else if trimmed := strings.TrimPrefix(tag, "user:"); trimmed != tag {
+		// posts only carry the uploader's id, so uploaders can only be matched by id (user:!123). blacklists have their
+		// names turned into ids by api.ResolveBlacklistUsers when they're saved, and a name which is left never matches.
+		matches = strings.HasPrefix(trimmed, "!") && matchIntRange(trimmed[1:], post.Creator_id)
+	} else if trimmed := strings.TrimPrefix(tag, "md5:"); trimmed != tag {
+		matches = trimmed == strings.ToLower(post.Md5)
+	} else if trimmed := strings.TrimPrefix(tag, "parent:"); trimmed != tag {
+		if trimmed == "none" {
+			matches = post.Parent_id == 0
+		} else if trimmed == "any" {
+			matches = post.Parent_id != 0
+		} else {
+			matches = matchIntRange(trimmed, post.Parent_id)
+		}
+	} else if trimmed := strings.TrimPrefix(tag, "ischild:"); trimmed != tag {
+		matches = matchBool(trimmed, post.Parent_id != 0)
+	} else if trimmed := strings.TrimPrefix(tag, "isparent:"); trimmed != tag {
+		matches = matchBool(trimmed, post.Has_children)
+	} else if strings.Contains(tag, "*") {
+		matches = matchWildcard(t, tag)
 	} else {
 		matches = t.Status(tag) == tags.AddsTag
 	}
@@ -339,10 +527,8 @@ func matchesBlacklistLine(post *TPostInfo, tags *tags.TagSet, line string) bool
 	cumulative_or_tags := false
 	cumulative_and_tags := true
 
-	for _, tag := range strings.Split(line, " ") {
-		if tag == "" {
-			continue
-		} else if strings.HasPrefix(tag, "~") {
+	for _, tag := range strings.Fields(line) {
+		if strings.HasPrefix(tag, "~") {
 			no_or_tags = false
 			cumulative_or_tags = cumulative_or_tags || matchesTag(post, tags, tag[1:])
 		} else {
@@ -358,11 +544,17 @@ func (this *TPostInfo) MatchesBlacklist(blacklist string) (bool) {
 	var lines []string
 	if len(blacklist) != 0 { lines = strings.Split(blacklist, "\n") }
 	for _, line := range lines {
-		if matchesBlacklistLine(this, &tags, line) { return true }
+		if matchesBlacklistLine(this, &tags, strings.ToLower(line)) { return true }
 	}
 	return false
 }
 
+// tests whether a post would be returned by a search query. queries use the same syntax as a single blacklist line.
+func (this *TPostInfo) MatchesQuery(query string) (bool) {
+	tags := this.TagSet()
+	return matchesBlacklistLine(this, &tags, strings.ToLower(query))
+}
+
 type TUserInfoArray []TUserInfo
 
 type TPostInfoArray []TPostInfo
@@ -124,6 +124,25 @@ func Test_DeletedPostsAfterId(t *testing.T) {
 	}
 }
 
+func Test_PostsById(t *testing.T) {
+	testcases := map[string]struct{
+		ids []int
+		containsEach []string
+	}{
+		"one": {[]int{1000}, []string{"status:any", "id:1000"}},
+		"several": {[]int{1000, 12, 7}, []string{"status:any", "id:1000,12,7"}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			result := PostsById(v.ids)
+			for _, x := range v.containsEach {
+				if !strings.Contains(result, x) { t.Errorf("Unexpected output: result %s does not contain %s", result, x) }
+			}
+		})
+	}
+}
+
 func Test_SinglePostByMd5(t *testing.T) {
 	testcases := map[string]struct{
 		md5 string
@@ -216,6 +235,90 @@ func Test_TAliasListing_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func Test_TImplicationListing_UnmarshalJSON(t *testing.T) {
+	testcases := map[string]struct{
+		jsondata string
+		expected TImplicationInfoArray
+		err string
+	}{
+		"empty-untagged": {`[]`, TImplicationInfoArray{}, ""},
+		"empty": {`{"tag_implications":[]}`, TImplicationInfoArray{}, ""},
+		"full-untagged": {`[{"id":1, "antecedent_name": "cat", "consequent_name": "felid"}, {"id":2, "antecedent_name": "dog", "consequent_name": "canine"}]`, TImplicationInfoArray{TImplicationData{Id: 1, Tag: "cat", Implies: "felid"}, TImplicationData{Id: 2, Tag: "dog", Implies: "canine"}}, ""},
+		"full": {`{"tag_implications": [{"id":1, "antecedent_name": "cat", "consequent_name": "felid"}]}`, TImplicationInfoArray{TImplicationData{Id: 1, Tag: "cat", Implies: "felid"}}, ""},
+		"missing": {`{"missing":true}`, TImplicationInfoArray{}, "figure out how to parse"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var start TImplicationListing
+			result := start.UnmarshalJSON([]byte(v.jsondata))
+			if result == nil && v.err != "" || result != nil && (v.err == "" || !strings.Contains(result.Error(), v.err)) {
+				t.Errorf("Unexpected error: got %v, wanted matching %s", result, v.err)
+			}
+
+			if !(len(start.Implications) == 0 && len(v.expected) == 0 || reflect.DeepEqual(start.Implications, v.expected)) {
+				t.Errorf("Unexpected result: got %v, expected %v", start.Implications, v.expected)
+			}
+		})
+	}
+}
+
+func Test_TPoolListing_UnmarshalJSON(t *testing.T) {
+	testcases := map[string]struct{
+		jsondata string
+		expected TPoolInfoArray
+		err string
+	}{
+		"empty-untagged": {`[]`, TPoolInfoArray{}, ""},
+		"empty": {`{"pools":[]}`, TPoolInfoArray{}, ""},
+		"full-untagged": {`[{"id":1, "name": "some_comic", "category": "series", "is_active": true, "post_ids": [3, 1, 2], "post_count": 3}]`, TPoolInfoArray{TPoolData{Id: 1, Name: "some_comic", Category: PCSeries, IsActive: true, PostIds: []int{3, 1, 2}, PostCount: 3}}, ""},
+		"full": {`{"pools": [{"id":2, "name": "some_collection", "category": "collection", "post_ids": []}]}`, TPoolInfoArray{TPoolData{Id: 2, Name: "some_collection", Category: PCCollection, PostIds: []int{}}}, ""},
+		"missing": {`{"missing":true}`, TPoolInfoArray{}, "figure out how to parse"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var start TPoolListing
+			result := start.UnmarshalJSON([]byte(v.jsondata))
+			if result == nil && v.err != "" || result != nil && (v.err == "" || !strings.Contains(result.Error(), v.err)) {
+				t.Errorf("Unexpected error: got %v, wanted matching %s", result, v.err)
+			}
+
+			if !(len(start.Pools) == 0 && len(v.expected) == 0 || reflect.DeepEqual(start.Pools, v.expected)) {
+				t.Errorf("Unexpected result: got %v, expected %v", start.Pools, v.expected)
+			}
+		})
+	}
+}
+
+func Test_TCommentListing_UnmarshalJSON(t *testing.T) {
+	testcases := map[string]struct{
+		jsondata string
+		expected TCommentInfoArray
+		err string
+	}{
+		"empty-untagged": {`[]`, TCommentInfoArray{}, ""},
+		"empty": {`{"comments":[]}`, TCommentInfoArray{}, ""},
+		"full-untagged": {`[{"id":1, "post_id": 5, "creator_id": 2, "creator_name": "someone", "body": "[b]hi[/b]", "score": 3}]`, TCommentInfoArray{TCommentData{Id: 1, PostId: 5, CreatorId: 2, CreatorName: "someone", Body: "[b]hi[/b]", Score: 3}}, ""},
+		"full": {`{"comments": [{"id":2, "post_id": 5, "body": "hidden", "is_hidden": true}]}`, TCommentInfoArray{TCommentData{Id: 2, PostId: 5, Body: "hidden", IsHidden: true}}, ""},
+		"missing": {`{"missing":true}`, TCommentInfoArray{}, "figure out how to parse"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var start TCommentListing
+			result := start.UnmarshalJSON([]byte(v.jsondata))
+			if result == nil && v.err != "" || result != nil && (v.err == "" || !strings.Contains(result.Error(), v.err)) {
+				t.Errorf("Unexpected error: got %v, wanted matching %s", result, v.err)
+			}
+
+			if !(len(start.Comments) == 0 && len(v.expected) == 0 || reflect.DeepEqual(start.Comments, v.expected)) {
+				t.Errorf("Unexpected result: got %v, expected %v", start.Comments, v.expected)
+			}
+		})
+	}
+}
+
 func Test_TPostListing_UnmarshalJSON(t *testing.T) {
 	testcases := map[string]struct{
 		jsondata string
@@ -291,10 +394,7 @@ func Test_MatchesBlacklist(t *testing.T) {
 		"empty-": {TPostInfo{Id: 5, Rating: Questionable, TPostTags: TPostTags{General: []string{"cat", "dog", "dragon", "gryphon"}}}, "", false},
 		"rating-": {TPostInfo{Id: 5, Rating: Questionable, TPostTags: TPostTags{General: []string{"cat", "dog", "dragon", "gryphon"}}}, "rating:e", false},
 		"id-": {TPostInfo{Id: 5, Rating: Questionable, TPostTags: TPostTags{General: []string{"cat", "dog", "dragon", "gryphon"}}}, "id:4", false},
-
-		// support more blacklist tag options:
-		// by upload user
-		// by file type
+		"mixed case+": {TPostInfo{Id: 5, Rating: Questionable, TPostTags: TPostTags{General: []string{"cat", "dog", "dragon", "gryphon"}}}, "Cat Rating:Questionable\r", true},
 	}
 
 	for k, v := range testcases {
@@ -306,6 +406,101 @@ func Test_MatchesBlacklist(t *testing.T) {
 	}
 }
 
+func Test_MatchesBlacklist_Metatags(t *testing.T) {
+	post := TPostInfo{
+		Id: 1234,
+		Rating: Explicit,
+		Creator_id: 77,
+		Fav_count: 40,
+		TPostScore: TPostScore{Score: -3},
+		TPostFile: TPostFile{Width: 1920, Height: 1080, File_ext: "webm", Md5: "0123456789ABCDEF0123456789ABCDEF"},
+		TPostFlags: TPostFlags{Pending: true},
+		TPostRelationships: TPostRelationships{Parent_id: 1000},
+		TPostTags: TPostTags{
+			General: []string{"male", "solo", "hyper_penis", "muscular_male"},
+			Species: []string{"canine", "wolf"},
+			Meta: []string{"animated", "sound"},
+		},
+	}
+
+	testcases := map[string]struct{
+		line string
+		matches bool
+	}{
+		// lines lifted from real blacklists
+		"rating letter": {"rating:e", true},
+		"rating word": {"rating:explicit", true},
+		"rating other letter": {"rating:q", false},
+		"rating other word": {"rating:questionable", false},
+		"rating garbage": {"rating:sexy", false},
+		"negated rating": {"wolf -rating:s", true},
+		"negated rating-": {"wolf -rating:e", false},
+		"negated rating word": {"canine -rating:safe", true},
+		"wildcard suffix": {"hyper_*", true},
+		"wildcard prefix": {"*_penis", true},
+		"wildcard middle": {"muscular_*male", true},
+		"wildcard none": {"inflation*", false},
+		"negated wildcard": {"wolf -feral*", true},
+		"negated wildcard-": {"wolf -musc*", false},
+		"either wildcard": {"~diaper* ~hyper*", true},
+		"type": {"type:webm", true},
+		"type-": {"type:swf", false},
+		"type and tag": {"type:webm sound", true},
+		"width range": {"width:>1000", true},
+		"height exact": {"height:1080", true},
+		"height range-": {"height:..720", false},
+		"open range": {"width:1000..", true},
+		"closed range": {"id:1000..2000", true},
+		"small images": {"width:<500 height:<500", false},
+		"status pending": {"status:pending", true},
+		"status flagged": {"status:flagged", false},
+		"status deleted": {"status:deleted", false},
+		"status active": {"status:active", false},
+		"uploader id": {"user:!77", true},
+		"uploader id-": {"user:!78", false},
+		"uploader name": {"user:somebody", false},
+		"negative score": {"score:<0", true},
+		"low score": {"score:<-10", false},
+		"popular": {"favcount:>=100", false},
+		"md5": {"md5:0123456789abcdef0123456789abcdef", true},
+		"parent": {"parent:1000", true},
+		"parent none": {"parent:none", false},
+		"is child": {"ischild:true", true},
+		"is parent": {"isparent:true", false},
+		"everything": {"wolf ~solo ~duo -rating:s type:webm width:>=1920 status:pending user:!77 -feral", true},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := post.MatchesBlacklist(v.line)
+			if out != v.matches { t.Errorf("Unexpected result for %q: got %t, expected %t", v.line, out, v.matches) }
+		})
+	}
+}
+
+func Test_MatchesQuery(t *testing.T) {
+	post := TPostInfo{Id: 5, Rating: Questionable, TPostTags: TPostTags{General: []string{"cat", "dog", "dragon", "gryphon"}}}
+	testcases := map[string]struct{
+		query string
+		matches bool
+	}{
+		"tags+": {"cat dog", true},
+		"case+": {"Cat DOG Rating:Q", true},
+		"either+": {"~fox ~dragon -walrus", true},
+		"empty+": {"", true},
+		"tags-": {"cat fox", false},
+		"exclude-": {"cat -dog", false},
+		"rating-": {"cat rating:s", false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := post.MatchesQuery(v.query)
+			if out != v.matches { t.Errorf("Unexpected result: got %t, expected %t", out, v.matches) }
+		})
+	}
+}
+
 // this is a dummy test. It's used to mark simple functions such as getters and setters which are too simple to test, so they
 // show up as covered code. If it's more complicated than a getter or a setter, it shouldn't be here.
 func Test_Others(t *testing.T) {
@@ -24,7 +24,7 @@ type UploadCallResult struct {
 	Status     string
 }
 
-func UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating types.PostRating, source, description string, parent *int, user, apitoken string) (*UploadCallResult, error) {
+func (E621Backend) UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating types.PostRating, source, description string, parent *int, user, apitoken string) (*UploadCallResult, error) {
 	url := "/uploads.json"
 
 	out := UploadCallResult{}
@@ -58,7 +58,7 @@ func UploadFile(file_data io.Reader, upload_url string, tags tags.TagSet, rating
 
 var PostIsDeleted error = errors.New("This post has been deleted.")
 
-func UpdatePost(user, apitoken string,
+func (E621Backend) UpdatePost(user, apitoken string,
 		id int,
 		tagdiff tags.TagDiff,				// empty to leave tags unchanged.
 		rating types.PostRating,			// nil to leave rating unchanged.
@@ -98,7 +98,7 @@ func UpdatePost(user, apitoken string,
 	return &post.Post, e
 }
 
-func VotePost(user, apitoken string,
+func (E621Backend) VotePost(user, apitoken string,
               id int,
               vote types.PostVote,
               no_unvote bool) (*types.TPostScore, error) {
@@ -122,10 +122,14 @@ func VotePost(user, apitoken string,
 		return nil, e
 	}
 
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
 	return &score, e
 }
 
-func UnvotePost(user, apitoken string,
+func (E621Backend) UnvotePost(user, apitoken string,
 		id int) (error) {
 	url := fmt.Sprintf("/posts/%d/votes.json", id)
 
@@ -152,7 +156,7 @@ func UnvotePost(user, apitoken string,
 }
 
 // you shouldn't depend on this to return anything useful, as it will return nil if you favorite the same post twice
-func FavoritePost(user, apitoken string,
+func (E621Backend) FavoritePost(user, apitoken string,
 		id int) (*types.TPostInfo, error) {
 	url := "/favorites.json"
 
@@ -176,12 +180,14 @@ func FavoritePost(user, apitoken string,
 		return nil, nil
 	} else if e != nil {
 		return nil, e
+	} else if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
 	}
 
 	return &post.Post, e
 }
 
-func UnfavoritePost(user, apitoken string,
+func (E621Backend) UnfavoritePost(user, apitoken string,
 		id int) (error) {
 	// i know this isn't the same as the other one, i promise it's correct right now though
 	url := fmt.Sprintf("/favorites/%d.json", id)
@@ -206,3 +212,31 @@ func UnfavoritePost(user, apitoken string,
 
 	return e
 }
+
+func (E621Backend) CreateComment(user, apitoken string,
+		post_id int,
+		body string) (*types.TCommentData, error) {
+	url := "/comments.json"
+
+	var comment types.TCommentData
+
+	r, e := api.New(url).
+		Method(reqtify.POST).
+		BasicAuthentication(user, apitoken).
+		FormArg("comment[post_id]", post_id).
+		FormArg("comment[body]", body).
+		JSONInto(&comment).
+		Do()
+
+	APILog(url, user, -1, r, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, errors.New(r.Status)
+	}
+
+	return &comment, nil
+}
@@ -1,6 +1,7 @@
 package apiextra
 
 import (
+	"github.com/thewug/fsb/pkg/api/types"
 	"github.com/thewug/fsb/pkg/storage"
 
 	"github.com/thewug/gogram/data"
@@ -29,6 +30,11 @@ func Init(s settings) error {
 	apiurlmatch.regexp, err = regexp.Compile(fmt.Sprintf(`(https?://)?(www\.)?(%s|%s)/(posts|post/show)/(\d+)`,
 	                                                     regexp.QuoteMeta(s.GetApiEndpoint()),
 	                                                     regexp.QuoteMeta(s.GetApiFilteredEndpoint())))
+	apipoolurlmatch.fields = []int{4}
+	apipoolurlmatch.regexp, err = regexp.Compile(fmt.Sprintf(`(https?://)?(www\.)?(%s|%s)/(?:pools|pool/show)/(\d+)`,
+	                                                         regexp.QuoteMeta(s.GetApiEndpoint()),
+	                                                         regexp.QuoteMeta(s.GetApiFilteredEndpoint())))
+	if err != nil { return err }
 	md5hashmatch.fields = []int{3,8}
 	md5hashmatch.regexp, err = regexp.Compile(fmt.Sprintf(`((^|[^\w-])md5:([0-9A-Fa-f]{32})(\W|$)|(https?://)?%s(%s|%s)/data/(\w+/)+([0-9A-Za-z]{32})\.\w+)`,
 	                                                      regexp.QuoteMeta(s.GetApiStaticPrefix()),
@@ -76,6 +82,8 @@ var numericmatch = matcher{
 	[]int{2},
 }
 
+var apipoolurlmatch matcher
+
 var md5hashmatch matcher
 
 // attempts to recover a post id from the specified text string.
@@ -102,6 +110,20 @@ func GetPostIDFromText(text string) int {
 	return found
 }
 
+// attempts to recover a pool id from the specified text string.
+// first, searches for a matching pool url and returns its pool number if present.
+// second, searches for and returns a non-negative number not part of another word.
+// returns NONEXISTENT_POST if no matches were found.
+func GetPoolIDFromText(text string) int {
+	found := apipoolurlmatch.Match(text)
+
+	if found == NONEXISTENT_POST {
+		found = numericmatch.Match(text)
+	}
+
+	return found
+}
+
 // attempts to recover a post id from a telegram message.
 // first, tries to match any URL in a url text entity.
 // second, tries GetPostIDFromText on the full message plaintext.
@@ -194,6 +216,12 @@ func (this Ratings) RatingTag() string {
 	}
 }
 
+func (this Ratings) Allows(rating types.PostRating) bool {
+	return rating == types.Safe && this.Safe ||
+	       rating == types.Questionable && this.Questionable ||
+	       rating == types.Explicit && this.Explicit
+}
+
 var ws *regexp.Regexp = regexp.MustCompile(`\s+`)
 
 func RatingsFromString(tags string) Ratings {
@@ -47,6 +47,9 @@ func Test_regexes(t *testing.T) {
 		"url5": {"(" + api.FilteredEndpoint + "/post/show/111)", apiurlmatch, 111},
 		"url6": {api.FilteredEndpoint + "/post/show/111", apiurlmatch, 111},
 		"url7": {"htp:/" + api.FilteredEndpoint + ".nope/post/show/111", apiurlmatch, NONEXISTENT_POST},
+		"pool1": {"https://" + api.Endpoint + "/pools/333", apipoolurlmatch, 333},
+		"pool2": {"see " + api.FilteredEndpoint + "/pool/show/333 for more", apipoolurlmatch, 333},
+		"pool3": {api.Endpoint + "/posts/333", apipoolurlmatch, NONEXISTENT_POST},
 		"id1": {"222", numericmatch, 222},
 		"id2": {" x y z 222 yadda", numericmatch, 222},
 		"id3": {"(222)", numericmatch, 222},
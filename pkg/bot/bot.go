@@ -15,7 +15,9 @@ import (
 	"github.com/thewug/gogram/persist"
 
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io/ioutil"
@@ -75,14 +77,31 @@ func ShowHelp(topic string) (string) {
 . <code>* </code>Vote on and favorite posts
 . <code>* </code>Upload new posts
 . <code>* </code>Edit existing posts
+. <code>* </code>Get new posts matching your favorite searches sent to you
 . <code>* </code>All without leaving Telegram!
 .
 . <b>Important Info and FAQ</b>
 . <code>* </code>Adjust your rating filter and blacklist from your search settings.
+. <code>* </code>Besides your ` + api.ApiName + ` blacklist, you can keep a blacklist with the bot: see <code>/settings blacklist</code>. It works even if you haven't connected an account.
 . <code>* </code>Before posting to ` + api.ApiName + `, please make sure you read the site's rules.
 . <code>* </code>Your account standing is your own responsibility.
 . <code>* </code>Your ` + api.ApiName + ` API key is NOT your password. To find it, go to your <a href="https://` + api.Endpoint + `/users/home">Account Settings</a> and click "Manage API Access".
 . <code>* </code>To report a bug, see <code>/help report.</code>
+. <code>* </code>To save a search and get notified about new posts, see <code>/help subscribe</code>.
+. <code>* </code>To read and post comments, see <code>/help comments</code>.
+. <code>* </code>Search for <code>pool:12345</code> inline to get the posts in a pool, in order. You can add a post to a pool with <code>/edit</code>.
+. <code>* </code>Send me a picture in private to check whether it's already been posted, even if it's been resized or recompressed.
+comment.comments. <b>Comments</b>
+comment.comments. Tap the 💬 button on a post to get its latest comments sent to you, or use one of these:
+comment.comments. <code>/comments [post]</code> - show the latest comments on a post
+comment.comments. <code>/comment [post] [your comment]</code> - post a comment, using your ` + api.ApiName + ` account
+comment.comments. You can also reply to a post with either command, and leave out the post ID.
+subscribe.subscriptions. <b>Saved searches</b>
+subscribe.subscriptions. Save a search, and I'll send you new posts matching it every so often. They're filtered by your rating filter and blacklist, the same as inline searches are.
+subscribe.subscriptions. <code>/subscribe [search]</code> - save a new search
+subscribe.subscriptions. <code>/subscriptions</code> - list your saved searches
+subscribe.subscriptions. <code>/subscriptions delete [ID]</code> - delete a saved search
+subscribe.subscriptions. Searches use the same syntax as your blacklist: all plain tags must be present, tags prefixed with <code>-</code> must be absent, and at least one tag prefixed with <code>~</code> must be present. Tags can use <code>*</code> as a wildcard, and <code>rating:</code>, <code>id:</code>, <code>score:</code>, <code>favcount:</code>, <code>type:</code>, <code>width:</code>, <code>height:</code>, <code>status:</code>, <code>parent:</code> and <code>user:!id</code> work too.
 security.abuse.report. <b>Reporting abuse, bugs, or other issues</b>
 security.abuse.report. Use the following command to send a message to the janitor's chat. If your issue is private or security related, please send a report asking to be contacted back.
 security.abuse.report.
@@ -92,6 +111,7 @@ public. This bot's commands and help messages should be used via PM.
 janitor.
 janitor. <b>Janitor Commands</b>
 janitor. For a full description of any command, use <code>/help [command]</code>.
+janitor. Each command needs a role with the matching permission, which the bot's owner can grant you.
 janitor.cats. <code>/cats</code>
 cats. A <i>CAT</i> is a malformed tag formed from two valid tags accidentally concatenated together. These tags are typos, and are added to posts by accident (if it isn't an accident, it's not a <i>CAT</i>). This command helps search for <i>CAT</i>s, resolve them to their correct tags, and automatically apply them to posts.
 cats. <b>General Listing options:</b>
@@ -134,13 +154,13 @@ blits. <code> --include,-I TAG -</code> mark <code>TAG</code> as a <i>BLIT</i>
 blits. <code> --exclude,-E TAG -</code> mark <code>TAG</code> as a non-<i>BLIT</i>
 blits. <code> --delete, -D TAG -</code> clear <code>TAG</code> entirely from <i>BLIT</i> list
 janitor.syncposts. <code>/syncposts</code>
-syncposts. This command is used to keep my internal index of ` + api.ApiName + ` data up to date. This index is used to speed up operations like searching for similar tag names and listing all posts with a tag. Some metadata is maintained for each tag, each tag alias, and each post, on the site.
+syncposts. This command is used to keep my internal index of ` + api.ApiName + ` data up to date. This index is used to speed up operations like searching for similar tag names and listing all posts with a tag. Some metadata is maintained for each tag, each tag alias, and each post, on the site. The previews of new and changed posts are hashed separately, a batch every few minutes, so people can search for posts by sending me a picture.
 syncposts. <i>Control</i> options:
 syncposts. <code> (no arguments) -</code> incremental sync of tags and posts (default)
 syncposts. <code> --full         -</code> discard local database and sync from scratch
-syncposts. <code> --aliases      -</code> sync tag aliases as well
+syncposts. <code> --aliases      -</code> sync tag aliases and implications as well
 syncposts. <code> --recount      -</code> tally post tag counts afterwards
-syncposts. You do not normally need to use this command. Commands which push changes to ` + api.ApiName + ` should apply them locally as well, and an incremental sync is performed by the bot's internal maintenance routine every five minutes (with an alias sync and a tag recount happening every 60 minutes).
+syncposts. You do not normally need to use this command. Commands which push changes to ` + api.ApiName + ` should apply them locally as well, and an incremental sync is performed by the bot's internal maintenance routine every five minutes (with an alias and implication sync and a tag recount happening every 60 minutes).
 janitor.indextags. <code>/indextags</code>
 indextags. This command syncs new changes on ` + api.ApiName + ` to the local tag database.
 indextags. <i>Control</i> options:
@@ -149,6 +169,8 @@ indextags. <code> --full         -</code> discard local database and sync from s
 indextags. This operation is invoked by <code>/syncposts</code>, which passes <code>--full</code> to this command if it is present.
 janitor.indextagaliases. <code>/indextagaliases</code>
 indextagaliases. This command syncs tag aliases between ` + api.ApiName + ` and the local alias database. Because of how aliases are listed on ` + api.ApiName + `, an incremental sync is not possible, and a full sync is always performed. This command takes no options. It is invoked by <code>/syncposts</code> if <code>--aliases</code> is specified.
+janitor.indextagimplications. <code>/indextagimplications</code>
+indextagimplications. This command syncs tag implications between ` + api.ApiName + ` and the local implication database. Like aliases, implications are always fully synced. This command takes no options. It is invoked by <code>/syncposts</code> if <code>--aliases</code> is specified.
 janitor.typos. <code>/typos</code>
 typos. This command searches for likely typos of a tag, as determined by their edit distance to other tags. The way you should use this command is broadly at first, listing all typos, and then more and more specifically as you investigate each possible option on the site, adding selection options until you have a comprehensive, accurate listing of typos, then apply them to the site by issuing the command again with <code>--fix</code> and using <code>--include</code> or <code>--autofix</code> to register them for future auto-fixes.
 typos. <i>Listing</i> options:
@@ -180,6 +202,34 @@ janitor.resyncdeleted. <code>/resyncdeleted</code>
 resyncdeleted. <s>This command is disabled.</s> You should not need to use it. It enumerates all deleted posts from ` + api.ApiName + ` and updates the local database's deleted status. It exists because at one point, that information was not stored, but it affects certain parts of the API (namely, ordinary users can no longer edit deleted posts) and it needed to be re-imported. It takes no options. If you need to use it again, you should clear the deleted status of all posts manually from the database console first.
 janitor.resynclist. <code>/resynclist</code>
 resynclist. Use this command captioned on an uploaded file, containing whitespace delimited post ids (and comments beginning with #). The bot will perform a local DB sync on each post listed in the file.
+janitor.bulkretag. <code>/bulkretag "EXPR" TAGS...</code>
+bulkretag. This command applies a set of tag changes to every post in my local index which matches a tag expression. By default it only performs a dry run, showing how many posts match and a sample of the changes it would make. Every edit it makes is recorded.
+bulkretag. <code>EXPR</code> is a tag expression, which should be quoted. Tags separated by spaces must all be present, tags separated by commas are alternatives, tags prefixed with <code>-</code> must be absent, and <code>{</code> <code>}</code> group things together, so <code>"canine -canid, {wolf, fox} -canid"</code> is a valid expression. You can check how an expression is understood with <code>/parseexpression EXPR</code>.
+bulkretag. <code>TAGS</code> is a list of tags to add, or to remove if prefixed with <code>-</code>.
+bulkretag. <i>Control</i> options:
+bulkretag. <code> --fix,     -F   -</code> actually apply the changes
+bulkretag. <code> --reason,  -r R -</code> include reason <code>R</code> when performing edits
+janitor.revertreplacements. <code>/revertreplacements</code>
+revertreplacements. This command undoes the tag changes made by automatic tag cleanups, for when a replacer turns out to have been a mistake. Posts which have been changed again since the cleanup are skipped, so as not to clobber anyone else's edits. By default it only performs a dry run. You will probably also want to disable or fix the replacer in question, or it will just be applied again.
+revertreplacements. <i>Selection</i> options (at least one is required, and all must match):
+revertreplacements. <code> --replacer, -R N -</code> revert changes made by replacer <code>N</code>
+revertreplacements. <code> --user,     -u N -</code> revert changes made on behalf of telegram user <code>N</code>, or <code>autofix</code> for the automatic cleanups
+revertreplacements. <code> --since,    -S T -</code> revert changes made after <code>T</code>
+revertreplacements. <code> --until,    -U T -</code> revert changes made before <code>T</code>
+revertreplacements. Times can be a date like <code>2021-08-01</code> or <code>2021-08-01T15:30</code>, or a duration like <code>36h</code>, meaning that long ago.
+revertreplacements. <i>Control</i> options:
+revertreplacements. <code> --fix,      -F   -</code> actually revert the changes
+revertreplacements. <code> --reason,   -r R -</code> include reason <code>R</code> when performing edits
+janitor.replacements. <code>/replacements SUBCOMMAND [args]</code>
+replacements. This command manages replacers, the rules used by my maintenance routine to clean up tags on posts as they are edited. Each replacer has a match spec, a list of tags which must be present (or absent, if prefixed with <code>-</code>) for it to apply to a post, and a replace spec, a list of tags to add (or remove, if prefixed with <code>-</code>). Replacers with autofix enabled are applied automatically, the rest are only suggested. Replacers are also created by <code>/typos</code> and <code>/cats</code>.
+replacements. <i>Subcommands</i>:
+replacements. <code> list [PAGE]               -</code> list replacers
+replacements. <code> add "MATCH" "REPLACE"     -</code> add a new replacer (with <code>--autofix</code>, <code>-A</code> to enable autofix)
+replacements. <code> edit ID "MATCH" "REPLACE" -</code> change replacer <code>ID</code> (with <code>--autofix</code>, <code>-A</code> or <code>--no-autofix</code>, <code>-N</code> to turn autofix on or off)
+replacements. <code> autofix ID                -</code> toggle autofix for replacer <code>ID</code>
+replacements. <code> delete ID                 -</code> delete replacer <code>ID</code>
+replacements. <code> test ID                   -</code> show which posts replacer <code>ID</code> would change, without changing them
+replacements. For example, <code>/replacements add "canine -canid" "canid"</code> adds <code>canid</code> to posts tagged <code>canine</code> which don't already have it. It's a good idea to <code>test</code> a new replacer before enabling autofix for it.
 birds. What <b>are</b> birds?
 birds. We just don't know.`
 
@@ -257,8 +307,10 @@ func (this *AutofixState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.Callba
 		return nil
 	}
 
-	if !creds.Janitor {
-		ctx.AnswerAsync(data.OCallback{Notification: "\U0001F512 Sorry, this feature is currently limited to janitors.", ShowAlert: true}, nil)
+	if allowed, err := storage.HasPermission(tx, ctx.Cb.From.Id, storage.PERM_AUTOFIX); err != nil {
+		return err
+	} else if !allowed {
+		ctx.AnswerAsync(data.OCallback{Notification: "\U0001F512 Sorry, you don't have permission to do this.", ShowAlert: true}, nil)
 		return nil
 	}
 
@@ -344,6 +396,105 @@ func (this *OperatorState) Handle(ctx *gogram.MessageCtx) {
 	}
 }
 
+const MAX_SUBSCRIPTIONS = 20
+
+type SubscriptionState struct {
+	gogram.StateBase
+
+	Behavior *botbehavior.Behavior
+	lock sync.Mutex
+}
+
+func (this *SubscriptionState) GetInterval() int64 {
+	return 5 * 60
+}
+
+func (this *SubscriptionState) DoMaintenance(bot *gogram.TelegramBot) {
+	go func() {
+		// digests can take a while to send, don't start on the next batch before the last one is done.
+		this.lock.Lock()
+		defer this.lock.Unlock()
+
+		err := this.Behavior.SendSubscriptionDigests(bot)
+		if err != nil {
+			bot.ErrorLog.Println("Error sending subscription digests:", err.Error())
+		}
+	}()
+}
+
+func (this *SubscriptionState) Handle(ctx *gogram.MessageCtx) {
+	err := storage.DefaultTransact(func(tx storage.DBLike) error { return this.HandleTx(tx, ctx) })
+	if err != nil {
+		ctx.Bot.ErrorLog.Println(fmt.Errorf("SubscriptionState.HandleTx: %w", err))
+	}
+}
+
+func (this *SubscriptionState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) error {
+	if ctx.Msg.From == nil { return nil }
+	if ctx.Msg.Chat.Type != data.Private {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Saved searches are managed via PM."}}, nil)
+		return nil
+	}
+
+	if ctx.Cmd.Command == "/subscribe" {
+		query := strings.Join(strings.Fields(ctx.Cmd.Argstr), " ")
+		if query == "" {
+			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Tell me what to search for, like this:\n<code>/subscribe wolf solo -comic</code>", ParseMode: data.ParseHTML}}, nil)
+			return nil
+		}
+
+		count, err := storage.CountSubscriptions(tx, ctx.Msg.From.Id)
+		if err != nil { return fmt.Errorf("CountSubscriptions: %w", err) }
+		if count >= MAX_SUBSCRIPTIONS {
+			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("You can't have more than %d saved searches. Delete some first (see /subscriptions).", MAX_SUBSCRIPTIONS)}}, nil)
+			return nil
+		}
+
+		sub, err := storage.AddSubscription(tx, ctx.Msg.From.Id, query)
+		if err != nil { return fmt.Errorf("AddSubscription: %w", err) }
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("Saved search <code>%d</code>: <code>%s</code>\nI'll send you new posts that match it every so often.", sub.Id, html.EscapeString(sub.Query)), ParseMode: data.ParseHTML}}, nil)
+	} else if ctx.Cmd.Command == "/subscriptions" {
+		if len(ctx.Cmd.Args) != 0 && strings.ToLower(ctx.Cmd.Args[0]) == "delete" {
+			var id int64
+			var err error
+			if len(ctx.Cmd.Args) == 2 { id, err = strconv.ParseInt(ctx.Cmd.Args[1], 10, 64) }
+			if len(ctx.Cmd.Args) != 2 || err != nil {
+				ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Which saved search? Use <code>/subscriptions delete ID</code>.", ParseMode: data.ParseHTML}}, nil)
+				return nil
+			}
+
+			deleted, err := storage.DeleteSubscription(tx, ctx.Msg.From.Id, id)
+			if err != nil { return fmt.Errorf("DeleteSubscription: %w", err) }
+			if !deleted {
+				ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("You don't have a saved search with ID %d.", id)}}, nil)
+			} else {
+				ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("Deleted saved search %d.", id)}}, nil)
+			}
+			return nil
+		}
+
+		subs, err := storage.GetSubscriptions(tx, ctx.Msg.From.Id)
+		if err != nil { return fmt.Errorf("GetSubscriptions: %w", err) }
+		if len(subs) == 0 {
+			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "You don't have any saved searches. Add one with <code>/subscribe [search]</code>.", ParseMode: data.ParseHTML}}, nil)
+			return nil
+		}
+
+		var b bytes.Buffer
+		b.WriteString("<b>Your saved searches:</b>\n")
+		for _, sub := range subs {
+			b.WriteString(fmt.Sprintf("<code>%d</code>: <code>%s</code>\n", sub.Id, html.EscapeString(sub.Query)))
+		}
+		b.WriteString("\nTo delete one, use <code>/subscriptions delete ID</code>.")
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: b.String(), ParseMode: data.ParseHTML}}, nil)
+	}
+
+	return nil
+}
+
+// voting looks up credentials through this, so tests can supply them without a database.
+var getUserCreds = storage.GetUserCreds
+
 type VoteState struct {
 	gogram.StateBase
 
@@ -421,7 +572,7 @@ func (this *VoteState) MarkAndTestRecentlyFaved(tg_user data.UserID, post_id int
 func (this *VoteState) HandleCmd(from *data.TUser, cmd *gogram.CommandData, reply_message *data.TMessage, bot *gogram.TelegramBot) (data.OMessage, bool) {
 	var response data.OMessage
 
-	creds, err := storage.GetUserCreds(nil, from.Id)
+	creds, err := getUserCreds(nil, from.Id)
 	if err == storage.ErrNoLogin {
 		response.Text = "\U0001F512 You need to login to do that!\n(use /login, in PM)"
 		return response, true
@@ -438,8 +589,8 @@ func (this *VoteState) HandleCmd(from *data.TUser, cmd *gogram.CommandData, repl
 		id = apiextra.GetPostIDFromMessage(reply_message)
 	}
 
-	// if after all that, the id is still the zero value, that means we didn't find one, so die
-	if id == 0 {
+	// if after all that, the id is still the zero value (or NONEXISTENT_POST), that means we didn't find one, so die
+	if id <= 0 {
 		response.Text = "You must to specify a post ID."
 		return response, true
 	}
@@ -503,6 +654,103 @@ func (this *VoteState) HandleCmd(from *data.TUser, cmd *gogram.CommandData, repl
 	return response, false
 }
 
+type CommentState struct {
+	gogram.StateBase
+
+	Behavior *botbehavior.Behavior
+}
+
+func (this *CommentState) Handle(ctx *gogram.MessageCtx) {
+	if ctx.Msg.From == nil { return }
+	go func() {
+		var text string
+		if ctx.Cmd.Command == "/comments" {
+			text = this.ShowComments(ctx.Msg.From, ctx.Cmd.Args, ctx.Msg.ReplyToMessage, ctx.Bot)
+		} else if ctx.Cmd.Command == "/comment" {
+			text = this.PostComment(ctx.Msg.From, ctx.Cmd.Argstr, ctx.Msg.ReplyToMessage, ctx.Bot)
+		}
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: text, ParseMode: data.ParseHTML}, DisableWebPagePreview: true}, nil)
+	}()
+}
+
+// the comments button on inline results sends the comments by PM, rather than cluttering up whatever chat the post is in.
+func (this *CommentState) HandleCallback(ctx *gogram.CallbackCtx) {
+	go func() {
+		text := this.ShowComments(&ctx.Cb.From, ctx.Cmd.Args, nil, ctx.Bot)
+		_, err := ctx.Bot.Remote.SendMessage(data.OMessage{SendData: data.SendData{TargetData: data.TargetData{ChatId: data.ChatID(ctx.Cb.From.Id)}, Text: text, ParseMode: data.ParseHTML}, DisableWebPagePreview: true})
+		if err != nil {
+			ctx.AnswerAsync(data.OCallback{Notification: "I couldn't message you! Start a chat with me first, then try again.", ShowAlert: true}, nil)
+		} else {
+			ctx.AnswerAsync(data.OCallback{Notification: "\U0001F4AC I've sent you the comments in PM."}, nil)
+		}
+	}()
+}
+
+func commentPostID(args []string, reply_message *data.TMessage) int {
+	if len(args) > 0 {
+		return apiextra.GetPostIDFromText(args[0])
+	} else if reply_message != nil {
+		return apiextra.GetPostIDFromMessage(reply_message)
+	}
+	return apiextra.NONEXISTENT_POST
+}
+
+func (this *CommentState) ShowComments(from *data.TUser, args []string, reply_message *data.TMessage, bot *gogram.TelegramBot) string {
+	id := commentPostID(args, reply_message)
+	if id <= 0 { return "You must specify a post ID." }
+
+	// anyone can read comments, so fall back to the search account for people who aren't logged in.
+	creds, err := storage.GetUserCreds(nil, from.Id)
+	if err == storage.ErrNoLogin {
+		creds = this.Behavior.MySettings.DefaultSearchCredentials()
+	} else if err != nil {
+		bot.ErrorLog.Printf("Failed to get credentials for user %d: %s\n", from.Id, err.Error())
+		return "An error occurred while fetching up your " + api.ApiName + " credentials."
+	}
+
+	text, err := this.Behavior.PostComments(creds, id)
+	if err != nil {
+		bot.ErrorLog.Printf("Error when listing comments on post %d: %s\n", id, err.Error())
+		return "An error occurred when fetching the comments! (Is " + api.ApiName + " down?)"
+	}
+	return text
+}
+
+func (this *CommentState) PostComment(from *data.TUser, argstr string, reply_message *data.TMessage, bot *gogram.TelegramBot) string {
+	creds, err := storage.GetUserCreds(nil, from.Id)
+	if err == storage.ErrNoLogin {
+		return "\U0001F512 You need to login to do that!\n(use /login, in PM)"
+	} else if err != nil {
+		bot.ErrorLog.Printf("Failed to get credentials for user %d: %s\n", from.Id, err.Error())
+		return "An error occurred while fetching up your " + api.ApiName + " credentials."
+	}
+
+	// the post is either the one being replied to, or the first thing after the command.
+	body := strings.TrimSpace(argstr)
+	id := apiextra.NONEXISTENT_POST
+	if reply_message != nil { id = apiextra.GetPostIDFromMessage(reply_message) }
+	if id <= 0 {
+		fields := strings.Fields(body)
+		if len(fields) != 0 {
+			id = apiextra.GetPostIDFromText(fields[0])
+			body = strings.TrimSpace(strings.TrimPrefix(body, fields[0]))
+		}
+	}
+
+	if id <= 0 {
+		return "You must specify a post ID, like this:\n<code>/comment 12345 your comment</code>"
+	} else if body == "" {
+		return "You can't post an empty comment."
+	}
+
+	comment, err := api.CreateComment(creds.User, creds.ApiKey, id, body)
+	if err != nil {
+		bot.ErrorLog.Printf("Error when commenting on post %d: %s\n", id, err.Error())
+		return "An error occurred when posting your comment! (Is " + api.ApiName + " down?)"
+	}
+	return fmt.Sprintf("\U0001F4AC Your comment has been posted on <a href=\"https://%s/posts/%d#comment-%d\">post #%d</a>.", api.Endpoint, id, comment.Id, id)
+}
+
 type esp struct {
 	User string `json:"user"`
 	ApiKey string `json:"apikey"`
@@ -618,7 +866,7 @@ func (this *EditState) Freeform(ctx *gogram.MessageCtx) {
 		p, err := dialogs.LoadEditPrompt(tx, this.data.MsgId, this.data.ChatId)
 		if err != nil { return fmt.Errorf("LoadEditPrompt: %w", err) }
 
-		p.HandleFreeform(ctx)
+		p.HandleFreeform(tx, ctx)
 
 		p.Prompt(tx, ctx.Bot, nil, dialogs.NewEditFormatter(ctx.Msg.Chat.Type != data.Private, nil))
 		return nil
@@ -668,20 +916,15 @@ func (this *EditState) Edit(ctx *gogram.MessageCtx) {
 			return nil
 		}
 
+		err = e.SuggestTags(tx, e.TagChanges.APIString())
+		if err != nil { return fmt.Errorf("SuggestTags: %w", err) }
+
 		if e.PostId <= 0 {
 			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Sorry, I can't figure out which post you're talking about!\n\nYou can reply to a message with a post URL, or you can pass an ID or a link directly."}}, nil)
 			return nil
 		}
 
-		e.OrigSources = make(map[string]int)
-
-		post_data, err := storage.PostByID(tx, e.PostId)
-		if post_data != nil {
-			for _, s := range post_data.Sources {
-				e.SeeSource(s)
-				e.OrigSources[s] = 1
-			}
-		}
+		e.LoadOriginalSources(tx)
 
 		savestate := func(prompt *gogram.MessageCtx) {
 			ctx.SetState(EditStateFactoryWithData(nil, this.StateBasePersistent, esp{
@@ -713,6 +956,23 @@ func (this *EditState) Edit(ctx *gogram.MessageCtx) {
 	}
 }
 
+// shows an edit prompt which was prepared somewhere else, such as from a post prompt for a file which already
+// exists, and waits for changes to it, as if it had been started with /edit.
+func (this *EditState) Begin(tx storage.DBLike, ctx *gogram.CallbackCtx, e *dialogs.EditPrompt, user, api_key string) error {
+	if err := e.LoadOriginalSources(tx); err != nil { return fmt.Errorf("LoadOriginalSources: %w", err) }
+
+	prompt := e.Prompt(tx, ctx.Bot, ctx.MsgCtx, dialogs.NewEditFormatter(ctx.Cb.Message.Chat.Type != data.Private, nil))
+	if prompt == nil { return errors.New("couldn't send edit prompt") }
+
+	ctx.SetState(EditStateFactoryWithData(nil, this.StateBasePersistent, esp{
+		User: user,
+		ApiKey: api_key,
+		MsgId: prompt.Msg.Id,
+		ChatId: prompt.Msg.Chat.Id,
+	}))
+	return nil
+}
+
 type LoginState struct {
 	gogram.StateBase
 
@@ -758,16 +1018,15 @@ func (this *LoginState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) erro
 				ctx.DeleteAsync(nil)
 			}
 			if this.user != "" && this.apikey != "" {
-				user, success, err := api.TestLogin(this.user, this.apikey)
+				creds := storage.UserCreds{
+					TelegramId: ctx.Msg.From.Id,
+					User: this.user,
+					ApiKey: this.apikey,
+				}
+				success, err := botbehavior.RefreshCreds(ctx.Bot, &creds)
 				if success && err == nil {
 					ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("You are now logged in as <code>%s</code>.\n\nTo protect the security of your account, I have deleted the message containing your API key.", this.user), ParseMode: data.ParseHTML}}, nil)
-					err = storage.WriteUserCreds(tx, storage.UserCreds{
-						TelegramId: ctx.Msg.From.Id,
-						User: this.user,
-						ApiKey: this.apikey,
-						Blacklist: user.Blacklist,
-						BlacklistFetched: time.Now(),
-					})
+					err = storage.WriteUserCreds(tx, creds)
 					if err != nil {
 						return fmt.Errorf("WriteUserCreds: %w", err)
 					}
@@ -775,7 +1034,7 @@ func (this *LoginState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) erro
 				} else if err != nil {
 					ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: fmt.Sprintf("An error occurred when testing if you were logged in! (%s)", err.Error())}}, nil)
 					ctx.SetState(nil)
-					return fmt.Errorf("api.TestLogin: %w", err)
+					return fmt.Errorf("RefreshCreds: %w", err)
 				} else if !success {
 					ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: "Login failed! (api key invalid?)\n\nLet's try again. Please send your " + api.Endpoint + " username."}}, nil)
 					this.user = ""
@@ -809,16 +1068,15 @@ func (this *LoginState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) erro
 			ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: "An error occurred! Try again later."}}, nil)
 			return fmt.Errorf("GetUserCreds: %w", err)
 		}
-		user, success, err := api.TestLogin(creds.User, creds.ApiKey)
+		success, err := botbehavior.RefreshCreds(ctx.Bot, &creds)
 		if err != nil {
 			ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: "An error occurred while communicating with " + api.ApiName + "! Try again later."}}, nil)
-			return fmt.Errorf("api.TestLogin: %w", err)
+			return fmt.Errorf("RefreshCreds: %w", err)
 		} else if !success {
 			ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: "Your API key is invalid or has expired, please update it."}}, nil)
-			return nil
+			creds.Invalid, creds.Validated = true, time.Now()
+			return storage.WriteUserCreds(tx, creds)
 		}
-		creds.Blacklist = user.Blacklist
-		creds.BlacklistFetched = time.Now()
 		err = storage.WriteUserCreds(tx, creds)
 		if err != nil {
 			ctx.RespondAsync(data.OMessage{SendData: data.SendData{Text: "An error occurred while saving your settings! Try again later."}}, nil)
@@ -831,6 +1089,74 @@ func (this *LoginState) HandleTx(tx storage.DBLike, ctx *gogram.MessageCtx) erro
 	return nil
 }
 
+// re-validates stored api keys every so often, see Behavior.CheckCredentials.
+type CredentialCheckState struct {
+	Behavior *botbehavior.Behavior
+	lock sync.Mutex
+}
+
+func (this *CredentialCheckState) GetInterval() int64 {
+	return 5 * 60
+}
+
+func (this *CredentialCheckState) DoMaintenance(bot *gogram.TelegramBot) {
+	go func() {
+		// checks are spaced out, so a batch can outlast the interval. don't start another until it's done.
+		this.lock.Lock()
+		defer this.lock.Unlock()
+
+		err := this.Behavior.CheckCredentials(bot)
+		if err != nil {
+			bot.ErrorLog.Println("Error checking credentials:", err.Error())
+		}
+	}()
+}
+
+// fills in missing information about old posts every so often, see Behavior.BackfillPosts.
+type PostBackfillState struct {
+	Behavior *botbehavior.Behavior
+	lock sync.Mutex
+}
+
+func (this *PostBackfillState) GetInterval() int64 {
+	return 5 * 60
+}
+
+func (this *PostBackfillState) DoMaintenance(bot *gogram.TelegramBot) {
+	go func() {
+		// a batch can outlast the interval. don't start another until it's done.
+		this.lock.Lock()
+		defer this.lock.Unlock()
+
+		err := this.Behavior.BackfillPosts(bot)
+		if err != nil {
+			bot.ErrorLog.Println("Error backfilling posts:", err.Error())
+		}
+	}()
+}
+
+// hashes the previews of new posts every so often, see Behavior.HashPreviews.
+type PreviewHashState struct {
+	Behavior *botbehavior.Behavior
+	lock sync.Mutex
+}
+
+func (this *PreviewHashState) GetInterval() int64 {
+	return 5 * 60
+}
+
+func (this *PreviewHashState) DoMaintenance(bot *gogram.TelegramBot) {
+	go func() {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+
+		err := this.Behavior.HashPreviews(bot)
+		if err != nil {
+			bot.ErrorLog.Println("Error hashing previews:", err.Error())
+		}
+	}()
+}
+
 type TagRuleState struct {
 	gogram.StateBase
 
@@ -954,19 +1280,83 @@ func (this *PostState) WriteUserTagRules(tx storage.DBLike, my_id data.UserID, t
 	return storage.WriteUserTagRules(tx, my_id, name, tagrules)
 }
 
+// the post prompts whose files are being checked right now, and which file, so each file is only checked once.
+type postPromptID struct {
+	chat data.ChatID
+	msg  data.MsgID
+}
+
+var checkingFiles = struct {
+	sync.Mutex
+	files map[postPromptID]string
+}{files: make(map[postPromptID]string)}
+
+// checks a post prompt's file in the background, if it hasn't been checked yet, and updates the prompt with what was
+// found once it's done. downloading a file can take a while, and updates are handled one at a time, so this mustn't
+// hold up the caller. call it after the transaction which saved the prompt has been committed.
+func (this *PostState) StartFileCheck(bot *gogram.TelegramBot, p *dialogs.PostPrompt, from data.UserID, group bool) {
+	if p.FileChecked() { return }
+
+	state := this.data
+	id, file := postPromptID{chat: state.ChatId, msg: state.MsgId}, p.CurrentFile()
+	checkingFiles.Lock()
+	running := checkingFiles.files[id] == file
+	if !running { checkingFiles.files[id] = file }
+	checkingFiles.Unlock()
+	if running { return }
+
+	// only the file and the credentials are needed, so the caller can go on using the prompt.
+	var download dialogs.PostPrompt
+	download.File, download.CheckedFile = p.File, p.CheckedFile
+	download.SetCredentials(state.User, state.ApiKey)
+
+	go func() {
+		defer func() {
+			checkingFiles.Lock()
+			if checkingFiles.files[id] == file { delete(checkingFiles.files, id) }
+			checkingFiles.Unlock()
+		}()
+
+		check := download.DownloadFile(bot)
+		err := storage.DefaultTransact(func(tx storage.DBLike) error {
+			// it's loaded again, in case anything changed while the file was downloading.
+			p, err := dialogs.LoadPostPrompt(tx, state.MsgId, state.ChatId, from, "main")
+			if err == sql.ErrNoRows { return nil } // it was posted or discarded in the meantime.
+			if err != nil { return fmt.Errorf("LoadPostPrompt: %w", err) }
+			if err := p.CheckFile(tx, check); err != nil { return fmt.Errorf("CheckFile: %w", err) }
+
+			p.Prompt(tx, bot, nil, dialogs.NewPostFormatter(group, nil))
+			return nil
+		})
+		if err != nil { bot.ErrorLog.Println("Error checking file: ", err.Error()) }
+	}()
+}
+
 func (this *PostState) HandleCallback(ctx *gogram.CallbackCtx) {
-	err := storage.DefaultTransact(func(tx storage.DBLike) error { return this.HandleCallbackTx(tx, ctx) })
+	var p *dialogs.PostPrompt
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		p, err = this.HandleCallbackTx(tx, ctx)
+		return err
+	})
 	if err != nil {
 		ctx.Bot.ErrorLog.Println(fmt.Errorf("PostState.HandleCallbackTx: %w", err))
 	}
+
+	// the file is normally checked when it's set, but if that was interrupted, it's checked again now.
+	if p != nil && p.State != dialogs.SAVED && p.State != dialogs.DISCARDED && p.State != dialogs.SWITCHED {
+		this.StartFileCheck(ctx.Bot, p, ctx.Cb.From.Id, ctx.Cb.Message.Chat.Type != data.Private)
+	}
 }
 
-func (this *PostState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.CallbackCtx) error {
+// handles a button press on a post prompt, returning the prompt afterwards.
+func (this *PostState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.CallbackCtx) (*dialogs.PostPrompt, error) {
 	p, err := dialogs.LoadPostPrompt(tx, this.data.MsgId, this.data.ChatId, ctx.Cb.From.Id, "upload")
 	if err != nil {
-		return fmt.Errorf("LoadEditPrompt: %w", err)
+		return nil, fmt.Errorf("LoadEditPrompt: %w", err)
 	}
 
+	p.SetCredentials(this.data.User, this.data.ApiKey)
 	p.HandleCallback(ctx)
 
 	if p.State == dialogs.SAVED {
@@ -979,7 +1369,7 @@ func (this *PostState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.CallbackC
 			ctx.AnswerAsync(data.OCallback{Notification: fmt.Sprintf("\U0001F534 %s", err.Error())}, nil)
 			p.Prompt(tx, ctx.Bot, nil, dialogs.NewPostFormatter(ctx.Cb.Message.Chat.Type != data.Private, nil))
 			p.State = dialogs.WAIT_MODE
-			return fmt.Errorf("p.CommitPost: %w", err)
+			return p, fmt.Errorf("p.CommitPost: %w", err)
 		} else if upload_result != nil && !upload_result.Success {
 			if upload_result.Reason == nil { upload_result.Reason = new(string) }
 			ctx.AnswerAsync(data.OCallback{Notification: fmt.Sprintf("\U0001F534 Error: %s", *upload_result.Reason)}, nil)
@@ -988,11 +1378,17 @@ func (this *PostState) HandleCallbackTx(tx storage.DBLike, ctx *gogram.CallbackC
 		}
 	} else if p.State == dialogs.DISCARDED {
 		p.Finalize(tx, ctx.Bot, nil, dialogs.NewPostFormatter(ctx.Cb.Message.Chat.Type != data.Private, nil))
+	} else if p.State == dialogs.SWITCHED {
+		// the file is already posted, so edit that post instead, with whatever was filled in here.
+		edit, ok := ctx.Machine.GetCommand("/edit").(*EditState)
+		if !ok { return p, errors.New("/edit isn't available") }
+		p.Finalize(tx, ctx.Bot, nil, dialogs.NewPostFormatter(ctx.Cb.Message.Chat.Type != data.Private, nil))
+		return p, edit.Begin(tx, ctx, p.EditDuplicate(), this.data.User, this.data.ApiKey)
 	} else {
 		p.Prompt(tx, ctx.Bot, nil, dialogs.NewPostFormatter(ctx.Cb.Message.Chat.Type != data.Private, nil))
 	}
 
-	return nil
+	return p, nil
 }
 
 func (this *PostState) Handle(ctx *gogram.MessageCtx) {
@@ -1047,25 +1443,38 @@ func (this *PostState) Handle(ctx *gogram.MessageCtx) {
 }
 
 func (this *PostState) Post(ctx *gogram.MessageCtx) {
-	err := storage.DefaultTransact(func(tx storage.DBLike) error {
-		var p dialogs.PostPrompt
+	var p dialogs.PostPrompt
 
-		if ctx.Msg.From == nil { return nil }
+	if ctx.Msg.From == nil { return }
 
-		creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-		if err != nil {
-			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "You need to be logged in to use this command!"}}, nil)
-			if err != storage.ErrNoLogin {
-				return fmt.Errorf("GetUserCreds: %w", err)
-			}
-			return nil
+	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
+	if err != nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "You need to be logged in to use this command!"}}, nil)
+		if err != storage.ErrNoLogin {
+			ctx.Bot.ErrorLog.Println(fmt.Errorf("GetUserCreds: %w", err))
 		}
+		return
+	}
 
-		postnow, err := p.ParseArgs(ctx)
-		if err != nil {
-			ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: err.Error()}}, nil)
-			return nil
-		}
+	postnow, err := p.ParseArgs(ctx)
+	if err != nil {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: err.Error()}}, nil)
+		return
+	}
+
+	// uploading right away means waiting for the check, so that --commit can't upload a duplicate. the upload itself
+	// takes about as long, so this doesn't hold anything up much more than it would be anyway. otherwise, the file is
+	// checked in the background once the prompt is up.
+	p.SetCredentials(creds.User, creds.ApiKey)
+	var check *dialogs.FileCheck
+	if postnow { check = p.DownloadFile(ctx.Bot) }
+
+	var state *PostState
+	err = storage.DefaultTransact(func(tx storage.DBLike) error {
+		if err := p.CheckFile(tx, check); err != nil { ctx.Bot.ErrorLog.Println("Error checking file: ", err.Error()) }
+
+		err := p.SuggestTags(tx, p.TagWizard.Tags().String())
+		if err != nil { return fmt.Errorf("SuggestTags: %w", err) }
 
 		tagrules, err := storage.GetUserTagRules(tx, ctx.Msg.From.Id, "upload")
 		if err != nil {
@@ -1075,12 +1484,13 @@ func (this *PostState) Post(ctx *gogram.MessageCtx) {
 
 		savestate := func(prompt *gogram.MessageCtx) {
 			p.TagWizard.SetNewRulesFromString(tagrules)
-			ctx.SetState(PostStateFactoryWithData(nil, this.StateBasePersistent, psp{
+			state = PostStateFactoryWithData(nil, this.StateBasePersistent, psp{
 				User: creds.User,
 				ApiKey: creds.ApiKey,
 				MsgId: prompt.Msg.Id,
 				ChatId: prompt.Msg.Chat.Id,
-			}))
+			}).(*PostState)
+			ctx.SetState(state)
 		}
 
 		if postnow {
@@ -1111,6 +1521,8 @@ func (this *PostState) Post(ctx *gogram.MessageCtx) {
 	})
 	if err != nil {
 		ctx.Bot.ErrorLog.Println(err)
+	} else if state != nil {
+		state.StartFileCheck(ctx.Bot, &p, ctx.Msg.From.Id, ctx.Msg.Chat.Type != data.Private)
 	}
 }
 
@@ -1132,13 +1544,18 @@ func (this *PostState) Cancel(ctx *gogram.MessageCtx) {
 }
 
 func (this *PostState) Freeform(ctx *gogram.MessageCtx) {
+	var p *dialogs.PostPrompt
+	var old_file string
 	err := storage.DefaultTransact(func(tx storage.DBLike) error {
-		p, err := dialogs.LoadPostPrompt(tx, this.data.MsgId, this.data.ChatId, ctx.Msg.From.Id, "main")
+		var err error
+		p, err = dialogs.LoadPostPrompt(tx, this.data.MsgId, this.data.ChatId, ctx.Msg.From.Id, "main")
 		if err != nil {
 			return fmt.Errorf("LoadPostPrompt: %w", err)
 		}
 
-		p.HandleFreeform(ctx)
+		p.SetCredentials(this.data.User, this.data.ApiKey)
+		old_file = p.CurrentFile()
+		p.HandleFreeform(tx, ctx)
 
 		p.Prompt(tx, ctx.Bot, nil, dialogs.NewPostFormatter(ctx.Msg.Chat.Type != data.Private, nil))
 		
@@ -1146,6 +1563,8 @@ func (this *PostState) Freeform(ctx *gogram.MessageCtx) {
 	})
 	if err != nil {
 		ctx.Bot.ErrorLog.Println(err)
+	} else if p.CurrentFile() != old_file {
+		this.StartFileCheck(ctx.Bot, p, ctx.Msg.From.Id, ctx.Msg.Chat.Type != data.Private)
 	}
 }
 
@@ -1153,17 +1572,45 @@ type JanitorState struct {
 	gogram.StateBase
 }
 
+// the permission each janitor command needs.
+var janitorPermissions = map[string]storage.Permission{
+	"/indextags": storage.PERM_SYNC,
+	"/indextagaliases": storage.PERM_SYNC,
+	"/indextagimplications": storage.PERM_SYNC,
+	"/syncposts": storage.PERM_SYNC,
+	"/recounttags": storage.PERM_SYNC,
+	"/recountnegative": storage.PERM_SYNC,
+	"/resyncdeleted": storage.PERM_SYNC,
+	"/resynclist": storage.PERM_SYNC,
+	"/blits": storage.PERM_EDIT_BLITS,
+	"/cats": storage.PERM_AUTOFIX,
+	"/typos": storage.PERM_AUTOFIX,
+	"/replacements": storage.PERM_AUTOFIX,
+	"/revertreplacements": storage.PERM_AUTOFIX,
+	"/bulkretag": storage.PERM_BULK_RETAG,
+	"/parseexpression": storage.PERM_BULK_RETAG,
+}
+
 func (this *JanitorState) Handle(ctx *gogram.MessageCtx) {
 	if ctx.Msg.From == nil {
 		// ignore messages not sent by a user.
 		return
 	}
 
-	creds, err := storage.GetUserCreds(nil, ctx.Msg.From.Id)
-	if !creds.Janitor {
+	permission, ok := janitorPermissions[ctx.Cmd.Command]
+	if !ok { return }
+
+	allowed, err := storage.HasPermission(storage.DefaultNoTx(), ctx.Msg.From.Id, permission)
+	if err != nil {
+		ctx.Bot.ErrorLog.Println("Error checking permissions:", err.Error())
+		return
+	}
+	if !allowed {
 		// commands from non-authorized users are silently ignored
 		return
 	}
+
+	_, err = storage.GetUserCreds(nil, ctx.Msg.From.Id)
 	if err != nil {
 		ctx.ReplyOrPMAsync(data.OMessage{SendData: data.SendData{Text: "You need to be logged in to " + api.ApiName + " to use this command (see <code>/help login</code>)", ParseMode: data.ParseHTML}}, nil)
 		return
@@ -1173,6 +1620,8 @@ func (this *JanitorState) Handle(ctx *gogram.MessageCtx) {
 		go tagindex.SyncTagsCommand(ctx)
 	} else if ctx.Cmd.Command == "/indextagaliases" {
 		go tagindex.SyncAliasesCommand(ctx)
+	} else if ctx.Cmd.Command == "/indextagimplications" {
+		go tagindex.SyncImplicationsCommand(ctx)
 	} else if ctx.Cmd.Command == "/syncposts" {
 		go tagindex.SyncPostsCommand(ctx)
 	} else if ctx.Cmd.Command == "/cats" {
@@ -1187,5 +1636,13 @@ func (this *JanitorState) Handle(ctx *gogram.MessageCtx) {
 		go tagindex.RefetchDeletedPostsCommand(ctx)
 	} else if ctx.Cmd.Command == "/resynclist" {
 		go tagindex.ResyncListCommand(ctx)
+	} else if ctx.Cmd.Command == "/bulkretag" {
+		go tagindex.BulkRetagCommand(ctx)
+	} else if ctx.Cmd.Command == "/parseexpression" {
+		go tagindex.ParseExpressionCommand(ctx)
+	} else if ctx.Cmd.Command == "/revertreplacements" {
+		go tagindex.RevertReplacementsCommand(ctx)
+	} else if ctx.Cmd.Command == "/replacements" {
+		go tagindex.ReplacementsCommand(ctx)
 	}
 }
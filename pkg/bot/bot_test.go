@@ -0,0 +1,131 @@
+package bot
+
+import (
+	apitest "github.com/thewug/fsb/pkg/api/test"
+
+	"github.com/thewug/fsb/pkg/api"
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/apiextra"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	voter data.UserID = 1
+	loggedOut data.UserID = 2
+	brokenDb data.UserID = 3
+)
+
+func startVotes(t *testing.T) (*apitest.FakeBooru, *gogram.TelegramBot) {
+	fake := apitest.NewFakeBooru()
+	if err := api.Init(fake.Settings()); err != nil { t.Fatalf("Couldn't initialize api: %s", err.Error()) }
+	if err := apiextra.Init(fake.Settings()); err != nil { t.Fatalf("Couldn't initialize apiextra: %s", err.Error()) }
+
+	getUserCreds = func(d storage.DBLike, id data.UserID) (storage.UserCreds, error) {
+		switch id {
+		case voter:
+			return storage.UserCreds{TelegramId: voter, User: "alice", ApiKey: "alicekey"}, nil
+		case brokenDb:
+			return storage.UserCreds{}, errors.New("database is down")
+		}
+		return storage.UserCreds{}, storage.ErrNoLogin
+	}
+
+	discard := log.New(io.Discard, "", 0)
+	return fake, &gogram.TelegramBot{Log: discard, ErrorLog: discard}
+}
+
+func Test_VoteState_HandleCmd(t *testing.T) {
+	text := func(s string) *data.TMessage { return &data.TMessage{Text: &s} }
+
+	testcases := map[string]struct{
+		from data.UserID
+		command string
+		args []string
+		reply *data.TMessage
+		fail string
+
+		text string
+		alert bool
+		post int
+		vote apitypes.PostVote
+		favorited bool
+	}{
+		"upvote": {from: voter, command: "/upvote", args: []string{"101"}, text: "You have upvoted", post: 101, vote: apitypes.Upvote},
+		"downvote": {from: voter, command: "/downvote", args: []string{"101"}, text: "You have downvoted", post: 101, vote: apitypes.Downvote},
+		"favorite": {from: voter, command: "/favorite", args: []string{"101"}, text: "You have favorited", post: 101, favorited: true},
+		"post url": {from: voter, command: "/upvote", args: []string{"http://FAKE/posts/102"}, text: "You have upvoted", post: 102, vote: apitypes.Upvote},
+		"reply": {from: voter, command: "/upvote", reply: text("look at post 103"), text: "You have upvoted", post: 103, vote: apitypes.Upvote},
+		"argument over reply": {from: voter, command: "/upvote", args: []string{"101"}, reply: text("look at post 103"), text: "You have upvoted", post: 101, vote: apitypes.Upvote},
+		"no post": {from: voter, command: "/upvote", text: "You must to specify a post ID", alert: true},
+		"unparseable post": {from: voter, command: "/downvote", args: []string{"that_one"}, text: "You must to specify a post ID", alert: true},
+		"no post in reply": {from: voter, command: "/favorite", reply: text("nothing to see here"), text: "You must to specify a post ID", alert: true},
+		"logged out": {from: loggedOut, command: "/upvote", args: []string{"101"}, text: "You need to login", alert: true},
+		"database error": {from: brokenDb, command: "/upvote", args: []string{"101"}, text: "An error occurred while fetching", alert: true},
+		"vote fails": {from: voter, command: "/upvote", args: []string{"101"}, fail: "/posts/", text: "An error occurred when voting", post: 101},
+		"favorite fails": {from: voter, command: "/favorite", args: []string{"101"}, fail: "/favorites", text: "An error occurred when favoriting", post: 101},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			fake, bot := startVotes(t)
+			defer fake.Close()
+			if v.fail != "" { fake.Fail(v.fail, http.StatusInternalServerError, 1) }
+
+			var args []string
+			for _, a := range v.args { args = append(args, strings.Replace(a, "FAKE", fake.Settings().Endpoint, 1)) }
+
+			var votes VoteState
+			msg, alert := votes.HandleCmd(&data.TUser{Id: v.from}, &gogram.CommandData{Command: v.command, Args: args}, v.reply, bot)
+			if !strings.Contains(msg.Text, v.text) { t.Errorf("Unexpected reply: got %q, expected it to contain %q", msg.Text, v.text) }
+			if alert != v.alert { t.Errorf("Unexpected alert: got %t, expected %t", alert, v.alert) }
+
+			if v.post != 0 {
+				if vote := fake.Vote("alice", v.post); vote != v.vote { t.Errorf("Unexpected vote on %d: got %d, expected %d", v.post, vote, v.vote) }
+				if faved := fake.Favorited("alice", v.post); faved != v.favorited { t.Errorf("Unexpected favorite on %d: got %t, expected %t", v.post, faved, v.favorited) }
+			}
+		})
+	}
+}
+
+// doing the same thing twice in a row undoes it, so the inline buttons work as toggles.
+func Test_VoteState_HandleCmd_Toggle(t *testing.T) {
+	testcases := map[string]struct{
+		commands []string
+		text string
+		vote apitypes.PostVote
+		favorited bool
+	}{
+		"upvote twice": {[]string{"/upvote", "/upvote"}, "You have deleted your vote", apitypes.Neutral, false},
+		"downvote twice": {[]string{"/downvote", "/downvote"}, "You have deleted your vote", apitypes.Neutral, false},
+		"change vote": {[]string{"/upvote", "/downvote"}, "You have downvoted", apitypes.Downvote, false},
+		"favorite twice": {[]string{"/favorite", "/favorite"}, "You have unfavorited", apitypes.Neutral, false},
+		"vote and favorite": {[]string{"/upvote", "/favorite"}, "You have favorited", apitypes.Upvote, true},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			fake, bot := startVotes(t)
+			defer fake.Close()
+
+			var votes VoteState
+			var msg data.OMessage
+			for _, command := range v.commands {
+				msg, _ = votes.HandleCmd(&data.TUser{Id: voter}, &gogram.CommandData{Command: command, Args: []string{"101"}}, nil, bot)
+			}
+
+			if !strings.Contains(msg.Text, v.text) { t.Errorf("Unexpected reply: got %q, expected it to contain %q", msg.Text, v.text) }
+			if vote := fake.Vote("alice", 101); vote != v.vote { t.Errorf("Unexpected vote: got %d, expected %d", vote, v.vote) }
+			if faved := fake.Favorited("alice", 101); faved != v.favorited { t.Errorf("Unexpected favorite: got %t, expected %t", faved, v.favorited) }
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package dialogs
+
+import (
+	apitest "github.com/thewug/fsb/pkg/api/test"
+	dbtest "github.com/thewug/fsb/pkg/storage/test"
+
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"net/http"
+	"testing"
+)
+
+func startFake(t *testing.T) *apitest.FakeBooru {
+	fake := apitest.NewFakeBooru()
+	if err := api.Init(fake.Settings()); err != nil { t.Fatalf("Couldn't initialize api: %s", err.Error()) }
+	return fake
+}
+
+func Test_CommitPost(t *testing.T) {
+	fake := startFake(t)
+	defer fake.Close()
+
+	var prompt PostPrompt
+	prompt.TagWizard.MergeTagsFromString("solo wolf canine mammal outside standing rating:s")
+	prompt.Sources.Set("https://example.com/new")
+	prompt.Parent = 101
+	prompt.File.SetUrl("https://example.com/new.png", 0)
+	prompt.CheckedFile = "https://example.com/new.png" // the fake site doesn't serve files to check, so pretend it was.
+
+	result, err := prompt.CommitPost("alice", "alicekey", nil)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if !result.Success || result.Location == nil { t.Fatalf("Unexpected upload result: %+v", result) }
+
+	post := fake.Post(106)
+	if post == nil { t.Fatalf("Upload didn't create a post") }
+	if post.Rating != types.Safe || post.Parent_id != 101 || len(post.Sources) != 1 { t.Errorf("Uploaded post has wrong details: %+v", *post) }
+
+	// uploading the same thing again gets rejected by the site, which isn't an error as far as the prompt is concerned.
+	result, err = prompt.CommitPost("alice", "alicekey", nil)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if result.Success || result.StatusCode != http.StatusPreconditionFailed { t.Errorf("Expected a duplicate to be rejected, got %+v", result) }
+
+	// an incomplete prompt never reaches the site.
+	before := len(fake.Requests())
+	var empty PostPrompt
+	if _, err = empty.CommitPost("alice", "alicekey", nil); err == nil { t.Errorf("Expected an incomplete post to fail") }
+	if len(fake.Requests()) != before { t.Errorf("Incomplete post was sent to the site") }
+}
+
+func Test_CommitEdit(t *testing.T) {
+	db, err := dbtest.TestDatabase()
+	if err == nil { err = db.Ping() }
+	if err != nil { t.Skipf("Test database not available: %s", err.Error()) }
+	storage.Db_pool = db
+
+	fake := startFake(t)
+	defer fake.Close()
+
+	err = storage.DefaultTransact(dbtest.Rollbacker(func(tx storage.DBLike) error {
+		prompt := EditPrompt{PostId: 102, TagChanges: tags.TagDiffFromString("standing -sitting"), Rating: types.Safe}
+		update, err := prompt.CommitEdit(tx, "alice", "alicekey", nil)
+		if err != nil { return err }
+
+		local, err := storage.PostByID(tx, 102)
+		if err != nil { return err }
+		if update == nil || local == nil || local.Change != fake.Post(102).Change || local.Rating != types.Safe {
+			t.Errorf("Edit wasn't saved locally: got %+v", local)
+		}
+
+		deleted := EditPrompt{PostId: 105, Rating: types.Safe}
+		if _, err := deleted.CommitEdit(tx, "alice", "alicekey", nil); err != api.PostIsDeleted {
+			t.Errorf("Expected PostIsDeleted, got %v", err)
+		}
+		return nil
+	}))
+
+	if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+}
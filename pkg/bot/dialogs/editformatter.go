@@ -3,6 +3,8 @@ package dialogs
 import (
 	"github.com/thewug/fsb/pkg/api"
 	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/imagehash"
+	"github.com/thewug/fsb/pkg/storage"
 
 	"github.com/thewug/gogram/data"
 
@@ -42,6 +44,65 @@ func (this EditFormatterBase) WarningsBase(b *bytes.Buffer, warnings []string) {
 	b.WriteRune('\n')
 }
 
+// describes what will happen to the tags when they're saved: aliases, implications, and anything that looks like a typo.
+func (this EditFormatterBase) NormalizationWarnings(n storage.TagNormalization) []string {
+	code := func(tag string) string { return "<code>" + html.EscapeString(tag) + "</code>" }
+
+	var warnings []string
+	for _, a := range n.Aliased {
+		warnings = append(warnings, fmt.Sprintf("%s will become %s.", code(a.From), code(a.To)))
+	}
+
+	for i := 0; i < len(n.Implied); {
+		from := n.Implied[i].From
+		var implied []string
+		for ; i < len(n.Implied) && n.Implied[i].From == from; i++ {
+			implied = append(implied, code(n.Implied[i].To))
+		}
+		warnings = append(warnings, fmt.Sprintf("Adding %s also adds %s.", code(from), strings.Join(implied, ", ")))
+	}
+
+	for _, t := range n.Typos {
+		if t.To != "" {
+			warnings = append(warnings, fmt.Sprintf("%s looks like a typo, did you mean %s?", code(t.From), code(t.To)))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("%s doesn't have any posts, is it a typo?", code(t.From)))
+		}
+	}
+	return warnings
+}
+
+// warns about posts which have, or look like, the file being uploaded, in case it's already been posted.
+func (this PostFormatter) DuplicateWarnings(prompt *PostPrompt) []string {
+	var warnings []string
+	if !prompt.FileChecked() {
+		warnings = append(warnings, "Checking whether this file has already been posted...")
+	} else if prompt.CheckWarning != "" {
+		warnings = append(warnings, fmt.Sprintf("I couldn't check whether this file has already been posted (%s), so make sure it hasn't been before you upload it.", html.EscapeString(prompt.CheckWarning)))
+	}
+
+	if prompt.DuplicateOf != 0 {
+		link := fmt.Sprintf(`<a href="https://%s/posts/%d">post #%d</a>`, api.Endpoint, prompt.DuplicateOf, prompt.DuplicateOf)
+		if prompt.DuplicateDeleted {
+			warnings = append(warnings, fmt.Sprintf("<b>This file was already posted as %s, which was deleted.</b> It can't be uploaded again.", link))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("<b>This file has already been posted as %s!</b> You can edit that post instead.", link))
+		}
+	}
+
+	for _, m := range prompt.ImageMatches {
+		if m.PostId == prompt.DuplicateOf { continue }
+
+		link := fmt.Sprintf(`<a href="https://%s/posts/%d">post #%d</a>`, api.Endpoint, m.PostId, m.PostId)
+		if m.Distance <= imagehash.LIKELY_MATCH {
+			warnings = append(warnings, fmt.Sprintf("This looks like %s (%d%% similar), make sure it isn't a duplicate!", link, imagehash.Similarity(m.Distance)))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("This might be a version of %s (%d%% similar).", link, imagehash.Similarity(m.Distance)))
+		}
+	}
+	return warnings
+}
+
 func NewEditFormatter(request_reply bool, err error) EditFormatter {
 	return EditFormatter{EditFormatterBase{request_reply}, err}
 }
@@ -63,6 +124,8 @@ func (this EditFormatter) Warnings(b *bytes.Buffer, prompt *EditPrompt) {
 		warnings = append(warnings, "Too many sources, each post can only have 10! Remove some before committing.")
 	}
 
+	warnings = append(warnings, this.NormalizationWarnings(prompt.normalization)...)
+
 	for tag, _ := range set.Data {
 		if len(tag) > 1024 {
 			warnings = append(warnings, "One of the sources is too long, each source can only be 1024 characters long. Shorten them before committing.")
@@ -127,6 +190,7 @@ func (this EditFormatter) GenerateMarkup(prompt *EditPrompt) interface{} {
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Tags", Data: sptr("/tags")})
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Rating", Data: sptr("/rating")})
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Parent", Data: sptr("/parent")})
+	kb.AddButton(data.TInlineKeyboardButton{Text: "Pools", Data: sptr("/pools")})
 	kb.AddRow()
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Sources", Data: sptr("/sources")})
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Description", Data: sptr("/description")})
@@ -141,7 +205,9 @@ func (this EditFormatter) GenerateMarkup(prompt *EditPrompt) interface{} {
 	kb.AddButton(data.TInlineKeyboardButton{Text: "\U0001F534 Discard", Data: sptr("/discard")})
 
 	var extra_buttons data.TInlineKeyboard
-	if prompt.State == WAIT_RATING {
+	if prompt.State == WAIT_TAGS || prompt.State == WAIT_MODE {
+		extra_buttons = tagSuggestionButtons(prompt.TagSuggestions)
+	} else if prompt.State == WAIT_RATING {
 		extra_buttons.AddRow()
 		extra_buttons.AddButton(data.TInlineKeyboardButton{Text: "\U0001F7E9 Safe", Data: sptr("/rating s")})
 		extra_buttons.AddButton(data.TInlineKeyboardButton{Text: "\U0001F7E8 Questionable", Data: sptr("/rating q")})
@@ -188,10 +254,14 @@ func (this PostFormatter) Warnings(b *bytes.Buffer, prompt *PostPrompt) {
 		warnings = append(warnings, "No file selected!")
 	}
 
+	warnings = append(warnings, this.DuplicateWarnings(prompt)...)
+
 	if prompt.TagWizard.Len() < 6 {
 		warnings = append(warnings, "Not enough tags, each post must have at least 6! Add some more before committing.")
 	}
 
+	warnings = append(warnings, this.NormalizationWarnings(prompt.normalization)...)
+
 	if len(prompt.Rating) == 0 {
 		warnings = append(warnings, "You must specify a rating!")
 	}
@@ -243,6 +313,8 @@ func (this PostFormatter) GenerateMessage(prompt *PostPrompt) string {
 		this.Warnings(&b, prompt)
 	} else if prompt.State == DISCARDED {
 		b.WriteString("New post discarded.")
+	} else if prompt.State == SWITCHED {
+		b.WriteString(fmt.Sprintf("New post discarded, editing <a href=\"https://%s/posts/%d\">post #%d</a> instead.", api.Endpoint, prompt.DuplicateOf, prompt.DuplicateOf))
 	} else {
 		b.WriteString("Preparing to post a new file.\nCurrently editing: <code>")
 		b.WriteString(GetNameOfState(prompt.State))
@@ -266,9 +338,13 @@ func (this PostFormatter) GenerateMessage(prompt *PostPrompt) string {
 func (this PostFormatter) GenerateMarkup(prompt *PostPrompt) interface{} {
 	sptr := func(x string) (*string) {return &x }
 	// no buttons for a prompt which has already been finalized
-	if prompt.State == DISCARDED || prompt.State == SAVED { return nil }
+	if prompt.State == DISCARDED || prompt.State == SAVED || prompt.State == SWITCHED { return nil }
 
 	var kb data.TInlineKeyboard
+	if prompt.DuplicateOf != 0 && !prompt.DuplicateDeleted {
+		kb.AddRow()
+		kb.AddButton(data.TInlineKeyboardButton{Text: fmt.Sprintf("\u270F\uFE0F Edit post #%d instead", prompt.DuplicateOf), Data: sptr("/editduplicate")})
+	}
 	kb.AddRow()
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Tags", Data: sptr("/tags")})
 	kb.AddButton(data.TInlineKeyboardButton{Text: "Rating", Data: sptr("/rating")})
@@ -288,7 +364,10 @@ func (this PostFormatter) GenerateMarkup(prompt *PostPrompt) interface{} {
 
 	var extra_buttons data.TInlineKeyboard
 	if prompt.State == WAIT_TAGS {
-		extra_buttons = prompt.TagWizard.Buttons()
+		extra_buttons = tagSuggestionButtons(prompt.TagSuggestions)
+		extra_buttons.Buttons = append(extra_buttons.Buttons, prompt.TagWizard.Buttons().Buttons...)
+	} else if prompt.State == WAIT_MODE {
+		extra_buttons = tagSuggestionButtons(prompt.TagSuggestions)
 	} else if prompt.State == WAIT_RATING {
 		extra_buttons.AddRow()
 		extra_buttons.AddButton(data.TInlineKeyboardButton{Text: "\U0001F7E9 Safe", Data: sptr("/rating s")})
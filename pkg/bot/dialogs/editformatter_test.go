@@ -0,0 +1,65 @@
+package dialogs
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"reflect"
+	"testing"
+)
+
+func Test_NormalizationWarnings(t *testing.T) {
+	n := storage.TagNormalization{
+		Aliased: []storage.TagRewrite{{From: "lupine", To: "wolf"}},
+		Implied: []storage.TagRewrite{{From: "wolf", To: "canine"}, {From: "wolf", To: "mammal"}, {From: "webm", To: "animated"}},
+		Typos: []storage.TagRewrite{{From: "wolff", To: "wolf"}, {From: "<3", To: ""}},
+	}
+	expected := []string{
+		"<code>lupine</code> will become <code>wolf</code>.",
+		"Adding <code>wolf</code> also adds <code>canine</code>, <code>mammal</code>.",
+		"Adding <code>webm</code> also adds <code>animated</code>.",
+		"<code>wolff</code> looks like a typo, did you mean <code>wolf</code>?",
+		"<code>&lt;3</code> doesn't have any posts, is it a typo?",
+	}
+
+	out := EditFormatterBase{}.NormalizationWarnings(n)
+	if !reflect.DeepEqual(out, expected) { t.Errorf("Unexpected warnings:\ngot      %q\nexpected %q", out, expected) }
+
+	if out := (EditFormatterBase{}).NormalizationWarnings(storage.TagNormalization{}); len(out) != 0 { t.Errorf("Expected no warnings, got %q", out) }
+}
+
+func Test_DuplicateWarnings(t *testing.T) {
+	api.Endpoint = "example.com"
+	matches := []storage.ImageMatch{{PostId: 100, Distance: 0}, {PostId: 101, Distance: 2}, {PostId: 102, Distance: 12}}
+
+	testcases := map[string]struct{
+		prompt   PostPrompt
+		expected []string
+	}{
+		"nothing": {PostPrompt{}, nil},
+		"similar": {PostPrompt{ImageMatches: matches[1:]}, []string{
+			`This looks like <a href="https://example.com/posts/101">post #101</a> (96% similar), make sure it isn't a duplicate!`,
+			`This might be a version of <a href="https://example.com/posts/102">post #102</a> (81% similar).`,
+		}},
+		"duplicate": {PostPrompt{DuplicateOf: 100, ImageMatches: matches[:2]}, []string{
+			`<b>This file has already been posted as <a href="https://example.com/posts/100">post #100</a>!</b> You can edit that post instead.`,
+			`This looks like <a href="https://example.com/posts/101">post #101</a> (96% similar), make sure it isn't a duplicate!`,
+		}},
+		"checking": {PostPrompt{File: PostFile{Mode: PF_FROM_URL, Url: "https://example.org/a.png"}}, []string{
+			`Checking whether this file has already been posted...`,
+		}},
+		"check failed": {PostPrompt{File: PostFile{Mode: PF_FROM_URL, Url: "https://example.org/a.png"}, CheckedFile: "https://example.org/a.png", CheckWarning: "Request failed: 403 Forbidden"}, []string{
+			`I couldn't check whether this file has already been posted (Request failed: 403 Forbidden), so make sure it hasn't been before you upload it.`,
+		}},
+		"deleted": {PostPrompt{DuplicateOf: 100, DuplicateDeleted: true}, []string{
+			`<b>This file was already posted as <a href="https://example.com/posts/100">post #100</a>, which was deleted.</b> It can't be uploaded again.`,
+		}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := PostFormatter{}.DuplicateWarnings(&v.prompt)
+			if !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected warnings:\ngot      %q\nexpected %q", out, v.expected) }
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/thewug/fsb/pkg/api/tags"
 	"github.com/thewug/fsb/pkg/api/types"
 	"github.com/thewug/fsb/pkg/apiextra"
+	"github.com/thewug/fsb/pkg/dtext"
 
 	"github.com/thewug/gogram"
 	"github.com/thewug/gogram/data"
@@ -17,10 +18,14 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -34,10 +39,12 @@ const WAIT_SOURCE string = "wait_source"
 const WAIT_RATING string = "wait_rating"
 const WAIT_DESC   string = "wait_desc"
 const WAIT_PARENT string = "wait_parent"
+const WAIT_POOLS  string = "wait_pools"
 const WAIT_REASON string = "wait_reason"
 const WAIT_FILE   string = "wait_file"
 const SAVED       string = "saved"
 const DISCARDED   string = "discarded"
+const SWITCHED    string = "switched"
 
 // special parent constants
 const PARENT_NONE int = -1
@@ -49,6 +56,7 @@ var name_of_state = map[string]string{
 	WAIT_RATING: "Rating",
 	WAIT_DESC:   "Description",
 	WAIT_PARENT: "Parent",
+	WAIT_POOLS:  "Pools",
 	WAIT_FILE:   "File",
 	WAIT_REASON: "Edit Reason",
 	WAIT_ALL:    "Everything",
@@ -68,6 +76,7 @@ type PostFile struct {
 	FileName string `json:"pf_tfname"`
 	Url string `json:"pf_furl"`
 	SizeBytes int64 `json:"pf_size"`
+	Md5 string `json:"pf_md5,omitempty"` // filled in once the file has been downloaded, so it's only downloaded once.
 }
 
 func (this *PostFile) SetTelegramFile(id data.FileID, name string, size int64) {
@@ -76,6 +85,7 @@ func (this *PostFile) SetTelegramFile(id data.FileID, name string, size int64) {
 	this.FileName = name
 	this.Url = ""
 	this.SizeBytes = size
+	this.Md5 = ""
 }
 
 func filenameFromURL(u string) string {
@@ -94,6 +104,93 @@ func (this *PostFile) SetUrl(url string, size int64) {
 	this.FileId = ""
 	this.FileName = filenameFromURL(url)
 	this.SizeBytes = size
+	this.Md5 = ""
+}
+
+// the biggest file the bot will download from a url, which is as big as the site accepts.
+const MAX_URL_FILE_SIZE = 100 * 1024 * 1024
+
+// how long the bot will spend downloading a file from a url.
+const URL_FILE_TIMEOUT = 60 * time.Second
+
+var ErrFileTooLarge = errors.New("File is too large")
+var ErrPrivateAddress = errors.New("Won't download from a private address")
+
+// address ranges which aren't on the internet, beyond what net.IP can tell about itself.
+var privateNetworks = func() []*net.IPNet {
+	var out []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		out = append(out, network)
+	}
+	return out
+}()
+
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() { return false }
+	for _, network := range privateNetworks {
+		if network.Contains(ip) { return false }
+	}
+	return true
+}
+
+// files at urls are downloaded with this, which refuses to connect anywhere that isn't on the internet, so users can't
+// point the bot at things on its own network. the check is made on the address actually connected to, so it covers
+// redirects, and hostnames which resolve somewhere else the second time.
+var urlFileClient = &http.Client{
+	Timeout: URL_FILE_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil { return err }
+				if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) { return ErrPrivateAddress }
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+	},
+}
+
+// a download which fails once it's read more than MAX_URL_FILE_SIZE, rather than quietly stopping short.
+type cappedBody struct {
+	io.ReadCloser
+	reader io.Reader
+	read int64
+}
+
+func (this *cappedBody) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.read += int64(n)
+	if this.read > MAX_URL_FILE_SIZE { return n, ErrFileTooLarge }
+	return n, err
+}
+
+// starts downloading the file, from telegram or from its url.
+func (this *PostFile) Open(bot *gogram.TelegramBot) (io.ReadCloser, error) {
+	if this.Mode == PF_FROM_URL {
+		u, err := url.Parse(this.Url)
+		if err != nil { return nil, err }
+		if u.Scheme != "http" && u.Scheme != "https" { return nil, errors.New("Not a web address: " + this.Url) }
+
+		resp, err := urlFileClient.Get(this.Url)
+		if err != nil { return nil, err }
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, errors.New("Request failed: " + resp.Status)
+		} else if resp.ContentLength > MAX_URL_FILE_SIZE {
+			resp.Body.Close()
+			return nil, ErrFileTooLarge
+		}
+		return &cappedBody{ReadCloser: resp.Body, reader: io.LimitReader(resp.Body, MAX_URL_FILE_SIZE + 1)}, nil
+	}
+
+	file, err := bot.Remote.GetFile(data.OGetFile{Id: this.FileId})
+	if err != nil { return nil, err }
+	if file == nil || file.FilePath == nil { return nil, errors.New("Telegram didn't return a file path") }
+	return bot.Remote.DownloadFile(data.OFile{FilePath: *file.FilePath})
 }
 
 func (this *PostFile) Clear() {
@@ -101,6 +198,7 @@ func (this *PostFile) Clear() {
 	this.FileId = ""
 	this.FileName = ""
 	this.Url = ""
+	this.Md5 = ""
 }
 
 type EditPrompt struct {
@@ -112,20 +210,40 @@ type EditPrompt struct {
 
 	// stuff to generate the post info
 	TagChanges tags.TagDiff `json:"tag_changes"`
+	TagSuggestions []TagSuggestion `json:"tag_suggestions,omitempty"`
 	SourceChanges tags.StringDiff `json:"source_changes"` // not actually tags, but you can treat them the same.
 	OrigSources map[string]int `json:"sources_live"`
 	SeenSources map[string]int `json:"source_seen"`
 	SeenSourcesReverse []string `json:"source_seen_rev"`
 	Parent int `json:"parent"`
+	PoolChanges tags.StringDiff `json:"pool_changes"` // pool ids, as strings.
 	Rating types.PostRating `json:"rating"`
 	Description string `json:"description"`
 	File PostFile `json:"file"`
 	Reason string `json:"reason"`
+
+	// not saved, this is recalculated every time the prompt is shown.
+	normalization storage.TagNormalization
+}
+
+// remembers the post's current sources, so they can be offered as buttons and removed.
+func (this *EditPrompt) LoadOriginalSources(tx storage.DBLike) error {
+	this.OrigSources = make(map[string]int)
+
+	post_data, err := storage.PostByID(tx, this.PostId)
+	if post_data != nil {
+		for _, s := range post_data.Sources {
+			this.SeeSource(s)
+			this.OrigSources[s] = 1
+		}
+	}
+	return err
 }
 
 func (this *EditPrompt) ApplyReset(state string) {
 	if state == WAIT_TAGS || state == WAIT_ALL {
 		this.TagChanges.Clear()
+		this.TagSuggestions = nil
 	}
 
 	if state == WAIT_SOURCE || state == WAIT_ALL {
@@ -149,6 +267,10 @@ func (this *EditPrompt) ApplyReset(state string) {
 		this.Parent = PARENT_RESET
 	}
 
+	if state == WAIT_POOLS || state == WAIT_ALL {
+		this.PoolChanges.Clear()
+	}
+
 	if state == WAIT_REASON || state == WAIT_ALL {
 		this.Reason = ""
 	}
@@ -194,6 +316,34 @@ func (this *EditPrompt) SourceStringPrefixed(source string) {
 	}
 }
 
+// applies a list of pool changes, which are pool ids or links to pools, optionally prefixed with a minus (-) to take
+// the post out of that pool, or an equals (=) to leave it alone. returns false if any of them weren't understood.
+func (this *EditPrompt) PoolsString(pools string) bool {
+	ok := true
+	for _, token := range strings.Fields(pools) {
+		prefix := ""
+		if strings.HasPrefix(token, "-") || strings.HasPrefix(token, "+") || strings.HasPrefix(token, "=") {
+			prefix, token = token[:1], token[1:]
+		}
+
+		id := apiextra.GetPoolIDFromText(token)
+		if id <= 0 {
+			ok = false
+			continue
+		}
+		this.PoolChanges.Apply(prefix + strconv.Itoa(id))
+	}
+	return ok
+}
+
+// posts are added to and removed from pools with the pool: metatag, so pool changes are sent along with the tags.
+func (this *EditPrompt) TagChangesWithPools() tags.TagDiff {
+	var pools tags.TagDiff
+	for id, _ := range this.PoolChanges.AddList { pools.Add("pool:" + id) }
+	for id, _ := range this.PoolChanges.RemoveList { pools.Remove("pool:" + id) }
+	return this.TagChanges.Union(pools)
+}
+
 func (this *EditPrompt) SeeSource(source string) {
 	if _, ok := this.SeenSources[source]; ok { return } // already seen
 	if this.SeenSources == nil { this.SeenSources = make(map[string]int) }
@@ -212,6 +362,10 @@ func (this *EditPrompt) ID() data.DialogID {
 }
 
 func (this *EditPrompt) Prompt(tx storage.DBLike, bot *gogram.TelegramBot, ctx *gogram.MessageCtx, frmt EditFormatter) (*gogram.MessageCtx) {
+	var err error
+	this.normalization, err = storage.NormalizeTags(tx, strings.Fields(this.TagChanges.AddedSet().String()))
+	if err != nil { bot.ErrorLog.Println("Error normalizing tags: ", err.Error()) }
+
 	var send data.SendData
 	send.Text = frmt.GenerateMessage(this)
 	send.ParseMode = data.ParseHTML
@@ -256,6 +410,24 @@ func (this *EditPrompt) Finalize(tx storage.DBLike, bot *gogram.TelegramBot, ctx
 	return prompt
 }
 
+// offers replacements for any tags in tagstr which don't exist.
+func (this *EditPrompt) SuggestTags(tx storage.DBLike, tagstr string) error {
+	suggestions, err := SuggestTags(tx, tagstr)
+	if err != nil { return err }
+	this.TagSuggestions = mergeTagSuggestions(this.TagSuggestions, suggestions)
+	return nil
+}
+
+// swaps a mistyped tag for its suggested replacement.
+func (this *EditPrompt) AcceptSuggestion(n int) {
+	if n < 0 || n >= len(this.TagSuggestions) { return }
+	s := this.TagSuggestions[n]
+	this.TagChanges.Reset(s.Typed)
+	this.TagChanges.Add(s.Tag)
+	this.TagSuggestions = removeTagSuggestion(this.TagSuggestions, s.Typed)
+	this.Status = fmt.Sprintf("Replaced <code>%s</code> with <code>%s</code>.", html.EscapeString(s.Typed), html.EscapeString(s.Tag))
+}
+
 func (this *EditPrompt) ResetState() {
 	this.State = WAIT_MODE
 	this.Status = "What would you like to edit? Pick a button from below."
@@ -265,6 +437,7 @@ func (this *EditPrompt) IsNoop() bool {
 	return len(this.Rating) == 0 &&
                this.TagChanges.IsZero() &&
                this.SourceChanges.IsZero() &&
+               this.PoolChanges.IsZero() &&
                len(this.Description) == 0 &&
                this.Parent == 0
 }
@@ -345,6 +518,16 @@ func (this *EditPrompt) PostStatus(b *bytes.Buffer) {
 		b.WriteString(fmt.Sprintf("Parent post: <a href=\"https://" + api.Endpoint + "/posts/%d\">Post #%d</a>\n", this.Parent, this.Parent))
 		no_changes = false
 	}
+	if !this.PoolChanges.IsZero() {
+		b.WriteString("Pools:")
+		for _, id := range this.PoolChanges.Array() {
+			verb, id := "add to", id
+			if strings.HasPrefix(id, "-") { verb, id = "remove from", id[1:] }
+			b.WriteString(fmt.Sprintf(" <a href=\"https://" + api.Endpoint + "/pools/%s\">%s #%s</a>", id, verb, id))
+		}
+		b.WriteString("\n")
+		no_changes = false
+	}
 	if no_changes {
 		b.WriteString("No changes so far.\n")
 	}
@@ -367,7 +550,7 @@ func (this *EditPrompt) CommitEdit(tx storage.DBLike, user, api_key string, ctx
 	if this.Description != "" { description = &this.Description }
 	if this.Reason != "" { reason = &this.Reason }
 
-	update, err := api.UpdatePost(user, api_key, this.PostId, this.TagChanges, this.Rating, parent, this.SourceChanges.Array(), description, reason)
+	update, err := api.UpdatePost(user, api_key, this.PostId, this.TagChangesWithPools(), this.Rating, parent, this.SourceChanges.Array(), description, reason)
 	if err != nil {
 		return nil, err
 	}
@@ -398,6 +581,7 @@ const (
 		postsource
 		postdescription
 		postparent
+		postpools
 		postupload
 		postnext
 	editreason
@@ -426,7 +610,7 @@ func (this *EditPrompt) ParseArgs(ctx *gogram.MessageCtx) (bool, error) {
 	var mode int
 	var commitnow bool
 
-	for _, token := range ctx.Cmd.Args {
+	for i, token := range ctx.Cmd.Args {
 		if mode != root {
 			if mode == posttags {
 				this.TagChanges.ApplyString(token)
@@ -438,13 +622,17 @@ func (this *EditPrompt) ParseArgs(ctx *gogram.MessageCtx) (bool, error) {
 					return false, errors.New("Please try again with a valid rating.")
 				}
 			} else if mode == postdescription {
-				this.Description = token // at some point i should make it convert telegram markup to dtext but i can't do that right now
+				this.Description = dtext.FromMessageArg(ctx.Msg, i, token)
 			} else if mode == postparent {
 				this.Parent = apiextra.GetParentPostFromText(token)
 
 				if this.Parent == apiextra.NONEXISTENT_PARENT {
 					return false, errors.New("Please try again wth a valid parent post.")
 				}
+			} else if mode == postpools {
+				if !this.PoolsString(token) {
+					return false, errors.New("Please try again with valid pool IDs.")
+				}
 			} else if mode == postfileurl {
 				this.File.SetUrl(token, 0)
 			} else if mode == editreason {
@@ -461,6 +649,8 @@ func (this *EditPrompt) ParseArgs(ctx *gogram.MessageCtx) (bool, error) {
 			mode = postdescription
 		} else if token == "--parent" {
 			mode = postparent
+		} else if token == "--pools" {
+			mode = postpools
 		} else if token == "--reason" {
 			mode = editreason
 		} else if token == "--url" {
@@ -487,6 +677,11 @@ func (this *EditPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 	case "/tags":
 		this.Status = "Enter a list of tag changes, seperated by spaces. You can clear tags by prefixing them with a minus (-) and reset them by prefixing with an equals (=)."
 		this.State = WAIT_TAGS
+	case "/suggest":
+		if len(ctx.Cmd.Args) != 1 { return }
+		index, err := strconv.Atoi(ctx.Cmd.Args[0])
+		if err != nil { return }
+		this.AcceptSuggestion(index)
 	case "/sources":
 		if len(ctx.Cmd.Args) == 2 {
 			index, err := strconv.Atoi(ctx.Cmd.Args[0])
@@ -507,7 +702,7 @@ func (this *EditPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 		this.Status = "Post the new rating."
 		this.State = WAIT_RATING
 	case "/description":
-		this.Status = `Post the new description. You can use <a href="https://" + api.Endpoint + "/help/dtext">dtext</a>.`
+		this.Status = `Post the new description. You can use <a href="https://` + api.Endpoint + `/help/dtext">dtext</a>, or telegram's own formatting.`
 		this.State = WAIT_DESC
 	case "/parent":
 		if len(ctx.Cmd.Args) == 1 {
@@ -518,6 +713,9 @@ func (this *EditPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 		}
 		this.Status = `Post the new parent.`
 		this.State = WAIT_PARENT
+	case "/pools":
+		this.Status = "Post the pools to add this post to, as pool IDs or links, seperated by spaces. You can take it out of a pool by prefixing it with a minus (-)."
+		this.State = WAIT_POOLS
 	case "/reason":
 		this.Status = "Why are you editing this post? Post an edit reason, 250 characters max."
 		this.State = WAIT_REASON
@@ -536,9 +734,10 @@ func (this *EditPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 	}
 }
 
-func (this *EditPrompt) HandleFreeform(ctx *gogram.MessageCtx) {
+func (this *EditPrompt) HandleFreeform(tx storage.DBLike, ctx *gogram.MessageCtx) {
 	if this.State == WAIT_TAGS {
 		this.TagChanges.ApplyString(ctx.Msg.PlainText())
+		if err := this.SuggestTags(tx, ctx.Msg.PlainText()); err != nil { ctx.Bot.ErrorLog.Println("Error suggesting tags: ", err.Error()) }
 		this.Status = "Got it. Continue sending more tag changes, and pick a button from below when you're done."
 	} else if this.State == WAIT_SOURCE {
 		for _, source := range strings.Split(ctx.Msg.PlainText(), "\n") {
@@ -555,7 +754,7 @@ func (this *EditPrompt) HandleFreeform(ctx *gogram.MessageCtx) {
 			this.ResetState()
 		}
 	} else if this.State == WAIT_DESC {
-		this.Description = ctx.Msg.PlainText() // TODO: convert telegram markup to dtext
+		this.Description = dtext.FromMessage(ctx.Msg)
 		this.ResetState()
 	} else if this.State == WAIT_PARENT {
 		parent := apiextra.GetParentPostFromText(ctx.Msg.PlainText())
@@ -566,6 +765,12 @@ func (this *EditPrompt) HandleFreeform(ctx *gogram.MessageCtx) {
 			this.Parent = parent
 			this.ResetState()
 		}
+	} else if this.State == WAIT_POOLS {
+		if this.PoolsString(ctx.Msg.PlainText()) {
+			this.Status = "Got it. Continue sending more pool changes, and pick a button from below when you're done."
+		} else {
+			this.Status = "Please enter <i>valid</i> pools. (You can send links to " + api.ApiName + " pools or bare numeric IDs, and prefix them with a minus (-) to remove the post from them.)"
+		}
 	} else if this.State == WAIT_REASON {
 		this.Reason = ctx.Msg.PlainText()
 		this.ResetState()
@@ -0,0 +1,108 @@
+package dialogs
+
+import (
+	apitest "github.com/thewug/fsb/pkg/api/test"
+
+	"github.com/thewug/fsb/pkg/api/tags"
+	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/apiextra"
+
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_EditPrompt_Pools(t *testing.T) {
+	if err := apiextra.Init(apitest.Settings{Endpoint: "example.com"}); err != nil { t.Fatalf("Couldn't initialize apiextra: %s", err.Error()) }
+
+	testcases := map[string]struct{
+		pools    string
+		ok       bool
+		expected string
+	}{
+		"ids": {"12 -34", true, "pool:12 wolf -pool:34 -solo"},
+		"links": {"https://example.com/pools/12 -example.com/pools/34", true, "pool:12 wolf -pool:34 -solo"},
+		"reset": {"12 =12", true, "wolf -solo"},
+		"garbage": {"12 pools", false, "pool:12 wolf -solo"},
+		"empty": {"", true, "wolf -solo"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			prompt := EditPrompt{TagChanges: tags.TagDiffFromString("wolf -solo")}
+			if ok := prompt.PoolsString(v.pools); ok != v.ok { t.Errorf("Unexpected result: got %t, expected %t", ok, v.ok) }
+			if out := prompt.TagChangesWithPools().APIString(); out != v.expected { t.Errorf("Unexpected tag changes: got %s, expected %s", out, v.expected) }
+			if prompt.TagChanges.APIString() != "wolf -solo" { t.Errorf("Pool changes leaked into the tag changes: %s", prompt.TagChanges.APIString()) }
+		})
+	}
+}
+
+func Test_PostPrompt_EditDuplicate(t *testing.T) {
+	var p PostPrompt
+	p.DuplicateOf = 101
+	p.TagWizard.MergeTagsFromString("wolf solo")
+	p.Sources.Set("https://example.org/art/1")
+	p.Rating = types.Safe
+	p.Description = "[b]hi[/b]"
+
+	e := p.EditDuplicate()
+	if e.PostId != 101 { t.Errorf("Unexpected post: %d", e.PostId) }
+	if e.TagChanges.APIString() != "solo wolf" { t.Errorf("Unexpected tag changes: %s", e.TagChanges.APIString()) }
+	if e.SourceChanges.Status("https://example.org/art/1") != tags.AddsTag { t.Errorf("Source wasn't carried over") }
+	if e.Rating != types.Safe || e.Description != p.Description { t.Errorf("Unexpected rating or description: %s, %s", e.Rating, e.Description) }
+}
+
+func Test_isPublicAddress(t *testing.T) {
+	testcases := map[string]bool{
+		"93.184.216.34": true,
+		"2606:2800:220:1:248:1893:25c8:1946": true,
+		"127.0.0.1": false,
+		"::1": false,
+		"10.1.2.3": false,
+		"172.20.0.1": false,
+		"192.168.1.1": false,
+		"169.254.169.254": false,
+		"100.64.0.1": false,
+		"0.0.0.0": false,
+		"fd00::1": false,
+		"fe80::1": false,
+		"::ffff:127.0.0.1": false,
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := isPublicAddress(net.ParseIP(k)); out != v { t.Errorf("Unexpected result: got %t, expected %t", out, v) }
+		})
+	}
+}
+
+func Test_cappedBody(t *testing.T) {
+	open := func(size int) io.ReadCloser {
+		body := ioutil.NopCloser(strings.NewReader(strings.Repeat("x", size)))
+		return &cappedBody{ReadCloser: body, reader: io.LimitReader(body, MAX_URL_FILE_SIZE + 1)}
+	}
+
+	if n, err := io.Copy(ioutil.Discard, open(MAX_URL_FILE_SIZE)); n != MAX_URL_FILE_SIZE || err != nil { t.Errorf("Couldn't read a file at the limit: %d, %v", n, err) }
+	if _, err := io.Copy(ioutil.Discard, open(MAX_URL_FILE_SIZE + 1)); !errors.Is(err, ErrFileTooLarge) { t.Errorf("Expected a file over the limit to fail, got %v", err) }
+}
+
+func Test_PostPrompt_IsComplete_File(t *testing.T) {
+	base := func() PostPrompt {
+		var p PostPrompt
+		p.TagWizard.MergeTagsFromString("solo wolf canine mammal outside standing rating:s")
+		p.File.SetUrl("https://example.org/a.png", 0)
+		return p
+	}
+
+	p := base()
+	if err := p.IsComplete(); err == nil { t.Errorf("Expected an unchecked file to be incomplete") }
+
+	p.CheckedFile, p.CheckWarning = p.File.Url, "Request failed: 403 Forbidden"
+	if err := p.IsComplete(); err != nil { t.Errorf("Expected a file which couldn't be checked to be allowed, got %s", err.Error()) }
+
+	p.DuplicateOf = 101
+	if err := p.IsComplete(); err == nil { t.Errorf("Expected a duplicate to be incomplete") }
+}
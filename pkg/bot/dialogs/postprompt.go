@@ -7,17 +7,22 @@ import (
 	"github.com/thewug/fsb/pkg/api/types"
 	"github.com/thewug/fsb/pkg/storage"
 	"github.com/thewug/fsb/pkg/apiextra"
+	"github.com/thewug/fsb/pkg/dtext"
+	"github.com/thewug/fsb/pkg/imagehash"
 
 	"github.com/thewug/gogram"
 	"github.com/thewug/gogram/data"
 	"github.com/thewug/gogram/dialog"
 
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"strconv"
 	"strings"
@@ -26,6 +31,8 @@ import (
 
 const POST_PROMPT_ID data.DialogID = "postprompt"
 
+const IMAGE_MATCHES_SHOWN = 3
+
 func PostPromptID() data.DialogID {
 	return POST_PROMPT_ID
 }
@@ -55,6 +62,7 @@ type PostPrompt struct {
 
 	// stuff to generate the post info
 	TagWizard wizard.TagWizard `json:"tagwiz"`
+	TagSuggestions []TagSuggestion `json:"tag_suggestions,omitempty"`
 	Sources tags.StringSet `json:"sources"` // not actually tags, but you can treat them the same.
 	SeenSources map[string]int `json:"source_seen"`
 	SeenSourcesReverse []string `json:"source_seen_rev"`
@@ -62,6 +70,26 @@ type PostPrompt struct {
 	Rating types.PostRating `json:"rating"`
 	Description string `json:"description"`
 	File PostFile `json:"file"`
+
+	// what's known about the file: which file was checked, the post that already has that exact file if there is
+	// one, posts which look like it, and why the check couldn't be done, if it couldn't. a file can't be posted until
+	// it has been checked, but a check which couldn't be done only gets a warning, since the site might still be
+	// able to fetch a url the bot can't. CheckedFile was called MatchedFile once, and it's still saved under that name.
+	CheckedFile string `json:"matched_file,omitempty"`
+	CheckWarning string `json:"check_warning,omitempty"`
+	DuplicateOf int `json:"duplicate_of,omitempty"`
+	DuplicateDeleted bool `json:"duplicate_deleted,omitempty"`
+	ImageMatches []storage.ImageMatch `json:"image_matches,omitempty"`
+
+	// not saved, this is recalculated every time the prompt is shown.
+	normalization storage.TagNormalization
+
+	// not saved, used to search the site for duplicates which aren't in the local index.
+	user, api_key string
+}
+
+func (this *PostPrompt) SetCredentials(user, api_key string) {
+	this.user, this.api_key = user, api_key
 }
 
 func (this *PostPrompt) JSON() (string, error) {
@@ -76,6 +104,7 @@ func (this *PostPrompt) ID() data.DialogID {
 func (this *PostPrompt) ApplyReset(state string) {
 	if state == WAIT_TAGS || state == WAIT_ALL {
 		this.TagWizard.Reset()
+		this.TagSuggestions = nil
 		this.Status = html.EscapeString(this.TagWizard.Prompt())
 	}
 
@@ -141,6 +170,132 @@ func (this *PostPrompt) SeeSource(source string) {
 	this.SeenSourcesReverse = append(this.SeenSourcesReverse, source)
 }
 
+// offers replacements for any tags in tagstr which don't exist.
+func (this *PostPrompt) SuggestTags(tx storage.DBLike, tagstr string) error {
+	suggestions, err := SuggestTags(tx, tagstr)
+	if err != nil { return err }
+	this.TagSuggestions = mergeTagSuggestions(this.TagSuggestions, suggestions)
+	return nil
+}
+
+// swaps a mistyped tag for its suggested replacement.
+func (this *PostPrompt) AcceptSuggestion(n int) {
+	if n < 0 || n >= len(this.TagSuggestions) { return }
+	s := this.TagSuggestions[n]
+	this.TagWizard.ClearTag(s.Typed)
+	this.TagWizard.SetTag(s.Tag)
+	this.TagSuggestions = removeTagSuggestion(this.TagSuggestions, s.Typed)
+	this.Status = fmt.Sprintf("Replaced <code>%s</code> with <code>%s</code>.", html.EscapeString(s.Typed), html.EscapeString(s.Tag))
+}
+
+// what was found out about a file by downloading it, see DownloadFile.
+type FileCheck struct {
+	file      string
+	md5       string
+	hash      uint64
+	hashed    bool
+	site_post *types.TPostInfo
+	err       error
+}
+
+// the file being uploaded, either a url or a telegram file id, or an empty string if there isn't one yet.
+func (this *PostPrompt) CurrentFile() string {
+	if this.File.Mode == PF_FROM_TELEGRAM { return string(this.File.FileId) }
+	if this.File.Mode == PF_FROM_URL { return this.File.Url }
+	return ""
+}
+
+func (this *PostPrompt) FileChecked() bool {
+	return this.CurrentFile() == this.CheckedFile
+}
+
+// downloads the file being uploaded and hashes it, and looks it up on the site in case the local index hasn't caught
+// up with it yet. this can take a while, so it should be done outside of any transaction, and the result handed to
+// CheckFile inside one afterwards. anything which goes wrong is part of the result. returns nil if the file has already
+// been checked, and doesn't download it again if its md5 is already known.
+func (this *PostPrompt) DownloadFile(bot *gogram.TelegramBot) *FileCheck {
+	file := this.CurrentFile()
+	if file == this.CheckedFile { return nil }
+
+	check := &FileCheck{file: file, md5: this.File.Md5}
+	if file == "" { return check }
+
+	if check.md5 == "" {
+		check.err = check.download(bot, &this.File)
+		if check.err != nil { return check }
+	}
+
+	if this.user != "" {
+		posts, err := api.ListPosts(this.user, this.api_key, types.ListPostOptions{SearchQuery: "md5:" + check.md5, Limit: 1})
+		if err != nil { check.err = err; return check }
+		if len(posts) != 0 { check.site_post = &posts[0] }
+	}
+
+	return check
+}
+
+func (this *FileCheck) download(bot *gogram.TelegramBot, file *PostFile) error {
+	body, err := file.Open(bot)
+	if err != nil { return err }
+	defer body.Close()
+
+	// hash the file while decoding the image, and then read whatever the decoder didn't need.
+	sum := md5.New()
+	tee := io.TeeReader(body, sum)
+	hash, hash_err := imagehash.FromReader(tee)
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil { return err }
+	this.md5 = hex.EncodeToString(sum.Sum(nil))
+
+	if hash_err == nil {
+		this.hash, this.hashed = hash, true
+	} else if hash_err != imagehash.ErrUnsupported { // videos and flash can't be compared, that's fine.
+		return hash_err
+	}
+	return nil
+}
+
+// records what DownloadFile found out about the file, and looks for posts which already have it, or which look like it.
+// if the file couldn't be downloaded, or the site couldn't be asked about it, the file is still marked as checked, with
+// a warning saying why, and whatever could be found out anyway. a database error leaves it unchecked.
+func (this *PostPrompt) CheckFile(tx storage.DBLike, check *FileCheck) error {
+	// nothing was checked, or the file has changed again since.
+	if check == nil || check.file != this.CurrentFile() { return nil }
+
+	this.CheckedFile, this.CheckWarning, this.DuplicateOf, this.DuplicateDeleted, this.ImageMatches = "", "", 0, false, nil
+	this.File.Md5 = check.md5
+	if check.file == "" { return nil }
+
+	if check.md5 != "" {
+		// prefer the local index, and fall back on what the site said.
+		post, err := storage.PostByMD5(tx, check.md5)
+		if err != nil { return err }
+		if post == nil { post = check.site_post }
+		if post != nil { this.DuplicateOf, this.DuplicateDeleted = post.Id, post.Deleted }
+	}
+
+	if check.hashed {
+		var err error
+		this.ImageMatches, err = storage.SimilarPosts(tx, check.hash, imagehash.POSSIBLE_MATCH, IMAGE_MATCHES_SHOWN)
+		if err != nil { return err }
+	}
+
+	if check.err != nil { this.CheckWarning = check.err.Error() }
+	this.CheckedFile = check.file
+	return nil
+}
+
+// starts an edit of the post which already has this file, carrying over everything filled in so far.
+func (this *PostPrompt) EditDuplicate() *EditPrompt {
+	e := &EditPrompt{PostId: this.DuplicateOf, Parent: this.Parent, Rating: this.TestRating(), Description: this.Description}
+	e.TagChanges = tags.TagDiffFromString(this.TagWizard.Tags().String())
+	for source := range this.Sources.Data {
+		e.SourceChanges.Add(source)
+		e.SeeSource(source)
+	}
+	e.ResetState()
+	return e
+}
+
 func (this *PostPrompt) ResetState() {
 	this.State = WAIT_MODE
 	this.Status = "What would you like to edit? Pick a button from below."
@@ -212,6 +367,10 @@ func (this *PostPrompt) IsComplete() error {
 		return errors.New("You must specify at least six tags!")
 	} else if this.File.Mode == PF_UNSET {
 		return errors.New("You must specify a file!")
+	} else if !this.FileChecked() {
+		return errors.New("I'm still checking whether this file has already been posted, try again in a moment.")
+	} else if this.DuplicateOf != 0 {
+		return fmt.Errorf("This file has already been posted, as post #%d!", this.DuplicateOf)
 	}
 
 	return nil
@@ -252,6 +411,10 @@ func (this *PostPrompt) CommitPost(user, api_key string, ctx *gogram.MessageCtx)
 }
 
 func (this *PostPrompt) Prompt(tx storage.DBLike, bot *gogram.TelegramBot, ctx *gogram.MessageCtx, frmt PostFormatter) (*gogram.MessageCtx) {
+	var err error
+	this.normalization, err = storage.NormalizeTags(tx, strings.Fields(this.TagWizard.Tags().String()))
+	if err != nil { bot.ErrorLog.Println("Error normalizing tags: ", err.Error()) }
+
 	var send data.SendData
 	send.Text = frmt.GenerateMessage(this)
 	send.ParseMode = data.ParseHTML
@@ -319,7 +482,7 @@ func (this *PostPrompt) ParseArgs(ctx *gogram.MessageCtx) (bool, error) {
 	var mode int
 	var commitnow bool
 
-	for _, token := range ctx.Cmd.Args {
+	for i, token := range ctx.Cmd.Args {
 		if mode != root {
 			if mode == posttags {
 				this.TagWizard.MergeTagsFromString(token)
@@ -331,7 +494,7 @@ func (this *PostPrompt) ParseArgs(ctx *gogram.MessageCtx) (bool, error) {
 					return false, errors.New("Please try again with a valid rating.")
 				}
 			} else if mode == postdescription {
-				this.Description = token // at some point i should make it convert telegram markup to dtext but i can't do that right now
+				this.Description = dtext.FromMessageArg(ctx.Msg, i, token)
 			} else if mode == postparent {
 				this.Parent = apiextra.GetParentPostFromText(token)
 
@@ -376,6 +539,11 @@ func (this *PostPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 	case "/tags":
 		this.Status = html.EscapeString(this.TagWizard.Prompt())
 		this.State = WAIT_TAGS
+	case "/suggest":
+		if len(ctx.Cmd.Args) != 1 { return }
+		index, err := strconv.Atoi(ctx.Cmd.Args[0])
+		if err != nil { return }
+		this.AcceptSuggestion(index)
 	case "/sources":
 		if len(ctx.Cmd.Args) == 2 {
 			index, err := strconv.Atoi(ctx.Cmd.Args[0])
@@ -396,7 +564,7 @@ func (this *PostPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 		this.Status = "Post the new rating."
 		this.State = WAIT_RATING
 	case "/description":
-		this.Status = `Post the new description. You can use <a href="https://" + api.Endpoint + "/help/dtext">dtext</a>.`
+		this.Status = `Post the new description. You can use <a href="https://` + api.Endpoint + `/help/dtext">dtext</a>, or telegram's own formatting.`
 		this.State = WAIT_DESC
 	case "/parent":
 		if len(ctx.Cmd.Args) == 1 {
@@ -413,6 +581,10 @@ func (this *PostPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 	case "/save":
 		this.Status = ""
 		this.State = SAVED
+	case "/editduplicate":
+		if this.DuplicateOf == 0 || this.DuplicateDeleted { return }
+		this.Status = ""
+		this.State = SWITCHED
 	case "/discard":
 		ctx.AnswerAsync(data.OCallback{Notification: "\U0001F534 Edit discarded."}, nil) // finalize dialog post and discard edit
 		this.Status = ""
@@ -425,9 +597,10 @@ func (this *PostPrompt) HandleCallback(ctx *gogram.CallbackCtx) {
 	}
 }
 
-func (this *PostPrompt) HandleFreeform(ctx *gogram.MessageCtx) {
+func (this *PostPrompt) HandleFreeform(tx storage.DBLike, ctx *gogram.MessageCtx) {
 	if this.State == WAIT_TAGS {
 		this.TagWizard.MergeTagsFromString(ctx.Msg.PlainText())
+		if err := this.SuggestTags(tx, ctx.Msg.PlainText()); err != nil { ctx.Bot.ErrorLog.Println("Error suggesting tags: ", err.Error()) }
 		this.Status = "Got it. Continue sending more tag changes, and pick a button from below when you're done."
 	} else if this.State == WAIT_SOURCE {
 		for _, source := range strings.Split(ctx.Msg.PlainText(), "\n") {
@@ -444,7 +617,7 @@ func (this *PostPrompt) HandleFreeform(ctx *gogram.MessageCtx) {
 			this.ResetState()
 		}
 	} else if this.State == WAIT_DESC {
-		this.Description = ctx.Msg.PlainText() // TODO: convert telegram markup to dtext
+		this.Description = dtext.FromMessage(ctx.Msg)
 		this.ResetState()
 	} else if this.State == WAIT_PARENT {
 		parent := apiextra.GetParentPostFromText(ctx.Msg.PlainText())
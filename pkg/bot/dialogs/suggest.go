@@ -0,0 +1,73 @@
+package dialogs
+
+import (
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram/data"
+
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// a tag someone typed which doesn't exist, and the tag they probably meant.
+type TagSuggestion struct {
+	Typed string `json:"typed"`
+	Tag string `json:"tag"`
+}
+
+// no more than this many suggestions are offered at once, any more and they'd crowd out the other buttons.
+const MAX_TAG_SUGGESTIONS = 8
+
+var tag_delimiter *regexp.Regexp = regexp.MustCompile(`\s+`)
+
+// looks for unknown tags in a list of tag changes and suggests replacements for them. removals and resets are
+// skipped, as are metatags and wildcards, since those aren't in the tag index.
+func SuggestTags(tx storage.DBLike, tagstr string) ([]TagSuggestion, error) {
+	var out []TagSuggestion
+	for _, tag := range tag_delimiter.Split(strings.ToLower(tagstr), -1) {
+		if len(out) == MAX_TAG_SUGGESTIONS { break }
+		if strings.HasPrefix(tag, "-") || strings.HasPrefix(tag, "=") { continue }
+		tag = strings.TrimPrefix(tag, "+")
+		if tag == "" || strings.ContainsAny(tag, ":*") { continue }
+
+		known, err := storage.IsKnownTag(tx, tag)
+		if err != nil { return nil, err }
+		if known { continue }
+
+		completions, err := storage.CompleteTag(tx, tag, 1)
+		if err != nil { return nil, err }
+		if len(completions) == 0 || completions[0].Tag == tag { continue }
+		out = append(out, TagSuggestion{Typed: tag, Tag: completions[0].Tag})
+	}
+	return out, nil
+}
+
+// merges new suggestions into existing ones, replacing any for the same typed tag.
+func mergeTagSuggestions(existing, more []TagSuggestion) []TagSuggestion {
+	for _, s := range more {
+		existing = removeTagSuggestion(existing, s.Typed)
+		existing = append(existing, s)
+	}
+	if len(existing) > MAX_TAG_SUGGESTIONS { existing = existing[len(existing) - MAX_TAG_SUGGESTIONS:] }
+	return existing
+}
+
+func removeTagSuggestion(existing []TagSuggestion, typed string) []TagSuggestion {
+	var out []TagSuggestion
+	for _, s := range existing {
+		if s.Typed != typed { out = append(out, s) }
+	}
+	return out
+}
+
+// one button per suggestion, which swaps the typed tag for the suggested one when pressed.
+func tagSuggestionButtons(suggestions []TagSuggestion) data.TInlineKeyboard {
+	sptr := func(x string) (*string) {return &x }
+	var kb data.TInlineKeyboard
+	for i, s := range suggestions {
+		if i % 2 == 0 { kb.AddRow() }
+		kb.AddButton(data.TInlineKeyboardButton{Text: fmt.Sprintf("%s ➡ %s", s.Typed, s.Tag), Data: sptr(fmt.Sprintf("/suggest %d", i))})
+	}
+	return kb
+}
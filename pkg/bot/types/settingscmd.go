@@ -30,9 +30,21 @@ func (this RatingMode) String() string {
 type BlacklistMode int
 const BLACKLIST_ON           BlacklistMode = 0
 const BLACKLIST_OFF          BlacklistMode = 1
+const BLACKLIST_BOT_ONLY     BlacklistMode = 2
+const BLACKLIST_SITE_ONLY    BlacklistMode = 3
 func (this BlacklistMode) Display() string {
-	return map[BlacklistMode]string{BLACKLIST_ON: "Blacklist enabled", BLACKLIST_OFF: "Blacklist disabled"}[this]
+	return map[BlacklistMode]string{BLACKLIST_ON: "Blacklist enabled", BLACKLIST_OFF: "Blacklist disabled", BLACKLIST_BOT_ONLY: "Bot blacklist only", BLACKLIST_SITE_ONLY: "Site blacklist only"}[this]
 }
 func (this BlacklistMode) String() string {
 	return strconv.Itoa(int(this))
 }
+
+// whether the blacklist from the user's site account (or the default one, if they aren't logged in) applies.
+func (this BlacklistMode) UsesSite() bool {
+	return this == BLACKLIST_ON || this == BLACKLIST_SITE_ONLY
+}
+
+// whether the blacklist the user keeps with the bot applies.
+func (this BlacklistMode) UsesBot() bool {
+	return this == BLACKLIST_ON || this == BLACKLIST_BOT_ONLY
+}
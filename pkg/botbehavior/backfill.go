@@ -0,0 +1,29 @@
+package botbehavior
+
+import (
+	"github.com/thewug/fsb/pkg/api/tagindex"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+)
+
+// the most posts filled in each time the backfill runs.
+const POST_BACKFILL_BATCH = 5000
+
+// fills in information the post index didn't used to store, a batch at a time, so older posts show up in local
+// searches without needing a full resync.
+func (this *Behavior) BackfillPosts(bot *gogram.TelegramBot) error {
+	filled, err := tagindex.BackfillPostFilesInternal(storage.DefaultNoTx(), this.MySettings.SearchUser, this.MySettings.SearchAPIKey, POST_BACKFILL_BATCH)
+	if filled != 0 { bot.Log.Printf("Filled in file information for %d posts\n", filled) }
+	return err
+}
+
+// the most previews hashed each time hashing runs.
+const PREVIEW_HASH_BATCH = 1000
+
+// hashes the previews of posts which haven't been hashed yet, a batch at a time, for reverse image search.
+func (this *Behavior) HashPreviews(bot *gogram.TelegramBot) error {
+	hashed, err := tagindex.HashPostPreviewsInternal(storage.DefaultNoTx(), PREVIEW_HASH_BATCH)
+	if hashed != 0 { bot.Log.Printf("Hashed previews for %d posts\n", hashed) }
+	return err
+}
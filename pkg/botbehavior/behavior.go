@@ -9,6 +9,7 @@ import (
 	apitypes "github.com/thewug/fsb/pkg/api/types"
 	"github.com/thewug/fsb/pkg/apiextra"
 	"github.com/thewug/fsb/pkg/fsb/errorlog"
+	"github.com/thewug/fsb/pkg/fsb/metrics"
 	"github.com/thewug/fsb/pkg/fsb/proxify"
 	"github.com/thewug/fsb/pkg/storage"
 
@@ -19,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -35,21 +37,39 @@ func ShowHelp() {
 	fmt.Println("  api_endpoint          - the api endpoint hostname.")
 	fmt.Println("  api_filtered_endpoint - the api SSF endpoint hostname.")
 	fmt.Println("  api_static_prefix     - the api endpoint static resource hostname prefix/subdomain.")
+	fmt.Println("  api_backend           - the kind of site the api endpoint is: e621 (default) or danbooru.")
 	fmt.Println("  search_user      - api user with which unathenticated searches are performed.")
 	fmt.Println("  search_apikey    - api key with which unathenticated searches are performed.")
+	fmt.Println("  credential_keys        - a json object of numbered, base64 encoded 32 byte keys, used to encrypt stored api keys.")
+	fmt.Println("  credential_key_file    - a file holding a json object like credential_keys, whose keys are added to them.")
+	fmt.Println("  credential_key_version - which key new api keys are encrypted with (default the highest numbered one).")
+	fmt.Println("                           to rotate keys, add a new one, then run fsbctl rekey before removing the old one.")
+	fmt.Println("  local_search          - search the local post index instead of the api.")
+	fmt.Println("  local_search_fallback - search the local post index if an api search fails.")
+	fmt.Println("  search_timeout        - number of seconds to wait for an api search before giving up (0 for no limit).")
 	fmt.Println("  results_per_page - max number of telegram inline results to return at a time in searches.")
 	fmt.Println("  max_artists      - max number of artists an inline result can include.")
 	fmt.Println("  max_chars        - max number of characters an inline result can include.")
 	fmt.Println("  max_sources      - max number of sources an inline result can include.")
+	fmt.Println("  description_length - max length of the post description excerpt in inline results (0 for none).")
 	fmt.Println("  owner - numeric telegram user ID of bot operator.")
 	fmt.Println("  home  - numeric telegram chat ID of bot's service chat.")
 	fmt.Println("  no_results_photo_id  - base64 telegram photo ID of 'no results' placeholder photo.")
 	fmt.Println("  blacklisted_photo_id - base64 telegram photo ID of 'all results blacklisted' placeholder photo.")
 	fmt.Println("  error_photo_id       - base64 telegram photo ID of 'error' placeholder photo.")
 	fmt.Println("  media_convert_directory   - conversion directory for webm -> mp4 conversions.")
-	fmt.Println("  webm2mp4_convert_script   - script to convert webms into mp4s.")
+	fmt.Println("  webm_profile              - a json object controlling how webms are converted to mp4s, with the keys:")
+	fmt.Println("                                ffmpeg - the ffmpeg binary to use (default ffmpeg, from PATH).")
+	fmt.Println("                                video_codec - ffmpeg video encoder (default libx264).")
+	fmt.Println("                                max_bitrate - in kbit/s (default 4000).")
+	fmt.Println("                                max_dimension - larger videos are scaled down to fit, in pixels (default 1280).")
+	fmt.Println("                                target_size - larger outputs are redone at a lower bitrate, in bytes (default 20MiB).")
 	fmt.Println("  media_store_channel       - numeric telegram chat ID of channel to use for converted media storage.")
+	fmt.Println("  webm_convert_workers      - number of webm -> mp4 conversions to run at once (default 2).")
 	fmt.Println("  maintenance_sync_interval - number of seconds between automatic api post syncs.")
+	fmt.Println("  subscription_digest_interval - minimum number of seconds between saved search digests sent to each user.")
+	fmt.Println("  credential_check_interval    - number of seconds between checks that each user's api key still works (default 1 day).")
+	fmt.Println("  metrics_listen            - address (like localhost:9100) to serve prometheus style metrics on at /metrics, off if unset.")
 	fmt.Println("  debug_media_received      - helper flag, show media ids of incoming photos (useful for setting *_photo_id settings).")
 	fmt.Println("  source_map   - a json array of match rules which control how to format sources.")
 	fmt.Println("                 rules have the following keys:")
@@ -59,6 +79,10 @@ func ShowHelp() {
 	fmt.Println("                   next - either a string (to use this label), or one or more match rules to be evaluated recursively")
 }
 
+var maintenanceDuration = metrics.NewHistogram("fsb_maintenance_duration_seconds", "Time taken by each run of the maintenance routine.", nil, "outcome")
+var autofixEdits = metrics.NewCounter("fsb_autofix_edits_total", "Automatic tag cleanups attempted by the maintenance routine, by outcome.", "outcome")
+var inlineQueryDuration = metrics.NewHistogram("fsb_inline_query_duration_seconds", "Time taken to answer inline queries, by outcome.", nil, "outcome")
+
 type Behavior struct {
 	ForwardTo *gogram.MessageStateMachine
 	MySettings settings.Settings
@@ -91,9 +115,13 @@ func (this *Behavior) StartMaintenanceAsync(bot *gogram.TelegramBot) (chan bool)
 		for maintenances := 0; true; maintenances++ {
 			_ = <- channel
 
+			start := time.Now()
 			err := storage.DefaultTransact(func(tx storage.DBLike) error { return this.maintenanceInternal(tx, bot, maintenances % 144 == 143) })
 			if err != nil {
+				maintenanceDuration.ObserveSince(start, "error")
 				bot.ErrorLog.Println("Error during maintenance routine:", err)
+			} else {
+				maintenanceDuration.ObserveSince(start, "ok")
 			}
 		}
 	}()
@@ -122,7 +150,12 @@ func (this *Behavior) maintenanceInternal(tx storage.DBLike, bot *gogram.Telegra
 	err = tagindex.SyncPostsInternal(tx, this.MySettings.SearchUser, this.MySettings.SearchAPIKey, extra_expensive, extra_expensive, nil, update_chan)
 	if err != nil { return err }
 
+	err = this.QueueSubscriptionMatches(tx, updated_posts)
+	if err != nil { return err }
+
 	edits := make(map[int]*storage.PostSuggestedEdit)
+	// what each autofix replacer will actually change about each post, so it can be recorded and reverted later if need be.
+	autofix_diffs := make(map[int]map[int64]tags.TagDiff)
 
 	page_channel := storage.PaginatedPostsById(tx, updated_post_ids, 10000)
 	for page := range page_channel {
@@ -157,6 +190,18 @@ func (this *Behavior) maintenanceInternal(tx storage.DBLike, bot *gogram.Telegra
 						to := &edits[id].Prompt
 						if r.Autofix {
 							to = &edits[id].AutoFix
+
+							var effective tags.TagDiff
+							for tag, _ := range m.ReplaceSpec.AddList {
+								if sh.metadata.Status(tag) != tags.AddsTag { effective.Add(tag) }
+							}
+							for tag, _ := range m.ReplaceSpec.RemoveList {
+								if sh.metadata.Status(tag) == tags.AddsTag { effective.Remove(tag) }
+							}
+							if autofix_diffs[id] == nil {
+								autofix_diffs[id] = make(map[int64]tags.TagDiff)
+							}
+							autofix_diffs[id][r.Id] = effective
 						}
 						*to = append(*to, m.ReplaceSpec)
 					}
@@ -173,21 +218,29 @@ func (this *Behavior) maintenanceInternal(tx storage.DBLike, bot *gogram.Telegra
 		if !auto_diff.IsZero() {
 			post, err := api.UpdatePost(default_creds.User, default_creds.ApiKey, id, auto_diff, apitypes.Original, nil, nil, nil, sptr("Automatic tag cleanup: typos and concatenations (via KnottyBot)"))
 			if err != nil {
+				autofixEdits.Inc("error")
 				bot.ErrorLog.Println("Error updating post:", err.Error())
 			} else {
+				autofixEdits.Inc("applied")
 				edit.Apply()
 				var applied_api []string
 				for k, _ := range edit.AppliedEdits { applied_api = append(applied_api, k) }
 
+				var change_seq int
+				if post != nil { change_seq = post.Change }
+
 				for _, replacerId := range edit.Represents {
-					storage.AddReplacementHistory(
+					err = storage.AddReplacementHistory(
 						tx,
 						&storage.ReplacementHistory{
 							ReplacementHistoryKey: storage.ReplacementHistoryKey{ReplacerId: replacerId, PostId: id},
-							TelegramUserId: -1,
+							TelegramUserId: storage.AUTOFIX_USER,
 							Timestamp: time.Now(),
+							TagDiff: autofix_diffs[id][replacerId].APIString(),
+							ChangeSeq: change_seq,
 						},
 					)
+					if err != nil { return err }
 				}
 
 				if post != nil {
@@ -433,6 +486,9 @@ func (this *Behavior) ProcessMessage(ctx *gogram.MessageCtx) {
 
 // inline query, do tag search.
 func (this *Behavior) ProcessInlineQuery(ctx *gogram.InlineCtx) {
+	start, outcome := time.Now(), "ok"
+	defer func() { inlineQueryDuration.ObserveSince(start, outcome) }()
+
 	var q QuerySettings
 	q.resultsperpage = this.MySettings.ResultsPerPage
 
@@ -465,6 +521,7 @@ func (this *Behavior) ProcessInlineQuery(ctx *gogram.InlineCtx) {
 
 	var creds storage.UserCreds
 	creds, err := storage.GetUserCreds(nil, ctx.Query.From.Id)
+	logged_in := err == nil
 	if err == storage.ErrNoLogin {
 		creds = this.MySettings.DefaultSearchCredentials()
 	} else if err != nil {
@@ -474,31 +531,30 @@ func (this *Behavior) ProcessInlineQuery(ctx *gogram.InlineCtx) {
 	var settings *storage.UserSettings
 	err = storage.DefaultTransact(func(tx storage.DBLike) error { settings, err = storage.GetUserSettings(tx, ctx.Query.From.Id); return err })
 
-	var blacklist string
-	if settings.BlacklistMode == bottypes.BLACKLIST_ON {
-		if now := time.Now(); creds.BlacklistFetched.Add(time.Hour).Before(now) {
-			user, success, err := api.TestLogin(creds.User, creds.ApiKey)
-			if success {
-				creds.Blacklist = user.Blacklist
-				creds.BlacklistFetched = now
-			} else if err != nil {
-				ctx.Bot.ErrorLog.Println("Error testing login: ", err.Error())
-			}
-			err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.WriteUserCreds(tx, creds) })
+	if settings.BlacklistMode.UsesSite() && logged_in && !creds.Invalid {
+		if creds.BlacklistFetched.Add(time.Hour).Before(time.Now()) {
+			success, err := RefreshCreds(ctx.Bot, &creds)
 			if err != nil {
-				ctx.Bot.ErrorLog.Println("Error writing credentials: ", err.Error())
+				ctx.Bot.ErrorLog.Println("Error testing login: ", err.Error())
+			} else {
+				err = storage.DefaultTransact(func(tx storage.DBLike) error {
+					if !success { return this.CredentialsRevoked(tx, ctx.Bot, creds) }
+					return storage.WriteUserCreds(tx, creds)
+				})
+				if err != nil {
+					ctx.Bot.ErrorLog.Println("Error writing credentials: ", err.Error())
+				}
 			}
 		}
-
-		blacklist = creds.Blacklist
 	}
 
-	allowed_ratings := apiextra.Ratings{Safe: true, Questionable: true, Explicit: true}
+	site_blacklist := api.DefaultBlacklist
+	if logged_in { site_blacklist = creds.Blacklist }
+	blacklist := settings.EffectiveBlacklist(site_blacklist)
+
+	allowed_ratings := AllowedRatings(settings.RatingMode)
 	q.settingsbutton = "Search Settings"
-	if settings.RatingMode == bottypes.FILTER_EXPLICIT {
-		allowed_ratings = apiextra.Ratings{Safe: true, Questionable: true, Explicit: false}
-	} else if settings.RatingMode == bottypes.FILTER_QUESTIONABLE {
-		allowed_ratings = apiextra.Ratings{Safe: true, Questionable: false, Explicit: false}
+	if settings.RatingMode == bottypes.FILTER_QUESTIONABLE {
 		q.settingsbutton += " [SFW mode]"
 	}
 
@@ -506,19 +562,167 @@ func (this *Behavior) ProcessInlineQuery(ctx *gogram.InlineCtx) {
 
 	var iqa data.OInlineQueryAnswer
 
+	// only the first page gets suggestions, otherwise they'd show up again every time more results are loaded.
+	// they're looked up while the search runs, rather than after it.
+	var suggestions <-chan []storage.TagCompletion
+	if ctx.Query.Offset == "" { suggestions = this.GetTagSuggestionsAsync(ctx.Bot, ctx.Query.Query) }
+
 	offset, err := proxify.Offset(ctx.Query.Offset)
-	if err == nil {
-		search_results, err := api.ListPosts(creds.User, creds.ApiKey, apitypes.ListPostOptions{SearchQuery: ctx.Query.Query + " " + force_rating, Page: apitypes.Page(offset + 1), Limit: q.resultsperpage})
-		errorlog.ErrorLog(ctx.Bot.ErrorLog, "api", "api.TagSearch", err)
+	if pool_id, rest := PoolQuery(ctx.Query.Query); err == nil && pool_id != 0 {
+		search_results, more, err := this.SearchPool(ctx.Bot, creds, pool_id, rest + " " + force_rating, offset, q.resultsperpage)
+		if err != nil {
+			outcome = "error"
+		}
+		iqa = this.ApiResultsToInlineResponse(ctx.Query.Query, blacklist, search_results, offset, err, q)
+		// filtering can leave a page short, so whether there's another one depends on the pool, not the results.
+		if more {
+			iqa.NextOffset = strconv.FormatInt(int64(offset + 1), 10)
+		} else {
+			iqa.NextOffset = ""
+		}
+	} else if err == nil {
+		search_results, err := this.SearchPosts(ctx.Bot, creds, ctx.Query.Query + " " + force_rating, offset, q.resultsperpage)
+		if err == ErrSearchTimeout {
+			outcome = "timeout"
+		} else if err != nil {
+			outcome = "error"
+		}
 		iqa = this.ApiResultsToInlineResponse(ctx.Query.Query, blacklist, search_results, offset, err, q)
 	} else {
+		outcome = "error"
 		errorlog.ErrorLog(ctx.Bot.ErrorLog, "proxify", "proxify.Offset", errors.New(fmt.Sprintf("Bad Offset: %s (%s)", ctx.Query.Offset, err.Error())))
 		iqa = this.ApiResultsToInlineResponse(ctx.Query.Query, blacklist, nil, 0, err, q)
 	}
 
+	if suggestions != nil {
+		select {
+		case completions := <-suggestions:
+			iqa.Results = append(TagSuggestionResults(ctx.Query.Query, completions, MAX_INLINE_RESULTS - len(iqa.Results)), iqa.Results...)
+		case <-time.After(INLINE_TAG_SUGGESTION_WAIT):
+			// don't hold up the results for them. they'll be cached in time for the next keystroke.
+		}
+	}
+
 	ctx.AnswerAsync(iqa, nil)
 }
 
+// the ratings a user's rating filter lets them see.
+func AllowedRatings(mode bottypes.RatingMode) apiextra.Ratings {
+	if mode == bottypes.FILTER_EXPLICIT {
+		return apiextra.Ratings{Safe: true, Questionable: true, Explicit: false}
+	} else if mode == bottypes.FILTER_QUESTIONABLE {
+		return apiextra.Ratings{Safe: true, Questionable: false, Explicit: false}
+	}
+	return apiextra.Ratings{Safe: true, Questionable: true, Explicit: true}
+}
+
+var ErrSearchTimeout error = errors.New("Timed out waiting for search results")
+
+// searches for posts, either through the api or the local post index depending on settings.
+// page is zero based, the same as the offsets handed out in inline query responses.
+func (this *Behavior) SearchPosts(bot *gogram.TelegramBot, creds storage.UserCreds, query string, page, limit int) (apitypes.TPostInfoArray, error) {
+	if this.MySettings.LocalSearch {
+		search_results, err := this.LocalSearchPosts(query, page, limit)
+		errorlog.ErrorLog(bot.ErrorLog, "storage", "storage.SearchPosts", err)
+		return search_results, err
+	}
+
+	type result struct {
+		posts apitypes.TPostInfoArray
+		err error
+	}
+
+	// buffered, so that the api call can finish and be discarded if we stop waiting for it.
+	results := make(chan result, 1)
+	go func() {
+		search_results, err := api.ListPosts(creds.User, creds.ApiKey, apitypes.ListPostOptions{SearchQuery: query, Page: apitypes.Page(page + 1), Limit: limit})
+		results <- result{search_results, err}
+	}()
+
+	var timeout <-chan time.Time
+	if this.MySettings.SearchTimeout > 0 {
+		timeout = time.After(time.Duration(this.MySettings.SearchTimeout) * time.Second)
+	}
+
+	var r result
+	select {
+	case r = <-results:
+	case <-timeout:
+		r.err = ErrSearchTimeout
+	}
+
+	errorlog.ErrorLog(bot.ErrorLog, "api", "api.TagSearch", r.err)
+	if r.err != nil && this.MySettings.LocalSearchFallback {
+		bot.Log.Println("Falling back to local search:", query)
+		search_results, err := this.LocalSearchPosts(query, page, limit)
+		errorlog.ErrorLog(bot.ErrorLog, "storage", "storage.SearchPosts", err)
+		if err == nil { return search_results, nil }
+	}
+
+	return r.posts, r.err
+}
+
+var poolToken = regexp.MustCompile(`^pool:(\d+)$`)
+
+// picks a pool:12345 token out of an inline query, returning the pool's id and the rest of the query.
+// the id is 0 if the query doesn't ask for a pool.
+func PoolQuery(query string) (int, string) {
+	var id int
+	var rest []string
+	for _, tok := range strings.Fields(query) {
+		if m := poolToken.FindStringSubmatch(strings.ToLower(tok)); m != nil && id == 0 {
+			id, _ = strconv.Atoi(m[1])
+		} else {
+			rest = append(rest, tok)
+		}
+	}
+	return id, strings.Join(rest, " ")
+}
+
+// fetches one page of a pool's posts, in the same order as they are in the pool, so that comics can be posted in order.
+// the rest of the query filters which of them are shown. more is true if the pool has posts past this page.
+// page is zero based, the same as in SearchPosts.
+func (this *Behavior) SearchPool(bot *gogram.TelegramBot, creds storage.UserCreds, pool_id int, query string, page, limit int) (apitypes.TPostInfoArray, bool, error) {
+	pool, err := api.FetchPool(creds.User, creds.ApiKey, pool_id)
+	errorlog.ErrorLog(bot.ErrorLog, "api", "api.FetchPool", err)
+	if pool == nil || err != nil { return nil, false, err }
+
+	start, end := page * limit, (page + 1) * limit
+	if start >= len(pool.PostIds) { return nil, false, nil }
+	if end > len(pool.PostIds) { end = len(pool.PostIds) }
+	ids := pool.PostIds[start:end]
+
+	var id_strings []string
+	for _, id := range ids { id_strings = append(id_strings, strconv.Itoa(id)) }
+	posts, err := api.ListPosts(creds.User, creds.ApiKey, apitypes.ListPostOptions{SearchQuery: fmt.Sprintf("id:%s %s", strings.Join(id_strings, ","), query), Limit: len(ids)})
+	errorlog.ErrorLog(bot.ErrorLog, "api", "api.ListPosts", err)
+	if err != nil { return nil, false, err }
+
+	// the site returns them newest first, so put them back in pool order.
+	byid := make(map[int]apitypes.TPostInfo)
+	for _, p := range posts { byid[p.Id] = p }
+	var out apitypes.TPostInfoArray
+	for _, id := range ids {
+		if p, ok := byid[id]; ok { out = append(out, p) }
+	}
+	return out, end < len(pool.PostIds), nil
+}
+
+func (this *Behavior) LocalSearchPosts(query string, page, limit int) (apitypes.TPostInfoArray, error) {
+	var search_results apitypes.TPostInfoArray
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		search_results, err = storage.SearchPosts(tx, storage.ParsePostSearch(query), page, limit)
+		return err
+	})
+
+	for i := range search_results {
+		api.FillPostURLs(&search_results[i])
+	}
+
+	return search_results, err
+}
+
 func (this *Behavior) ApiResultsToInlineResponse(query, blacklist string, search_results apitypes.TPostInfoArray, current_offset int, err error, q QuerySettings) data.OInlineQueryAnswer {
 	iqa := data.OInlineQueryAnswer{CacheTime: 30, IsPersonal: true, SwitchPMText: q.settingsbutton, SwitchPMParam: "settings"}
 	if err != nil {
@@ -552,6 +756,97 @@ func (this *Behavior) ApiResultsToInlineResponse(query, blacklist string, search
 	return iqa
 }
 
+// telegram won't accept more results than this in one answer.
+const MAX_INLINE_RESULTS = 50
+
+// how many completions are offered for a tag which doesn't exist.
+const INLINE_TAG_SUGGESTIONS = 5
+
+// gogram doesn't have article results, which are all tag suggestions need.
+type inlineArticle struct {
+	Type                string                          `json:"type"`
+	Id                  string                          `json:"id"`
+	Title               string                          `json:"title"`
+	Description         string                          `json:"description,omitempty"`
+	InputMessageContent *data.TInputMessageTextContent  `json:"input_message_content"`
+	ReplyMarkup         *data.TInlineKeyboard           `json:"reply_markup,omitempty"`
+}
+
+// how long inline results wait for tag suggestions, once the search itself is done.
+const INLINE_TAG_SUGGESTION_WAIT = 100 * time.Millisecond
+
+var tagSuggestions storage.TagSuggestionCache
+
+// splits the last tag off of a query, along with any prefix it has. last is empty if it's something which can't
+// be suggested for, like a metatag or a wildcard.
+func lastQueryTag(query string) (tokens []string, prefix, last string) {
+	tokens = strings.Split(query, " ")
+	last = tokens[len(tokens) - 1]
+	if strings.HasPrefix(last, "-") || strings.HasPrefix(last, "~") { prefix, last = last[:1], last[1:] }
+	if strings.ContainsAny(last, ":*") { last = "" }
+	return
+}
+
+// if the last tag in a query doesn't exist, this looks up what it might have been meant to be, in the background.
+// the channel receives nothing useful if there's nothing to suggest, and is closed afterwards.
+func (this *Behavior) GetTagSuggestionsAsync(bot *gogram.TelegramBot, query string) <-chan []storage.TagCompletion {
+	out := make(chan []storage.TagCompletion, 1)
+	_, _, last := lastQueryTag(query)
+	if last == "" {
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		completions, err := tagSuggestions.Get(last, func(typed string) ([]storage.TagCompletion, error) {
+			var completions []storage.TagCompletion
+			err := storage.DefaultTransact(func(tx storage.DBLike) error {
+				var err error
+				completions, err = storage.SuggestTags(tx, typed, INLINE_TAG_SUGGESTIONS)
+				return err
+			})
+			return completions, err
+		})
+		errorlog.ErrorLog(bot.ErrorLog, "storage", "storage.SuggestTags", err)
+		out <- completions
+	}()
+	return out
+}
+
+// turns suggestions for the last tag in a query into results, with a button which swaps each one into the query.
+func TagSuggestionResults(query string, completions []storage.TagCompletion, limit int) []interface{} {
+	if limit > INLINE_TAG_SUGGESTIONS { limit = INLINE_TAG_SUGGESTIONS }
+	if limit <= 0 { return nil }
+	if len(completions) > limit { completions = completions[:limit] }
+
+	tokens, prefix, _ := lastQueryTag(query)
+
+	var out []interface{}
+	for i, c := range completions {
+		tokens[len(tokens) - 1] = prefix + c.Tag
+		fixed := strings.Join(tokens, " ")
+
+		description := fmt.Sprintf("%d posts", c.Count)
+		if c.Name != c.Tag { description = fmt.Sprintf("%s (from the alias %s)", description, c.Name) }
+
+		out = append(out, inlineArticle{
+			Type: "article",
+			Id: fmt.Sprintf("suggest-%d", i),
+			Title: fmt.Sprintf("Did you mean %s?", c.Tag),
+			Description: description,
+			InputMessageContent: &data.TInputMessageTextContent{
+				MessageText: fmt.Sprintf("<code>%s</code>", html.EscapeString(fixed)),
+				ParseMode: data.ParseHTML,
+			},
+			ReplyMarkup: &data.TInlineKeyboard{Buttons: [][]data.TInlineKeyboardButton{{
+				data.TInlineKeyboardButton{Text: "Search for " + c.Tag, SwitchInlineHere: &fixed},
+			}}},
+		})
+	}
+	return out
+}
+
 func (this *Behavior) GetErrorPlaceholder() *data.TInlineQueryResultCachedPhoto {
 	if this.MySettings.ErrorPhotoID == "" { return nil }
 	return &data.TInlineQueryResultCachedPhoto{
@@ -597,5 +892,5 @@ func (this *Behavior) ProcessInlineQueryResult(ctx *gogram.InlineResultCtx) {
 		return
 	}
 
-	go proxify.HandleWebmConversionRequest(ctx, this.MySettings.DefaultSearchCredentials())
+	go proxify.HandleConversionRequest(ctx, this.MySettings.DefaultSearchCredentials())
 }
@@ -0,0 +1,47 @@
+package botbehavior
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/dtext"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"bytes"
+	"fmt"
+	"html"
+)
+
+// how many of a post's comments are shown at once, newest first, and how much of each one.
+const COMMENTS_SHOWN = 5
+const COMMENT_EXCERPT_LENGTH = 500
+
+// fetches the latest comments on a post, formatted as a telegram message.
+func (this *Behavior) PostComments(creds storage.UserCreds, post_id int) (string, error) {
+	comments, err := api.ListComments(creds.User, creds.ApiKey, apitypes.ListCommentOptions{PostId: post_id, Limit: COMMENTS_SHOWN})
+	if err != nil { return "", err }
+	return CommentsText(post_id, comments), nil
+}
+
+// comments come newest first, but they read better the other way around.
+func CommentsText(post_id int, comments apitypes.TCommentInfoArray) string {
+	var b bytes.Buffer
+	post := fmt.Sprintf("<a href=\"https://%s/posts/%d\">post #%d</a>", api.Endpoint, post_id, post_id)
+
+	shown := 0
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if c.IsHidden { continue }
+		if shown == 0 { b.WriteString(fmt.Sprintf("<b>Latest comments on %s:</b>\n", post)) }
+		shown++
+
+		date := c.CreatedAt
+		if len(date) > 10 { date = date[:10] }
+		b.WriteString(fmt.Sprintf("\n<b>%s</b> (%s, score %d):\n", html.EscapeString(c.CreatorName), html.EscapeString(date), c.Score))
+		b.WriteString(dtext.ToHTML(dtext.Excerpt(c.Body, COMMENT_EXCERPT_LENGTH)))
+		b.WriteString("\n")
+	}
+
+	if shown == 0 { b.WriteString(fmt.Sprintf("Nobody has commented on %s yet.\n", post)) }
+	b.WriteString(fmt.Sprintf("\nTo add a comment, use <code>/comment %d [your comment]</code>.", post_id))
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package botbehavior
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"errors"
+	"fmt"
+	"html"
+	"time"
+)
+
+// the most credentials checked each time the health check runs, so the site only sees a trickle of logins.
+const CREDENTIAL_CHECK_BATCH = 10
+
+// how long to wait between checking one credential and the next.
+const credentialCheckDelay = 2 * time.Second
+
+// re-validates the api keys which haven't been checked in a while, refreshing their blacklists. keys which
+// the site no longer accepts are marked invalid, and their owners are told how to log in again.
+func (this *Behavior) CheckCredentials(bot *gogram.TelegramBot) error {
+	var due []data.UserID
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		due, err = storage.GetCredsDueForValidation(tx, time.Now().Add(-time.Duration(this.MySettings.CredentialCheckInterval) * time.Second), CREDENTIAL_CHECK_BATCH)
+		return err
+	})
+	if err != nil { return err }
+
+	for i, user := range due {
+		if i != 0 { time.Sleep(credentialCheckDelay) }
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return this.checkCredential(tx, bot, user) })
+		if err == nil { continue }
+
+		if errors.Is(err, storage.ErrUndecryptable) {
+			bot.ErrorLog.Printf("Stored API key for %d can't be decrypted, and won't be until its key version is configured again: %s\n", user, err.Error())
+		} else {
+			bot.ErrorLog.Printf("Error checking credentials for %d: %s\n", user, err.Error())
+		}
+
+		// whatever went wrong, try the others first next time, otherwise a full batch of failures would be retried forever.
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.PostponeCredentialCheck(tx, user, time.Now()) })
+		if err != nil { bot.ErrorLog.Printf("Couldn't postpone checking credentials for %d: %s\n", user, err.Error()) }
+	}
+
+	return nil
+}
+
+func (this *Behavior) checkCredential(tx storage.DBLike, bot *gogram.TelegramBot, user data.UserID) error {
+	creds, err := storage.GetUserCreds(tx, user)
+	if err == storage.ErrNoLogin { return nil }
+	if err != nil { return err }
+
+	success, err := RefreshCreds(bot, &creds)
+	if err != nil {
+		// the site being down says nothing about the key, so leave it to be tried again later.
+		return fmt.Errorf("RefreshCreds: %w", err)
+	} else if !success {
+		return this.CredentialsRevoked(tx, bot, creds)
+	}
+
+	return storage.WriteUserCreds(tx, creds)
+}
+
+// logs in to the site with a user's credentials, and if they still work, refreshes what's kept from their account:
+// their blacklist, with its user:name tags resolved, and when they were last checked. returns whether the site accepted
+// them. the credentials aren't saved, that's up to the caller.
+func RefreshCreds(bot *gogram.TelegramBot, creds *storage.UserCreds) (bool, error) {
+	info, success, err := api.TestLogin(creds.User, creds.ApiKey)
+	if err != nil || !success { return success, err }
+
+	blacklist, _, err := api.ResolveBlacklistUsers(info.Blacklist)
+	if err != nil { bot.ErrorLog.Printf("Couldn't resolve blacklist users for %d: %s\n", creds.TelegramId, err.Error()) }
+
+	now := time.Now()
+	creds.Blacklist, creds.BlacklistFetched = blacklist, now
+	creds.Validated, creds.Invalid = now, false
+	return true, nil
+}
+
+// marks a user's credentials as invalid and lets them know. this only happens once, since invalid credentials
+// aren't checked again until the user logs in again.
+func (this *Behavior) CredentialsRevoked(tx storage.DBLike, bot *gogram.TelegramBot, creds storage.UserCreds) error {
+	if creds.Invalid { return nil }
+
+	creds.Invalid, creds.Validated = true, time.Now()
+	if err := storage.WriteUserCreds(tx, creds); err != nil { return err }
+
+	message := data.OMessage{
+		SendData: data.SendData{
+			TargetData: data.TargetData{ChatId: data.ChatID(creds.TelegramId)},
+			Text: CredentialsRevokedText(creds.User),
+			ParseMode: data.ParseHTML,
+		},
+		DisableWebPagePreview: true,
+	}
+
+	// a user who has blocked the bot can't be told, but their credentials are still invalid.
+	if _, err := bot.Remote.SendMessage(message); err != nil {
+		bot.ErrorLog.Printf("Couldn't notify %d about revoked credentials: %s\n", creds.TelegramId, err.Error())
+	}
+	return nil
+}
+
+func CredentialsRevokedText(user string) string {
+	return fmt.Sprintf("<b>Your %s API key stopped working.</b>\n\nI couldn't log in as <code>%s</code> with the API key you gave me, so it was probably revoked or changed. Until you log in again, voting, favoriting, posting and editing won't work, and I can't keep your %s blacklist up to date.\n\nTo log in again, get your current API key from your <a href=\"https://%s/users/home\">account settings</a> under \"Manage API Access\", and use /login.", api.ApiName, html.EscapeString(user), api.ApiName, api.Endpoint)
+}
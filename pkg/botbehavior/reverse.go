@@ -0,0 +1,94 @@
+package botbehavior
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	"github.com/thewug/fsb/pkg/imagehash"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"bytes"
+	"fmt"
+)
+
+// how many posts a reverse image search shows.
+const REVERSE_SEARCH_RESULTS = 5
+
+// finds the picture in a message, if it has one: the biggest size of a photo, or a document which is an image.
+func MessageImage(msg *data.TMessage) (data.FileID, bool) {
+	if msg.Photo != nil && len(*msg.Photo) != 0 {
+		photos := *msg.Photo
+		return photos[len(photos) - 1].Id, true
+	}
+
+	if msg.Document != nil && msg.Document.MimeType != nil {
+		switch *msg.Document.MimeType {
+		case "image/jpeg", "image/png", "image/gif":
+			return msg.Document.Id, true
+		}
+	}
+
+	return "", false
+}
+
+// looks up pictures sent in private, outside of any command or prompt, to see if they've been posted already.
+// this stands in for the state machine's default state, so it only sees messages nothing else is waiting for,
+// and passes everything which isn't a picture along to the state machine.
+type ReverseSearchState struct {
+	gogram.StateBase
+	Behavior *Behavior
+}
+
+func (this *ReverseSearchState) Handle(ctx *gogram.MessageCtx) {
+	if ctx.Msg.Chat.Type == data.Private && len(ctx.Cmd.Command) == 0 {
+		if file, ok := MessageImage(ctx.Msg); ok {
+			// downloading and hashing the picture takes a while, so don't hold up other messages for it.
+			go this.Behavior.ReverseSearch(ctx, file)
+			return
+		}
+	}
+
+	this.StateMachine.Handle(ctx)
+}
+
+func (this *ReverseSearchState) HandleCallback(ctx *gogram.CallbackCtx) {
+	this.StateMachine.HandleCallback(ctx)
+}
+
+// looks for indexed posts which look like a picture someone sent, and replies with them.
+func (this *Behavior) ReverseSearch(ctx *gogram.MessageCtx, file data.FileID) {
+	hash, err := imagehash.FromTelegram(ctx.Bot, file)
+	if err == imagehash.ErrUnsupported {
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "I can only search for jpg, png and gif images."}}, nil)
+		return
+	} else if err != nil {
+		ctx.Bot.ErrorLog.Println("Error hashing image: ", err.Error())
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "I couldn't download that, try sending it again?"}}, nil)
+		return
+	}
+
+	matches, err := storage.SimilarPosts(storage.DefaultNoTx(), hash, imagehash.POSSIBLE_MATCH, REVERSE_SEARCH_RESULTS)
+	if err != nil {
+		ctx.Bot.ErrorLog.Println("Error finding similar posts: ", err.Error())
+		ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: "Something went wrong while searching, try again later."}}, nil)
+		return
+	}
+
+	ctx.ReplyAsync(data.OMessage{SendData: data.SendData{Text: ReverseSearchText(matches), ParseMode: data.ParseHTML}, DisableWebPagePreview: true}, nil)
+}
+
+func ReverseSearchText(matches []storage.ImageMatch) string {
+	if len(matches) == 0 {
+		return "I couldn't find any posts which look like this. (I only know about posts I've indexed, so it might still be on " + api.ApiName + ".)"
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<b>Posts which look like this:</b>\n")
+	for _, m := range matches {
+		likely := ""
+		if m.Distance <= imagehash.LIKELY_MATCH { likely = ", probably the same picture" }
+		b.WriteString(fmt.Sprintf("<a href=\"https://%s/posts/%d\">Post #%d</a> (%d%% similar%s)\n", api.Endpoint, m.PostId, m.PostId, imagehash.Similarity(m.Distance), likely))
+	}
+	return b.String()
+}
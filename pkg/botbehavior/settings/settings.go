@@ -7,14 +7,18 @@ import (
 
 	"github.com/thewug/fsb/pkg/api"
 	"github.com/thewug/fsb/pkg/apiextra"
+	"github.com/thewug/fsb/pkg/fsb/metrics"
 	"github.com/thewug/fsb/pkg/fsb/proxify"
 	"github.com/thewug/fsb/pkg/fsb/proxify/webm"
 
 	"github.com/thewug/gogram"
 	"github.com/thewug/gogram/data"
 
+	"encoding/base64"
 	"encoding/json"
 
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"time"
@@ -24,7 +28,10 @@ const MAX_RESULTS_PER_PAGE = 50
 const MAX_ARTISTS = 10
 const MAX_CHARS = 10
 const MAX_SOURCES = 10
+const MAX_DESCRIPTION_LENGTH = 300
 const MAINTENANCE_SYNC_DEFAULT = 60
+const SUBSCRIPTION_DIGEST_DEFAULT = 60 * 60
+const CREDENTIAL_CHECK_DEFAULT = 24 * 60 * 60
 
 type Settings struct {
 	gogram.InitSettings
@@ -38,6 +45,7 @@ type Settings struct {
 	ApiEndpoint         string `json:"api_endpoint"`
 	ApiFilteredEndpoint string `json:"api_filtered_endpoint"`
 	ApiStaticPrefix     string `json:"api_static_prefix"`
+	ApiBackend          string `json:"api_backend"`
 
 	Owner   data.UserID `json:"owner"`
 	Home    data.ChatID `json:"home"`
@@ -47,15 +55,27 @@ type Settings struct {
 	SearchUser   string `json:"search_user"`
 	SearchAPIKey string `json:"search_apikey"`
 
+	CredentialKeys       map[int]string `json:"credential_keys"`
+	CredentialKeyFile    string         `json:"credential_key_file"`
+	CredentialKeyVersion int            `json:"credential_key_version"`
+
+	LocalSearch         bool `json:"local_search"`
+	LocalSearchFallback bool `json:"local_search_fallback"`
+	SearchTimeout       int  `json:"search_timeout"`
+
 	NoResultsPhotoID   data.FileID `json:"no_results_photo_id"`
 	BlacklistedPhotoID data.FileID `json:"blacklisted_photo_id"`
 	ErrorPhotoID       data.FileID `json:"error_photo_id"`
 
 	MediaConvertDirectory string      `json:"media_convert_directory"`
-	Webm2Mp4ConvertScript string      `json:"webm2mp4_convert_script"`
+	WebmProfile           webm.Profile `json:"webm_profile"`
 	MediaStoreChannel     data.ChatID `json:"media_store_channel"`
+	WebmConvertWorkers    int         `json:"webm_convert_workers"`
 	MaintenanceSyncInterval int       `json:"maintenance_sync_interval"`
 	DebugMediaReceived      bool      `json:"debug_media_received"`
+	SubscriptionDigestInterval int    `json:"subscription_digest_interval"`
+	CredentialCheckInterval int       `json:"credential_check_interval"`
+	MetricsListen           string    `json:"metrics_listen"`
 
 	SourceMap        json.RawMessage `json:"source_map"`
 
@@ -82,6 +102,10 @@ func (s Settings) GetApiStaticPrefix() string {
 	return s.ApiStaticPrefix
 }
 
+func (s Settings) GetApiBackend() string {
+	return s.ApiBackend
+}
+
 func (s Settings) GetMediaConvertDirectory() string {
 	return s.MediaConvertDirectory
 }
@@ -90,8 +114,12 @@ func (s Settings) GetMediaStoreChannel() data.ChatID {
 	return s.MediaStoreChannel
 }
 
-func (s Settings) GetWebm2Mp4ConvertScript() string {
-	return s.Webm2Mp4ConvertScript
+func (s Settings) GetWebmProfile() webm.Profile {
+	return s.WebmProfile
+}
+
+func (s Settings) GetWebmConvertWorkers() int {
+	return s.WebmConvertWorkers
 }
 
 func (this *Settings) RedirectLogs(bot *gogram.TelegramBot) (error) {
@@ -107,12 +135,48 @@ func (this *Settings) RedirectLogs(bot *gogram.TelegramBot) (error) {
 	return nil
 }
 
+// LoadCredentialKeys sets up the keys stored api keys are encrypted with. keys come from credential_keys and from
+// credential_key_file, which holds a json object in the same format, and new credentials are encrypted with the
+// key numbered credential_key_version, or the highest numbered one if that isn't set.
+func (this *Settings) LoadCredentialKeys() (error) {
+	encoded := make(map[int]string)
+	for version, key := range this.CredentialKeys { encoded[version] = key }
+
+	if this.CredentialKeyFile != "" {
+		b, err := ioutil.ReadFile(this.CredentialKeyFile)
+		if err != nil { return fmt.Errorf("reading credential key file: %w", err) }
+
+		var from_file map[int]string
+		if err = json.Unmarshal(b, &from_file); err != nil { return fmt.Errorf("reading credential key file: %w", err) }
+		for version, key := range from_file { encoded[version] = key }
+	}
+
+	keys := make(map[int][]byte)
+	current := this.CredentialKeyVersion
+	for version, key := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil { return fmt.Errorf("credential key version %d: %w", version, err) }
+		keys[version] = raw
+		if this.CredentialKeyVersion == 0 && version > current { current = version }
+	}
+
+	k, err := storage.NewCredentialKeys(keys, current)
+	if err != nil { return err }
+	if current == storage.PLAINTEXT_KEY_VERSION { log.Println("[settings] No credential keys are configured, api keys will be stored unencrypted!") }
+
+	storage.SetCredentialKeys(k)
+	return nil
+}
+
 func (this *Settings) InitializeAll(bot *gogram.TelegramBot) (error) {
 	if this.ResultsPerPage < 1 || this.ResultsPerPage > MAX_RESULTS_PER_PAGE { this.ResultsPerPage = MAX_RESULTS_PER_PAGE }
 	if this.MaxArtists < 1 || this.MaxArtists > MAX_ARTISTS { this.MaxArtists = MAX_ARTISTS }
 	if this.MaxChars < 1 || this.MaxChars > MAX_CHARS { this.MaxChars = MAX_CHARS }
 	if this.MaxSources < 1 || this.MaxSources > MAX_SOURCES { this.MaxSources = MAX_SOURCES }
+	if this.DescriptionLength < 0 || this.DescriptionLength > MAX_DESCRIPTION_LENGTH { this.DescriptionLength = MAX_DESCRIPTION_LENGTH }
 	if this.MaintenanceSyncInterval <= 60 { this.MaintenanceSyncInterval = MAINTENANCE_SYNC_DEFAULT }
+	if this.SubscriptionDigestInterval <= 0 { this.SubscriptionDigestInterval = SUBSCRIPTION_DIGEST_DEFAULT }
+	if this.CredentialCheckInterval <= 0 { this.CredentialCheckInterval = CREDENTIAL_CHECK_DEFAULT }
 
 	e := this.RedirectLogs(bot)
 	if e != nil { return e }
@@ -128,6 +192,15 @@ func (this *Settings) InitializeAll(bot *gogram.TelegramBot) (error) {
 
 	e = storage.DBInit(this.DbUrl)
 	if e != nil { return e }
+	storage.SetOwner(this.Owner)
+
+	e = this.LoadCredentialKeys()
+	if e != nil { return e }
+
+	if this.MetricsListen != "" {
+		e = metrics.Serve(this.MetricsListen)
+		if e != nil { return e }
+	}
 
 	bot.Remote.SetAPIKey(this.ApiKey)
 	e = bot.Remote.Test()
@@ -138,6 +211,19 @@ func (this *Settings) InitializeAll(bot *gogram.TelegramBot) (error) {
 	return e
 }
 
+// InitializeStandalone sets up just enough to use the API and the database, for tools which run outside of
+// telegram and don't have a bot.
+func (this *Settings) InitializeStandalone() (error) {
+	e := api.Init(this)
+	if e != nil { return e }
+
+	e = storage.DBInit(this.DbUrl)
+	if e != nil { return e }
+	storage.SetOwner(this.Owner)
+
+	return this.LoadCredentialKeys()
+}
+
 func (this *Settings) DefaultSearchCredentials() (storage.UserCreds) {
 	return storage.UserCreds{
 		User: this.SearchUser,
@@ -1,7 +1,8 @@
 package types
 
 type CaptionSettings struct {
-	MaxArtists int `json:"max_artists"`
-	MaxChars   int `json:"max_chars"`
-	MaxSources int `json:"max_sources"`
+	MaxArtists        int `json:"max_artists"`
+	MaxChars          int `json:"max_chars"`
+	MaxSources        int `json:"max_sources"`
+	DescriptionLength int `json:"description_length"` // 0 leaves descriptions out of captions.
 }
@@ -0,0 +1,148 @@
+package botbehavior
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/storage"
+
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"bytes"
+	"fmt"
+	"html"
+	"time"
+)
+
+// the most posts a single digest will show. anything past that is counted, but not shown.
+const SUBSCRIPTION_DIGEST_SIZE = 10
+
+// the most pending posts looked at for a single digest. whatever is left over is sent next time.
+const subscriptionBacklog = 1000
+
+// queues up posts from a sync which match someone's saved searches. only posts newer than a subscription are
+// considered for it, so that edits to old posts don't show up as new ones.
+func (this *Behavior) QueueSubscriptionMatches(tx storage.DBLike, posts map[int]apitypes.TPostInfo) error {
+	if len(posts) == 0 { return nil }
+
+	subs, err := storage.GetSubscriptions(tx, 0)
+	if err != nil { return err }
+
+	for _, sub := range subs {
+		for id, post := range posts {
+			if id <= sub.AfterPostId || !post.MatchesQuery(sub.Query) { continue }
+			err = storage.QueueSubscriptionMatch(tx, sub, id)
+			if err != nil { return err }
+		}
+	}
+
+	return nil
+}
+
+// sends a digest of new posts to everyone who has some waiting, unless they've already had one recently.
+func (this *Behavior) SendSubscriptionDigests(bot *gogram.TelegramBot) error {
+	var due []data.UserID
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		due, err = storage.GetSubscriptionDigestsDue(tx, time.Now().Add(-time.Duration(this.MySettings.SubscriptionDigestInterval) * time.Second))
+		return err
+	})
+	if err != nil { return err }
+
+	for _, user := range due {
+		err = storage.DefaultTransact(func(tx storage.DBLike) error { return this.sendSubscriptionDigest(tx, bot, user) })
+		if err != nil { bot.ErrorLog.Printf("Error sending subscription digest to %d: %s\n", user, err.Error()) }
+		time.Sleep(time.Second) // avoid rate limiting in telegram message sending
+	}
+
+	return nil
+}
+
+// the rating filter and blacklist are checked here rather than when posts are queued, so that
+// they always reflect the user's current settings.
+func (this *Behavior) sendSubscriptionDigest(tx storage.DBLike, bot *gogram.TelegramBot, user data.UserID) error {
+	settings, err := storage.GetUserSettings(tx, user)
+	if err != nil { return err }
+
+	site_blacklist := api.DefaultBlacklist
+	creds, err := storage.GetUserCreds(tx, user)
+	if err == nil {
+		site_blacklist = creds.Blacklist
+	} else if err != storage.ErrNoLogin {
+		return err
+	}
+
+	blacklist := settings.EffectiveBlacklist(site_blacklist)
+	allowed_ratings := AllowedRatings(settings.RatingMode)
+
+	matches, err := storage.GetPendingSubscriptionMatches(tx, user, subscriptionBacklog)
+	if err != nil { return err }
+
+	var ids []int
+	for _, m := range matches { ids = append(ids, m.PostId) }
+
+	posts, err := storage.PostsById(tx, ids)
+	if err != nil { return err }
+
+	by_id := make(map[int]*apitypes.TPostInfo)
+	for i := range posts { by_id[posts[i].Id] = &posts[i] }
+
+	var shown []storage.SubscriptionMatch
+	var extra int
+	for _, m := range matches {
+		post := by_id[m.PostId]
+		if post == nil || post.Deleted || !allowed_ratings.Allows(post.Rating) || post.MatchesBlacklist(blacklist) { continue }
+		if len(shown) < SUBSCRIPTION_DIGEST_SIZE {
+			shown = append(shown, m)
+		} else {
+			extra++
+		}
+	}
+
+	// everything looked at is done with, whether it was shown or not.
+	err = storage.MarkSubscriptionMatchesSent(tx, user, ids)
+	if err != nil { return err }
+
+	if len(shown) == 0 { return nil }
+
+	message := data.OMessage{
+		SendData: data.SendData{
+			TargetData: data.TargetData{ChatId: data.ChatID(user)},
+			Text: SubscriptionDigestText(shown, extra),
+			ParseMode: data.ParseHTML,
+			ReplyMarkup: SubscriptionDigestKeyboard(shown),
+		},
+		DisableWebPagePreview: true,
+	}
+
+	// a user who has blocked the bot will never get their digest, so don't hold up everyone else's over it.
+	if _, err = bot.Remote.SendMessage(message); err != nil {
+		bot.ErrorLog.Printf("Couldn't send subscription digest to %d: %s\n", user, err.Error())
+	}
+
+	return storage.SetSubscriptionDigestSent(tx, user, time.Now())
+}
+
+func SubscriptionDigestText(shown []storage.SubscriptionMatch, extra int) string {
+	var b bytes.Buffer
+	b.WriteString("<b>New posts matching your saved searches:</b>\n")
+	for _, m := range shown {
+		b.WriteString(fmt.Sprintf("<a href=\"https://%s/posts/%d\">#%d</a> <code>%s</code>\n", api.Endpoint, m.PostId, m.PostId, html.EscapeString(m.Query)))
+	}
+	if extra != 0 {
+		b.WriteString(fmt.Sprintf("\n...and %d more. If that's too many, try narrowing your searches down (see /subscriptions).", extra))
+	}
+	return b.String()
+}
+
+func SubscriptionDigestKeyboard(shown []storage.SubscriptionMatch) *data.TInlineKeyboard {
+	var keyboard data.TInlineKeyboard
+	for _, m := range shown {
+		keyboard.Buttons = append(keyboard.Buttons, []data.TInlineKeyboardButton{
+			data.TInlineKeyboardButton{Text: fmt.Sprintf("#%d \U0001F44D", m.PostId), Data: sptr(fmt.Sprintf("/upvote %d", m.PostId))},
+			data.TInlineKeyboardButton{Text: "\U0001F44E", Data: sptr(fmt.Sprintf("/downvote %d", m.PostId))},
+			data.TInlineKeyboardButton{Text: "\u2764\uFE0F", Data: sptr(fmt.Sprintf("/favorite %d", m.PostId))},
+		})
+	}
+	return &keyboard
+}
@@ -0,0 +1,142 @@
+// Package dtext converts the site's DText markup into the html telegram understands, and telegram's message
+// formatting back into DText.
+package dtext
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// dtext tags which have a telegram equivalent.
+var formatTags = map[string]string{
+	"b":       "b",
+	"i":       "i",
+	"u":       "u",
+	"s":       "s",
+	"quote":   "blockquote",
+	"spoiler": "tg-spoiler",
+}
+
+// one match of this is one piece of markup: a [nodtext] block, a format tag, a code block, inline code, a "link":url,
+// a [[wiki link]], a {{search link}}, or a post #123 reference. everything between matches is plain text.
+var markup = regexp.MustCompile(`(?i)` +
+	`\[nodtext\](?P<nodtext>(?s:.*?))\[/nodtext\]` +
+	`|\[(?P<close>/?)(?P<tag>b|i|u|s|quote|spoiler)\]` +
+	`|\[code\](?P<code>(?s:.*?))\[/code\]` +
+	"|`(?P<inline>[^`\\n]+)`" +
+	`|"(?P<text>[^"\n]+)":(?P<url>https?://[^\s<>"\[\]]+|/[^\s<>"\[\]]*)` +
+	`|\[\[(?P<wiki>[^\]|\n]+)(?:\|(?P<wikitext>[^\]\n]+))?\]\]` +
+	`|\{\{(?P<search>[^}|\n]+)(?:\|(?P<searchtext>[^}\n]+))?\}\}` +
+	`|\bpost #(?P<post>\d+)`)
+
+// absolute makes links to pages on the site into full urls.
+func absolute(url string) string {
+	if strings.HasPrefix(url, "/") { return fmt.Sprintf("https://%s%s", api.Endpoint, url) }
+	return url
+}
+
+func link(url, text string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+}
+
+// ToHTML renders DText as telegram html. markup which isn't supported, or which doesn't make sense, such as a closing
+// tag with nothing to close, is left as text. unclosed tags are closed at the end, so the output is always valid.
+func ToHTML(text string) string {
+	var b bytes.Buffer
+	var open []string
+
+	close := func(n int) {
+		for len(open) > n {
+			b.WriteString("</" + formatTags[open[len(open) - 1]] + ">")
+			open = open[:len(open) - 1]
+		}
+	}
+
+	last := 0
+	for _, m := range markup.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		last = m[1]
+		whole := text[m[0]:m[1]]
+
+		group := func(name string) (string, bool) {
+			i := markup.SubexpIndex(name)
+			if m[2 * i] == -1 { return "", false }
+			return text[m[2 * i]:m[2 * i + 1]], true
+		}
+
+		if literal, ok := group("nodtext"); ok {
+			b.WriteString(html.EscapeString(literal))
+		} else if tag, ok := group("tag"); ok {
+			tag = strings.ToLower(tag)
+			if slash, _ := group("close"); slash == "" {
+				open = append(open, tag)
+				b.WriteString("<" + formatTags[tag] + ">")
+				continue
+			}
+
+			found := -1
+			for i := len(open) - 1; i >= 0 && found == -1; i-- {
+				if open[i] == tag { found = i }
+			}
+			if found == -1 {
+				b.WriteString(html.EscapeString(whole))
+			} else {
+				close(found)
+			}
+		} else if code, ok := group("code"); ok {
+			b.WriteString("<pre>" + html.EscapeString(strings.Trim(code, "\n")) + "</pre>")
+		} else if code, ok := group("inline"); ok {
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		} else if label, ok := group("text"); ok {
+			u, _ := group("url")
+			b.WriteString(link(absolute(u), label))
+		} else if page, ok := group("wiki"); ok {
+			title := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(page)), " ", "_")
+			label, ok := group("wikitext")
+			if !ok { label = page }
+			b.WriteString(link(fmt.Sprintf("https://%s/wiki_pages/show_or_new?title=%s", api.Endpoint, url.QueryEscape(title)), label))
+		} else if search, ok := group("search"); ok {
+			label, ok := group("searchtext")
+			if !ok { label = search }
+			b.WriteString(link(fmt.Sprintf("https://%s/posts?tags=%s", api.Endpoint, url.QueryEscape(strings.TrimSpace(search))), label))
+		} else if id, ok := group("post"); ok {
+			b.WriteString(link(fmt.Sprintf("https://%s/posts/%s", api.Endpoint, id), whole))
+		}
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	close(0)
+
+	return b.String()
+}
+
+// Escape protects anything in text which DText would otherwise take as markup, so that it shows up as it is.
+// post references are left alone, since they mean the same thing either way.
+func Escape(text string) string {
+	return markup.ReplaceAllStringFunc(text, func(m string) string {
+		lower := strings.ToLower(m)
+		if strings.HasPrefix(lower, "post #") || strings.Contains(lower, "[/nodtext]") { return m }
+		return "[nodtext]" + m + "[/nodtext]"
+	})
+}
+
+// Excerpt shortens DText to at most n characters, cutting at a space if there's one nearby, and marking the cut
+// with an ellipsis. do this before ToHTML, which will close any tags left open.
+func Excerpt(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n { return text }
+
+	cut := n
+	for i := n; i > n * 3 / 4; i-- {
+		if runes[i] == ' ' || runes[i] == '\n' {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " \n") + "…"
+}
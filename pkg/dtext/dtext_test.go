@@ -0,0 +1,62 @@
+package dtext
+
+import (
+	"github.com/thewug/fsb/pkg/api"
+
+	"testing"
+)
+
+func Test_ToHTML(t *testing.T) {
+	api.Endpoint = "example.com"
+
+	testcases := map[string]struct{
+		dtext    string
+		expected string
+	}{
+		"plain": {"just some text", "just some text"},
+		"escaped": {"<b>not bold</b> & stuff", "&lt;b&gt;not bold&lt;/b&gt; &amp; stuff"},
+		"formatting": {"[b]bold[/b] [I]italic[/I] [u]under[/u] [s]struck[/s]", "<b>bold</b> <i>italic</i> <u>under</u> <s>struck</s>"},
+		"nested": {"[b]bold [i]both[/i][/b]", "<b>bold <i>both</i></b>"},
+		"misnested": {"[b]bold [i]both[/b] italic?[/i]", "<b>bold <i>both</i></b> italic?[/i]"},
+		"unclosed": {"[b]bold [i]forever", "<b>bold <i>forever</i></b>"},
+		"stray close": {"[/b]nothing", "[/b]nothing"},
+		"unknown": {"[table]x[/table]", "[table]x[/table]"},
+		"quote": {"[quote]someone said:\nhi[/quote]\nhello", "<blockquote>someone said:\nhi</blockquote>\nhello"},
+		"link": {`see "my gallery":https://example.org/a?b=1&c=2 please`, `see <a href="https://example.org/a?b=1&amp;c=2">my gallery</a> please`},
+		"site link": {`"rules":/wiki_pages/rules`, `<a href="https://example.com/wiki_pages/rules">rules</a>`},
+		"post": {"dupe of post #123.", `dupe of <a href="https://example.com/posts/123">post #123</a>.`},
+		"spoiler": {"it was [spoiler]the butler[/spoiler]", "it was <tg-spoiler>the butler</tg-spoiler>"},
+		"code": {"[code]\n[b]x[/b] < y\n[/code]", "<pre>[b]x[/b] &lt; y</pre>"},
+		"inline code": {"type `[b]` for bold", "type <code>[b]</code> for bold"},
+		"wiki": {"see [[Tagging Checklist]]", `see <a href="https://example.com/wiki_pages/show_or_new?title=tagging_checklist">Tagging Checklist</a>`},
+		"wiki text": {"[[e621:rules|the rules]]", `<a href="https://example.com/wiki_pages/show_or_new?title=e621%3Arules">the rules</a>`},
+		"search": {"more {{wolf rating:s}}", `more <a href="https://example.com/posts?tags=wolf+rating%3As">wolf rating:s</a>`},
+		"nodtext": {"[nodtext][b]not bold[/b][/nodtext] & [b]bold[/b]", "[b]not bold[/b] &amp; <b>bold</b>"},
+		"search text": {"{{fox|foxes}}", `<a href="https://example.com/posts?tags=fox">foxes</a>`},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := ToHTML(v.dtext); out != v.expected { t.Errorf("\nExpected: %s\nActual:   %s\n", v.expected, out) }
+		})
+	}
+}
+
+func Test_Excerpt(t *testing.T) {
+	testcases := map[string]struct{
+		text     string
+		n        int
+		expected string
+	}{
+		"short": {"hello", 10, "hello"},
+		"word": {"hello there world", 13, "hello there…"},
+		"no space": {"abcdefghij", 5, "abcde…"},
+		"unicode": {"ééééééé", 3, "ééé…"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := Excerpt(v.text, v.n); out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package dtext
+
+import (
+	"github.com/thewug/gogram/data"
+
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// telegram entity types which aren't in gogram's list yet.
+const (
+	spoilerEntity    data.EntityType = "spoiler"
+	blockquoteEntity data.EntityType = "blockquote"
+)
+
+// the DText which surrounds text with each kind of telegram formatting. entities which aren't listed here, like
+// mentions, hashtags and bare urls, are already plain text and are left alone.
+func entityMarkup(e data.TMessageEntity) (string, string) {
+	switch e.Type {
+	case data.Bold:
+		return "[b]", "[/b]"
+	case data.Italic:
+		return "[i]", "[/i]"
+	case data.Underline:
+		return "[u]", "[/u]"
+	case data.Strike:
+		return "[s]", "[/s]"
+	case spoilerEntity:
+		return "[spoiler]", "[/spoiler]"
+	case blockquoteEntity:
+		return "[quote]", "[/quote]"
+	case data.Code:
+		return "`", "`"
+	case data.Pre:
+		return "[code]", "[/code]"
+	case data.TextLink:
+		if e.Url != nil { return `"`, `":` + *e.Url }
+	}
+	return "", ""
+}
+
+// FromTelegram converts telegram formatted text into DText. entity offsets and lengths are in UTF-16 code units,
+// as telegram sends them. telegram doesn't allow entities to partially overlap, so they always nest cleanly.
+// anything in the text which looks like DText is escaped, except inside code, where DText is shown as it is anyway.
+func FromTelegram(text string, entities []data.TMessageEntity) string {
+	sorted := append([]data.TMessageEntity(nil), entities...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset { return sorted[i].Offset < sorted[j].Offset }
+		return sorted[i].Length > sorted[j].Length
+	})
+
+	var b bytes.Buffer
+	var plain strings.Builder
+	var open []data.TMessageEntity
+	next, code := 0, 0
+
+	flush := func() {
+		if code == 0 {
+			b.WriteString(Escape(plain.String()))
+		} else {
+			b.WriteString(plain.String())
+		}
+		plain.Reset()
+	}
+
+	closeUntil := func(pos int) {
+		for len(open) != 0 && open[len(open) - 1].Offset + open[len(open) - 1].Length <= pos {
+			flush()
+			e := open[len(open) - 1]
+			_, end := entityMarkup(e)
+			b.WriteString(end)
+			if e.Type == data.Code || e.Type == data.Pre { code-- }
+			open = open[:len(open) - 1]
+		}
+	}
+
+	pos := 0
+	for _, r := range text {
+		closeUntil(pos)
+		for next < len(sorted) && sorted[next].Offset <= pos {
+			flush()
+			e := sorted[next]
+			start, _ := entityMarkup(e)
+			b.WriteString(start)
+			if e.Type == data.Code || e.Type == data.Pre { code++ }
+			open = append(open, e)
+			next++
+		}
+
+		plain.WriteRune(r)
+		pos += len(utf16.Encode([]rune{r}))
+	}
+	closeUntil(math.MaxInt32)
+	flush()
+
+	return b.String()
+}
+
+// FromMessage converts the text or caption of a telegram message into DText.
+func FromMessage(msg *data.TMessage) string {
+	return FromTelegram(msg.PlainText(), msg.GetEntities())
+}
+
+// one character of a command's argument, and where it was in the message, in UTF-16 code units.
+type argRune struct {
+	r   rune
+	pos int
+}
+
+// splits a command's arguments out of the message it came in, the same way gogram does, with /bin/sh's quoting
+// rules. unlike gogram, this keeps track of where every character came from, so that entities can be matched up
+// with them. returns nil if the quoting is broken, in which case gogram wouldn't have accepted the command either.
+func splitArgs(text string) [][]argRune {
+	var runes []argRune
+	pos := 0
+	for _, r := range text {
+		runes = append(runes, argRune{r: r, pos: pos})
+		pos += len(utf16.Encode([]rune{r}))
+	}
+
+	// the command itself isn't one of the arguments, and neither is the space after it.
+	if strings.HasPrefix(text, "/") {
+		i := 0
+		for i < len(runes) && runes[i].r != ' ' { i++ }
+		if i < len(runes) { i++ }
+		runes = runes[i:]
+	}
+
+	var out [][]argRune
+	var word []argRune
+	in_word := false
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i].r; {
+		case c == '\'':
+			for i++; i < len(runes) && runes[i].r != '\''; i++ { word = append(word, runes[i]) }
+			if i == len(runes) { return nil }
+			in_word = true
+		case c == '"':
+			for i++; i < len(runes) && runes[i].r != '"'; i++ {
+				if runes[i].r == '\\' && i + 1 < len(runes) && strings.ContainsRune("$`\"\n\\", runes[i + 1].r) {
+					i++
+					if runes[i].r == '\n' { continue }
+				}
+				word = append(word, runes[i])
+			}
+			if i == len(runes) { return nil }
+			in_word = true
+		case c == '\\':
+			i++
+			if i == len(runes) { return nil }
+			// an escaped newline disappears entirely.
+			if runes[i].r == '\n' { continue }
+			word = append(word, runes[i])
+			in_word = true
+		case strings.ContainsRune(" \n\t", c):
+			if in_word { out = append(out, word) }
+			word, in_word = nil, false
+		default:
+			word = append(word, runes[i])
+			in_word = true
+		}
+	}
+	if in_word { out = append(out, word) }
+	return out
+}
+
+// FromMessageArg converts one of a command's arguments into DText, keeping the formatting it had in the message.
+// n counts from zero, like gogram's CommandData.Args, and arg is what gogram parsed it as. if the argument can't
+// be found in the message, for whatever reason, its formatting can't be recovered and arg is used as plain text.
+func FromMessageArg(msg *data.TMessage, n int, arg string) string {
+	args := splitArgs(msg.PlainText())
+	if n < 0 || n >= len(args) { return FromTelegram(arg, nil) }
+
+	var text strings.Builder
+	local := make([]int, len(args[n]))
+	pos := 0
+	for i, c := range args[n] {
+		text.WriteRune(c.r)
+		local[i] = pos
+		pos += len(utf16.Encode([]rune{c.r}))
+	}
+	if text.String() != arg { return FromTelegram(arg, nil) }
+
+	// quotes and escapes are gone from the argument, so an entity covers everything from the first character
+	// which was inside it in the message, to the last one.
+	var entities []data.TMessageEntity
+	for _, e := range msg.GetEntities() {
+		first, last := -1, -1
+		for i, c := range args[n] {
+			if c.pos < e.Offset || c.pos >= e.Offset + e.Length { continue }
+			if first == -1 { first = i }
+			last = i
+		}
+		if first == -1 { continue }
+
+		e.Offset = local[first]
+		e.Length = local[last] + len(utf16.Encode([]rune{args[n][last].r})) - local[first]
+		entities = append(entities, e)
+	}
+
+	return FromTelegram(arg, entities)
+}
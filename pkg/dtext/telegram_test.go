@@ -0,0 +1,98 @@
+package dtext
+
+import (
+	"github.com/thewug/gogram/data"
+
+	"reflect"
+	"testing"
+)
+
+func Test_FromTelegram(t *testing.T) {
+	url := "https://example.org"
+	e := func(kind data.EntityType, offset, length int) data.TMessageEntity {
+		return data.TMessageEntity{Type: kind, Offset: offset, Length: length}
+	}
+
+	testcases := map[string]struct{
+		text     string
+		entities []data.TMessageEntity
+		expected string
+	}{
+		"plain": {"nothing here", nil, "nothing here"},
+		"bold": {"a bold word", []data.TMessageEntity{e(data.Bold, 2, 4)}, "a [b]bold[/b] word"},
+		"nested": {"bold italic", []data.TMessageEntity{e(data.Italic, 5, 6), e(data.Bold, 0, 11)}, "[b]bold [i]italic[/i][/b]"},
+		"adjacent": {"ab", []data.TMessageEntity{e(data.Bold, 0, 1), e(data.Italic, 1, 1)}, "[b]a[/b][i]b[/i]"},
+		"link": {"my gallery", []data.TMessageEntity{{Type: data.TextLink, Offset: 3, Length: 7, Url: &url}}, `my "gallery":https://example.org`},
+		"ignored": {"hi @someone #tag", []data.TMessageEntity{e(data.Mention, 3, 8), e(data.Hashtag, 12, 4)}, "hi @someone #tag"},
+		"code": {"x := 1", []data.TMessageEntity{e(data.Pre, 0, 6)}, "[code]x := 1[/code]"},
+		"spoiler": {"the end", []data.TMessageEntity{e(spoilerEntity, 4, 3)}, "the [spoiler]end[/spoiler]"},
+		"utf16": {"🦊 fox", []data.TMessageEntity{e(data.Bold, 3, 3)}, "🦊 [b]fox[/b]"},
+		"escaped": {"type [b] for bold", nil, "type [nodtext][b][/nodtext] for bold"},
+		"escaped in entity": {"[i]literal[/i]", []data.TMessageEntity{e(data.Bold, 0, 14)}, "[b][nodtext][i][/nodtext]literal[nodtext][/i][/nodtext][/b]"},
+		"escaped link": {`"not":https://a.link`, nil, `[nodtext]"not":https://a.link[/nodtext]`},
+		"not escaped in code": {"x [b] y", []data.TMessageEntity{e(data.Code, 2, 3)}, "x `[b]` y"},
+		"post reference": {"see post #123", nil, "see post #123"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := FromTelegram(v.text, v.entities); out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
+
+func Test_splitArgs(t *testing.T) {
+	testcases := map[string]struct{
+		text     string
+		expected []string
+	}{
+		"plain": {"/cmd a b  c", []string{"a", "b", "c"}},
+		"no args": {"/cmd", nil},
+		"quoted": {`/cmd "a b" 'c d' e\ f`, []string{"a b", "c d", "e f"}},
+		"escapes in quotes": {`/cmd "say \"hi\" \x"`, []string{`say "hi" \x`}},
+		"empty": {`/cmd "" x`, []string{"", "x"}},
+		"escaped newline": {"/cmd a\\\nb", []string{"ab"}},
+		"unterminated": {`/cmd "oops`, nil},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var out []string
+			for _, arg := range splitArgs(v.text) {
+				var word []rune
+				for _, c := range arg { word = append(word, c.r) }
+				out = append(out, string(word))
+			}
+			if !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
+
+func Test_FromMessageArg(t *testing.T) {
+	// the description is the same as the argument before it, so only its position tells them apart.
+	text := "/edit fox --description \"a 🦊 [b]bold[/b] \\\"fox\\\"\" fox"
+	msg := data.TMessage{Text: &text, Entities: &[]data.TMessageEntity{
+		{Type: data.Command, Offset: 0, Length: 5},
+		{Type: data.Italic, Offset: 25, Length: 4},
+		{Type: data.Underline, Offset: 42, Length: 7},
+		{Type: data.Bold, Offset: 51, Length: 3},
+	}}
+
+	testcases := map[string]struct{
+		n        int
+		arg      string
+		expected string
+	}{
+		"first": {0, "fox", "fox"},
+		"description": {2, `a 🦊 [b]bold[/b] "fox"`, `[i]a 🦊[/i] [nodtext][b][/nodtext]bold[nodtext][/b][/nodtext] [u]"fox"[/u]`},
+		"last": {3, "fox", "[b]fox[/b]"},
+		"missing": {4, "[b]", "[nodtext][b][/nodtext]"},
+		"mismatched": {0, "not fox", "not fox"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := FromMessageArg(&msg, v.n, v.arg); out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
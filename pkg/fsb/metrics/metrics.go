@@ -0,0 +1,256 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a small, dependency free implementation of counters, gauges and histograms, which can be scraped by prometheus
+// (or anything else which understands its text exposition format) over http.
+//
+// metrics are declared once, usually as package level variables, and then updated from wherever is convenient:
+//
+//   var things = metrics.NewCounter("fsb_things_total", "Number of things which have happened.", "kind")
+//   ...
+//   things.Inc("widget")
+//
+// label values are passed positionally, in the same order as the label names the metric was declared with.
+// updating metrics is cheap, and safe whether or not anything ever serves them.
+
+// reasonable buckets for timing things measured in seconds, from a few milliseconds up to several minutes.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type Registry struct {
+	lock     sync.Mutex
+	families []*family
+}
+
+var DefaultRegistry = &Registry{}
+
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+
+	// histograms only. counts are per bucket, not cumulative.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (this *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	this.lock.Lock()
+	this.families = append(this.families, f)
+	this.lock.Unlock()
+	return f
+}
+
+// calls fn with the series for the provided label values, creating it if it doesn't exist yet.
+func (this *family) with(values []string, fn func(*series)) {
+	if len(values) != len(this.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", this.name, len(this.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	s := this.series[key]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		if this.buckets != nil { s.counts = make([]uint64, len(this.buckets)) }
+		this.series[key] = s
+	}
+	fn(s)
+}
+
+// Counter is a value which only ever goes up.
+type Counter struct {
+	f *family
+}
+
+func (this *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: this.register(name, help, "counter", nil, labels)}
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+func (this *Counter) Add(n float64, values ...string) {
+	if n < 0 { return }
+	this.f.with(values, func(s *series) { s.value += n })
+}
+
+func (this *Counter) Inc(values ...string) {
+	this.Add(1, values...)
+}
+
+// Gauge is a value which can go up and down, like the length of a queue.
+type Gauge struct {
+	f *family
+}
+
+func (this *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: this.register(name, help, "gauge", nil, labels)}
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+func (this *Gauge) Set(v float64, values ...string) {
+	this.f.with(values, func(s *series) { s.value = v })
+}
+
+func (this *Gauge) Add(n float64, values ...string) {
+	this.f.with(values, func(s *series) { s.value += n })
+}
+
+func (this *Gauge) Inc(values ...string) {
+	this.Add(1, values...)
+}
+
+func (this *Gauge) Dec(values ...string) {
+	this.Add(-1, values...)
+}
+
+// Histogram counts observations, like how long something took, into buckets.
+type Histogram struct {
+	f *family
+}
+
+// buckets are the upper bounds of each bucket, in increasing order. if nil, DefaultBuckets is used.
+func (this *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil { buckets = DefaultBuckets }
+	return &Histogram{f: this.register(name, help, "histogram", buckets, labels)}
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+func (this *Histogram) Observe(v float64, values ...string) {
+	this.f.with(values, func(s *series) {
+		for i, bound := range this.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+				break
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+// observes the number of seconds which have passed since start.
+func (this *Histogram) ObserveSince(start time.Time, values ...string) {
+	this.Observe(time.Since(start).Seconds(), values...)
+}
+
+// writes every metric in the registry, in prometheus' text exposition format.
+func (this *Registry) WriteText(w io.Writer) error {
+	this.lock.Lock()
+	families := append([]*family(nil), this.families...)
+	this.lock.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(b)
+	}
+	return b.Flush()
+}
+
+func (this *family) write(b *bufio.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", this.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(this.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", this.name, this.kind)
+
+	var keys []string
+	for k := range this.series { keys = append(keys, k) }
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := this.series[k]
+		if this.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", this.name, labelString(this.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range this.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", this.name, labelString(this.labels, s.values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", this.name, labelString(this.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", this.name, labelString(this.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", this.name, labelString(this.labels, s.values, "", ""), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formats a set of labels like {a="1",b="2"}, with an optional extra label on the end.
+func labelString(names, values []string, extra_name, extra_value string) string {
+	var pairs []string
+	for i := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], labelEscaper.Replace(values[i])))
+	}
+	if extra_name != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra_name, extra_value))
+	}
+	if len(pairs) == 0 { return "" }
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) { return "+Inf" }
+	if math.IsInf(v, -1) { return "-Inf" }
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (this *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.WriteText(w)
+}
+
+// starts serving DefaultRegistry at /metrics on the given address, in the background.
+// errors listening on the address are returned, errors afterwards are only logged.
+func Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil { return err }
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", DefaultRegistry)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		log.Println("Metrics listener stopped:", err)
+	}()
+
+	log.Printf("Serving metrics on %s\n", listener.Addr())
+	return nil
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_WriteText(t *testing.T) {
+	r := &Registry{}
+	calls := r.NewCounter("test_calls_total", "Calls made.", "endpoint", "status")
+	waiting := r.NewGauge("test_waiting", "Things waiting.")
+	durations := r.NewHistogram("test_duration_seconds", "How long things took.", []float64{0.5, 1}, "kind")
+
+	calls.Inc("/posts.json", "200")
+	calls.Add(2, "/posts.json", "200")
+	calls.Inc(`/tags "quoted"`, "error")
+	calls.Add(-5, "/posts.json", "200") // counters don't go down
+	waiting.Inc()
+	waiting.Inc()
+	waiting.Dec()
+	durations.Observe(0.25, "fast")
+	durations.Observe(0.75, "fast")
+	durations.Observe(3, "fast")
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+	expected := `# HELP test_calls_total Calls made.
+# TYPE test_calls_total counter
+test_calls_total{endpoint="/posts.json",status="200"} 3
+test_calls_total{endpoint="/tags \"quoted\"",status="error"} 1
+# HELP test_duration_seconds How long things took.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{kind="fast",le="0.5"} 1
+test_duration_seconds_bucket{kind="fast",le="1"} 2
+test_duration_seconds_bucket{kind="fast",le="+Inf"} 3
+test_duration_seconds_sum{kind="fast"} 4
+test_duration_seconds_count{kind="fast"} 3
+# HELP test_waiting Things waiting.
+# TYPE test_waiting gauge
+test_waiting 1
+`
+	if b.String() != expected { t.Errorf("Unexpected output:\n%s\nexpected:\n%s", b.String(), expected) }
+}
+
+func Test_WrongLabels(t *testing.T) {
+	r := &Registry{}
+	calls := r.NewCounter("test_calls_total", "Calls made.", "endpoint")
+
+	defer func() {
+		if recover() == nil { t.Errorf("Expected a panic for missing label values") }
+	}()
+	calls.Inc()
+}
+
+func Test_ServeHTTP(t *testing.T) {
+	r := &Registry{}
+	r.NewCounter("test_calls_total", "Calls made.").Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") { t.Errorf("Unexpected content type: %s", w.Header().Get("Content-Type")) }
+	if !strings.Contains(w.Body.String(), "test_calls_total 1\n") { t.Errorf("Unexpected body: %s", w.Body.String()) }
+}
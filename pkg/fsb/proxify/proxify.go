@@ -4,6 +4,7 @@ import (
 	stypes "github.com/thewug/fsb/pkg/botbehavior/settings/types"
 	"github.com/thewug/fsb/pkg/api"
 	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/dtext"
 	"github.com/thewug/fsb/pkg/fsb/proxify/webm"
 	"github.com/thewug/fsb/pkg/storage"
 
@@ -134,7 +135,7 @@ func GenerateCaption(result types.TPostInfo, force_safe bool, query string, sett
 	// add the post and image links
 	caption = append(caption, fmt.Sprintf(`View <a href="%s">Post</a>, <a href="%s">%s</a>`, post_url, image_url, display_type))
 	if convert_notice {
-		caption = append(caption, "(Converting: webm \u27a1 gif)")
+		caption = append(caption, fmt.Sprintf("(Converting: %s \u27a1 %s)", result.File_ext, map[bool]string{false: "gif", true: "jpg"}[ConversionNeeded(&result) == webm.KindPhoto]))
 	}
 
 	// add the artist links
@@ -160,6 +161,11 @@ func GenerateCaption(result types.TPostInfo, force_safe bool, query string, sett
 	// add generic source links
 	caption = append(caption, sourcesList(result.Sources, settings)...)
 
+	// add the start of the description
+	if description := strings.TrimSpace(result.Description); description != "" && settings.DescriptionLength > 0 {
+		caption = append(caption, dtext.ToHTML(dtext.Excerpt(description, settings.DescriptionLength)))
+	}
+
 	// add search query
 	if query == "" {
 		caption = append(caption, fmt.Sprintf(`(from the front page)`))
@@ -173,60 +179,93 @@ func GenerateCaption(result types.TPostInfo, force_safe bool, query string, sett
 
 // https://api/artists/show_or_new?name=dizzyvixen
 
-func ConvertApiResultToTelegramInline(result types.TPostInfo, force_safe bool, query string, debugmode bool, settings stypes.CaptionSettings) (interface{}) {
+// telegram quietly refuses to show inline results whose files are too big, and the limits it actually enforces
+// aren't the ones it documents. these are on the cautious side.
+const (
+	INLINE_PHOTO_MAX_SIZE   = 5 * 1024 * 1024
+	INLINE_PHOTO_MAX_PIXELS = 10000000
+	INLINE_GIF_MAX_SIZE     = 20 * 1024 * 1024
+)
+
+// works out whether a post's file needs converting before it can be shown inline, and if so into what.
+// returns one of the webm.Kind* constants, or an empty string if the file can be used as it is.
+func ConversionNeeded(result *types.TPostInfo) string {
+	switch result.File_ext {
+	case "webm":
+		return webm.KindWebm
+	case "gif":
+		if result.File_size > INLINE_GIF_MAX_SIZE { return webm.KindAnimation }
+	case "png", "apng":
+		// telegram will only show the first frame of an animated png.
+		if IsAnimatedPng(result) { return webm.KindAnimation }
+		fallthrough
+	case "jpg", "jpeg":
+		if result.File_size > INLINE_PHOTO_MAX_SIZE || result.Width * result.Height > INLINE_PHOTO_MAX_PIXELS { return webm.KindPhoto }
+	}
+	return ""
+}
+
+func IsAnimatedPng(result *types.TPostInfo) bool {
+	if result.File_ext == "apng" { return true }
+	for _, tag := range result.Meta {
+		if tag == "animated_png" { return true }
+	}
+	return false
+}
+
+// checks the cache for an already converted version of a post's file.
+func checkConverted(result *types.TPostInfo, kind string) *data.FileID {
+	var file_id *data.FileID
+	err := storage.DefaultTransact(func(tx storage.DBLike) error {
+		var err error
+		if kind == webm.KindWebm {
+			file_id, err = webm.CheckMp4ForWebm(tx, result)
+		} else {
+			file_id, err = webm.CheckConvertedImage(tx, result, kind)
+		}
+		return err
+	})
+	if err != nil { log.Printf("checkConverted: %v", err) }
+	return file_id
+}
+
+// the buttons under a post sent inline, for voting on it, favoriting it and reading its comments.
+func PostKeyboard(post *types.TPostInfo) *data.TInlineKeyboard {
 	s2p := func(s string) *string { return &s }
-	replymarkup := &data.TInlineKeyboard{
+	return &data.TInlineKeyboard{
 		Buttons: [][]data.TInlineKeyboardButton{
 			[]data.TInlineKeyboardButton{
-				data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44D %d", result.Upvotes), Data: s2p(fmt.Sprintf("/upvote %d", result.Id))},
-				data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44E %d", result.Downvotes), Data: s2p(fmt.Sprintf("/downvote %d", result.Id))},
-				data.TInlineKeyboardButton{Text: fmt.Sprintf("\u2764\uFE0F %d", result.Fav_count), Data: s2p(fmt.Sprintf("/favorite %d", result.Id))},
+				data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44D %d", post.Upvotes), Data: s2p(fmt.Sprintf("/upvote %d", post.Id))},
+				data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44E %d", post.Downvotes), Data: s2p(fmt.Sprintf("/downvote %d", post.Id))},
+				data.TInlineKeyboardButton{Text: fmt.Sprintf("\u2764\uFE0F %d", post.Fav_count), Data: s2p(fmt.Sprintf("/favorite %d", post.Id))},
+				data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F4AC %d", post.Comment_count), Data: s2p(fmt.Sprintf("/comments %d", post.Id))},
 			},
 		},
 	}
+}
+
+func ConvertApiResultToTelegramInline(result types.TPostInfo, force_safe bool, query string, debugmode bool, settings stypes.CaptionSettings) (interface{}) {
+	replymarkup := PostKeyboard(&result)
 
 	width := result.Width
 	height := result.Height
 
-	if result.File_ext == "gif" {
-		foo := data.TInlineQueryResultGif{
-			Type:        "gif",
-			Id:          result.Md5,
-			GifUrl:      result.File_url,
-			ThumbUrl:    result.Preview_url,
-			GifWidth:    &width,
-			GifHeight:   &height,
-			ParseMode:   data.ParseHTML,
-			Caption:     GenerateCaption(result, force_safe, query, settings, false),
-			ReplyMarkup: replymarkup,
-		}
-		if debugmode { GenerateDebugText(&foo, result) }
-		return foo
-	} else if result.File_ext == "webm" {
+	// this comes first, since gifs which are too big to be shown inline need converting too.
+	if kind := ConversionNeeded(&result); kind != "" {
 		var foo interface{}
 
-		var file_id *data.FileID
-		err := storage.DefaultTransact(func(tx storage.DBLike) error {
-			var err error
-			file_id, err = webm.CheckMp4ForWebm(tx, &result)
-			return err
-		})
-		if err != nil { log.Printf("CheckMp4ForWebm: %v", err) }
-
-		if file_id != nil {
-			foo = data.TInlineQueryResultCachedAnimation{
-				Type:        "mpeg4_gif",
-				Id:          result.Md5,
-				AnimationId:*file_id,
-				ParseMode:   data.ParseHTML,
-				Caption:     GenerateCaption(result, force_safe, query, settings, false),
-				ReplyMarkup: replymarkup,
+		if file_id := checkConverted(&result, kind); file_id == nil {
+			// show a still image while the file is converted, which is swapped out once it's ready.
+			// see HandleConversionRequest.
+			placeholder_url, width, height := result.Preview_url, result.Preview_width, result.Preview_height
+			if result.Has_sample {
+				placeholder_url, width, height = result.Sample_url, result.Sample_width, result.Sample_height
 			}
-		} else {
+
 			foo = data.TInlineQueryResultPhoto{
 				Type:        "photo",
 				Id:          result.Md5 + "_cvt",
-				PhotoUrl:    result.Sample_url,
+				PhotoUrl:    placeholder_url,
 				ThumbUrl:    result.Preview_url,
 				PhotoWidth:  &width,
 				PhotoHeight: &height,
@@ -234,8 +273,40 @@ func ConvertApiResultToTelegramInline(result types.TPostInfo, force_safe bool, q
 				Caption:     GenerateCaption(result, force_safe, query, settings, true),
 				ReplyMarkup: replymarkup,
 			}
+		} else if kind == webm.KindPhoto {
+			foo = data.TInlineQueryResultCachedPhoto{
+				Type:        "photo",
+				Id:          result.Md5,
+				PhotoId:     *file_id,
+				ParseMode:   data.ParseHTML,
+				Caption:     GenerateCaption(result, force_safe, query, settings, false),
+				ReplyMarkup: replymarkup,
+			}
+		} else {
+			foo = data.TInlineQueryResultCachedAnimation{
+				Type:        "mpeg4_gif",
+				Id:          result.Md5,
+				AnimationId:*file_id,
+				ParseMode:   data.ParseHTML,
+				Caption:     GenerateCaption(result, force_safe, query, settings, false),
+				ReplyMarkup: replymarkup,
+			}
 		}
 
+		if debugmode { GenerateDebugText(&foo, result) }
+		return foo
+	} else if result.File_ext == "gif" {
+		foo := data.TInlineQueryResultGif{
+			Type:        "gif",
+			Id:          result.Md5,
+			GifUrl:      result.File_url,
+			ThumbUrl:    result.Preview_url,
+			GifWidth:    &width,
+			GifHeight:   &height,
+			ParseMode:   data.ParseHTML,
+			Caption:     GenerateCaption(result, force_safe, query, settings, false),
+			ReplyMarkup: replymarkup,
+		}
 		if debugmode { GenerateDebugText(&foo, result) }
 		return foo
 	} else if result.File_ext == "swf" {
@@ -243,20 +314,11 @@ func ConvertApiResultToTelegramInline(result types.TPostInfo, force_safe bool, q
 		log.Printf("[Wug     ] Not handling result ID %d (it's an incompatible animation)\n", result.Id)
 		return nil
 	} else if (result.File_ext == "png" || result.File_ext == "jpg" || result.File_ext == "jpeg"){
-		// telegram's logic about what files bots can send is fucked. it's tied to web previewing logic somehow,
-		// and the limits seem to kick in long before the posted limits on the bot api say they should.
-		// here is a shitty heuristic which will hopefilly be good enough to at least make most of them display SOMETHING.
-		file_url := result.File_url
-		if width * height > 10000000 { // images larger than 10MP will use the sample image instead of the full res
-			file_url = result.Sample_url
-			width = result.Sample_width
-			height = result.Sample_height
-		}
-
+		// anything too big for telegram to show was dealt with above, by ConversionNeeded.
 		foo := data.TInlineQueryResultPhoto{
 			Type:        "photo",
 			Id:          result.Md5,
-			PhotoUrl:    file_url,
+			PhotoUrl:    result.File_url,
 			ThumbUrl:    result.Preview_url,
 			PhotoWidth:  &width,
 			PhotoHeight: &height,
@@ -297,10 +359,14 @@ func GenerateDebugText(iqr interface{}, result types.TPostInfo) {
 	case *data.TInlineQueryResultCachedAnimation:
 		imt.MessageText = fmt.Sprintf("`ID:    `%d\n`MD5:   `%s\n`Size:  `??? (cached)\n`ID:  `%s\n", result.Id, result.Md5, v.AnimationId)
 		v.InputMessageContent = &imt
+	case *data.TInlineQueryResultCachedPhoto:
+		imt.MessageText = fmt.Sprintf("`ID:    `%d\n`MD5:   `%s\n`Size:  `??? (cached)\n`ID:  `%s\n", result.Id, result.Md5, v.PhotoId)
+		v.InputMessageContent = &imt
 	}
 }
 
-func HandleWebmConversionRequest(ctx *gogram.InlineResultCtx, creds storage.UserCreds) {
+// converts the file for an inline result which was sent with a placeholder, and swaps it in once it's ready.
+func HandleConversionRequest(ctx *gogram.InlineResultCtx, creds storage.UserCreds) {
 	md5 := strings.Split(ctx.Result.ResultId, "_")[0]
 
 	posts, err := api.ListPosts(creds.User, creds.ApiKey, types.ListPostOptions{SearchQuery: types.SinglePostByMd5(md5)})
@@ -309,38 +375,58 @@ func HandleWebmConversionRequest(ctx *gogram.InlineResultCtx, creds storage.User
 		ctx.Bot.ErrorLog.Println("Got wrong number of posts for single post lookup?")
 		return
 	} else if err != nil {
-		ctx.Bot.ErrorLog.Println("Error looking up post by MD5 during webm conversion prep:", err.Error())
+		ctx.Bot.ErrorLog.Println("Error looking up post by MD5 during conversion prep:", err.Error())
 		return
 	}
 
 	post := posts[0]
-	file_id := webm.GetMp4ForWebm(&post)
+	kind := ConversionNeeded(&post)
+	if kind == "" { return }
+
+	var file_id *data.FileID
+	if kind == webm.KindWebm {
+		file_id, err = webm.GetMp4ForWebm(&post)
+	} else {
+		file_id, err = webm.GetConvertedImage(&post, kind)
+	}
+
+	// a pending conversion keeps going in the background, but the placeholder is left as it is, since it might be
+	// quite a while before it's done. the converted file will be used for any later results for the same post.
+	if err == webm.ErrConversionPending {
+		log.Printf("Conversion of %s is still pending, leaving the placeholder in place\n", md5)
+		return
+	} else if err != nil {
+		ctx.Bot.ErrorLog.Println("Error converting file:", err.Error())
+		return
+	}
 
 	if ctx.Result.InlineMessageId == nil || file_id == nil { return }
 
-	UpdateWebmPostWithConvertedFile(ctx, &post, *file_id)
+	UpdatePostWithConvertedFile(ctx, &post, *file_id, kind)
 }
 
-func UpdateWebmPostWithConvertedFile(ctx *gogram.InlineResultCtx, post *types.TPostInfo, file_id data.FileID) {
-	s2p := func(s string) *string { return &s }
+func UpdatePostWithConvertedFile(ctx *gogram.InlineResultCtx, post *types.TPostInfo, file_id data.FileID, kind string) {
+	caption := *GenerateCaption(*post, false, ctx.Result.Query, stypes.CaptionSettings{MaxArtists: 3, MaxChars: 3, MaxSources: 3}, false)
+
+	var media data.TInputMedia = data.TInputMediaAnimation{
+		ParseMode: data.ParseHTML,
+		Caption: caption,
+		Media: file_id,
+	}
+	if kind == webm.KindPhoto {
+		media = data.TInputMediaPhoto{
+			ParseMode: data.ParseHTML,
+			Caption: caption,
+			Media: file_id,
+		}
+	}
+
 	edit := data.OMediaEdit{
 		SourceData: data.SourceData{
 			SourceInlineId: *ctx.Result.InlineMessageId,
 		},
-		Media: data.TInputMediaAnimation{
-			ParseMode: data.ParseHTML,
-			Caption: *GenerateCaption(*post, false, ctx.Result.Query, stypes.CaptionSettings{3,3,3}, false),
-			Media: file_id,
-		},
-		ReplyMarkup: &data.TInlineKeyboard{
-			Buttons: [][]data.TInlineKeyboardButton{
-				[]data.TInlineKeyboardButton{
-					data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44D %d", post.Upvotes), Data: s2p(fmt.Sprintf("/upvote %d", post.Id))},
-					data.TInlineKeyboardButton{Text: fmt.Sprintf("\U0001F44E %d", post.Downvotes), Data: s2p(fmt.Sprintf("/downvote %d", post.Id))},
-					data.TInlineKeyboardButton{Text: fmt.Sprintf("\u2764\uFE0F %d", post.Fav_count), Data: s2p(fmt.Sprintf("/favorite %d", post.Id))},
-				},
-			},
-		},
+		Media: media,
+		ReplyMarkup: PostKeyboard(post),
 	}
 
 	ctx.Bot.Remote.EditMessageMediaAsync(edit, nil)
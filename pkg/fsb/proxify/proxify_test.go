@@ -0,0 +1,51 @@
+package proxify
+
+import (
+	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/fsb/proxify/webm"
+
+	"reflect"
+	"testing"
+)
+
+func Test_ConversionNeeded(t *testing.T) {
+	post := func(ext string, size, width, height int, meta ...string) *types.TPostInfo {
+		var p types.TPostInfo
+		p.File_ext, p.File_size, p.Width, p.Height, p.Meta = ext, size, width, height, meta
+		return &p
+	}
+
+	testcases := map[string]struct {
+		post     *types.TPostInfo
+		expected string
+	}{
+		"small jpg":     {post("jpg", 1000000, 1000, 1000), ""},
+		"small png":     {post("png", 1000000, 1000, 1000), ""},
+		"heavy png":     {post("png", 6 * 1024 * 1024, 1000, 1000), webm.KindPhoto},
+		"huge jpg":      {post("jpg", 1000000, 5000, 5000), webm.KindPhoto},
+		"animated png":  {post("png", 1000000, 1000, 1000, "animated_png"), webm.KindAnimation},
+		"apng":          {post("apng", 1000000, 1000, 1000), webm.KindAnimation},
+		"small gif":     {post("gif", 1000000, 500, 500), ""},
+		"heavy gif":     {post("gif", 25 * 1024 * 1024, 500, 500), webm.KindAnimation},
+		"webm":          {post("webm", 1000000, 500, 500), webm.KindWebm},
+		"swf":           {post("swf", 1000000, 500, 500), ""},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := ConversionNeeded(v.post); out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
+
+// results which are converted later get their keyboard replaced, and shouldn't lose any buttons when they do.
+func Test_PostKeyboard(t *testing.T) {
+	post := types.TPostInfo{Id: 123}
+	var commands []string
+	for _, row := range PostKeyboard(&post).Buttons {
+		for _, b := range row { commands = append(commands, *b.Data) }
+	}
+
+	expected := []string{"/upvote 123", "/downvote 123", "/favorite 123", "/comments 123"}
+	if !reflect.DeepEqual(commands, expected) { t.Errorf("Unexpected buttons: got %v, expected %v", commands, expected) }
+}
@@ -0,0 +1,231 @@
+package webm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Profile controls how videos are transcoded. zero values are replaced with the defaults below, so an empty
+// profile is a perfectly good one.
+type Profile struct {
+	FFmpeg       string `json:"ffmpeg"`        // ffmpeg binary, looked up in PATH unless it's a path.
+	VideoCodec   string `json:"video_codec"`   // any video encoder ffmpeg knows which can go in an mp4.
+	MaxBitrate   int    `json:"max_bitrate"`   // in kbit/s.
+	MaxDimension int    `json:"max_dimension"` // videos wider or taller than this many pixels are scaled down.
+	TargetSize   int64  `json:"target_size"`   // in bytes. outputs bigger than this are redone at a lower bitrate.
+}
+
+const (
+	DEFAULT_FFMPEG        = "ffmpeg"
+	DEFAULT_VIDEO_CODEC   = "libx264"
+	DEFAULT_MAX_BITRATE   = 4000
+	DEFAULT_MAX_DIMENSION = 1280
+	DEFAULT_TARGET_SIZE   = 20 * 1024 * 1024 // comfortably under the 50MB telegram lets bots upload
+)
+
+// audio is only kept on request, and it's always encoded at this bitrate, in kbit/s.
+const audioBitrate = 128
+
+// how much of the target size the second pass aims to use, leaving room for container overhead and
+// the encoder overshooting a bit.
+const sizeHeadroom = 0.9
+
+func (this Profile) withDefaults() Profile {
+	if this.FFmpeg == "" { this.FFmpeg = DEFAULT_FFMPEG }
+	if this.VideoCodec == "" { this.VideoCodec = DEFAULT_VIDEO_CODEC }
+	if this.MaxBitrate <= 0 { this.MaxBitrate = DEFAULT_MAX_BITRATE }
+	if this.MaxDimension <= 0 { this.MaxDimension = DEFAULT_MAX_DIMENSION }
+	if this.TargetSize <= 0 { this.TargetSize = DEFAULT_TARGET_SIZE }
+	return this
+}
+
+// which part of a conversion went wrong.
+const (
+	StageDownload = "download"
+	StageEncode   = "encode"
+	StageSize     = "size"
+)
+
+var ErrTooLarge = errors.New("output is larger than the target size")
+
+// TranscodeError describes a failed conversion.
+type TranscodeError struct {
+	Stage    string
+	Pass     int    // which encoding pass failed, if any.
+	ExitCode int    // ffmpeg's exit code, if it got as far as exiting.
+	Output   string // the end of what ffmpeg had to say about it.
+	Err      error
+}
+
+func (this *TranscodeError) Error() string {
+	msg := fmt.Sprintf("transcode %s", this.Stage)
+	if this.Pass != 0 { msg += fmt.Sprintf(" (pass %d)", this.Pass) }
+	msg += ": " + this.Err.Error()
+	if this.ExitCode != 0 { msg += fmt.Sprintf(" [exit code %d]", this.ExitCode) }
+	if this.Output != "" { msg += ": " + this.Output }
+	return msg
+}
+
+func (this *TranscodeError) Unwrap() error {
+	return this.Err
+}
+
+// only this much of ffmpeg's output is kept in errors, since the interesting part is always at the end.
+const outputTail = 400
+
+// converts the video in src into an mp4 at dst. if the first pass comes out bigger than the profile's target size,
+// it's done again at whatever bitrate should make it fit. dst is removed if the conversion fails.
+func Transcode(profile Profile, src, dst string, strip_audio bool) error {
+	profile = profile.withDefaults()
+
+	output, err := runFFmpeg(profile, ffmpegArgs(profile, src, dst, strip_audio, 0), 1)
+	if err != nil { return err }
+
+	size, err := fileSize(dst)
+	if err != nil { return &TranscodeError{Stage: StageEncode, Pass: 1, Err: err} }
+	if size <= profile.TargetSize { return nil }
+
+	duration, ok := parseDuration(output)
+	bitrate := secondPassBitrate(profile, duration, strip_audio)
+	if !ok || bitrate <= 0 {
+		os.Remove(dst)
+		return &TranscodeError{Stage: StageSize, Pass: 1, Err: ErrTooLarge}
+	}
+
+	_, err = runFFmpeg(profile, ffmpegArgs(profile, src, dst, strip_audio, bitrate), 2)
+	if err != nil { return err }
+
+	size, err = fileSize(dst)
+	if err != nil { return &TranscodeError{Stage: StageEncode, Pass: 2, Err: err} }
+	if size > profile.TargetSize {
+		os.Remove(dst)
+		return &TranscodeError{Stage: StageSize, Pass: 2, Err: ErrTooLarge}
+	}
+	return nil
+}
+
+// the limits for converted photos, in pixels on the longest side, and bytes.
+const (
+	PHOTO_MAX_DIMENSION = 2560
+	PHOTO_TARGET_SIZE   = 5 * 1024 * 1024
+)
+
+// jpeg quality settings to try, from best to worst, until a photo comes out small enough.
+var photoQualities = []int{2, 5, 10}
+
+// converts the image in src into a jpeg at dst, which is scaled down if it's very large. for animated images,
+// only the first frame is kept. dst is removed if the conversion fails.
+func TranscodeImage(profile Profile, src, dst string) error {
+	profile = profile.withDefaults()
+
+	for i, quality := range photoQualities {
+		_, err := runFFmpeg(profile, imageArgs(src, dst, quality), i + 1)
+		if err != nil { return err }
+
+		size, err := fileSize(dst)
+		if err != nil { return &TranscodeError{Stage: StageEncode, Pass: i + 1, Err: err} }
+		if size <= PHOTO_TARGET_SIZE { return nil }
+	}
+
+	os.Remove(dst)
+	return &TranscodeError{Stage: StageSize, Pass: len(photoQualities), Err: ErrTooLarge}
+}
+
+func imageArgs(src, dst string, quality int) []string {
+	scale := fmt.Sprintf("scale=w='min(iw,%[1]d)':h='min(ih,%[1]d)':force_original_aspect_ratio=decrease", PHOTO_MAX_DIMENSION)
+	return []string{"-y", "-hide_banner", "-nostdin", "-i", src, "-frames:v", "1", "-vf", scale, "-c:v", "mjpeg", "-q:v", strconv.Itoa(quality), "-f", "image2", dst}
+}
+
+// builds ffmpeg's arguments. a bitrate of 0 means a quality based encode, capped at the profile's maximum bitrate,
+// otherwise the video is encoded at that many kbit/s.
+func ffmpegArgs(profile Profile, src, dst string, strip_audio bool, bitrate int) []string {
+	args := []string{"-y", "-hide_banner", "-nostdin", "-i", src, "-map", "0:v:0"}
+	if !strip_audio { args = append(args, "-map", "0:a:0?") }
+
+	// scale down anything too big, keeping the aspect ratio, and make sure both dimensions are even, which most encoders insist on.
+	scale := fmt.Sprintf("scale=w='min(iw,%[1]d)':h='min(ih,%[1]d)':force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2", profile.MaxDimension)
+	args = append(args, "-c:v", profile.VideoCodec, "-pix_fmt", "yuv420p", "-vf", scale)
+
+	if bitrate == 0 {
+		if profile.VideoCodec == "libx264" || profile.VideoCodec == "libx265" { args = append(args, "-crf", "23") }
+		bitrate = profile.MaxBitrate
+	} else {
+		args = append(args, "-b:v", fmt.Sprintf("%dk", bitrate))
+	}
+	args = append(args, "-maxrate", fmt.Sprintf("%dk", bitrate), "-bufsize", fmt.Sprintf("%dk", bitrate * 2))
+
+	if strip_audio {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioBitrate))
+	}
+
+	return append(args, "-movflags", "+faststart", "-f", "mp4", dst)
+}
+
+// runs ffmpeg, returning everything it printed.
+func runFFmpeg(profile Profile, args []string, pass int) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command(profile.FFmpeg, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err != nil {
+		out := &TranscodeError{Stage: StageEncode, Pass: pass, Output: tail(output.String(), outputTail), Err: err}
+		var exit *exec.ExitError
+		if errors.As(err, &exit) { out.ExitCode = exit.ExitCode() }
+		if len(args) != 0 { os.Remove(args[len(args) - 1]) }
+		return output.String(), out
+	}
+
+	return output.String(), nil
+}
+
+var progressTime = regexp.MustCompile(`time=(\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+var inputDuration = regexp.MustCompile(`Duration: (\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+
+// works out how long a video is from ffmpeg's output. the last progress report is the most reliable, since webms
+// don't always say how long they are up front, but the input's duration will do if there isn't one.
+func parseDuration(output string) (time.Duration, bool) {
+	for _, re := range []*regexp.Regexp{progressTime, inputDuration} {
+		matches := re.FindAllStringSubmatch(output, -1)
+		if len(matches) == 0 { continue }
+		m := matches[len(matches) - 1]
+
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		d := time.Duration(h) * time.Hour + time.Duration(min) * time.Minute + time.Duration(sec * float64(time.Second))
+		if d > 0 { return d, true }
+	}
+	return 0, false
+}
+
+// the video bitrate, in kbit/s, which should make a video of the given length fit within the target size.
+// returns 0 or less if it won't fit at any bitrate.
+func secondPassBitrate(profile Profile, duration time.Duration, strip_audio bool) int {
+	if duration <= 0 { return 0 }
+
+	bitrate := int(float64(profile.TargetSize) * 8 * sizeHeadroom / duration.Seconds() / 1000)
+	if !strip_audio { bitrate -= audioBitrate }
+	if bitrate > profile.MaxBitrate { bitrate = profile.MaxBitrate }
+	return bitrate
+}
+
+func fileSize(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if err != nil { return 0, err }
+	return info.Size(), nil
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n { return s }
+	return s[len(s) - n:]
+}
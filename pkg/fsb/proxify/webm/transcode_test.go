@@ -0,0 +1,194 @@
+package webm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseDuration(t *testing.T) {
+	testcases := map[string]struct {
+		output   string
+		expected time.Duration
+		ok       bool
+	}{
+		"progress": {"Duration: N/A, start: 0.000000\nframe=  10 time=00:00:01.50 bitrate=1.0kbits/s\nframe=  20 time=00:00:02.25 bitrate=1.0kbits/s\n", 2250 * time.Millisecond, true},
+		"input only": {"  Duration: 01:02:03.50, start: 0.000000, bitrate: 123 kb/s\n", time.Hour + 2 * time.Minute + 3500 * time.Millisecond, true},
+		"prefer progress": {"Duration: 00:00:10.00\ntime=00:00:04.00\n", 4 * time.Second, true},
+		"none": {"Duration: N/A\nsomething went wrong\n", 0, false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out, ok := parseDuration(v.output)
+			if out != v.expected || ok != v.ok { t.Errorf("Unexpected result: got %s %t, expected %s %t", out, ok, v.expected, v.ok) }
+		})
+	}
+}
+
+func Test_secondPassBitrate(t *testing.T) {
+	profile := Profile{TargetSize: 1000 * 1000, MaxBitrate: 4000}.withDefaults()
+
+	testcases := map[string]struct {
+		duration    time.Duration
+		strip_audio bool
+		expected    int
+	}{
+		"silent":   {10 * time.Second, true, 720},
+		"audio":    {10 * time.Second, false, 592},
+		"capped":   {time.Second, true, 4000},
+		"too long": {time.Hour, false, -126},
+		"unknown":  {0, true, 0},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := secondPassBitrate(profile, v.duration, v.strip_audio); out != v.expected { t.Errorf("Unexpected bitrate: got %d, expected %d", out, v.expected) }
+		})
+	}
+}
+
+func Test_ffmpegArgs(t *testing.T) {
+	profile := Profile{MaxDimension: 640, MaxBitrate: 1000}.withDefaults()
+
+	testcases := map[string]struct {
+		strip_audio bool
+		bitrate     int
+		contains    []string
+		excludes    []string
+	}{
+		"first pass": {true, 0, []string{"-c:v libx264", "-crf 23", "-maxrate 1000k", "-bufsize 2000k", "-an", "min(iw,640)", "in.webm", "-f mp4 out.mp4"}, []string{"-b:v", "-c:a"}},
+		"second pass": {true, 300, []string{"-b:v 300k", "-maxrate 300k", "-bufsize 600k", "-an"}, []string{"-crf"}},
+		"audio": {false, 0, []string{"-map 0:a:0?", "-c:a aac -b:a 128k"}, []string{"-an"}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			args := strings.Join(ffmpegArgs(profile, "in.webm", "out.mp4", v.strip_audio, v.bitrate), " ")
+			for _, c := range v.contains {
+				if !strings.Contains(args, c) { t.Errorf("Expected %q in args: %s", c, args) }
+			}
+			for _, c := range v.excludes {
+				if strings.Contains(args, c) { t.Errorf("Unexpected %q in args: %s", c, args) }
+			}
+		})
+	}
+}
+
+func Test_TranscodeError(t *testing.T) {
+	var err error = &TranscodeError{Stage: StageSize, Pass: 2, Err: ErrTooLarge}
+	if !errors.Is(err, ErrTooLarge) { t.Errorf("Expected the error to wrap ErrTooLarge") }
+	if err.Error() != "transcode size (pass 2): output is larger than the target size" { t.Errorf("Unexpected message: %s", err.Error()) }
+}
+
+// the rest of these run the real thing, on tiny videos generated by ffmpeg itself, so they need ffmpeg to be installed.
+
+func requireFFmpeg(t *testing.T) string {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil { t.Skip("ffmpeg not found") }
+	return ffmpeg
+}
+
+// generates a short test pattern webm, with a tone if audio is requested.
+func makeWebm(t *testing.T, ffmpeg, dir string, audio bool) string {
+	name := filepath.Join(dir, "fixture.webm")
+	args := []string{"-y", "-hide_banner", "-nostdin", "-f", "lavfi", "-i", "testsrc=size=160x120:rate=10:duration=2"}
+	if audio { args = append(args, "-f", "lavfi", "-i", "sine=duration=2", "-c:a", "libvorbis") }
+	args = append(args, "-c:v", "libvpx", "-b:v", "200k", name)
+
+	if out, err := exec.Command(ffmpeg, args...).CombinedOutput(); err != nil {
+		t.Skipf("Couldn't generate a webm fixture (%s): %s", err.Error(), out)
+	}
+	return name
+}
+
+func Test_Transcode(t *testing.T) {
+	ffmpeg := requireFFmpeg(t)
+
+	for _, audio := range []bool{false, true} {
+		dir := t.TempDir()
+		src := makeWebm(t, ffmpeg, dir, audio)
+		dst := filepath.Join(dir, "out.mp4")
+
+		err := Transcode(Profile{FFmpeg: ffmpeg}, src, dst, !audio)
+		if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+		out, err := os.ReadFile(dst)
+		if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+		if len(out) < 8 || !bytes.Equal(out[4:8], []byte("ftyp")) { t.Errorf("Output doesn't look like an mp4") }
+	}
+}
+
+func Test_Transcode_TooLarge(t *testing.T) {
+	ffmpeg := requireFFmpeg(t)
+	dir := t.TempDir()
+	src := makeWebm(t, ffmpeg, dir, false)
+	dst := filepath.Join(dir, "out.mp4")
+
+	// no bitrate could make this fit, so it should give up without trying a second pass.
+	err := Transcode(Profile{FFmpeg: ffmpeg, TargetSize: 1}, src, dst, true)
+
+	var terr *TranscodeError
+	if !errors.As(err, &terr) || terr.Stage != StageSize || !errors.Is(err, ErrTooLarge) { t.Fatalf("Unexpected error: %v", err) }
+	if _, err := os.Stat(dst); !os.IsNotExist(err) { t.Errorf("Expected the oversized output to be removed") }
+}
+
+func Test_Transcode_SecondPass(t *testing.T) {
+	ffmpeg := requireFFmpeg(t)
+	dir := t.TempDir()
+	src := makeWebm(t, ffmpeg, dir, false)
+	dst := filepath.Join(dir, "out.mp4")
+
+	// find out how big a normal encode is, then ask for something a bit smaller.
+	err := Transcode(Profile{FFmpeg: ffmpeg}, src, dst, true)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	size, _ := fileSize(dst)
+
+	err = Transcode(Profile{FFmpeg: ffmpeg, TargetSize: size * 3 / 4}, src, dst, true)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if smaller, _ := fileSize(dst); smaller > size * 3 / 4 { t.Errorf("Expected the second pass to fit in %d bytes, got %d", size * 3 / 4, smaller) }
+}
+
+func Test_Transcode_BadInput(t *testing.T) {
+	ffmpeg := requireFFmpeg(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "garbage.webm")
+	dst := filepath.Join(dir, "out.mp4")
+	os.WriteFile(src, []byte("this is not a video"), 0600)
+
+	err := Transcode(Profile{FFmpeg: ffmpeg}, src, dst, true)
+
+	var terr *TranscodeError
+	if !errors.As(err, &terr) || terr.Stage != StageEncode || terr.Pass != 1 || terr.ExitCode == 0 { t.Fatalf("Unexpected error: %v", err) }
+}
+
+func Test_Transcode_NoFFmpeg(t *testing.T) {
+	dir := t.TempDir()
+	err := Transcode(Profile{FFmpeg: filepath.Join(dir, "no-such-ffmpeg")}, "in.webm", filepath.Join(dir, "out.mp4"), true)
+
+	var terr *TranscodeError
+	if !errors.As(err, &terr) || terr.Stage != StageEncode { t.Fatalf("Unexpected error: %v", err) }
+}
+
+func Test_TranscodeImage(t *testing.T) {
+	ffmpeg := requireFFmpeg(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "fixture.png")
+	dst := filepath.Join(dir, "out.jpg")
+
+	if out, err := exec.Command(ffmpeg, "-y", "-hide_banner", "-nostdin", "-f", "lavfi", "-i", "testsrc=size=3000x200", "-frames:v", "1", src).CombinedOutput(); err != nil {
+		t.Skipf("Couldn't generate a png fixture (%s): %s", err.Error(), out)
+	}
+
+	err := TranscodeImage(Profile{FFmpeg: ffmpeg}, src, dst)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+	out, err := os.ReadFile(dst)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if len(out) < 2 || out[0] != 0xff || out[1] != 0xd8 { t.Errorf("Output doesn't look like a jpeg") }
+}
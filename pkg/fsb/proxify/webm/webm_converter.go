@@ -2,6 +2,7 @@ package webm
 
 import (
 	"github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/fsb/metrics"
 	"github.com/thewug/fsb/pkg/storage"
 
 	"github.com/thewug/gogram"
@@ -10,52 +11,159 @@ import (
 
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
+// conversions are queued up in the database, so that pending ones survive restarts, and are worked through by a
+// small pool of workers. a conversion which fails is retried a few times, waiting longer each time, before it's
+// given up on.
+
 type webmToTelegramMp4Converter struct {
-	convert_requests chan *webm2Mp4Req
 	bot *gogram.TelegramBot
 	s settings
+
+	// wakes up an idle worker when there's a new job.
+	wake chan bool
+	waiters waitList
 }
 
 var converter *webmToTelegramMp4Converter
 
+var conversionsWaiting = metrics.NewGauge("fsb_webm_conversions_waiting", "Requests waiting for the webm converter.")
+var conversions = metrics.NewCounter("fsb_webm_conversions_total", "Webm conversion jobs, by outcome.", "outcome")
+var conversionDuration = metrics.NewHistogram("fsb_webm_conversion_duration_seconds", "Time taken to convert and upload webms which weren't already cached.", nil)
+
 type settings interface {
 	GetMediaConvertDirectory() string
-	GetWebm2Mp4ConvertScript() string
+	GetWebmProfile() Profile
 	GetMediaStoreChannel() data.ChatID
+	GetWebmConvertWorkers() int
 }
 
+const DEFAULT_CONVERT_WORKERS = 2
+
+// what a file is being converted for. webms and animations which telegram won't show inline become mp4s,
+// and images which are too big to be shown inline become jpegs.
+const (
+	KindWebm      = "webm"
+	KindAnimation = "animation"
+	KindPhoto     = "photo"
+)
+
+// a conversion is attempted this many times before it's marked as failed for good.
+const MAX_CONVERT_ATTEMPTS = 5
+
+// how often idle workers look for jobs which have become due for a retry.
+const convertPollInterval = 15 * time.Second
+
 func ConfigureWebmToTelegramMp4Converter(bot *gogram.TelegramBot, s settings) {
 	converter = &webmToTelegramMp4Converter{
 		bot: bot,
 		s: s,
-		convert_requests: make(chan *webm2Mp4Req),
+		wake: make(chan bool, 1),
+	}
+
+	// nothing can still be working on conversions which were running when the bot stopped, so start them over.
+	err := storage.DefaultTransact(func(tx storage.DBLike) error { return storage.RequeueRunningWebmConversions(tx) })
+	if err != nil { log.Println(fmt.Errorf("storage.RequeueRunningWebmConversions: %w", err)) }
+
+	workers := s.GetWebmConvertWorkers()
+	if workers < 1 { workers = DEFAULT_CONVERT_WORKERS }
+	for i := 0; i < workers; i++ {
+		go converter.convertRoutine()
+	}
+}
+
+// keeps track of who is waiting for which conversions, so that lots of requests for the same file at once only
+// convert it once.
+type waitList struct {
+	lock sync.Mutex
+	waiting map[string][]chan conversionResult
+}
+
+type conversionResult struct {
+	file_id *data.FileID
+	err error
+}
+
+// returned instead of a file when a conversion has failed but will be retried later, in the background.
+// nobody is kept waiting through the retries, the file will be in the cache once one of them succeeds.
+var ErrConversionPending = errors.New("conversion failed, but will be retried")
+
+// returned when a conversion has failed for good.
+var ErrConversionFailed = errors.New("conversion failed")
+
+// returns a channel which will receive the converted file, and whether this is the first request for it.
+func (this *waitList) add(md5 string) (chan conversionResult, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.waiting == nil { this.waiting = make(map[string][]chan conversionResult) }
+	output := make(chan conversionResult, 1)
+	first := len(this.waiting[md5]) == 0
+	this.waiting[md5] = append(this.waiting[md5], output)
+	return output, first
+}
+
+// hands a converted file, or the reason there isn't one, to everyone waiting for it.
+func (this *waitList) finish(md5 string, file_id *data.FileID, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, output := range this.waiting[md5] {
+		output <- conversionResult{file_id: file_id, err: err}
+		close(output)
 	}
+	delete(this.waiting, md5)
+}
 
-	go converter.convertRoutine()
+// waits for an mp4 file id to become available, possibly triggering a conversion and blocking until one attempt
+// at it completes. if that attempt fails, ErrConversionPending or ErrConversionFailed is returned, depending on
+// whether it will be retried.
+func GetMp4ForWebm(result *types.TPostInfo) (*data.FileID, error) {
+	return getConverted(result, KindWebm)
 }
 
-type webm2Mp4Req struct {
-	output chan *data.FileID
-	post  *types.TPostInfo
+// like GetMp4ForWebm, but for images. kind says what to convert it into.
+func GetConvertedImage(result *types.TPostInfo, kind string) (*data.FileID, error) {
+	return getConverted(result, kind)
 }
 
+func getConverted(result *types.TPostInfo, kind string) (*data.FileID, error) {
+	conversionsWaiting.Inc()
+	defer conversionsWaiting.Dec()
+
+	md5 := strings.ToLower(result.Md5)
+	output, first := converter.waiters.add(md5)
+	if first {
+		var job *storage.WebmConversionJob
+		err := storage.DefaultTransact(func(tx storage.DBLike) error {
+			err := storage.QueueWebmConversion(tx, md5, kind, result.File_url)
+			if err != nil { return fmt.Errorf("storage.QueueWebmConversion: %w", err) }
+			job, err = storage.GetWebmConversion(tx, md5)
+			if err != nil { return fmt.Errorf("storage.GetWebmConversion: %w", err) }
+			return nil
+		})
 
-// waits for an mp4 file id to become available, possibly triggering a
-// conversion and blocking until it completes.
-func GetMp4ForWebm(result *types.TPostInfo) *data.FileID {
-	req := webm2Mp4Req{
-		output: make(chan *data.FileID),
-		post: result,
+		if err != nil {
+			log.Println(err)
+			converter.waiters.finish(md5, nil, err)
+		} else if job != nil && job.Status == storage.WebmJobQueued && job.Attempts != 0 {
+			// it's already failed at least once, and is waiting to be retried, so don't wait for that.
+			converter.waiters.finish(md5, nil, ErrConversionPending)
+		}
+		converter.wakeWorker()
 	}
-	converter.convert_requests <- &req
-	return <- req.output
+
+	out := <- output
+	return out.file_id, out.err
 }
 
 // checks if an mp4 file id is available, returning it immediately if so
@@ -66,68 +174,167 @@ func CheckMp4ForWebm(tx storage.DBLike, result *types.TPostInfo) (*data.FileID,
 	return cached, nil
 }
 
-// synchronous converter routine.
-func (this webmToTelegramMp4Converter) convertRoutine() {
-	for req := range this.convert_requests {
-		err := storage.DefaultTransact(func(tx storage.DBLike) error {
-			// within this function, return = continue outer loop
-			// so I can use defer to process stuff at end of iteration
-			defer func() { close(req.output) }()
+// like CheckMp4ForWebm, but for images.
+func CheckConvertedImage(tx storage.DBLike, result *types.TPostInfo, kind string) (*data.FileID, error) {
+	cached, err := storage.FindCachedConvertedImage(tx, result.Md5, kind)
+	if err != nil { return nil, fmt.Errorf("FindCachedConvertedImage: %w", err) }
+	return cached, nil
+}
 
-			cached, err := storage.FindCachedMp4ForWebm(tx, req.post.Md5)
-			if err != nil { return fmt.Errorf("storage.FindCachedMp4ForWebm: %w", err) }
+func findCached(tx storage.DBLike, md5, kind string) (*data.FileID, error) {
+	if kind == KindWebm {
+		return storage.FindCachedMp4ForWebm(tx, md5)
+	}
+	return storage.FindCachedConvertedImage(tx, md5, kind)
+}
 
-			if cached != nil {
-				req.output <- cached
-				return nil
-			}
+func saveCached(tx storage.DBLike, md5, kind string, file_id data.FileID) error {
+	if kind == KindWebm {
+		return storage.SaveCachedMp4ForWebm(tx, md5, file_id)
+	}
+	return storage.SaveCachedConvertedImage(tx, md5, kind, file_id)
+}
 
-			converted_file, err := this.convertFile(req.post.File_url, true)
-			if err != nil { return fmt.Errorf("webm.convertFile: %w", err) }
+func (this *webmToTelegramMp4Converter) wakeWorker() {
+	select {
+	case this.wake <- true:
+	default: // a wakeup is already pending
+	}
+}
 
-			cached, err = this.uploadConvertedFileToTelegram(converted_file)
-			if err != nil { return fmt.Errorf("webm.uploadConvertedFileToTelegram: %w", err) }
+// worker routine, several of these run at once.
+func (this *webmToTelegramMp4Converter) convertRoutine() {
+	for {
+		var job *storage.WebmConversionJob
+		err := storage.DefaultTransact(func(tx storage.DBLike) error {
+			var err error
+			job, err = storage.ClaimWebmConversion(tx)
+			return err
+		})
+		if err != nil { log.Println(fmt.Errorf("storage.ClaimWebmConversion: %w", err)) }
+
+		if job == nil {
+			select {
+			case <- this.wake:
+			case <- time.After(convertPollInterval):
+			}
+			continue
+		}
 
-			req.output <- cached
+		// there might be more where that came from, so get another worker looking too.
+		this.wakeWorker()
+		this.runJob(job)
+	}
+}
 
-			err = storage.SaveCachedMp4ForWebm(tx, req.post.Md5, *cached)
-			if err != nil { return fmt.Errorf("storage.SaveCachedMp4ForWebm: %w", err) }
+func (this *webmToTelegramMp4Converter) runJob(job *storage.WebmConversionJob) {
+	start := time.Now()
+	outcome := "converted"
 
-			return nil
+	var file_id *data.FileID
+	err := func() error {
+		err := storage.DefaultTransact(func(tx storage.DBLike) error {
+			var err error
+			file_id, err = findCached(tx, job.Md5, job.Kind)
+			return err
 		})
+		if err != nil { return fmt.Errorf("webm.findCached: %w", err) }
+
+		if file_id != nil {
+			outcome = "cached"
+			return nil
+		}
+
+		converted_file, err := this.convertFile(job.FileURL, job.Kind)
+		if err != nil { return fmt.Errorf("webm.convertFile: %w", err) }
+
+		if job.Kind == KindPhoto {
+			file_id, err = this.uploadConvertedPhotoToTelegram(converted_file)
+			if err != nil { return fmt.Errorf("webm.uploadConvertedPhotoToTelegram: %w", err) }
+		} else {
+			file_id, err = this.uploadConvertedFileToTelegram(converted_file)
+			if err != nil { return fmt.Errorf("webm.uploadConvertedFileToTelegram: %w", err) }
+		}
 
-		// if an error occurs, there's not a lot we can do about it, so just log it and soldier on
+		return nil
+	}()
+
+	if err == nil {
+		err = storage.DefaultTransact(func(tx storage.DBLike) error {
+			if outcome == "converted" {
+				err := saveCached(tx, job.Md5, job.Kind, *file_id)
+				if err != nil { return fmt.Errorf("webm.saveCached: %w", err) }
+			}
+			return storage.FinishWebmConversion(tx, job.Md5)
+		})
+		// the job will be redone after a restart, but there's no reason to keep anyone waiting on it now.
 		if err != nil { log.Println(err) }
+
+		if outcome == "converted" { conversionDuration.ObserveSince(start) }
+		conversions.Inc(outcome)
+		this.waiters.finish(job.Md5, file_id, nil)
+		return
 	}
-}
 
-func (this webmToTelegramMp4Converter) convertFile(url string, strip_audio bool) (reqtify.FormFile, error) {
-	if _, err := os.Stat(this.s.GetWebm2Mp4ConvertScript()); os.IsNotExist(err) {
-		return reqtify.FormFile{}, err
+	log.Printf("Conversion of %s (%s) failed (attempt %d): %s\n", job.Md5, job.Kind, job.Attempts + 1, err.Error())
+
+	var retry_at *time.Time
+	if job.Attempts + 1 < MAX_CONVERT_ATTEMPTS {
+		t := time.Now().Add(retryDelay(job.Attempts + 1))
+		retry_at = &t
 	}
 
+	reason := err.Error()
+	err = storage.DefaultTransact(func(tx storage.DBLike) error { return storage.FailWebmConversion(tx, job.Md5, reason, retry_at) })
+	if err != nil { log.Println(fmt.Errorf("storage.FailWebmConversion: %w", err)) }
+
+	if retry_at != nil {
+		conversions.Inc("retry")
+		this.waiters.finish(job.Md5, nil, ErrConversionPending)
+	} else {
+		conversions.Inc("failed")
+		this.waiters.finish(job.Md5, nil, ErrConversionFailed)
+	}
+}
+
+// how long to wait before trying a conversion again after it has failed some number of times.
+// starts at 30 seconds, and doubles each time.
+func retryDelay(failures int) time.Duration {
+	if failures < 1 { failures = 1 }
+	return (30 * time.Second) << uint(failures - 1)
+}
+
+// animations are always converted without sound, since telegram shows them inline like gifs anyway.
+func (this *webmToTelegramMp4Converter) convertFile(url string, kind string) (reqtify.FormFile, error) {
 	var file reqtify.FormFile
 	resp, err := http.Get(url)
 	if err != nil {
-		return file, err
+		return file, &TranscodeError{Stage: StageDownload, Err: err}
 	} else if resp.StatusCode != 200 {
-		return file, errors.New("Request failed: " + resp.Status)
-	} else if resp.Body == nil {
-		return file, errors.New("Request succeeded, but has empty body!")
+		resp.Body.Close()
+		return file, &TranscodeError{Stage: StageDownload, Err: errors.New("Request failed: " + resp.Status)}
 	}
 
 	defer resp.Body.Close()
 
-	base_name := path.Base(url) + map[bool]string{false: ".mp4", true: ".silent.mp4"}[strip_audio]
+	base_name := path.Base(url) + map[bool]string{false: ".silent.mp4", true: ".jpg"}[kind == KindPhoto]
 	out_name := this.s.GetMediaConvertDirectory() + base_name
-	cmd := exec.Command(this.s.GetWebm2Mp4ConvertScript(), out_name,
-	                    map[bool]string{false: "audio", true: "noaudio"}[strip_audio])
-	cmd.Stdin = resp.Body
-	err = cmd.Run()
-	if err != nil {
-		os.Remove(out_name)
-		return file, err
+	in_name := out_name + ".src"
+
+	source, err := os.Create(in_name)
+	if err != nil { return file, &TranscodeError{Stage: StageDownload, Err: err} }
+	defer os.Remove(in_name)
+
+	_, err = io.Copy(source, resp.Body)
+	if close_err := source.Close(); err == nil { err = close_err }
+	if err != nil { return file, &TranscodeError{Stage: StageDownload, Err: err} }
+
+	if kind == KindPhoto {
+		err = TranscodeImage(this.s.GetWebmProfile(), in_name, out_name)
+	} else {
+		err = Transcode(this.s.GetWebmProfile(), in_name, out_name, true)
 	}
+	if err != nil { return file, err }
 
 	converted, err := os.Open(out_name)
 	if err != nil {
@@ -146,7 +353,7 @@ func (this webmToTelegramMp4Converter) convertFile(url string, strip_audio bool)
 	return file, err
 }
 
-func (this webmToTelegramMp4Converter) uploadConvertedFileToTelegram(file reqtify.FormFile) (*data.FileID, error) {
+func (this *webmToTelegramMp4Converter) uploadConvertedFileToTelegram(file reqtify.FormFile) (*data.FileID, error) {
 	message, err := this.bot.Remote.SendAnimation(data.OAnimation{
 		SendData: data.SendData{
 			TargetData: data.TargetData{
@@ -171,3 +378,33 @@ func (this webmToTelegramMp4Converter) uploadConvertedFileToTelegram(file reqtif
 		return nil, errors.New("Unexpected error condition")
 	}
 }
+
+func (this *webmToTelegramMp4Converter) uploadConvertedPhotoToTelegram(file reqtify.FormFile) (*data.FileID, error) {
+	message, err := this.bot.Remote.SendPhoto(data.OPhoto{
+		SendData: data.SendData{
+			TargetData: data.TargetData{
+				ChatId: this.s.GetMediaStoreChannel(),
+			},
+			Text: file.Name,
+		},
+		MediaData: data.MediaData{
+			File: file,
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	} else if message == nil {
+		return nil, errors.New("Nil message returned for seemingly successful call?")
+	} else if message.Photo == nil || len(*message.Photo) == 0 {
+		return nil, errors.New("message sent successfully, but not of type photo?")
+	}
+
+	// telegram sends back every size it made, and the biggest one is the one we sent.
+	photos := *message.Photo
+	largest := photos[0]
+	for _, p := range photos {
+		if p.Width * p.Height > largest.Width * largest.Height { largest = p }
+	}
+	return &largest.Id, nil
+}
@@ -0,0 +1,55 @@
+package webm
+
+import (
+	"github.com/thewug/gogram/data"
+
+	"testing"
+	"time"
+)
+
+func Test_retryDelay(t *testing.T) {
+	testcases := map[string]struct {
+		failures int
+		expected time.Duration
+	}{
+		"first":  {1, 30 * time.Second},
+		"second": {2, time.Minute},
+		"fourth": {4, 4 * time.Minute},
+		"zero":   {0, 30 * time.Second},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := retryDelay(v.failures); out != v.expected { t.Errorf("Unexpected delay: got %s, expected %s", out, v.expected) }
+		})
+	}
+}
+
+func Test_waitList(t *testing.T) {
+	var w waitList
+
+	first, is_first := w.add("abc")
+	if !is_first { t.Errorf("Expected the first request to be first") }
+	second, is_first := w.add("abc")
+	if is_first { t.Errorf("Expected the second request not to be first") }
+	other, is_first := w.add("def")
+	if !is_first { t.Errorf("Expected a request for a different file to be first") }
+
+	id := data.FileID("converted")
+	w.finish("abc", &id, nil)
+
+	for _, output := range []chan conversionResult{first, second} {
+		if out := <- output; out.err != nil || out.file_id == nil || *out.file_id != id { t.Errorf("Unexpected result: %v", out) }
+	}
+
+	select {
+	case out := <- other:
+		t.Errorf("Unexpected result for another file: %v", out)
+	default:
+	}
+
+	w.finish("def", nil, ErrConversionPending)
+	if out := <- other; out.file_id != nil || out.err != ErrConversionPending { t.Errorf("Expected a pending conversion, got %v", out) }
+
+	if _, is_first = w.add("abc"); !is_first { t.Errorf("Expected a request after finishing to be first again") }
+}
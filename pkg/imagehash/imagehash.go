@@ -0,0 +1,118 @@
+// Package imagehash computes perceptual hashes of images, which stay nearly the same when an image is resized,
+// recompressed or slightly edited, so that reposts of the same picture can be found even when the files differ.
+package imagehash
+
+import (
+	"github.com/thewug/gogram"
+	"github.com/thewug/gogram/data"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"net/http"
+	"time"
+)
+
+// hashes this many bits apart or fewer are very probably the same picture.
+const LIKELY_MATCH = 8
+
+// hashes this many bits apart or fewer might be the same picture, with edits, crops or a different background.
+const POSSIBLE_MATCH = 14
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// returned for files which aren't a kind of image that can be hashed, such as videos.
+var ErrUnsupported = errors.New("unsupported image format")
+
+// returned by FromURL for images which were downloaded, but couldn't be decoded.
+var ErrUndecodable = errors.New("image can't be decoded")
+
+// the biggest image FromURL will download.
+const MAX_URL_IMAGE_SIZE = 20 * 1024 * 1024
+
+// Hash computes a 64 bit difference hash: the image is shrunk to 9x8 grayscale, and each bit records whether a
+// pixel is brighter than the one to its right.
+func Hash(img image.Image) uint64 {
+	var cells [8][9]float64
+	var counts [8][9]int
+
+	b := img.Bounds()
+	if b.Empty() { return 0 }
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * 8 / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			col := (x - b.Min.X) * 9 / b.Dx()
+			cells[row][col] += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash <<= 1
+			left, right := cells[row][col], cells[row][col + 1]
+			if counts[row][col] != 0 { left /= float64(counts[row][col]) }
+			if counts[row][col + 1] != 0 { right /= float64(counts[row][col + 1]) }
+			if left > right { hash |= 1 }
+		}
+	}
+	return hash
+}
+
+// Distance counts how many bits differ between two hashes. 0 is identical, 64 is as different as possible.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Similarity is the percentage of bits which two hashes share, which is friendlier to show people than a distance.
+func Similarity(distance int) int {
+	return (64 - distance) * 100 / 64
+}
+
+// FromReader decodes a jpg, png or gif image and hashes it.
+func FromReader(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err == image.ErrFormat { return 0, ErrUnsupported }
+	if err != nil { return 0, err }
+	return Hash(img), nil
+}
+
+// FromURL downloads an image and hashes it. the whole image is downloaded before it's decoded, so that an image which
+// can't be hashed (ErrUnsupported or ErrUndecodable) can be told apart from one which couldn't be downloaded.
+func FromURL(url string) (uint64, error) {
+	resp, err := client.Get(url)
+	if err != nil { return 0, err }
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 { return 0, errors.New("Request failed: " + resp.Status) }
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_URL_IMAGE_SIZE + 1))
+	if err != nil { return 0, err }
+	if len(body) > MAX_URL_IMAGE_SIZE { return 0, ErrUnsupported }
+
+	hash, err := FromReader(bytes.NewReader(body))
+	if err != nil && err != ErrUnsupported { return 0, fmt.Errorf("%w: %s", ErrUndecodable, err.Error()) }
+	return hash, err
+}
+
+// FromTelegram downloads a file which was sent to the bot and hashes it.
+func FromTelegram(bot *gogram.TelegramBot, id data.FileID) (uint64, error) {
+	file, err := bot.Remote.GetFile(data.OGetFile{Id: id})
+	if err != nil { return 0, err }
+	if file == nil || file.FilePath == nil { return 0, errors.New("Telegram didn't return a file path") }
+
+	body, err := bot.Remote.DownloadFile(data.OFile{FilePath: *file.FilePath})
+	if err != nil { return 0, err }
+	if body == nil { return 0, errors.New("Telegram didn't return any file data") }
+	defer body.Close()
+
+	return FromReader(body)
+}
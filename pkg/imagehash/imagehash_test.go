@@ -0,0 +1,110 @@
+package imagehash
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a picture with some structure to it: diagonal stripes with a bright block, scaled to any size.
+func picture(w, h int, flip bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := x * 100 / w, y * 100 / h
+			if flip { fx = 99 - fx }
+			v := uint8((fx * 3 + fy * 2) % 100 * 2)
+			if fx > 20 && fx < 45 && fy > 30 && fy < 70 { v = 255 - v / 4 }
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func Test_Hash(t *testing.T) {
+	original := Hash(picture(300, 200, false))
+
+	testcases := map[string]struct{
+		img     image.Image
+		similar bool
+	}{
+		"same": {picture(300, 200, false), true},
+		"smaller": {picture(150, 100, false), true},
+		"stretched": {picture(400, 220, false), true},
+		"mirrored": {picture(300, 200, true), false},
+		"blank": {image.NewGray(image.Rect(0, 0, 300, 200)), false},
+		"empty": {image.NewGray(image.Rect(0, 0, 0, 0)), false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			d := Distance(original, Hash(v.img))
+			if v.similar && d > LIKELY_MATCH { t.Errorf("Expected a match, but distance was %d", d) }
+			if !v.similar && d <= POSSIBLE_MATCH { t.Errorf("Expected no match, but distance was %d", d) }
+		})
+	}
+}
+
+func Test_FromReader(t *testing.T) {
+	var b bytes.Buffer
+	img := picture(64, 64, false)
+	if err := png.Encode(&b, img); err != nil { t.Fatalf("Couldn't encode test image: %s", err.Error()) }
+
+	hash, err := FromReader(&b)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if hash != Hash(img) { t.Errorf("Hash changed after encoding: %016x, expected %016x", hash, Hash(img)) }
+
+	if _, err := FromReader(bytes.NewBufferString("not an image")); err != ErrUnsupported { t.Errorf("Unexpected error decoding garbage: %v", err) }
+}
+
+func Test_FromURL(t *testing.T) {
+	var b bytes.Buffer
+	img := picture(64, 64, false)
+	if err := png.Encode(&b, img); err != nil { t.Fatalf("Couldn't encode test image: %s", err.Error()) }
+	encoded := b.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Write(encoded)
+		case "/truncated.png":
+			w.Write(encoded[:len(encoded) / 2])
+		case "/video.webm":
+			w.Write([]byte("not an image"))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if hash, err := FromURL(server.URL + "/image.png"); err != nil || hash != Hash(img) { t.Errorf("Unexpected result: %016x, %v", hash, err) }
+
+	// only images which are there, but can't be hashed, are reported as such. anything else might work next time.
+	testcases := map[string]struct{
+		path        string
+		unhashable  bool
+	}{
+		"truncated": {"/truncated.png", true},
+		"unsupported": {"/video.webm", true},
+		"unavailable": {"/down.png", false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			_, err := FromURL(server.URL + v.path)
+			if err == nil { t.Fatalf("Expected an error") }
+			if unhashable := errors.Is(err, ErrUnsupported) || errors.Is(err, ErrUndecodable); unhashable != v.unhashable { t.Errorf("Unexpected error: %s", err.Error()) }
+		})
+	}
+}
+
+func Test_Similarity(t *testing.T) {
+	if s := Similarity(0); s != 100 { t.Errorf("Unexpected similarity %d", s) }
+	if s := Similarity(64); s != 0 { t.Errorf("Unexpected similarity %d", s) }
+	if s := Similarity(Distance(0xFF, 0x0F)); s != 93 { t.Errorf("Unexpected similarity %d", s) }
+}
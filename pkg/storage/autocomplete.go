@@ -0,0 +1,203 @@
+package storage
+
+import (
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+	"github.com/thewug/fsb/pkg/wordset"
+
+	"github.com/thewug/dml"
+
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// a possible completion for a partially typed tag. Name is what matched, which is either the tag itself
+// or one of its aliases, and Tag is always the real tag it resolves to.
+type TagCompletion struct {
+	Name     string               `dml:"name"`
+	Tag      string               `dml:"tag"`
+	Count    int                  `dml:"tag_count"`
+	Type     apitypes.TagCategory `dml:"tag_type"`
+	Distance int                  // how many typos away from what was typed, set by RankTagCompletions.
+}
+
+// how many candidates are fetched from each source before ranking. typo tolerant matching only looks at the
+// most popular tags, since there are far too many obscure ones to check them all.
+const tagCompletionPrefixCandidates = 50
+const tagCompletionTypoCandidates = 2000
+
+// returns true if a tag with this exact name is in use, or if it's an alias of one.
+func IsKnownTag(d DBLike, name string) (bool, error) {
+	query := `
+SELECT	EXISTS (SELECT 1 FROM tag_index WHERE tag_name = $1 AND tag_count > 0) OR
+	EXISTS (SELECT 1 FROM alias_index WHERE alias_name = $1)
+`
+	var known bool
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, strings.ToLower(name)).Scan(&known) })
+	return known, err
+}
+
+// suggests up to limit tags for something which has been partially typed, best first.
+func CompleteTag(d DBLike, typed string, limit int) ([]TagCompletion, error) {
+	typed = strings.ToLower(strings.TrimSpace(typed))
+	if typed == "" { return nil, nil }
+
+	candidates, err := GetTagCompletionCandidates(d, typed)
+	if err != nil { return nil, err }
+	return RankTagCompletions(typed, candidates, limit), nil
+}
+
+// suggests up to limit tags for something which was meant to be a whole tag, but isn't one. returns nothing if it is.
+func SuggestTags(d DBLike, typed string, limit int) ([]TagCompletion, error) {
+	known, err := IsKnownTag(d, typed)
+	if err != nil || known { return nil, err }
+	return CompleteTag(d, typed, limit)
+}
+
+// suggestions are asked for over and over as people type, and tags don't change often enough for it to matter
+// if they're a little out of date, so they're kept for a while. the zero value is ready to use.
+type TagSuggestionCache struct {
+	lock    sync.Mutex
+	entries map[string]tagSuggestionEntry
+}
+
+type tagSuggestionEntry struct {
+	completions []TagCompletion
+	expires     time.Time
+}
+
+const TAG_SUGGESTION_CACHE_LIFETIME = 10 * time.Minute
+const TAG_SUGGESTION_CACHE_SIZE = 10000
+
+// returns the cached suggestions for typed, or calls lookup to find them if there aren't any. failed lookups
+// aren't cached.
+func (this *TagSuggestionCache) Get(typed string, lookup func(string) ([]TagCompletion, error)) ([]TagCompletion, error) {
+	typed = strings.ToLower(typed)
+	now := time.Now()
+
+	this.lock.Lock()
+	entry, ok := this.entries[typed]
+	this.lock.Unlock()
+	if ok && now.Before(entry.expires) { return entry.completions, nil }
+
+	completions, err := lookup(typed)
+	if err != nil { return nil, err }
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.entries == nil { this.entries = make(map[string]tagSuggestionEntry) }
+	if len(this.entries) >= TAG_SUGGESTION_CACHE_SIZE {
+		for k, v := range this.entries {
+			if !now.Before(v.expires) { delete(this.entries, k) }
+		}
+		// still full of things which are in use, so just start over.
+		if len(this.entries) >= TAG_SUGGESTION_CACHE_SIZE { this.entries = make(map[string]tagSuggestionEntry) }
+	}
+	this.entries[typed] = tagSuggestionEntry{completions: completions, expires: now.Add(TAG_SUGGESTION_CACHE_LIFETIME)}
+	return completions, nil
+}
+
+// fetches everything which might be a completion of typed: tags and aliases which start with it, and popular tags
+// which start with the same letter, in case of typos. these are unranked and contain lots of bad matches, see
+// RankTagCompletions.
+func GetTagCompletionCandidates(d DBLike, typed string) ([]TagCompletion, error) {
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(typed)
+	first, _ := utf8.DecodeRuneInString(prefix)
+	if first == '\\' { first, _ = utf8.DecodeRuneInString(prefix[1:]) }
+
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"SELECT tag_name AS name, tag_name AS tag, tag_count, tag_type FROM tag_index WHERE tag_name LIKE $1 AND tag_count > 0 ORDER BY tag_count DESC LIMIT $2",
+			[]interface{}{prefix + "%", tagCompletionPrefixCandidates}},
+		{"SELECT alias_name AS name, tag_name AS tag, tag_count, tag_type FROM alias_index INNER JOIN tag_index ON alias_target_id = tag_id WHERE alias_name LIKE $1 AND tag_count > 0 ORDER BY tag_count DESC LIMIT $2",
+			[]interface{}{prefix + "%", tagCompletionPrefixCandidates}},
+		{"SELECT tag_name AS name, tag_name AS tag, tag_count, tag_type FROM tag_index WHERE tag_name LIKE $1 AND tag_count > 0 ORDER BY tag_count DESC LIMIT $2",
+			[]interface{}{string(first) + "%", tagCompletionTypoCandidates}},
+	}
+
+	var out []TagCompletion
+	err := d.Enter(func(tx Queryable) error {
+		for _, q := range queries {
+			var some []TagCompletion
+			rows, err := dml.X(tx.Query(q.query, q.args...))
+			if err != nil { return err }
+			err = dml.ScanArray(rows, &some)
+			rows.Close()
+			if err != nil { return err }
+			out = append(out, some...)
+		}
+		return nil
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// how many typos are forgiven, which depends on how much has been typed. very short strings get none at all,
+// since nearly everything is a typo or two away from them.
+func tagTypoTolerance(typed string) int {
+	length := utf8.RuneCountInString(typed)
+	if length <= 3 {
+		return 0
+	} else if length <= 6 {
+		return 1
+	}
+	return 2
+}
+
+// how far name is from being a completion of typed. anything which starts with typed is 0, otherwise it's the
+// smallest edit distance between typed and a similarly long start of name. returns -1 if it's too far off.
+func tagCompletionDistance(typed, name string) int {
+	if strings.HasPrefix(name, typed) { return 0 }
+
+	tolerance := tagTypoTolerance(typed)
+	if tolerance == 0 { return -1 }
+
+	length := utf8.RuneCountInString(typed)
+	best := -1
+	for n := length - tolerance; n <= length + tolerance; n++ {
+		if n < 1 { continue }
+		start, _ := wordset.Utf8Split(name, n)
+		if distance := wordset.Levenshtein(typed, start); distance <= tolerance && (best == -1 || distance < best) {
+			best = distance
+		}
+	}
+	return best
+}
+
+// picks the best completions out of a list of candidates, resolving aliases and removing duplicates.
+// exact prefix matches come first, then those with fewer typos, and more popular tags before less popular ones.
+func RankTagCompletions(typed string, candidates []TagCompletion, limit int) []TagCompletion {
+	typed = strings.ToLower(typed)
+	best := make(map[string]TagCompletion)
+
+	for _, c := range candidates {
+		c.Distance = tagCompletionDistance(typed, c.Name)
+		if c.Distance < 0 { continue }
+
+		// prefer matching the tag itself over one of its aliases, and closer matches over worse ones.
+		if existing, ok := best[c.Tag]; ok {
+			if existing.Distance < c.Distance { continue }
+			if existing.Distance == c.Distance && existing.Name == existing.Tag { continue }
+		}
+		best[c.Tag] = c
+	}
+
+	var out []TagCompletion
+	for _, c := range best { out = append(out, c) }
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Distance != out[j].Distance { return out[i].Distance < out[j].Distance }
+		if out[i].Count != out[j].Count { return out[i].Count > out[j].Count }
+		return out[i].Tag < out[j].Tag
+	})
+
+	if limit > 0 && len(out) > limit { out = out[:limit] }
+	return out
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_tagCompletionDistance(t *testing.T) {
+	testcases := map[string]struct{
+		typed, name string
+		expected int
+	}{
+		"prefix": {"cani", "canine", 0},
+		"exact": {"canine", "canine", 0},
+		"short typo": {"cna", "canine", -1},
+		"one typo": {"cbnine", "canine", 1},
+		"transposed": {"cnaine", "canine", -1},
+		"typo in prefix": {"cannin", "canine", 1},
+		"missing letter": {"canne", "canine", 1},
+		"long two typos": {"blue_eeys", "blue_eyes", 2},
+		"too far": {"dog", "canine", -1},
+		"longer than name": {"caninexyz", "canine", -1},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := tagCompletionDistance(v.typed, v.name); out != v.expected { t.Errorf("Unexpected distance: got %d, expected %d", out, v.expected) }
+		})
+	}
+}
+
+func Test_RankTagCompletions(t *testing.T) {
+	candidates := []TagCompletion{
+		{Name: "canine", Tag: "canine", Count: 500},
+		{Name: "canid", Tag: "canid", Count: 1000},
+		{Name: "canis", Tag: "canis", Count: 10},
+		{Name: "canine_penis", Tag: "canine_genitalia", Count: 200}, // an alias
+		{Name: "canine_genitalia", Tag: "canine_genitalia", Count: 200},
+		{Name: "cat", Tag: "felid", Count: 2000},
+		{Name: "cbnine", Tag: "canine_typo", Count: 5},
+	}
+
+	testcases := map[string]struct{
+		typed string
+		limit int
+		expected []string
+	}{
+		"prefix by count": {"cani", 0, []string{"canid", "canine", "canine_genitalia", "canis", "canine_typo"}},
+		"limited": {"cani", 2, []string{"canid", "canine"}},
+		"typos after prefixes": {"canine", 0, []string{"canine", "canine_genitalia", "canine_typo"}},
+		"alias resolves": {"canine_p", 0, []string{"canine_genitalia", "canine"}},
+		"nothing": {"xyz", 0, nil},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			var out []string
+			for _, c := range RankTagCompletions(v.typed, candidates, v.limit) { out = append(out, c.Tag) }
+			if !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %v, expected %v", out, v.expected) }
+		})
+	}
+
+	out := RankTagCompletions("canine_", candidates, 0)
+	if len(out) == 0 || out[0].Tag != "canine_genitalia" || out[0].Name != "canine_genitalia" { t.Errorf("Expected the tag to be preferred over its alias: %+v", out) }
+}
+
+func Test_TagSuggestionCache(t *testing.T) {
+	var cache TagSuggestionCache
+	lookups := 0
+	lookup := func(typed string) ([]TagCompletion, error) {
+		lookups++
+		if typed == "broken" { return nil, errors.New("lookup failed") }
+		return []TagCompletion{{Name: typed + "_fixed", Tag: typed + "_fixed"}}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		out, err := cache.Get("Cnaine", lookup)
+		if err != nil { t.Errorf("Unexpected error: %s", err.Error()) }
+		if len(out) != 1 || out[0].Tag != "cnaine_fixed" { t.Errorf("Unexpected suggestions: %v", out) }
+	}
+	if lookups != 1 { t.Errorf("Expected one lookup for a cached suggestion, got %d", lookups) }
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get("broken", lookup); err == nil { t.Errorf("Expected an error from a failed lookup") }
+	}
+	if lookups != 3 { t.Errorf("Expected failed lookups not to be cached, got %d lookups", lookups) }
+
+	cache.entries["cnaine"] = tagSuggestionEntry{expires: time.Now().Add(-time.Second)}
+	if out, _ := cache.Get("cnaine", lookup); len(out) != 1 || lookups != 4 { t.Errorf("Expected an expired suggestion to be looked up again, got %v", out) }
+}
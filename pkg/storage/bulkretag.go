@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"github.com/lib/pq"
+	tgdata "github.com/thewug/gogram/data"
+)
+
+type BulkRetagAction struct {
+	Id             int64         `dml:"action_id"`
+	TelegramUserId tgdata.UserID `dml:"telegram_user_id"`
+	PostId         int           `dml:"post_id"`
+	Expression     string        `dml:"expression"`
+	TagDiff        string        `dml:"tag_diff"`
+	Timestamp      time.Time     `dml:"action_ts"`
+}
+
+// renders a serialized tag expression into a boolean SQL condition which is true for every post whose tags satisfy it.
+// the expression template may refer to {{.tag_id}}, {{.tag_index}}, {{.tag_name}} and {{.temp}}, which are
+// filled in here, and to any number of {{.tokenN}} placeholders, which are quoted and filled in from tokens.
+func expressionCondition(expression string, tokens map[string]string) (string, error) {
+	replace := make(map[string]string)
+	for k, v := range tokens {
+		replace[k] = pq.QuoteLiteral(v)
+	}
+	replace["tag_id"] = "tag_id"
+	replace["tag_index"] = "tag_index"
+	replace["tag_name"] = "tag_name"
+	replace["temp"] = "(SELECT tag_id FROM post_tags WHERE post_tags.post_id = post_index.post_id) AS x"
+
+	t, err := template.New("expression").Option("missingkey=error").Parse(expression)
+	if err != nil { return "", err }
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, replace)
+	return buf.String(), err
+}
+
+// lists the ids of all non-deleted posts in the local index which match a serialized tag expression, in ascending order.
+func PostsMatchingExpression(d DBLike, expression string, tokens map[string]string) ([]int, error) {
+	condition, err := expressionCondition(expression, tokens)
+	if err != nil { return nil, err }
+
+	query := "SELECT post_id FROM post_index WHERE NOT post_deleted AND (" + condition + ") ORDER BY post_id"
+	var out []int
+
+	err = d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			err = rows.Scan(&id)
+			if err != nil { return err }
+			out = append(out, id)
+		}
+
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+func AddBulkRetagAction(d DBLike, action *BulkRetagAction) error {
+	query := "INSERT INTO bulk_retag_actions (action_id, telegram_user_id, post_id, expression, tag_diff, action_ts) VALUES (default, $1, $2, $3, $4, $5) RETURNING action_id"
+
+	return d.Enter(func(tx Queryable) error {
+		return tx.QueryRow(query, action.TelegramUserId, action.PostId, action.Expression, action.TagDiff, action.Timestamp).Scan(&action.Id)
+	})
+}
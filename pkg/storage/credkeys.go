@@ -0,0 +1,97 @@
+package storage
+
+import (
+	tgtypes "github.com/thewug/gogram/data"
+
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// API keys are encrypted with AES-256-GCM before they're stored. every key has a version number, which is stored
+// next to each credential encrypted with it, so keys can be rotated: old keys are kept around to read existing
+// credentials until they've all been re-encrypted with the new one. version 0 is a credential stored as plaintext.
+type CredentialKeys struct {
+	keys    map[int]cipher.AEAD
+	current int
+}
+
+const PLAINTEXT_KEY_VERSION = 0
+
+var ErrUnknownKeyVersion = errors.New("no key configured for this credential's key version")
+
+var credentialKeys = &CredentialKeys{}
+
+// builds a key set from raw 32 byte keys. new credentials are encrypted with the key for version current,
+// or stored as plaintext if current is 0.
+func NewCredentialKeys(keys map[int][]byte, current int) (*CredentialKeys, error) {
+	out := &CredentialKeys{keys: make(map[int]cipher.AEAD), current: current}
+	for version, key := range keys {
+		if version <= PLAINTEXT_KEY_VERSION { return nil, fmt.Errorf("key version %d: versions must be positive", version) }
+		if len(key) != 32 { return nil, fmt.Errorf("key version %d: keys must be 32 bytes, not %d", version, len(key)) }
+
+		block, err := aes.NewCipher(key)
+		if err != nil { return nil, fmt.Errorf("key version %d: %w", version, err) }
+		out.keys[version], err = cipher.NewGCM(block)
+		if err != nil { return nil, fmt.Errorf("key version %d: %w", version, err) }
+	}
+
+	if _, ok := out.keys[current]; !ok && current != PLAINTEXT_KEY_VERSION {
+		return nil, fmt.Errorf("no key for current key version %d", current)
+	}
+	return out, nil
+}
+
+// sets the keys used to read and write stored credentials.
+func SetCredentialKeys(keys *CredentialKeys) {
+	credentialKeys = keys
+}
+
+// the key version new credentials are written with.
+func CurrentKeyVersion() int {
+	return credentialKeys.current
+}
+
+// whether a key is configured for a version.
+func HasKeyVersion(version int) bool {
+	_, ok := credentialKeys.keys[version]
+	return ok || version == PLAINTEXT_KEY_VERSION
+}
+
+// the owner's telegram id is authenticated along with the key, so an encrypted key can't be copied onto another row.
+func credentialAD(owner tgtypes.UserID) []byte {
+	return []byte(strconv.FormatInt(int64(owner), 10))
+}
+
+// encrypts an api key with the current key, returning it base64 encoded along with the version used.
+func (this *CredentialKeys) Encrypt(api_key string, owner tgtypes.UserID) (string, int, error) {
+	if this.current == PLAINTEXT_KEY_VERSION { return api_key, PLAINTEXT_KEY_VERSION, nil }
+
+	aead := this.keys[this.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil { return "", 0, err }
+
+	sealed := aead.Seal(nonce, nonce, []byte(api_key), credentialAD(owner))
+	return base64.StdEncoding.EncodeToString(sealed), this.current, nil
+}
+
+// decrypts an api key which was encrypted with the given key version.
+func (this *CredentialKeys) Decrypt(stored string, version int, owner tgtypes.UserID) (string, error) {
+	if version == PLAINTEXT_KEY_VERSION { return stored, nil }
+
+	aead, ok := this.keys[version]
+	if !ok { return "", ErrUnknownKeyVersion }
+
+	sealed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil { return "", err }
+	if len(sealed) < aead.NonceSize() { return "", errors.New("stored credential is too short") }
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], credentialAD(owner))
+	if err != nil { return "", err }
+	return string(plain), nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	tgtypes "github.com/thewug/gogram/data"
+
+	"bytes"
+	"testing"
+)
+
+func Test_CredentialKeys(t *testing.T) {
+	old_key, new_key := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	old_keys, err := NewCredentialKeys(map[int][]byte{1: old_key}, 1)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	rotated, err := NewCredentialKeys(map[int][]byte{1: old_key, 2: new_key}, 2)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	plaintext, err := NewCredentialKeys(nil, PLAINTEXT_KEY_VERSION)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+
+	stored, version, err := old_keys.Encrypt("hunter2", 1234)
+	if err != nil { t.Fatalf("Unexpected error: %s", err.Error()) }
+	if version != 1 || stored == "hunter2" { t.Errorf("Unexpected encryption: %q, version %d", stored, version) }
+
+	testcases := map[string]struct{
+		keys *CredentialKeys
+		stored string
+		version int
+		owner tgtypes.UserID
+		expected string
+		fails bool
+	}{
+		"same keys": {old_keys, stored, 1, 1234, "hunter2", false},
+		"after rotation": {rotated, stored, 1, 1234, "hunter2", false},
+		"plaintext": {rotated, "hunter2", PLAINTEXT_KEY_VERSION, 1234, "hunter2", false},
+		"wrong owner": {old_keys, stored, 1, 4321, "", true},
+		"unknown version": {plaintext, stored, 1, 1234, "", true},
+		"wrong version": {rotated, stored, 2, 1234, "", true},
+		"garbage": {old_keys, "not base64!", 1, 1234, "", true},
+		"truncated": {old_keys, "AAAA", 1, 1234, "", true},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out, err := v.keys.Decrypt(v.stored, v.version, v.owner)
+			if (err != nil) != v.fails { t.Errorf("Unexpected error: %v", err) }
+			if out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+
+	if stored, version, _ := plaintext.Encrypt("hunter2", 1234); stored != "hunter2" || version != PLAINTEXT_KEY_VERSION {
+		t.Errorf("Expected plaintext, got %q, version %d", stored, version)
+	}
+	if again, _, _ := old_keys.Encrypt("hunter2", 1234); again == stored {
+		t.Errorf("Encrypting twice gave the same result, nonces aren't random")
+	}
+}
+
+func Test_NewCredentialKeys(t *testing.T) {
+	testcases := map[string]struct{
+		keys map[int][]byte
+		current int
+	}{
+		"short key": {map[int][]byte{1: []byte("too short")}, 1},
+		"zero version": {map[int][]byte{0: bytes.Repeat([]byte{1}, 32)}, 0},
+		"missing current": {map[int][]byte{1: bytes.Repeat([]byte{1}, 32)}, 2},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if _, err := NewCredentialKeys(v.keys, v.current); err == nil { t.Errorf("Expected an error") }
+		})
+	}
+}
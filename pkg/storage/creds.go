@@ -3,6 +3,8 @@ package storage
 import (
 	"time"
 	"database/sql"
+	"errors"
+	"fmt"
 
 	tgtypes "github.com/thewug/gogram/data"
 
@@ -11,6 +13,10 @@ import (
 
 var ErrNoLogin error = NewCommitAndYield("no stored credentials for telegram user")
 
+// stored credentials whose api key can't be decrypted, usually because its key version isn't configured anymore.
+// these won't start working on their own.
+var ErrUndecryptable = errors.New("stored api key can't be decrypted")
+
 type UserCreds struct {
 	TelegramId           tgtypes.UserID `dml:"telegram_id"`
 	User                 string         `dml:"api_user"`
@@ -18,6 +24,9 @@ type UserCreds struct {
 	Janitor              bool           `dml:"privilege_janitorial"`
 	Blacklist            string         `dml:"api_blacklist"`
 	BlacklistFetched     time.Time      `dml:"api_blacklist_last_updated"`
+	KeyVersion           int            `dml:"api_key_version"`
+	Validated            time.Time      `dml:"api_key_validated"`
+	Invalid              bool           `dml:"api_key_invalid"`
 }
 
 func GetUserCreds(d DBLike, id tgtypes.UserID) (UserCreds, error) {
@@ -25,12 +34,17 @@ func GetUserCreds(d DBLike, id tgtypes.UserID) (UserCreds, error) {
 
 	f := func(d DBLike) error {
 		return d.Enter(func(tx Queryable) error {
-			query := "SELECT telegram_id, api_user, api_key, privilege_janitorial, api_blacklist, api_blacklist_last_updated FROM remote_user_credentials WHERE telegram_id = $1"
+			query := "SELECT telegram_id, api_user, api_key, privilege_janitorial, api_blacklist, api_blacklist_last_updated, api_key_version, api_key_validated, api_key_invalid FROM remote_user_credentials WHERE telegram_id = $1"
 			if err := dml.QuickScan(tx.QueryRow(query, id), &creds); err == sql.ErrNoRows {
 				return ErrNoLogin
-			} else {
+			} else if err != nil {
 				return err
 			}
+
+			api_key, err := credentialKeys.Decrypt(creds.ApiKey, creds.KeyVersion, creds.TelegramId)
+			if err != nil { return fmt.Errorf("%w (key version %d): %s", ErrUndecryptable, creds.KeyVersion, err.Error()) }
+			creds.ApiKey = api_key
+			return nil
 		})
 	}
 
@@ -46,20 +60,123 @@ func GetUserCreds(d DBLike, id tgtypes.UserID) (UserCreds, error) {
 
 func WriteUserCreds(d DBLike, creds UserCreds) (error) {
 	query := `
-INSERT INTO remote_user_credentials (telegram_id, api_user, api_key, api_blacklist, api_blacklist_last_updated)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO remote_user_credentials (telegram_id, api_user, api_key, api_blacklist, api_blacklist_last_updated, api_key_version, api_key_validated, api_key_invalid)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 ON CONFLICT (telegram_id) DO UPDATE
 SET	api_user = EXCLUDED.api_user,
 	api_key = EXCLUDED.api_key,
 	api_blacklist = EXCLUDED.api_blacklist,
-	api_blacklist_last_updated = EXCLUDED.api_blacklist_last_updated
+	api_blacklist_last_updated = EXCLUDED.api_blacklist_last_updated,
+	api_key_version = EXCLUDED.api_key_version,
+	api_key_validated = EXCLUDED.api_key_validated,
+	api_key_invalid = EXCLUDED.api_key_invalid
 `
+	api_key, version, err := credentialKeys.Encrypt(creds.ApiKey, creds.TelegramId)
+	if err != nil { return fmt.Errorf("encrypting api key: %w", err) }
+
+	return d.Enter(func(tx Queryable) error {
+		_, err := tx.Exec(query, creds.TelegramId, creds.User, api_key, creds.Blacklist, creds.BlacklistFetched, version, creds.Validated, creds.Invalid)
+		return err
+	})
+}
+
+// lists the users whose api keys were last validated before a certain time, least recently validated first.
+// logged out users and credentials already known to be invalid are skipped.
+func GetCredsDueForValidation(d DBLike, before time.Time, limit int) ([]tgtypes.UserID, error) {
+	query := "SELECT telegram_id FROM remote_user_credentials WHERE NOT api_key_invalid AND COALESCE(api_user, '') <> '' AND COALESCE(api_key, '') <> '' AND api_key_validated < $1 ORDER BY api_key_validated LIMIT $2"
+	var out []tgtypes.UserID
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, before, limit)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var id tgtypes.UserID
+			if err := rows.Scan(&id); err != nil { return err }
+			out = append(out, id)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// moves a user's credentials to the back of the validation queue without changing anything else about them,
+// so credentials which can't be checked right now don't hold up the ones behind them.
+func PostponeCredentialCheck(d DBLike, id tgtypes.UserID, until time.Time) error {
 	return d.Enter(func(tx Queryable) error {
-		_, err := tx.Exec(query, creds.TelegramId, creds.User, creds.ApiKey, creds.Blacklist, creds.BlacklistFetched)
+		_, err := tx.Exec("UPDATE remote_user_credentials SET api_key_validated = $2 WHERE telegram_id = $1", id, until)
 		return err
 	})
 }
 
+// re-encrypts every stored api key which isn't using the current key version, and returns how many were changed.
+// this moves plaintext credentials over once encryption is turned on, and old ones over to a new key after rotating keys.
+func ReencryptUserCreds(d DBLike) (int, error) {
+	type stored struct {
+		id tgtypes.UserID
+		api_key string
+		version int
+	}
+
+	var count int
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query("SELECT telegram_id, api_key, api_key_version FROM remote_user_credentials WHERE api_key_version <> $1 FOR UPDATE", credentialKeys.current)
+		if err != nil { return err }
+		defer rows.Close()
+
+		var all []stored
+		for rows.Next() {
+			var s stored
+			var api_key sql.NullString
+			if err := rows.Scan(&s.id, &api_key, &s.version); err != nil { return err }
+			s.api_key = api_key.String
+			all = append(all, s)
+		}
+		if err := rows.Err(); err != nil { return err }
+
+		for _, s := range all {
+			plain, err := credentialKeys.Decrypt(s.api_key, s.version, s.id)
+			if err != nil { return fmt.Errorf("decrypting api key for %d (key version %d): %w", s.id, s.version, err) }
+			api_key, version, err := credentialKeys.Encrypt(plain, s.id)
+			if err != nil { return fmt.Errorf("encrypting api key for %d: %w", s.id, err) }
+
+			_, err = tx.Exec("UPDATE remote_user_credentials SET api_key = $2, api_key_version = $3 WHERE telegram_id = $1", s.id, api_key, version)
+			if err != nil { return err }
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// counts how many stored credentials use each key version.
+func CountCredentialKeyVersions(d DBLike) (map[int]int, error) {
+	out := make(map[int]int)
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query("SELECT api_key_version, COUNT(*) FROM remote_user_credentials GROUP BY api_key_version")
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var version, count int
+			if err := rows.Scan(&version, &count); err != nil { return err }
+			out[version] = count
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
 func DeleteUserCreds(d DBLike, id tgtypes.UserID) (error) {
 	query := "DELETE FROM remote_user_credentials WHERE telegram_id = $1"
 
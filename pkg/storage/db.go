@@ -124,7 +124,27 @@ func AliasUpdater(d DBLike, input chan apitypes.TAliasData) (error) {
 			if err := WrapExec(tx.Exec(sql, alias.Id)); err != nil { return err }
 
 			sql = "INSERT INTO alias_index (alias_id, alias_name, alias_target_id) SELECT $1, $2, tag_id FROM tag_index WHERE tag_name = $3"
-			if err := WrapExec(tx.Exec(sql, alias.Id, alias.Name, alias.Alias)); err != nil { return err }
+			if err := WrapExec(tx.Exec(sql, alias.Id, alias.Alias, alias.Name)); err != nil { return err }
+		}
+
+		return nil
+	})
+}
+
+func ClearImplicationIndex(d DBLike) (error) {
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec("TRUNCATE implication_index")) })
+}
+
+func ImplicationUpdater(d DBLike, input chan apitypes.TImplicationData) (error) {
+	defer func(){ for _ = range input {} }()
+
+	return d.Enter(func(tx Queryable) error {
+		for implication := range input {
+			sql := "DELETE FROM implication_index WHERE implication_id = $1"
+			if err := WrapExec(tx.Exec(sql, implication.Id)); err != nil { return err }
+
+			sql = "INSERT INTO implication_index (implication_id, implication_tag_id, implication_implied_id) SELECT $1, a.tag_id, b.tag_id FROM tag_index AS a, tag_index AS b WHERE a.tag_name = $2 AND b.tag_name = $3"
+			if err := WrapExec(tx.Exec(sql, implication.Id, implication.Tag, implication.Implies)); err != nil { return err }
 		}
 
 		return nil
@@ -26,8 +26,10 @@ func EraseDialogPost(d DBLike, msg_id tgtypes.MsgID, chat_id tgtypes.ChatID) err
 	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, msg_id, chat_id)) })
 }
 
+// fetches a dialog, locking it until the end of the transaction, since dialogs are loaded, changed and saved again, and
+// can be changed from more than one place at once.
 func FetchDialogPost(d DBLike, msg_id tgtypes.MsgID, chat_id tgtypes.ChatID) (*DialogPost, error) {
-	query := "SELECT chat_id, msg_id, msg_ts, dialog_id, dialog_data FROM dialog_posts WHERE msg_id = $1 AND chat_id = $2"
+	query := "SELECT chat_id, msg_id, msg_ts, dialog_id, dialog_data FROM dialog_posts WHERE msg_id = $1 AND chat_id = $2 FOR UPDATE"
 	out := &DialogPost{}
 
 	err := d.Enter(func(tx Queryable) error {
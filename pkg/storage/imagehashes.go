@@ -0,0 +1,136 @@
+package storage
+
+import (
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+
+	"github.com/lib/pq"
+
+	"fmt"
+)
+
+// a post whose image looks like another one, and how many bits their perceptual hashes differ by.
+type ImageMatch struct {
+	PostId   int
+	Distance int
+}
+
+// returns up to limit undeleted posts whose current file has never been hashed, newest first. only the fields needed
+// to find their previews are filled in.
+func GetPostsWithoutImageHash(d DBLike, limit int) ([]apitypes.TPostInfo, error) {
+	query := "SELECT post_id, post_hash, post_file_ext, post_width, post_height, post_has_sample FROM post_index LEFT JOIN post_image_hashes USING (post_id) WHERE NOT post_deleted AND post_file_ext <> '' AND (image_md5 IS NULL OR image_md5 <> LOWER(post_hash)) ORDER BY post_id DESC LIMIT $1"
+	var out []apitypes.TPostInfo
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, limit)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var p apitypes.TPostInfo
+			if err := rows.Scan(&p.Id, &p.Md5, &p.File_ext, &p.Width, &p.Height, &p.Has_sample); err != nil { return err }
+			out = append(out, p)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// the hash is also stored split into four 16 bit bands, which are indexed. two hashes which are d bits apart must have
+// a band which is no more than d / 4 bits apart, since otherwise they'd be more than d apart overall. so SimilarPosts
+// looks up every band value that close to each of the hash's bands, instead of comparing against every hash.
+func imageHashBands(hash uint64) [4]int16 {
+	var out [4]int16
+	for i := range out {
+		out[i] = int16(uint16(hash >> (16 * uint(i))))
+	}
+	return out
+}
+
+// the furthest apart bands are looked up, beyond which there are so many values it's quicker to compare every hash.
+// it's enough for hashes up to 15 bits apart, which covers imagehash.POSSIBLE_MATCH.
+const MAX_IMAGE_HASH_BAND_RADIUS = 3
+
+// every band value no more than radius bits from band, including band itself.
+func imageHashBandNeighbours(band int16, radius int) []int64 {
+	out := []int64{int64(band)}
+	var flip func(value uint16, from, left int)
+	flip = func(value uint16, from, left int) {
+		if left == 0 { return }
+		for bit := from; bit < 16; bit++ {
+			next := value ^ 1 << uint(bit)
+			out = append(out, int64(int16(next)))
+			flip(next, bit + 1, left - 1)
+		}
+	}
+	flip(uint16(band), 0, radius)
+	return out
+}
+
+// the band values to look up to find every hash up to max_distance bits away, or nil if every hash should be compared.
+func imageHashCandidates(hash uint64, max_distance int) [][]int64 {
+	radius := max_distance / 4
+	if radius > MAX_IMAGE_HASH_BAND_RADIUS { return nil }
+
+	var out [][]int64
+	for _, band := range imageHashBands(hash) {
+		out = append(out, imageHashBandNeighbours(band, radius))
+	}
+	return out
+}
+
+// records the perceptual hash of a post's image. md5 is the post's file hash, so it can be hashed again if the file is replaced.
+func SetImageHash(d DBLike, post_id int, md5 string, hash uint64) error {
+	query := "INSERT INTO post_image_hashes (post_id, image_md5, image_hash, image_hash_band_0, image_hash_band_1, image_hash_band_2, image_hash_band_3) VALUES ($1, LOWER($2), $3, $4, $5, $6, $7) ON CONFLICT (post_id) DO UPDATE SET image_md5 = EXCLUDED.image_md5, image_hash = EXCLUDED.image_hash, image_hash_band_0 = EXCLUDED.image_hash_band_0, image_hash_band_1 = EXCLUDED.image_hash_band_1, image_hash_band_2 = EXCLUDED.image_hash_band_2, image_hash_band_3 = EXCLUDED.image_hash_band_3"
+	bands := imageHashBands(hash)
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, post_id, md5, int64(hash), bands[0], bands[1], bands[2], bands[3])) })
+}
+
+// records that a post's image couldn't be hashed, so it isn't tried again until its file changes.
+func SetImageUnhashable(d DBLike, post_id int, md5 string) error {
+	query := "INSERT INTO post_image_hashes (post_id, image_md5) VALUES ($1, LOWER($2)) ON CONFLICT (post_id) DO UPDATE SET image_md5 = EXCLUDED.image_md5, image_hash = NULL, image_hash_band_0 = NULL, image_hash_band_1 = NULL, image_hash_band_2 = NULL, image_hash_band_3 = NULL"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, post_id, md5)) })
+}
+
+// finds the undeleted posts whose images are closest to the given perceptual hash, most similar first.
+func SimilarPosts(d DBLike, hash uint64, max_distance, limit int) ([]ImageMatch, error) {
+	// only hashes with a band near enough to one of this one's are compared, see imageHashBands. postgres before 14 has
+	// no bit_count, so the distance is the number of ones in the xor's binary representation.
+	query := `
+SELECT post_id, distance FROM (
+	SELECT post_id, LENGTH(REPLACE((image_hash # $1)::bit(64)::text, '0', '')) AS distance FROM post_image_hashes
+	WHERE %s
+) AS x INNER JOIN post_index USING (post_id)
+WHERE distance <= $2 AND NOT post_deleted
+ORDER BY distance, post_id DESC LIMIT $3
+`
+	args := []interface{}{int64(hash), max_distance, limit}
+	if candidates := imageHashCandidates(hash, max_distance); candidates == nil {
+		query = fmt.Sprintf(query, "image_hash IS NOT NULL")
+	} else {
+		query = fmt.Sprintf(query, "image_hash_band_0 = ANY($4::smallint[]) OR image_hash_band_1 = ANY($5::smallint[]) OR image_hash_band_2 = ANY($6::smallint[]) OR image_hash_band_3 = ANY($7::smallint[])")
+		for _, c := range candidates { args = append(args, pq.Int64Array(c)) }
+	}
+	var out []ImageMatch
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, args...)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var m ImageMatch
+			if err := rows.Scan(&m.PostId, &m.Distance); err != nil { return err }
+			out = append(out, m)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"github.com/thewug/fsb/pkg/imagehash"
+
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+func Test_imageHashBands(t *testing.T) {
+	bands := imageHashBands(0xFFFF00000001F0F0)
+	if bands != [4]int16{-3856, 1, 0, -1} { t.Errorf("Unexpected bands: %v", bands) }
+}
+
+func Test_imageHashBandNeighbours(t *testing.T) {
+	// 1 + 16 + 120 + 560 values within 3 bits, with no repeats.
+	out := imageHashBandNeighbours(-3856, 3)
+	seen := make(map[int64]bool)
+	for _, v := range out {
+		if seen[v] { t.Errorf("Repeated band value %d", v) }
+		seen[v] = true
+		if n := bits.OnesCount16(uint16(v) ^ uint16(0xF0F0)); n > 3 { t.Errorf("Band value %d is %d bits away", v, n) }
+	}
+	if len(out) != 697 { t.Errorf("Unexpected number of band values: %d", len(out)) }
+}
+
+// everything up to imagehash.POSSIBLE_MATCH bits away has to be found, however the differences are spread out.
+func Test_imageHashCandidates(t *testing.T) {
+	hash := uint64(0x0123456789ABCDEF)
+	candidates := imageHashCandidates(hash, imagehash.POSSIBLE_MATCH)
+	if candidates == nil { t.Fatalf("POSSIBLE_MATCH is too far to look up by band") }
+
+	found := func(other uint64) bool {
+		for i, band := range imageHashBands(other) {
+			for _, c := range candidates[i] {
+				if c == int64(band) { return true }
+			}
+		}
+		return false
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		// flip POSSIBLE_MATCH different bits, and half of the time, spread them over all four bands.
+		var flipped uint64
+		for n := 0; bits.OnesCount64(flipped) < imagehash.POSSIBLE_MATCH; n++ {
+			bit := uint(random.Intn(64))
+			if i % 2 == 0 { bit = uint(n % 4) * 16 + uint(random.Intn(16)) }
+			flipped |= 1 << bit
+		}
+		if !found(hash ^ flipped) { t.Errorf("Hash %x, %d bits away, wouldn't be found", hash ^ flipped, imagehash.POSSIBLE_MATCH) }
+	}
+
+	if imageHashCandidates(hash, 64) != nil { t.Errorf("Expected distant matches to compare every hash") }
+}
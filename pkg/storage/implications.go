@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"github.com/thewug/fsb/pkg/api/tags"
+
+	"github.com/lib/pq"
+)
+
+// finds every tag implied by any of the given tags, directly or through a chain of implications, which isn't already
+// one of them. From is the tag the implication started at.
+func GetImpliedTags(d DBLike, tag_names []string) ([]TagRewrite, error) {
+	if len(tag_names) == 0 { return nil, nil }
+
+	// UNION rather than UNION ALL keeps loops of implications from going forever.
+	query := `
+WITH RECURSIVE implied(tag_id, implied_id) AS (
+	SELECT	tag_id, implication_implied_id
+	FROM	tag_index INNER JOIN implication_index ON implication_tag_id = tag_id
+	WHERE	tag_name = ANY($1::varchar[])
+UNION
+	SELECT	implied.tag_id, implication_implied_id
+	FROM	implied INNER JOIN implication_index ON implication_tag_id = implied.implied_id
+)
+SELECT	a.tag_name AS from_name, b.tag_name AS to_name
+FROM	implied
+	INNER JOIN tag_index AS a ON a.tag_id = implied.tag_id
+	INNER JOIN tag_index AS b ON b.tag_id = implied.implied_id
+WHERE	NOT b.tag_name = ANY($1::varchar[])
+`
+
+	return queryRewrites(d, query, pq.Array(tag_names))
+}
+
+// adds every tag implied by the tags in a set to it.
+func ExpandImplications(d DBLike, set *tags.TagSet) error {
+	var names []string
+	for tag, present := range set.Data {
+		if present { names = append(names, tag) }
+	}
+
+	implied, err := GetImpliedTags(d, names)
+	if err != nil { return err }
+
+	for _, i := range implied { set.Set(i.To) }
+	return nil
+}
+
+// returns a copy of a set of tags with all of their implications added.
+func ExpandedImplications(d DBLike, set tags.TagSet) (tags.TagSet, error) {
+	out := set.Clone()
+	err := ExpandImplications(d, &out)
+	return out, err
+}
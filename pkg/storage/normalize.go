@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"github.com/lib/pq"
+	"github.com/thewug/dml"
+
+	"sort"
+	"strings"
+)
+
+// a tag which will be replaced by, or which brings along, another one.
+type TagRewrite struct {
+	From string `dml:"from_name"`
+	To   string `dml:"to_name"`
+}
+
+// what the site is going to do to a set of tags when they're saved, worked out from the local tag index.
+type TagNormalization struct {
+	Aliased []TagRewrite // tags which will be replaced by the tag they're aliased to.
+	Implied []TagRewrite // tags which will be added because of an implication, and the tag implying them.
+	Typos   []TagRewrite // tags with no posts, which are probably mistakes. To is the likely fix, if one is known.
+}
+
+func (this TagNormalization) IsZero() bool {
+	return len(this.Aliased) == 0 && len(this.Implied) == 0 && len(this.Typos) == 0
+}
+
+// metatags don't go in the tag index, so there's nothing to normalize about them.
+var metatag_prefixes = []string{"rating:", "parent:", "source:", "pool:", "newpool:", "set:", "vote:", "fav:"}
+
+// cleans up a list of tags for looking up in the index: lowercased, without category prefixes, metatags,
+// duplicates or removals.
+func normalizableTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || strings.HasPrefix(tag, "-") { continue }
+		tag = strings.TrimPrefix(tag, "+")
+
+		metatag := false
+		for _, prefix := range metatag_prefixes {
+			metatag = metatag || strings.HasPrefix(tag, prefix)
+		}
+		if metatag { continue }
+
+		tag, _ = PrefixedTagToTypedTag(tag)
+		if tag == "" || seen[tag] { continue }
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// works out which of a set of tags will be aliased to something else, which other tags they imply, and which of
+// them look like typos, so that people can be warned before they commit.
+func NormalizeTags(d DBLike, tags []string) (TagNormalization, error) {
+	var out TagNormalization
+	tags = normalizableTags(tags)
+	if len(tags) == 0 { return out, nil }
+
+	aliases := `
+SELECT	alias_name AS from_name, tag_name AS to_name
+FROM	alias_index INNER JOIN tag_index ON alias_target_id = tag_id
+WHERE	alias_name = ANY($1::varchar[])
+`
+
+	// a tag is suspicious if it has no posts, or if a janitor has marked it as a typo.
+	typos := `
+SELECT	name AS from_name, COALESCE(fix.tag_name, '') AS to_name
+FROM	UNNEST($1::varchar[]) AS name
+	LEFT JOIN tag_index AS typo ON typo.tag_name = name
+	LEFT JOIN typos_registered ON tag_typo_id = typo.tag_id AND marked
+	LEFT JOIN tag_index AS fix ON fix.tag_id = tag_fix_id
+WHERE	typo.tag_id IS NULL OR typo.tag_count = 0 OR typos_registered.typo_id IS NOT NULL
+`
+
+	var err error
+	out.Aliased, err = queryRewrites(d, aliases, pq.Array(tags))
+	if err != nil { return TagNormalization{}, err }
+
+	// everything after this is about what the tags will be once they've been aliased.
+	aliased := make(map[string]string)
+	for _, a := range out.Aliased { aliased[a.From] = a.To }
+	var canonical, unaliased []string
+	for _, tag := range tags {
+		if to, ok := aliased[tag]; ok {
+			canonical = append(canonical, to)
+		} else {
+			canonical = append(canonical, tag)
+			unaliased = append(unaliased, tag)
+		}
+	}
+
+	out.Implied, err = GetImpliedTags(d, canonical)
+	if err != nil { return TagNormalization{}, err }
+
+	out.Typos, err = queryRewrites(d, typos, pq.Array(unaliased))
+	if err != nil { return TagNormalization{}, err }
+
+	// for typos nobody has registered yet, guess at what was meant.
+	for i, t := range out.Typos {
+		if t.To != "" { continue }
+		completions, err := CompleteTag(d, t.From, 1)
+		if err != nil { return TagNormalization{}, err }
+		if len(completions) != 0 && completions[0].Tag != t.From { out.Typos[i].To = completions[0].Tag }
+	}
+
+	out.Implied = dedupeImplications(out.Implied)
+	sortRewrites(out.Aliased)
+	sortRewrites(out.Typos)
+	return out, nil
+}
+
+// several tags can imply the same thing, but it only needs mentioning once, next to the first of them.
+func dedupeImplications(implied []TagRewrite) []TagRewrite {
+	sortRewrites(implied)
+	seen := make(map[string]bool)
+	var out []TagRewrite
+	for _, i := range implied {
+		if seen[i.To] { continue }
+		seen[i.To] = true
+		out = append(out, i)
+	}
+	return out
+}
+
+func queryRewrites(d DBLike, sql string, args ...interface{}) ([]TagRewrite, error) {
+	var out []TagRewrite
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(sql, args...))
+		if err != nil { return err }
+		defer rows.Close()
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+func sortRewrites(r []TagRewrite) {
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].From != r[j].From { return r[i].From < r[j].From }
+		return r[i].To < r[j].To
+	})
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_normalizableTags(t *testing.T) {
+	testcases := map[string]struct{
+		tags []string
+		expected []string
+	}{
+		"plain": {[]string{"wolf", "solo"}, []string{"wolf", "solo"}},
+		"case and duplicates": {[]string{"Wolf", "wolf", " solo "}, []string{"wolf", "solo"}},
+		"metatags": {[]string{"rating:s", "parent:123", "wolf", "source:http://example.com"}, []string{"wolf"}},
+		"categories": {[]string{"artist:someone", "species:wolf", "wolf"}, []string{"someone", "wolf"}},
+		"removals": {[]string{"-wolf", "+fox", ""}, []string{"fox"}},
+		"nothing": {nil, nil},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := normalizableTags(v.tags); !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %v, expected %v", out, v.expected) }
+		})
+	}
+}
+
+func Test_dedupeImplications(t *testing.T) {
+	implied := []TagRewrite{
+		{"wolf", "mammal"},
+		{"fox", "canine"},
+		{"wolf", "canine"},
+		{"fox", "mammal"},
+		{"webm", "animated"},
+	}
+	expected := []TagRewrite{
+		{"fox", "canine"},
+		{"fox", "mammal"},
+		{"webm", "animated"},
+	}
+
+	if out := dedupeImplications(implied); !reflect.DeepEqual(out, expected) { t.Errorf("Unexpected result: got %v, expected %v", out, expected) }
+}
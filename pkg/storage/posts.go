@@ -21,8 +21,9 @@ func PostUpdater(d DBLike, input chan apitypes.TPostInfo) (error) {
 			if err := WrapExec(tx.Exec("DELETE FROM post_index WHERE post_id = $1", post.Id)); err != nil { return err }
 			if err := WrapExec(tx.Exec("INSERT INTO post_tags_by_name (SELECT $1 as post_id, tag_name FROM UNNEST($2::varchar[]) as tag_name) ON CONFLICT DO NOTHING",
 					 post.Id, pq.Array(post.Tags()))); err != nil { return err }
-			if err := WrapExec(tx.Exec("INSERT INTO post_index (post_id, post_change_seq, post_rating, post_description, post_sources, post_hash, post_deleted) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-					 post.Id, post.Change, post.Rating, post.Description, strings.Join(post.Sources, "\n"), strings.ToLower(post.Md5), post.Deleted)); err != nil { return err }
+			if err := WrapExec(tx.Exec("INSERT INTO post_index (post_id, post_change_seq, post_rating, post_description, post_sources, post_hash, post_deleted, post_score_up, post_score_down, post_score, post_fav_count, post_file_ext, post_width, post_height, post_has_sample) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)",
+					 post.Id, post.Change, post.Rating, post.Description, strings.Join(post.Sources, "\n"), strings.ToLower(post.Md5), post.Deleted,
+					 post.Upvotes, post.Downvotes, post.Score, post.Fav_count, post.File_ext, post.Width, post.Height, post.Has_sample)); err != nil { return err }
 
 			i++
 			if i == 100000 {
@@ -67,6 +68,37 @@ func GetHighestPostID(d DBLike) (int, error) {
 	return result, err
 }
 
+// lists posts indexed before file information was stored, which can't be shown in search results until it's
+// been filled in. newest posts come first, since they're the ones most likely to be searched for.
+func GetPostsMissingFileInfo(d DBLike, limit int) ([]int, error) {
+	query := "SELECT post_id FROM post_index WHERE post_file_ext = '' AND NOT post_deleted ORDER BY post_id DESC LIMIT $1"
+	var out []int
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, limit)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil { return err }
+			out = append(out, id)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// fills in a post's file information without touching anything else about it.
+func SetPostFileInfo(d DBLike, post apitypes.TPostInfo) error {
+	query := "UPDATE post_index SET post_file_ext = $2, post_width = $3, post_height = $4, post_has_sample = $5 WHERE post_id = $1"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, post.Id, post.File_ext, post.Width, post.Height, post.Has_sample)) })
+}
+
 func GetMostRecentlyUpdatedPost(d DBLike) (*apitypes.TPostInfo, error) {
 	query := "SELECT post_id, post_change_seq, post_rating, post_description, post_hash FROM post_index ORDER BY post_change_seq DESC LIMIT 1"
 	p := &apitypes.TPostInfo{}
@@ -218,8 +250,9 @@ func UpdatePost(d DBLike, post apitypes.TPostInfo) (error) {
 		query = "DELETE FROM post_index WHERE post_id = $1"
 		if err := WrapExec(tx.Exec(query, post.Id)); err != nil { return err }
 
-		query = "INSERT INTO post_index (post_id, post_change_seq, post_rating, post_description, post_sources, post_hash, post_deleted) VALUES ($1, $2, $3, $4, $5, $6, $7)"
-		if err := WrapExec(tx.Exec(query, post.Id, post.Change, post.Rating, post.Description, strings.Join(post.Sources, "\n"), strings.ToLower(post.Md5), post.Deleted)); err != nil { return err }
+		query = "INSERT INTO post_index (post_id, post_change_seq, post_rating, post_description, post_sources, post_hash, post_deleted, post_score_up, post_score_down, post_score, post_fav_count, post_file_ext, post_width, post_height, post_has_sample) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)"
+		if err := WrapExec(tx.Exec(query, post.Id, post.Change, post.Rating, post.Description, strings.Join(post.Sources, "\n"), strings.ToLower(post.Md5), post.Deleted,
+		                           post.Upvotes, post.Downvotes, post.Score, post.Fav_count, post.File_ext, post.Width, post.Height, post.Has_sample)); err != nil { return err }
 
 		query = "INSERT INTO post_tags SELECT $1 as post_id, tag_id FROM UNNEST($2::varchar[]) AS tag_name INNER JOIN tag_index USING (tag_name)"
 		return WrapExec(tx.Exec(query, post.Id, pq.Array(post.Tags())))
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/thewug/fsb/pkg/api/tags"
@@ -38,12 +41,29 @@ func (rm *ReplacerMatcher) Matches(postTags tags.TagSet) bool {
 	return true
 }
 
+// replacements applied by the maintenance routine's automatic cleanups aren't made on behalf of anyone, so they're
+// recorded with this in place of a telegram user id.
+const AUTOFIX_USER tgdata.UserID = -1
+
 type ReplacementHistory struct {
 	ReplacementHistoryKey
 
 	Id             int64         `dml:"action_id"`
 	TelegramUserId tgdata.UserID `dml:"telegram_user_id"`
 	Timestamp      time.Time     `dml:"action_ts"`
+
+	// the tag changes this replacer actually made to the post, and the post's change sequence number right afterwards.
+	// older records don't have these, and can't be reverted.
+	TagDiff        string        `dml:"tag_diff"`
+	ChangeSeq      int           `dml:"post_change_seq"`
+	Reverted       bool          `dml:"reverted"`
+}
+
+// selects replacement history records. every criterion which is set must match, and at least one should be set.
+type ReplacementHistoryFilter struct {
+	ReplacerId     *int64
+	TelegramUserId *tgdata.UserID
+	Since, Until   *time.Time
 }
 
 type ReplacementHistoryKey struct {
@@ -68,6 +88,20 @@ func DeleteReplacement(d DBLike, id int64) (error) {
 	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, id)) })
 }
 
+// returns nil, with no error, if there's no such replacer.
+func GetReplacement(d DBLike, id int64) (*Replacer, error) {
+	query := "SELECT replace_id, match_spec, replace_spec, autofix FROM replacements WHERE replace_id = $1"
+	var out Replacer
+
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, id).Scan(&out.Id, &out.MatchSpec, &out.ReplaceSpec, &out.Autofix) })
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
 func GetReplacements(d DBLike, after_id int64, page_size int) ([]Replacer, error) {
 	query := "SELECT replace_id, match_spec, replace_spec, autofix FROM replacements WHERE replace_id > $1 ORDER BY replace_id LIMIT $2"
 	var out []Replacer
@@ -140,9 +174,46 @@ func GetReplacementHistorySince(d DBLike, post_ids []int, since time.Time) (map[
 }
 
 func AddReplacementHistory(d DBLike, event *ReplacementHistory) error {
-	query := "INSERT INTO replacement_actions (action_id, telegram_user_id, replace_id, post_id, action_ts) VALUES (default, $1, $2, $3, $4) RETURNING action_id"
+	query := "INSERT INTO replacement_actions (action_id, telegram_user_id, replace_id, post_id, action_ts, tag_diff, post_change_seq) VALUES (default, $1, $2, $3, $4, $5, $6) RETURNING action_id"
 
 	return d.Enter(func(tx Queryable) error {
-		return tx.QueryRow(query, event.TelegramUserId, event.ReplacerId, event.PostId, event.Timestamp).Scan(event.Id)
+		return tx.QueryRow(query, event.TelegramUserId, event.ReplacerId, event.PostId, event.Timestamp, event.TagDiff, event.ChangeSeq).Scan(&event.Id)
 	}) // ErrNoRows will be passed through here, and we do want to propagate that because it should never happen
 }
+
+// lists the replacement history records matching a filter which haven't been reverted yet, grouped by post and oldest first.
+func GetReplacementHistory(d DBLike, filter ReplacementHistoryFilter) ([]ReplacementHistory, error) {
+	var args []interface{}
+	conditions := []string{"NOT reverted"}
+	param := func(x interface{}) string {
+		args = append(args, x)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.ReplacerId != nil { conditions = append(conditions, "replace_id = " + param(*filter.ReplacerId)) }
+	if filter.TelegramUserId != nil { conditions = append(conditions, "telegram_user_id = " + param(*filter.TelegramUserId)) }
+	if filter.Since != nil { conditions = append(conditions, "action_ts >= " + param(*filter.Since)) }
+	if filter.Until != nil { conditions = append(conditions, "action_ts < " + param(*filter.Until)) }
+
+	query := "SELECT action_id, telegram_user_id, replace_id, post_id, action_ts, tag_diff, post_change_seq, reverted FROM replacement_actions WHERE " +
+	         strings.Join(conditions, " AND ") + " ORDER BY post_id, action_id"
+	var out []ReplacementHistory
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(query, args...))
+		if err != nil { return err }
+		defer rows.Close()
+
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+func MarkReplacementHistoryReverted(d DBLike, action_ids []int64) error {
+	query := "UPDATE replacement_actions SET reverted = true WHERE action_id = ANY($1::bigint[])"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, pq.Array(action_ids))) })
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	tgtypes "github.com/thewug/gogram/data"
+
+	"github.com/lib/pq"
+
+	"database/sql"
+	"time"
+)
+
+// a permission is the right to use a particular set of commands. permissions are given to users
+// by granting them roles, which are named sets of permissions.
+type Permission string
+
+const PERM_SYNC         Permission = "can-sync"
+const PERM_EDIT_BLITS   Permission = "can-edit-blits"
+const PERM_AUTOFIX      Permission = "can-autofix"
+const PERM_BULK_RETAG   Permission = "can-bulk-retag"
+const PERM_MANAGE_USERS Permission = "can-manage-users"
+const PERM_MANAGE_BOT   Permission = "can-manage-bot"
+
+var ALL_PERMISSIONS = []Permission{PERM_SYNC, PERM_EDIT_BLITS, PERM_AUTOFIX, PERM_BULK_RETAG, PERM_MANAGE_USERS, PERM_MANAGE_BOT}
+
+// what the old janitor flag still grants, so existing janitors keep working without being given a role.
+var JANITOR_PERMISSIONS = []Permission{PERM_SYNC, PERM_EDIT_BLITS, PERM_AUTOFIX, PERM_BULK_RETAG}
+
+func IsPermission(p Permission) bool {
+	for _, q := range ALL_PERMISSIONS {
+		if p == q { return true }
+	}
+	return false
+}
+
+// the bot's owner has every permission, whatever roles they have.
+var owner tgtypes.UserID
+
+func SetOwner(id tgtypes.UserID) {
+	owner = id
+}
+
+type Role struct {
+	Name        string
+	Permissions []Permission
+}
+
+func (this Role) Has(p Permission) bool {
+	for _, q := range this.Permissions {
+		if p == q { return true }
+	}
+	return false
+}
+
+type RoleGrant struct {
+	TelegramId tgtypes.UserID
+	User       string
+	Role       string
+}
+
+type RoleAuditEntry struct {
+	Time   time.Time
+	Actor  tgtypes.UserID
+	Target tgtypes.UserID
+	Action string
+	Role   string
+}
+
+// audit log actions.
+const ROLE_GRANT  = "grant"
+const ROLE_REVOKE = "revoke"
+const ROLE_DEFINE = "define"
+const ROLE_DELETE = "delete"
+
+// checks whether a user has a permission, from any of their roles or from the janitor flag.
+func HasPermission(d DBLike, id tgtypes.UserID, p Permission) (bool, error) {
+	if id == owner && owner != 0 { return true, nil }
+
+	query := `
+SELECT EXISTS (SELECT 1 FROM user_roles INNER JOIN roles USING (role_name) WHERE telegram_id = $1 AND $2 = ANY(role_permissions))
+    OR EXISTS (SELECT 1 FROM remote_user_credentials WHERE telegram_id = $1 AND privilege_janitorial AND $2 = ANY($3::varchar[]))
+`
+	var out bool
+	var janitor []string
+	for _, q := range JANITOR_PERMISSIONS { janitor = append(janitor, string(q)) }
+
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, id, string(p), pq.Array(janitor)).Scan(&out) })
+	return out && err == nil, err
+}
+
+func scanRoles(rows *sql.Rows) ([]Role, error) {
+	var out []Role
+	for rows.Next() {
+		var r Role
+		var perms []string
+		if err := rows.Scan(&r.Name, pq.Array(&perms)); err != nil { return nil, err }
+		for _, p := range perms { r.Permissions = append(r.Permissions, Permission(p)) }
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// lists every role, by name.
+func GetRoles(d DBLike) ([]Role, error) {
+	var out []Role
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query("SELECT role_name, role_permissions FROM roles ORDER BY role_name")
+		if err != nil { return err }
+		defer rows.Close()
+
+		out, err = scanRoles(rows)
+		return err
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// looks up a role by name, returning nil if there's no such role.
+func GetRole(d DBLike, name string) (*Role, error) {
+	var out *Role
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query("SELECT role_name, role_permissions FROM roles WHERE role_name = $1", name)
+		if err != nil { return err }
+		defer rows.Close()
+
+		roles, err := scanRoles(rows)
+		if len(roles) != 0 { out = &roles[0] }
+		return err
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// creates a role, or replaces the permissions of an existing one.
+func DefineRole(d DBLike, actor tgtypes.UserID, role Role) error {
+	var perms []string
+	for _, p := range role.Permissions { perms = append(perms, string(p)) }
+
+	return d.Enter(func(tx Queryable) error {
+		_, err := tx.Exec("INSERT INTO roles (role_name, role_permissions) VALUES ($1, $2) ON CONFLICT (role_name) DO UPDATE SET role_permissions = EXCLUDED.role_permissions", role.Name, pq.Array(perms))
+		if err != nil { return err }
+		return writeRoleAudit(tx, actor, 0, ROLE_DEFINE, role.Name)
+	})
+}
+
+// deletes a role, taking it away from everyone who had it. returns false if there was no such role.
+func DeleteRole(d DBLike, actor tgtypes.UserID, name string) (bool, error) {
+	var deleted bool
+	err := d.Enter(func(tx Queryable) error {
+		if _, err := tx.Exec("DELETE FROM user_roles WHERE role_name = $1", name); err != nil { return err }
+		result, err := tx.Exec("DELETE FROM roles WHERE role_name = $1", name)
+		if err != nil { return err }
+		if n, err := result.RowsAffected(); err != nil || n == 0 { return err }
+
+		deleted = true
+		return writeRoleAudit(tx, actor, 0, ROLE_DELETE, name)
+	})
+	return deleted, err
+}
+
+// lists who has which roles, along with their site usernames if they're logged in.
+func GetRoleGrants(d DBLike) ([]RoleGrant, error) {
+	query := "SELECT telegram_id, COALESCE(api_user, ''), role_name FROM user_roles LEFT JOIN remote_user_credentials USING (telegram_id) ORDER BY role_name, telegram_id"
+	var out []RoleGrant
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var g RoleGrant
+			if err := rows.Scan(&g.TelegramId, &g.User, &g.Role); err != nil { return err }
+			out = append(out, g)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// gives a user a role. returns false if they already had it.
+func GrantRole(d DBLike, actor, target tgtypes.UserID, role string) (bool, error) {
+	var granted bool
+	err := d.Enter(func(tx Queryable) error {
+		result, err := tx.Exec("INSERT INTO user_roles (telegram_id, role_name) VALUES ($1, $2) ON CONFLICT DO NOTHING", target, role)
+		if err != nil { return err }
+		if n, err := result.RowsAffected(); err != nil || n == 0 { return err }
+
+		granted = true
+		return writeRoleAudit(tx, actor, target, ROLE_GRANT, role)
+	})
+	return granted, err
+}
+
+// takes a role away from a user. returns false if they didn't have it.
+func RevokeRole(d DBLike, actor, target tgtypes.UserID, role string) (bool, error) {
+	var revoked bool
+	err := d.Enter(func(tx Queryable) error {
+		result, err := tx.Exec("DELETE FROM user_roles WHERE telegram_id = $1 AND role_name = $2", target, role)
+		if err != nil { return err }
+		if n, err := result.RowsAffected(); err != nil || n == 0 { return err }
+
+		revoked = true
+		return writeRoleAudit(tx, actor, target, ROLE_REVOKE, role)
+	})
+	return revoked, err
+}
+
+func writeRoleAudit(tx Queryable, actor, target tgtypes.UserID, action, role string) error {
+	return WrapExec(tx.Exec("INSERT INTO role_audit_log (audit_actor, audit_target, audit_action, role_name) VALUES ($1, NULLIF($2, 0), $3, $4)", actor, target, action, role))
+}
+
+// lists the most recent changes to roles, newest first.
+func GetRoleAudit(d DBLike, limit int) ([]RoleAuditEntry, error) {
+	query := "SELECT audit_time, audit_actor, COALESCE(audit_target, 0), audit_action, role_name FROM role_audit_log ORDER BY audit_id DESC LIMIT $1"
+	var out []RoleAuditEntry
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, limit)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var e RoleAuditEntry
+			if err := rows.Scan(&e.Time, &e.Actor, &e.Target, &e.Action, &e.Role); err != nil { return err }
+			out = append(out, e)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// finds the telegram user logged in with a particular site account.
+func FindUserBySiteName(d DBLike, name string) (tgtypes.UserID, error) {
+	var id tgtypes.UserID
+	err := d.Enter(func(tx Queryable) error {
+		err := tx.QueryRow("SELECT telegram_id FROM remote_user_credentials WHERE LOWER(api_user) = LOWER($1) LIMIT 1", name).Scan(&id)
+		if err == sql.ErrNoRows { return ErrNoLogin }
+		return err
+	})
+	return id, err
+}
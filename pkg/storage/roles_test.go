@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+)
+
+func Test_Role_Has(t *testing.T) {
+	role := Role{Name: "syncer", Permissions: []Permission{PERM_SYNC, PERM_EDIT_BLITS}}
+
+	testcases := map[string]struct{
+		permission Permission
+		has bool
+	}{
+		"first": {PERM_SYNC, true},
+		"second": {PERM_EDIT_BLITS, true},
+		"missing": {PERM_MANAGE_USERS, false},
+		"unknown": {Permission("can-fly"), false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			if out := role.Has(v.permission); out != v.has { t.Errorf("Unexpected result: got %t, expected %t", out, v.has) }
+		})
+	}
+}
+
+func Test_IsPermission(t *testing.T) {
+	for _, p := range ALL_PERMISSIONS {
+		if !IsPermission(p) { t.Errorf("%s should be a permission", p) }
+	}
+	for _, p := range JANITOR_PERMISSIONS {
+		if !IsPermission(p) { t.Errorf("janitor permission %s should be a permission", p) }
+		if p == PERM_MANAGE_USERS { t.Errorf("janitors shouldn't be able to manage users") }
+	}
+	if IsPermission(Permission("can-fly")) { t.Errorf("can-fly shouldn't be a permission") }
+}
+
+func Test_HasPermission_Owner(t *testing.T) {
+	defer SetOwner(owner)
+	SetOwner(1234)
+
+	// the owner is allowed everything without looking anything up, so this doesn't need a database.
+	allowed, err := HasPermission(nil, 1234, PERM_MANAGE_USERS)
+	if !allowed || err != nil { t.Errorf("Owner wasn't allowed: %t, %v", allowed, err) }
+}
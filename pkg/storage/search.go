@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+	"github.com/lib/pq"
+
+	"github.com/thewug/dml"
+)
+
+// PostSearch is a search query which can be run against the local post index.
+// it understands a useful subset of the site's own search syntax: plain tags must all be present,
+// tags prefixed with - must be absent, and at least one tag prefixed with ~ must be present.
+// the rating:, id:, score: and favcount: metatags are also supported, and may be negated.
+type PostSearch struct {
+	Tags, NotTags, AnyTags []string
+	Ratings, NotRatings    []apitypes.PostRating
+	Ranges                 []SearchRange
+
+	// tokens which looked like metatags but which the local index can't handle, and were ignored.
+	Ignored                []string
+}
+
+// SearchRange restricts an integer field of a post to an inclusive range. nil bounds are open.
+type SearchRange struct {
+	Field    string
+	Min, Max *int
+	Negate   bool
+}
+
+var searchRangeColumns = map[string]string{
+	"id":       "post_id",
+	"score":    "post_score",
+	"favcount": "post_fav_count",
+}
+
+// parses a range in any of the forms the site accepts: N, >N, >=N, <N, <=N, N..M, N.., and ..M.
+func ParseSearchRange(field, spec string) (SearchRange, bool) {
+	r := SearchRange{Field: field}
+	atoi := func(s string) (*int, bool) {
+		i, err := strconv.Atoi(s)
+		if err != nil { return nil, false }
+		return &i, true
+	}
+	adjust := func(i *int, by int) *int { *i += by; return i }
+
+	var ok bool
+	if strings.HasPrefix(spec, ">=") {
+		r.Min, ok = atoi(spec[2:])
+	} else if strings.HasPrefix(spec, "<=") {
+		r.Max, ok = atoi(spec[2:])
+	} else if strings.HasPrefix(spec, ">") {
+		r.Min, ok = atoi(spec[1:])
+		if ok { r.Min = adjust(r.Min, 1) }
+	} else if strings.HasPrefix(spec, "<") {
+		r.Max, ok = atoi(spec[1:])
+		if ok { r.Max = adjust(r.Max, -1) }
+	} else if parts := strings.SplitN(spec, "..", 2); len(parts) == 2 {
+		if parts[0] == "" && parts[1] == "" { return r, false }
+		ok = true
+		if parts[0] != "" { r.Min, ok = atoi(parts[0]) }
+		if ok && parts[1] != "" { r.Max, ok = atoi(parts[1]) }
+	} else {
+		r.Min, ok = atoi(spec)
+		r.Max = r.Min
+	}
+
+	return r, ok
+}
+
+func ParsePostSearch(query string) PostSearch {
+	var search PostSearch
+	for _, token := range strings.Fields(strings.ToLower(query)) {
+		negate, any := false, false
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			negate, token = true, token[1:]
+		} else if strings.HasPrefix(token, "~") && len(token) > 1 {
+			any, token = true, token[1:]
+		}
+
+		parts := strings.SplitN(token, ":", 2)
+		if len(parts) == 2 && parts[0] == "rating" {
+			var rating apitypes.PostRating
+			switch {
+			case strings.HasPrefix(parts[1], "s"):
+				rating = apitypes.Safe
+			case strings.HasPrefix(parts[1], "q"):
+				rating = apitypes.Questionable
+			case strings.HasPrefix(parts[1], "e"):
+				rating = apitypes.Explicit
+			default:
+				search.Ignored = append(search.Ignored, token)
+				continue
+			}
+
+			if negate {
+				search.NotRatings = append(search.NotRatings, rating)
+			} else {
+				search.Ratings = append(search.Ratings, rating)
+			}
+		} else if _, ok := searchRangeColumns[parts[0]]; len(parts) == 2 && ok {
+			r, ok := ParseSearchRange(parts[0], parts[1])
+			if !ok {
+				search.Ignored = append(search.Ignored, token)
+				continue
+			}
+			r.Negate = negate
+			search.Ranges = append(search.Ranges, r)
+		} else if len(parts) == 2 && (parts[0] == "order" || parts[0] == "limit" || parts[0] == "status") {
+			search.Ignored = append(search.Ignored, token)
+		} else if negate {
+			search.NotTags = append(search.NotTags, token)
+		} else if any {
+			search.AnyTags = append(search.AnyTags, token)
+		} else {
+			search.Tags = append(search.Tags, token)
+		}
+	}
+
+	return search
+}
+
+// builds the WHERE clause for a search, appending any parameters it needs to args.
+func (this PostSearch) condition(args *[]interface{}) string {
+	param := func(x interface{}) string {
+		*args = append(*args, x)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	// tags are resolved through aliases, the same way the site does it.
+	has_any_tag := func(names []string) string {
+		p := param(pq.Array(names))
+		return "EXISTS (SELECT 1 FROM post_tags WHERE post_tags.post_id = post_index.post_id AND tag_id IN " +
+		       "(SELECT tag_id FROM tag_index WHERE tag_name = ANY(" + p + "::varchar[]) UNION SELECT alias_target_id FROM alias_index WHERE alias_name = ANY(" + p + "::varchar[])))"
+	}
+
+	conditions := []string{"NOT post_deleted", "post_file_ext <> ''"}
+
+	for _, tag := range this.Tags {
+		conditions = append(conditions, has_any_tag([]string{tag}))
+	}
+	if len(this.NotTags) != 0 {
+		conditions = append(conditions, "NOT " + has_any_tag(this.NotTags))
+	}
+	if len(this.AnyTags) != 0 {
+		conditions = append(conditions, has_any_tag(this.AnyTags))
+	}
+
+	for _, rating := range this.Ratings {
+		conditions = append(conditions, "post_rating = " + param(string(rating)))
+	}
+	for _, rating := range this.NotRatings {
+		conditions = append(conditions, "post_rating <> " + param(string(rating)))
+	}
+
+	for _, r := range this.Ranges {
+		var bounds []string
+		if r.Min != nil { bounds = append(bounds, searchRangeColumns[r.Field] + " >= " + param(*r.Min)) }
+		if r.Max != nil { bounds = append(bounds, searchRangeColumns[r.Field] + " <= " + param(*r.Max)) }
+		if len(bounds) == 0 { continue }
+
+		condition := "(" + strings.Join(bounds, " AND ") + ")"
+		if r.Negate { condition = "NOT " + condition }
+		conditions = append(conditions, condition)
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+func postTagsOfType(column string, types ...apitypes.TagCategory) string {
+	var type_list []string
+	for _, t := range types {
+		type_list = append(type_list, strconv.Itoa(t.Value()))
+	}
+	return fmt.Sprintf("ARRAY(SELECT tag_name FROM tag_index INNER JOIN post_tags USING (tag_id) WHERE post_id = post_index.post_id AND tag_type IN (%s)) AS %s", strings.Join(type_list, ", "), column)
+}
+
+// runs a search against the local post index, returning one page of results, newest first.
+// the results are as complete as the local index allows, but don't include any file urls, since those aren't stored.
+func SearchPosts(d DBLike, search PostSearch, page, limit int) (apitypes.TPostInfoArray, error) {
+	var args []interface{}
+	where := search.condition(&args)
+	args = append(args, limit, page * limit)
+
+	query := "SELECT post_id, post_change_seq, post_rating, post_description, post_sources, post_hash, post_deleted, " +
+	         "post_score_up, post_score_down, post_score, post_fav_count, post_file_ext, post_width, post_height, post_has_sample, " +
+	         postTagsOfType("post_tags", apitypes.TCGeneral) + ", " +
+	         postTagsOfType("post_tags_artist", apitypes.TCArtist) + ", " +
+	         postTagsOfType("post_tags_copyright", apitypes.TCCopyright) + ", " +
+	         postTagsOfType("post_tags_character", apitypes.TCCharacter) + ", " +
+	         postTagsOfType("post_tags_species", apitypes.TCSpecies) + ", " +
+	         postTagsOfType("post_tags_invalid", apitypes.TCInvalid) + ", " +
+	         postTagsOfType("post_tags_meta", apitypes.TCMeta) + ", " +
+	         postTagsOfType("post_tags_lore", apitypes.TCLore) + " " +
+	         fmt.Sprintf("FROM post_index WHERE %s ORDER BY post_id DESC LIMIT $%d OFFSET $%d", where, len(args) - 1, len(args))
+	var out apitypes.TPostInfoArray
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(query, args...))
+		if err != nil { return err }
+		defer rows.Close()
+
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	apitypes "github.com/thewug/fsb/pkg/api/types"
+
+	"reflect"
+	"testing"
+)
+
+func Test_ParseSearchRange(t *testing.T) {
+	i := func(x int) *int { return &x }
+	testcases := map[string]struct{
+		spec string
+		expected SearchRange
+		ok bool
+	}{
+		"exact": {"5", SearchRange{Field: "id", Min: i(5), Max: i(5)}, true},
+		"greater": {">5", SearchRange{Field: "id", Min: i(6)}, true},
+		"greater-equal": {">=5", SearchRange{Field: "id", Min: i(5)}, true},
+		"less": {"<5", SearchRange{Field: "id", Max: i(4)}, true},
+		"less-equal": {"<=5", SearchRange{Field: "id", Max: i(5)}, true},
+		"between": {"5..10", SearchRange{Field: "id", Min: i(5), Max: i(10)}, true},
+		"open-top": {"5..", SearchRange{Field: "id", Min: i(5)}, true},
+		"open-bottom": {"..10", SearchRange{Field: "id", Max: i(10)}, true},
+		"negative": {"<0", SearchRange{Field: "id", Max: i(-1)}, true},
+		"junk": {"garbage", SearchRange{Field: "id"}, false},
+		"empty-range": {"..", SearchRange{Field: "id"}, false},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out, ok := ParseSearchRange("id", v.spec)
+			if ok != v.ok { t.Errorf("Unexpected status: got %t, expected %t", ok, v.ok) }
+			if ok && !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %+v, expected %+v", out, v.expected) }
+		})
+	}
+}
+
+func Test_ParsePostSearch(t *testing.T) {
+	i := func(x int) *int { return &x }
+	testcases := map[string]struct{
+		query string
+		expected PostSearch
+	}{
+		"empty": {"", PostSearch{}},
+		"tags": {"Wolf  -fox ~cat ~dog", PostSearch{Tags: []string{"wolf"}, NotTags: []string{"fox"}, AnyTags: []string{"cat", "dog"}}},
+		"ratings": {"rating:s -rating:explicit rating:x", PostSearch{Ratings: []apitypes.PostRating{apitypes.Safe}, NotRatings: []apitypes.PostRating{apitypes.Explicit}, Ignored: []string{"rating:x"}}},
+		"ranges": {"score:>10 -favcount:<5 id:1..2", PostSearch{Ranges: []SearchRange{{"score", i(11), nil, false}, {"favcount", nil, i(4), true}, {"id", i(1), i(2), false}}}},
+		"ignored": {"order:score wolf", PostSearch{Tags: []string{"wolf"}, Ignored: []string{"order:score"}}},
+		"colon-tags": {":3", PostSearch{Tags: []string{":3"}}},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			out := ParsePostSearch(v.query)
+			if !reflect.DeepEqual(out, v.expected) { t.Errorf("Unexpected result: got %+v, expected %+v", out, v.expected) }
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	tgtypes "github.com/thewug/gogram/data"
 
 	"database/sql"
+	"strings"
 )
 
 type UserSettings struct {
@@ -13,13 +14,14 @@ type UserSettings struct {
 	AgeStatus types.AgeStatus
 	RatingMode types.RatingMode
 	BlacklistMode types.BlacklistMode
+	Blacklist string
 }
 
 func GetUserSettings(d DBLike, telegram_id tgtypes.UserID) (*UserSettings, error) {
-	query := "SELECT telegram_id, age_status, rating_mode, blacklist_mode FROM user_settings WHERE telegram_id = $1"
+	query := "SELECT telegram_id, age_status, rating_mode, blacklist_mode, blacklist FROM user_settings WHERE telegram_id = $1"
 	u := &UserSettings{}
 
-	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, telegram_id).Scan(&u.TelegramId, &u.AgeStatus, &u.RatingMode, &u.BlacklistMode) })
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, telegram_id).Scan(&u.TelegramId, &u.AgeStatus, &u.RatingMode, &u.BlacklistMode, &u.Blacklist) })
 
 	if err == sql.ErrNoRows {
 		u.TelegramId = telegram_id
@@ -33,11 +35,52 @@ func GetUserSettings(d DBLike, telegram_id tgtypes.UserID) (*UserSettings, error
 }
 
 func WriteUserSettings(d DBLike, s *UserSettings) (error) {
-	query := "INSERT INTO user_settings (telegram_id, age_status, rating_mode, blacklist_mode) VALUES ($1, $2, $3, $4) ON CONFLICT (telegram_id) DO UPDATE SET age_status = EXCLUDED.age_status, rating_mode = EXCLUDED.rating_mode, blacklist_mode = EXCLUDED.blacklist_mode"
-	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, s.TelegramId, s.AgeStatus, s.RatingMode, s.BlacklistMode)) })
+	query := "INSERT INTO user_settings (telegram_id, age_status, rating_mode, blacklist_mode, blacklist) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (telegram_id) DO UPDATE SET age_status = EXCLUDED.age_status, rating_mode = EXCLUDED.rating_mode, blacklist_mode = EXCLUDED.blacklist_mode, blacklist = EXCLUDED.blacklist"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, s.TelegramId, s.AgeStatus, s.RatingMode, s.BlacklistMode, s.Blacklist)) })
 }
 
 func DeleteUserSettings(d DBLike, id tgtypes.UserID) (error) {
-	query := "UPDATE user_settings SET age_status = LEAST(age_status, 0), rating_mode = 0, blacklist_mode = 0 WHERE telegram_id = $1"
+	query := "UPDATE user_settings SET age_status = LEAST(age_status, 0), rating_mode = 0, blacklist_mode = 0, blacklist = '' WHERE telegram_id = $1"
 	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, id)) })
 }
+
+// the lines of the user's bot blacklist.
+func (this *UserSettings) BlacklistLines() []string {
+	if this.Blacklist == "" { return nil }
+	return strings.Split(this.Blacklist, "\n")
+}
+
+// tidies up a blacklist line the way it's stored: lowercase, with single spaces between tags.
+func NormalizeBlacklistLine(line string) string {
+	return strings.Join(strings.Fields(strings.ToLower(line)), " ")
+}
+
+// adds a line to the user's bot blacklist. returns false if the line is empty or already present.
+func (this *UserSettings) AddBlacklistLine(line string) bool {
+	line = NormalizeBlacklistLine(line)
+	if line == "" { return false }
+
+	lines := this.BlacklistLines()
+	for _, l := range lines {
+		if l == line { return false }
+	}
+	this.Blacklist = strings.Join(append(lines, line), "\n")
+	return true
+}
+
+// removes a line, numbered from 1, from the user's bot blacklist. returns false if there's no such line.
+func (this *UserSettings) RemoveBlacklistLine(n int) bool {
+	lines := this.BlacklistLines()
+	if n < 1 || n > len(lines) { return false }
+	this.Blacklist = strings.Join(append(lines[:n - 1], lines[n:]...), "\n")
+	return true
+}
+
+// combines the user's bot blacklist with a site blacklist according to their blacklist mode.
+// users who aren't logged in should pass the default blacklist as their site blacklist.
+func (this *UserSettings) EffectiveBlacklist(site string) string {
+	var parts []string
+	if this.BlacklistMode.UsesSite() && site != "" { parts = append(parts, site) }
+	if this.BlacklistMode.UsesBot() && this.Blacklist != "" { parts = append(parts, this.Blacklist) }
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/thewug/fsb/pkg/bot/types"
+
+	"testing"
+)
+
+func Test_UserSettings_EditBlacklist(t *testing.T) {
+	s := UserSettings{}
+
+	if !s.AddBlacklistLine("Gore") { t.Errorf("Couldn't add first line") }
+	if !s.AddBlacklistLine("  feral   -rating:s ") { t.Errorf("Couldn't add second line") }
+	if s.AddBlacklistLine("gore") { t.Errorf("Added a duplicate line") }
+	if s.AddBlacklistLine("   ") { t.Errorf("Added an empty line") }
+	if s.Blacklist != "gore\nferal -rating:s" { t.Errorf("Unexpected blacklist: %q", s.Blacklist) }
+
+	if s.RemoveBlacklistLine(0) || s.RemoveBlacklistLine(3) { t.Errorf("Removed a line which doesn't exist") }
+	if !s.RemoveBlacklistLine(1) { t.Errorf("Couldn't remove a line") }
+	if s.Blacklist != "feral -rating:s" { t.Errorf("Unexpected blacklist: %q", s.Blacklist) }
+	if !s.RemoveBlacklistLine(1) { t.Errorf("Couldn't remove a line") }
+	if s.Blacklist != "" || s.BlacklistLines() != nil { t.Errorf("Unexpected blacklist: %q", s.Blacklist) }
+}
+
+func Test_UserSettings_EffectiveBlacklist(t *testing.T) {
+	testcases := map[string]struct{
+		mode types.BlacklistMode
+		bot, site string
+		expected string
+	}{
+		"both": {types.BLACKLIST_ON, "feral", "gore\nscat", "gore\nscat\nferal"},
+		"bot only": {types.BLACKLIST_BOT_ONLY, "feral", "gore", "feral"},
+		"site only": {types.BLACKLIST_SITE_ONLY, "feral", "gore", "gore"},
+		"off": {types.BLACKLIST_OFF, "feral", "gore", ""},
+		"no bot blacklist": {types.BLACKLIST_ON, "", "gore", "gore"},
+		"no site blacklist": {types.BLACKLIST_ON, "feral", "", "feral"},
+	}
+
+	for k, v := range testcases {
+		t.Run(k, func(t *testing.T) {
+			s := UserSettings{BlacklistMode: v.mode, Blacklist: v.bot}
+			if out := s.EffectiveBlacklist(v.site); out != v.expected { t.Errorf("Unexpected result: got %q, expected %q", out, v.expected) }
+		})
+	}
+}
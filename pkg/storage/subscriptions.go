@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	tgdata "github.com/thewug/gogram/data"
+	"github.com/lib/pq"
+	"github.com/thewug/dml"
+)
+
+// a saved search. posts newer than AfterPostId which match Query are queued up and sent to the user periodically.
+type Subscription struct {
+	Id          int64         `dml:"subscription_id"`
+	TelegramId  tgdata.UserID `dml:"telegram_id"`
+	Query       string        `dml:"query"`
+	AfterPostId int           `dml:"after_post_id"`
+	Created     time.Time     `dml:"created_ts"`
+}
+
+// a post which matched one of a user's subscriptions and is waiting to be sent to them.
+type SubscriptionMatch struct {
+	SubscriptionId int64  `dml:"subscription_id"`
+	PostId         int    `dml:"post_id"`
+	Query          string `dml:"query"`
+}
+
+// only posts newer than the newest one currently in the post index will match the new subscription.
+func AddSubscription(d DBLike, telegram_id tgdata.UserID, search string) (*Subscription, error) {
+	query := "INSERT INTO subscriptions (telegram_id, query, after_post_id, created_ts) SELECT $1, $2, COALESCE(MAX(post_id), 0), NOW() FROM post_index RETURNING subscription_id, telegram_id, query, after_post_id, created_ts"
+	var out Subscription
+
+	err := d.Enter(func(tx Queryable) error { return dml.QuickScan(tx.QueryRow(query, telegram_id, search), &out) })
+	if err != nil { return nil, err }
+	return &out, nil
+}
+
+// deletes a subscription, along with any matches for it which haven't been sent yet.
+// returns false, with no error, if the user has no such subscription.
+func DeleteSubscription(d DBLike, telegram_id tgdata.UserID, id int64) (bool, error) {
+	var deleted bool
+	err := d.Enter(func(tx Queryable) error {
+		err := tx.QueryRow("DELETE FROM subscriptions WHERE telegram_id = $1 AND subscription_id = $2 RETURNING true", telegram_id, id).Scan(&deleted)
+		if err == sql.ErrNoRows { return nil }
+		if err != nil { return err }
+		return WrapExec(tx.Exec("DELETE FROM subscription_matches WHERE subscription_id = $1 AND NOT sent", id))
+	})
+	return deleted, err
+}
+
+func DeleteUserSubscriptions(d DBLike, telegram_id tgdata.UserID) error {
+	return d.Enter(func(tx Queryable) error {
+		if err := WrapExec(tx.Exec("DELETE FROM subscriptions WHERE telegram_id = $1", telegram_id)); err != nil { return err }
+		if err := WrapExec(tx.Exec("DELETE FROM subscription_matches WHERE telegram_id = $1", telegram_id)); err != nil { return err }
+		return WrapExec(tx.Exec("DELETE FROM subscription_digests WHERE telegram_id = $1", telegram_id))
+	})
+}
+
+func CountSubscriptions(d DBLike, telegram_id tgdata.UserID) (int, error) {
+	query := "SELECT COUNT(*) FROM subscriptions WHERE telegram_id = $1"
+	var count int
+
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, telegram_id).Scan(&count) })
+	return count, err
+}
+
+// lists one user's subscriptions, or everyone's if telegram_id is zero.
+func GetSubscriptions(d DBLike, telegram_id tgdata.UserID) ([]Subscription, error) {
+	query := "SELECT subscription_id, telegram_id, query, after_post_id, created_ts FROM subscriptions WHERE $1 = 0 OR telegram_id = $1 ORDER BY subscription_id"
+	var out []Subscription
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(query, telegram_id))
+		if err != nil { return err }
+		defer rows.Close()
+
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// queues a post to be sent to the owner of a subscription. posts which have already been queued for that user,
+// whether or not they've been sent yet, are ignored, so edits to a post don't cause it to be sent again.
+func QueueSubscriptionMatch(d DBLike, sub Subscription, post_id int) error {
+	query := "INSERT INTO subscription_matches (telegram_id, post_id, subscription_id, queued_ts, sent) VALUES ($1, $2, $3, NOW(), false) ON CONFLICT (telegram_id, post_id) DO NOTHING"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, sub.TelegramId, post_id, sub.Id)) })
+}
+
+// lists users who have matches waiting to be sent, and who haven't been sent a digest since the specified time.
+func GetSubscriptionDigestsDue(d DBLike, last_sent_before time.Time) ([]tgdata.UserID, error) {
+	query := `
+SELECT DISTINCT telegram_id FROM subscription_matches
+	LEFT JOIN subscription_digests USING (telegram_id)
+WHERE NOT sent AND (last_sent_ts IS NULL OR last_sent_ts < $1)
+`
+	var out []tgdata.UserID
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query, last_sent_before)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var id tgdata.UserID
+			if err := rows.Scan(&id); err != nil { return err }
+			out = append(out, id)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+// lists a user's unsent matches, oldest first.
+func GetPendingSubscriptionMatches(d DBLike, telegram_id tgdata.UserID, limit int) ([]SubscriptionMatch, error) {
+	query := `
+SELECT subscription_id, post_id, query FROM subscription_matches
+	INNER JOIN subscriptions USING (subscription_id, telegram_id)
+WHERE telegram_id = $1 AND NOT sent
+ORDER BY post_id LIMIT $2
+`
+	var out []SubscriptionMatch
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(query, telegram_id, limit))
+		if err != nil { return err }
+		defer rows.Close()
+
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+func MarkSubscriptionMatchesSent(d DBLike, telegram_id tgdata.UserID, post_ids []int) error {
+	query := "UPDATE subscription_matches SET sent = true WHERE telegram_id = $1 AND post_id = ANY($2::int[])"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, telegram_id, pq.Array(post_ids))) })
+}
+
+func SetSubscriptionDigestSent(d DBLike, telegram_id tgdata.UserID, when time.Time) error {
+	query := "INSERT INTO subscription_digests (telegram_id, last_sent_ts) VALUES ($1, $2) ON CONFLICT (telegram_id) DO UPDATE SET last_sent_ts = EXCLUDED.last_sent_ts"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, telegram_id, when)) })
+}
@@ -6,6 +6,8 @@ import (
 	"database/sql"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"io/ioutil"
 	"encoding/json"
 	"fmt"
@@ -25,10 +27,16 @@ func dburl() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s", settings.User, settings.User, settings.Host, settings.Port, settings.Dbname)
 }
 
+// finds the database directory relative to this file, so that tests work no matter which package they're in.
+func databaseDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "../../../database")
+}
+
 func ensureSettings() error {
 	if settings.User != "" { return nil }
 	
-	file, err := os.Open("../../database/test_db.json")
+	file, err := os.Open(filepath.Join(databaseDir(), "test_db.json"))
 	if err != nil { return fmt.Errorf("Couldn't open database test settings! Did you forget to run initialize_test_database.sh? (%w)", err) }
 	
 	data, err := ioutil.ReadAll(file)
@@ -59,7 +67,7 @@ func ResetTestDatabase() error {
 	err := ensureSettings()
 	if err != nil { return err }
 	
-	cmd := exec.Command("../../database/reset_test_database.sh", settings.User, settings.Dbname, settings.Host, settings.Port)
+	cmd := exec.Command(filepath.Join(databaseDir(), "reset_test_database.sh"), settings.User, settings.Dbname, settings.Host, settings.Port)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", settings.User))
 	return cmd.Run()
 }
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"github.com/thewug/fsb/pkg/fsb/metrics"
+
 	"context"
 	"errors"
 	"fmt"
@@ -186,6 +188,10 @@ func (q queryableError) QueryContext(context.Context, string, ...interface{}) (*
 func (q queryableError) QueryRow(string, ...interface{}) *sql.Row { return nil }
 func (q queryableError) QueryRowContext(context.Context, string, ...interface{}) *sql.Row { return nil }
 
+// counts how each transaction ended up: committed, rolled back (because of an error, or masked), committed despite
+// an error (yielded), or failed to begin, commit or roll back at all.
+var transactions = metrics.NewCounter("fsb_db_transactions_total", "Database transactions, by how they ended.", "outcome")
+
 // dbWrapper is the basic DBLike implementation.
 type dbWrapper struct {
 	q Queryable
@@ -222,6 +228,7 @@ func (d *dbWrapper) EnsureTransaction(parent_return *error) func() {
 	if txerr == nil {
 		return func(){ d.onParentReturn(parent_return) }
 	} else {
+		transactions.Inc("begin_error")
 		*parent_return = txerr
 		return noop
 	}
@@ -265,21 +272,32 @@ func (d *dbWrapper) commit() error {
 // onParentReturn is the internal body of the callback returned to the caller of EnsureTransaction, if a
 // new transaction is created.
 func (d *dbWrapper) onParentReturn(parent_return *error) {
+	var outcome string
+	var innerErr error
 	if *parent_return == nil {
-		*parent_return = d.commit()
+		outcome = "commit"
+		innerErr = d.commit()
+		*parent_return = innerErr
 	} else {
 		typedErr := *parent_return
 		switch typedErr.(type) {
 		case RollbackAndMask:
-			*parent_return = d.rollback()
+			outcome = "masked_rollback"
+			innerErr = d.rollback()
+			*parent_return = innerErr
 		case CommitAndYield:
-			innerErr := d.commit()
+			outcome = "yield"
+			innerErr = d.commit()
 			if innerErr != nil { *parent_return = innerErr }
 		default:
-			innerErr := d.rollback()
+			outcome = "rollback"
+			innerErr = d.rollback()
 			if innerErr != nil { *parent_return = innerErr }
 		}
 	}
+
+	if innerErr != nil { outcome += "_error" }
+	transactions.Inc(outcome)
 }
 
 // NoTx produces a DBLike around a database, without opening a transaction, allowing for untransacted queries.
@@ -2,9 +2,11 @@ package storage
 
 import (
 	tgtypes "github.com/thewug/gogram/data"
+	"github.com/thewug/dml"
 
 	"database/sql"
 	"strings"
+	"time"
 )
 
 func FindCachedMp4ForWebm(d DBLike, md5 string) (*tgtypes.FileID, error) {
@@ -27,3 +29,168 @@ func SaveCachedMp4ForWebm(d DBLike, md5 string, id tgtypes.FileID) error {
 
 	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, strings.ToLower(md5), id)) })
 }
+
+// images which telegram won't show inline as they are get converted too, either into a jpeg or an mp4 animation,
+// depending on kind.
+func FindCachedConvertedImage(d DBLike, md5, kind string) (*tgtypes.FileID, error) {
+	query := "SELECT telegram_id FROM images_converted_for_telegram WHERE md5 = $1 AND kind = $2"
+	out := new(tgtypes.FileID)
+
+	err := d.Enter(func(tx Queryable) error { return tx.QueryRow(query, strings.ToLower(md5), kind).Scan(&out) })
+
+	if err != nil {
+		out = nil
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	return out, err
+}
+
+func SaveCachedConvertedImage(d DBLike, md5, kind string, id tgtypes.FileID) error {
+	query := "INSERT INTO images_converted_for_telegram (md5, kind, telegram_id) VALUES ($1, $2, $3) ON CONFLICT (md5, kind) DO UPDATE SET telegram_id = EXCLUDED.telegram_id"
+
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, strings.ToLower(md5), kind, id)) })
+}
+
+// a queued conversion. kind is what the file is being converted for, which decides what it's converted into.
+// as well as webms, this covers images which are too big or otherwise unsuitable to be shown inline.
+// jobs are deleted once they succeed, at which point the converted file can be found in the cache.
+type WebmConversionJob struct {
+	Md5         string    `dml:"md5"`
+	Kind        string    `dml:"kind"`
+	FileURL     string    `dml:"file_url"`
+	Status      string    `dml:"status"`
+	Attempts    int       `dml:"attempts"`
+	LastError   string    `dml:"last_error"`
+	NextAttempt time.Time `dml:"next_attempt_ts"`
+	Queued      time.Time `dml:"queued_ts"`
+}
+
+const (
+	WebmJobQueued  = "queued"
+	WebmJobRunning = "running"
+	WebmJobFailed  = "failed"
+)
+
+// queues a conversion. if one is already queued or running, this does nothing, but if one has failed
+// for good, it gets another go from scratch.
+func QueueWebmConversion(d DBLike, md5, kind, file_url string) error {
+	query := `
+INSERT INTO webm_conversion_jobs (md5, kind, file_url, status, attempts, last_error, next_attempt_ts, queued_ts)
+VALUES ($1, $2, $3, 'queued', 0, '', NOW(), NOW())
+ON CONFLICT (md5) DO UPDATE
+SET	kind = EXCLUDED.kind,
+	file_url = EXCLUDED.file_url,
+	status = EXCLUDED.status,
+	attempts = EXCLUDED.attempts,
+	next_attempt_ts = EXCLUDED.next_attempt_ts,
+	queued_ts = EXCLUDED.queued_ts
+WHERE webm_conversion_jobs.status = 'failed'
+`
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, strings.ToLower(md5), kind, file_url)) })
+}
+
+// takes the oldest queued job which is ready to be attempted and marks it as running.
+// returns nil, with no error, if there's nothing to do.
+func ClaimWebmConversion(d DBLike) (*WebmConversionJob, error) {
+	query := `
+UPDATE webm_conversion_jobs SET status = 'running'
+WHERE md5 = (
+	SELECT md5 FROM webm_conversion_jobs
+	WHERE status = 'queued' AND next_attempt_ts <= NOW()
+	ORDER BY next_attempt_ts LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING md5, kind, file_url, status, attempts, last_error, next_attempt_ts, queued_ts
+`
+	var out WebmConversionJob
+
+	err := d.Enter(func(tx Queryable) error { return dml.QuickScan(tx.QueryRow(query), &out) })
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// returns nil, with no error, if there's no such job.
+func GetWebmConversion(d DBLike, md5 string) (*WebmConversionJob, error) {
+	query := "SELECT md5, kind, file_url, status, attempts, last_error, next_attempt_ts, queued_ts FROM webm_conversion_jobs WHERE md5 = $1"
+	var out WebmConversionJob
+
+	err := d.Enter(func(tx Queryable) error { return dml.QuickScan(tx.QueryRow(query, strings.ToLower(md5)), &out) })
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// removes a finished job. call this alongside SaveCachedMp4ForWebm.
+func FinishWebmConversion(d DBLike, md5 string) error {
+	query := "DELETE FROM webm_conversion_jobs WHERE md5 = $1"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, strings.ToLower(md5))) })
+}
+
+// records a failed attempt. the job is queued again to be retried at retry_at, or if that's nil, it's marked as failed for good.
+func FailWebmConversion(d DBLike, md5 string, reason string, retry_at *time.Time) error {
+	query := "UPDATE webm_conversion_jobs SET status = 'failed', attempts = attempts + 1, last_error = $2 WHERE md5 = $1"
+	args := []interface{}{strings.ToLower(md5), reason}
+	if retry_at != nil {
+		query = "UPDATE webm_conversion_jobs SET status = 'queued', attempts = attempts + 1, last_error = $2, next_attempt_ts = $3 WHERE md5 = $1"
+		args = append(args, *retry_at)
+	}
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query, args...)) })
+}
+
+// puts jobs which were running back in the queue. this is for after a restart, when nothing can actually still be running them.
+func RequeueRunningWebmConversions(d DBLike) error {
+	query := "UPDATE webm_conversion_jobs SET status = 'queued' WHERE status = 'running'"
+	return d.Enter(func(tx Queryable) error { return WrapExec(tx.Exec(query)) })
+}
+
+// lists jobs with the given status, oldest first.
+func GetWebmConversions(d DBLike, status string, limit int) ([]WebmConversionJob, error) {
+	query := "SELECT md5, kind, file_url, status, attempts, last_error, next_attempt_ts, queued_ts FROM webm_conversion_jobs WHERE status = $1 ORDER BY queued_ts LIMIT $2"
+	var out []WebmConversionJob
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := dml.X(tx.Query(query, status, limit))
+		if err != nil { return err }
+		defer rows.Close()
+
+		return dml.ScanArray(rows, &out)
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}
+
+func CountWebmConversions(d DBLike) (map[string]int, error) {
+	query := "SELECT status, COUNT(*) FROM webm_conversion_jobs GROUP BY status"
+	out := make(map[string]int)
+
+	err := d.Enter(func(tx Queryable) error {
+		rows, err := tx.Query(query)
+		if err != nil { return err }
+		defer rows.Close()
+
+		for rows.Next() {
+			var status string
+			var count int
+			if err := rows.Scan(&status, &count); err != nil { return err }
+			out[status] = count
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		out = nil
+	}
+	return out, err
+}